@@ -74,6 +74,7 @@ func main() {
 	)
 	rootCmd.AddCommand(
 		run(),
+		runs(),
 		install(),
 		// workflow(),
 		forensicstoreCmd.Element(),
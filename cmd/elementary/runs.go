@@ -0,0 +1,99 @@
+// Copyright (c) 2020 Siemens AG
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+//
+// Author(s): Jonas Plum
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/olekukonko/tablewriter"
+	"github.com/spf13/cobra"
+
+	"github.com/forensicanalysis/elementary"
+	"github.com/forensicanalysis/forensicstore"
+)
+
+// runs lists and removes the results of single runs.
+func runs() *cobra.Command {
+	command := &cobra.Command{
+		Use:   "runs",
+		Short: "List or remove runs that added elements to the store",
+	}
+	command.AddCommand(runsList(), runsRemove())
+	return command
+}
+
+func runsList() *cobra.Command {
+	return &cobra.Command{
+		Use:   "list <forensicstore>",
+		Short: "List all runs that added elements to the store",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			store, teardown, err := forensicstore.Open(args[0])
+			if err != nil {
+				return err
+			}
+			defer teardown() // nolint: errcheck
+
+			records, err := elementary.ListRuns(store)
+			if err != nil {
+				return err
+			}
+
+			table := tablewriter.NewWriter(os.Stdout)
+			table.SetHeader([]string{"id", "plugin", "version", "start", "end", "status", "elements"})
+			for _, record := range records {
+				table.Append([]string{
+					record.ID, record.Plugin, record.Version, record.Start, record.End,
+					string(record.Status), strconv.Itoa(record.Elements),
+				})
+			}
+			table.Render()
+			return nil
+		},
+	}
+}
+
+func runsRemove() *cobra.Command {
+	return &cobra.Command{
+		Use:   "rm <forensicstore> <id>...",
+		Short: "Remove runs and all elements they added",
+		Args:  cobra.MinimumNArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			store, teardown, err := forensicstore.Open(args[0])
+			if err != nil {
+				return err
+			}
+			defer teardown() // nolint: errcheck
+
+			for _, id := range args[1:] {
+				removed, err := elementary.RemoveRun(store, id)
+				if err != nil {
+					return err
+				}
+				fmt.Printf("removed run %s and %d elements\n", id, removed)
+			}
+			return nil
+		},
+	}
+}
@@ -129,7 +129,13 @@ func (e *Engine) RunTask(task Task, storeDir string) error {
 	}
 
 	// command.SetArgs(args)
-	return command.Run(command, nil)
+	diagnostics := &pluginlib.DiagnosticCollector{Plugin: command.Name()}
+	defer diagnostics.WriteSummary(log.Writer())
+	err = command.Run(command, diagnostics)
+	if pluginlib.IsStop(err) {
+		return nil
+	}
+	return err
 }
 
 func parseArgs(command pluginlib.Plugin, args []string) error {
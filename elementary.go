@@ -31,8 +31,6 @@ func Images() []string {
 		"docker.io/forensicanalysis/elementary-shimcache:v0.4.0",
 		"docker.io/forensicanalysis/elementary-plaso:v0.4.0",
 		// "docker.io/forensicanalysis/elementary-import-image:v0.4.0",
-		"docker.io/forensicanalysis/elementary-yara:v0.4.0",
-		"docker.io/forensicanalysis/elementary-sigma:v0.4.0",
 	}
 }
 
@@ -40,14 +38,28 @@ func Images() []string {
 var Scripts embed.FS
 
 func NewPluginProvider() pluginlib.Provider {
-	return &PluginProvider{Name: Name(), Dir: AppDir(), Images: Images(), Scripts: Scripts}
+	return &PluginProvider{Name: Name(), Dir: AppDir(), Images: Images(), Scripts: Scripts, Middleware: Middleware()}
+}
+
+// Middleware returns the default middleware: format output, output post
+// processing, store output, all middleware registered with
+// pluginlib.RegisterMiddleware and logging.
+func Middleware() []pluginlib.Middleware {
+	middleware := []pluginlib.Middleware{
+		&output.FormatMiddleware{},
+		&output.PostProcessMiddleware{},
+		&StoreMiddleware{},
+	}
+	middleware = append(middleware, pluginlib.Middlewares.List()...)
+	return append(middleware, &pluginlib.LoggerMiddleware{})
 }
 
 type PluginProvider struct {
-	Name    string
-	Dir     string
-	Images  []string
-	Scripts embed.FS
+	Name       string
+	Dir        string
+	Images     []string
+	Scripts    embed.FS
+	Middleware []pluginlib.Middleware
 }
 
 func (cp *PluginProvider) List() []pluginlib.Plugin {
@@ -59,21 +71,9 @@ func (cp *PluginProvider) List() []pluginlib.Plugin {
 		Plugins: builtin.List(),
 	}
 
-	return storeOutputLayer(mpp.List())
-}
-
-func storeOutputLayer(plugins []pluginlib.Plugin) []pluginlib.Plugin {
-	var layerd []pluginlib.Plugin
-	for _, p := range plugins {
-		layerd = append(layerd,
-			&output.FormatOutputPlugin{
-				Internal: &StoreOutputPlugin{
-					Internal: &pluginlib.LoggerOutputPlugin{
-						Internal: p,
-					},
-				},
-			},
-		)
+	var plugins []pluginlib.Plugin
+	for _, plugin := range mpp.List() {
+		plugins = append(plugins, pluginlib.Use(plugin, cp.Middleware...))
 	}
-	return layerd
+	return plugins
 }
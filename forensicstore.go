@@ -1,26 +1,180 @@
 package elementary
 
 import (
+	"encoding/json"
+	"fmt"
 	"log"
 
+	"crawshaw.io/sqlite"
+	"crawshaw.io/sqlite/sqlitex"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+
+	"github.com/forensicanalysis/elementary/pluginlib"
 	"github.com/forensicanalysis/forensicstore"
 )
 
+// ConflictMode defines how elements with an id that already exists in the
+// store are handled.
+type ConflictMode string
+
+const (
+	// Skip keeps the existing element.
+	Skip ConflictMode = "skip"
+	// Replace overwrites the existing element.
+	Replace ConflictMode = "replace"
+	// Fail returns an error.
+	Fail ConflictMode = "fail"
+)
+
+// ParseConflictMode validates a conflict mode.
+func ParseConflictMode(s string) (ConflictMode, error) {
+	switch mode := ConflictMode(s); mode {
+	case Skip, Replace, Fail:
+		return mode, nil
+	case "":
+		return Skip, nil
+	default:
+		return "", fmt.Errorf("unknown conflict mode %s, must be one of skip, replace or fail", s)
+	}
+}
+
+// DefaultBatchSize is the number of elements inserted in a single
+// transaction.
+const DefaultBatchSize = 1000
+
+// ForensicStoreOutput inserts elements into the forensicstore. The elements
+// are buffered and inserted in a transaction once BatchSize elements are
+// collected and on Flush or Close.
 type ForensicStoreOutput struct {
 	store *forensicstore.ForensicStore
+
+	// Diagnostics enables storing diagnostics as diagnostic elements.
+	Diagnostics bool
+	// OnConflict handles elements that already exist, defaults to Skip.
+	OnConflict ConflictMode
+	// RunID is recorded on stored diagnostics.
+	RunID string
+	// BatchSize is the number of elements inserted in a single transaction.
+	BatchSize int
+
+	batch    [][]byte
+	inserted int
 }
 
 func NewForensicStoreOutput(store *forensicstore.ForensicStore) *ForensicStoreOutput {
-	return &ForensicStoreOutput{store: store}
+	return &ForensicStoreOutput{store: store, OnConflict: Skip, BatchSize: DefaultBatchSize}
 }
 
 func (o *ForensicStoreOutput) WriteHeader([]string) {}
 
-func (o *ForensicStoreOutput) WriteLine(element []byte) {
+func (o *ForensicStoreOutput) WriteLine(element []byte) error {
+	o.batch = append(o.batch, append([]byte{}, element...))
+	if len(o.batch) >= o.BatchSize {
+		return o.Flush()
+	}
+	return nil
+}
+
+// Flush inserts all buffered elements in a single transaction. If any insert
+// fails, the whole batch is rolled back.
+func (o *ForensicStoreOutput) Flush() (err error) {
+	if len(o.batch) == 0 {
+		return nil
+	}
+	batch := o.batch
+	o.batch = nil
+
+	inserted := 0
+	release := sqlitex.Save(o.store.Connection())
+	defer func() {
+		release(&err)
+		if err == nil {
+			o.inserted += inserted
+		}
+	}()
+
+	for _, element := range batch {
+		ok, err := o.insert(element)
+		if err != nil {
+			return err
+		}
+		if ok {
+			inserted++
+		}
+	}
+	return nil
+}
+
+func (o *ForensicStoreOutput) insert(element []byte) (bool, error) {
+	if id := gjson.GetBytes(element, "id").String(); id != "" && o.OnConflict != Fail {
+		exists, err := o.exists(id)
+		if err != nil {
+			return false, err
+		}
+		if exists {
+			if o.OnConflict == Skip {
+				return false, nil
+			}
+			if err := o.delete(id); err != nil {
+				return false, err
+			}
+		}
+	}
+
 	_, err := o.store.Insert(element)
 	if err != nil {
-		log.Println(err, string(element))
+		return false, fmt.Errorf("could not insert element %s: %w", element, err)
+	}
+	return true, nil
+}
+
+// Inserted returns the number of inserted elements.
+func (o *ForensicStoreOutput) Inserted() int {
+	return o.inserted
+}
+
+func (o *ForensicStoreOutput) exists(id string) (bool, error) {
+	exists := false
+	err := sqlitex.Exec(o.store.Connection(), "SELECT 1 FROM `elements` WHERE id=?", func(*sqlite.Stmt) error {
+		exists = true
+		return nil
+	}, id)
+	if err != nil {
+		return false, fmt.Errorf("could not lookup element %s: %w", id, err)
 	}
+	return exists, nil
 }
 
-func (o *ForensicStoreOutput) WriteFooter() {}
+func (o *ForensicStoreOutput) delete(id string) error {
+	err := sqlitex.Exec(o.store.Connection(), "DELETE FROM `elements` WHERE id=?", nil, id)
+	if err != nil {
+		return fmt.Errorf("could not replace element %s: %w", id, err)
+	}
+	return nil
+}
+
+func (o *ForensicStoreOutput) WriteDiagnostic(d pluginlib.Diagnostic) {
+	if !o.Diagnostics {
+		return
+	}
+	element, err := json.Marshal(d)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	if o.RunID != "" {
+		if element, err = sjson.SetBytes(element, "provenance.run_id", o.RunID); err != nil {
+			log.Println(err)
+			return
+		}
+	}
+	if err := o.WriteLine(element); err != nil {
+		log.Println(err)
+	}
+}
+
+// Close inserts the remaining elements.
+func (o *ForensicStoreOutput) Close() error {
+	return o.Flush()
+}
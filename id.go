@@ -0,0 +1,62 @@
+package elementary
+
+import (
+	"crypto/sha256"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+
+	"github.com/forensicanalysis/elementary/pluginlib"
+)
+
+// idNamespace is the namespace for name based element ids.
+var idNamespace = uuid.MustParse("f8340d43-c66a-4429-99bc-597990a269d0")
+
+// NewIDWriter sets deterministic ids on elements without an id. The id is
+// derived from the plugin name and a hash of the given key fields or, if no
+// keys are given, the whole element without its provenance. The source element
+// is always part of the hash, so equal elements from different sources differ.
+func NewIDWriter(w pluginlib.LineWriter, plugin string, keys []string) pluginlib.LineWriter {
+	return &pluginlib.ElementWriter{LineWriter: w, Func: func(element []byte) ([]byte, error) {
+		elementType := gjson.GetBytes(element, "type").String()
+		if elementType == "" || gjson.GetBytes(element, "id").Exists() {
+			return element, nil
+		}
+
+		hash, err := contentHash(element, keys)
+		if err != nil {
+			return nil, err
+		}
+		id := uuid.NewSHA1(idNamespace, append([]byte(plugin+"\x00"), hash[:]...))
+		return sjson.SetBytes(element, "id", elementType+"--"+id.String())
+	}}
+}
+
+func contentHash(element []byte, keys []string) ([sha256.Size]byte, error) {
+	values := []string{gjson.GetBytes(element, "provenance.source").String()}
+	if len(keys) > 0 {
+		for _, key := range keys {
+			values = append(values, gjson.GetBytes(element, key).Raw)
+		}
+	} else {
+		content, err := sjson.DeleteBytes(element, "provenance")
+		if err != nil {
+			return [sha256.Size]byte{}, err
+		}
+		values = append(values, gjson.GetBytes(content, `@pretty:{"sortKeys":true}`).Raw)
+	}
+	return sha256.Sum256([]byte(strings.Join(values, "\x00"))), nil
+}
+
+// IDKeys returns the fields selected by the id-key parameter or nil.
+func IDKeys(p pluginlib.Plugin) []string {
+	var keys []string
+	for _, key := range strings.Split(p.Parameter().StringValue("id-key"), ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
@@ -0,0 +1,582 @@
+// Copyright (c) 2020 Siemens AG
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+//
+// Author(s): Jonas Plum
+
+package builtin
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tidwall/gjson"
+	"gopkg.in/yaml.v2"
+
+	"github.com/forensicanalysis/elementary/pluginlib"
+	"github.com/forensicanalysis/forensicstore"
+)
+
+var _ pluginlib.Plugin = &Alerts{}
+
+// Alerts evaluates YAML detection rules over the elements of the store.
+type Alerts struct {
+	parameter pluginlib.ParameterList
+}
+
+func (a *Alerts) Name() string {
+	return "alerts"
+}
+
+func (a *Alerts) Short() string {
+	return "Run YAML detection rules on any element"
+}
+
+func (a *Alerts) Parameter() pluginlib.ParameterList {
+	if a.parameter == nil {
+		a.parameter = pluginlib.ParameterList{
+			{Name: "forensicstore", Type: pluginlib.Path, Description: "forensicstore", Required: true, Argument: true},
+			Filter,
+			{Name: "rules", Type: pluginlib.PathArray, Description: "rule files or directories", Required: true},
+			{Name: "severity", Type: pluginlib.String, Description: "minimal severity of the rules, e.g. high", Value: "informational", Required: false},
+		}
+	}
+	return a.parameter
+}
+
+func (a *Alerts) Output() *pluginlib.Config {
+	return &pluginlib.Config{Header: []string{"severity", "title", "element_type", "count", "techniques"}}
+}
+
+func (a *Alerts) Run(p pluginlib.Plugin, out pluginlib.LineWriter) error {
+	severity := p.Parameter().StringValue("severity")
+	if _, ok := severityLevels[severity]; !ok {
+		return fmt.Errorf("invalid severity %s", severity)
+	}
+
+	var rules []*alertRule
+	err := forEachYAMLFile(p.Parameter().GetStringArrayValue("rules"), func(path string) {
+		fileRules, err := loadAlertRules(path)
+		if err != nil {
+			pluginlib.Warn(out, path, err)
+		}
+		for _, rule := range fileRules {
+			if severityLevels[rule.Severity] >= severityLevels[severity] {
+				rules = append(rules, rule)
+			}
+		}
+	})
+	if err != nil {
+		return err
+	}
+	if len(rules) == 0 {
+		return errors.New("no rules found")
+	}
+
+	store, teardown, err := getForensicStore(p)
+	if err != nil {
+		return err
+	}
+	defer teardown()
+
+	filter := pluginlib.ExtractFilter(p.Parameter().GetStringArrayValue("filter"))
+	for _, rule := range rules {
+		if err := alertsFromStore(out, store, filter, rule); err != nil {
+			return fmt.Errorf("%s: %w", rule.path, err)
+		}
+	}
+	return nil
+}
+
+// Alert is an element or a group of elements matched by a rule.
+type Alert struct {
+	Type        string            `json:"type"`
+	RuleID      string            `json:"rule_id,omitempty"`
+	Title       string            `json:"title"`
+	Description string            `json:"description,omitempty"`
+	Severity    string            `json:"severity"`
+	Techniques  []string          `json:"techniques,omitempty"`
+	Tactics     []string          `json:"tactics,omitempty"`
+	Tags        []string          `json:"tags,omitempty"`
+	RulePath    string            `json:"rule_path"`
+	ElementType string            `json:"element_type,omitempty"`
+	Count       int               `json:"count"`
+	Group       map[string]string `json:"group,omitempty"`
+	FirstTime   string            `json:"first_time,omitempty"`
+	LastTime    string            `json:"last_time,omitempty"`
+	Fields      map[string]string `json:"fields,omitempty"`
+	ElementRefs []string          `json:"element_refs"`
+}
+
+// alertRule is a detection rule for any element type, e.g.
+//
+//	id: run-key-temp
+//	title: Run key value in a temp directory
+//	severity: high
+//	technique: T1547.001
+//	select: ["type=windows-registry-key,key=%\\Run"]
+//	conditions:
+//	  - path: values.#.data
+//	    contains: \AppData\Local\Temp\
+//	threshold:
+//	  count: 3
+//	  group_by: [key]
+type alertRule struct {
+	ID          string           `yaml:"id"`
+	Title       string           `yaml:"title"`
+	Description string           `yaml:"description"`
+	Severity    string           `yaml:"severity"`
+	Technique   string           `yaml:"technique"`
+	Techniques  []string         `yaml:"techniques"`
+	Tactics     []string         `yaml:"tactics"`
+	Tags        []string         `yaml:"tags"`
+	Select      []interface{}    `yaml:"select"`
+	Match       string           `yaml:"match"`
+	Conditions  []alertCondition `yaml:"conditions"`
+	Threshold   *alertThreshold  `yaml:"threshold"`
+	Fields      []string         `yaml:"fields"`
+
+	path     string
+	selector pluginlib.Filter
+}
+
+// alertCondition tests the values of a gjson path. Exactly one operator must
+// be set. If the path returns multiple values, e.g. for values.#.data, any
+// value can match.
+type alertCondition struct {
+	Path       string        `yaml:"path"`
+	Equals     interface{}   `yaml:"equals"`
+	Contains   interface{}   `yaml:"contains"`
+	StartsWith interface{}   `yaml:"startswith"`
+	EndsWith   interface{}   `yaml:"endswith"`
+	Regex      string        `yaml:"regex"`
+	In         []interface{} `yaml:"in"`
+	Exists     *bool         `yaml:"exists"`
+	GT         *float64      `yaml:"gt"`
+	GTE        *float64      `yaml:"gte"`
+	LT         *float64      `yaml:"lt"`
+	LTE        *float64      `yaml:"lte"`
+	CIDR       string        `yaml:"cidr"`
+	Cased      bool          `yaml:"cased"`
+	Not        bool          `yaml:"not"`
+
+	test func(value string) bool
+}
+
+// alertThreshold aggregates the matching elements into groups and creates
+// an alert for each group that reaches the count.
+type alertThreshold struct {
+	Count    int      `yaml:"count"`
+	GroupBy  []string `yaml:"group_by"`
+	Distinct string   `yaml:"distinct"`
+	Window   string   `yaml:"window"`
+	Time     string   `yaml:"time"`
+
+	window time.Duration
+}
+
+// loadAlertRules loads the rules of a YAML file with one rule per document.
+func loadAlertRules(path string) ([]*alertRule, error) {
+	f, err := os.Open(path) // #nosec
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close() // nolint: errcheck
+
+	var rules []*alertRule
+	decoder := yaml.NewDecoder(f)
+	for {
+		rule := &alertRule{path: path}
+		if err := decoder.Decode(rule); err != nil {
+			if errors.Is(err, io.EOF) {
+				return rules, nil
+			}
+			return rules, err
+		}
+		if err := rule.compile(); err != nil {
+			return rules, fmt.Errorf("%s: %w", rule.Title, err)
+		}
+		rules = append(rules, rule)
+	}
+}
+
+func (r *alertRule) compile() error { // nolint: gocyclo
+	if r.Title == "" {
+		return errors.New("missing title")
+	}
+	r.Severity = strings.ToLower(r.Severity)
+	if r.Severity == "" {
+		r.Severity = "medium"
+	}
+	if _, ok := severityLevels[r.Severity]; !ok {
+		return fmt.Errorf("invalid severity %s", r.Severity)
+	}
+	if r.Technique != "" {
+		r.Techniques = append([]string{r.Technique}, r.Techniques...)
+	}
+	for i, technique := range r.Techniques {
+		r.Techniques[i] = strings.ToUpper(technique)
+	}
+	switch r.Match {
+	case "":
+		r.Match = "all"
+	case "all", "any":
+	default:
+		return fmt.Errorf("invalid match %s", r.Match)
+	}
+
+	if len(r.Select) == 0 {
+		return errors.New("missing select")
+	}
+	for _, selector := range r.Select {
+		switch s := selector.(type) {
+		case string:
+			r.selector = append(r.selector, pluginlib.ExtractFilter([]string{s})...)
+		case map[interface{}]interface{}:
+			condition := map[string]string{}
+			for key, value := range s {
+				condition[fmt.Sprint(key)] = fmt.Sprint(value)
+			}
+			r.selector = append(r.selector, condition)
+		default:
+			return fmt.Errorf("invalid select %v", selector)
+		}
+	}
+
+	for i := range r.Conditions {
+		if err := r.Conditions[i].compile(); err != nil {
+			return fmt.Errorf("condition %d: %w", i+1, err)
+		}
+	}
+
+	if t := r.Threshold; t != nil {
+		if t.Count < 1 {
+			return fmt.Errorf("invalid threshold count %d", t.Count)
+		}
+		if t.Window != "" {
+			window, err := time.ParseDuration(t.Window)
+			if err != nil || window <= 0 {
+				return fmt.Errorf("invalid window %s", t.Window)
+			}
+			if t.Time == "" {
+				return errors.New("window requires a time path")
+			}
+			t.window = window
+		}
+	}
+	return nil
+}
+
+func (c *alertCondition) compile() error { // nolint: gocyclo
+	if c.Path == "" {
+		return errors.New("missing path")
+	}
+	normalize := strings.ToLower
+	if c.Cased {
+		normalize = func(s string) string { return s }
+	}
+	compare := func(limit *float64, fn func(n, limit float64) bool) func(string) bool {
+		return func(s string) bool {
+			n, err := strconv.ParseFloat(s, 64)
+			return err == nil && fn(n, *limit)
+		}
+	}
+
+	var tests []func(string) bool
+	if c.Equals != nil {
+		v := normalize(fmt.Sprint(c.Equals))
+		tests = append(tests, func(s string) bool { return normalize(s) == v })
+	}
+	if c.Contains != nil {
+		v := normalize(fmt.Sprint(c.Contains))
+		tests = append(tests, func(s string) bool { return strings.Contains(normalize(s), v) })
+	}
+	if c.StartsWith != nil {
+		v := normalize(fmt.Sprint(c.StartsWith))
+		tests = append(tests, func(s string) bool { return strings.HasPrefix(normalize(s), v) })
+	}
+	if c.EndsWith != nil {
+		v := normalize(fmt.Sprint(c.EndsWith))
+		tests = append(tests, func(s string) bool { return strings.HasSuffix(normalize(s), v) })
+	}
+	if c.Regex != "" {
+		expression := c.Regex
+		if !c.Cased {
+			expression = "(?i)" + expression
+		}
+		re, err := regexp.Compile(expression)
+		if err != nil {
+			return err
+		}
+		tests = append(tests, re.MatchString)
+	}
+	if c.In != nil {
+		values := map[string]bool{}
+		for _, v := range c.In {
+			values[normalize(fmt.Sprint(v))] = true
+		}
+		tests = append(tests, func(s string) bool { return values[normalize(s)] })
+	}
+	if c.GT != nil {
+		tests = append(tests, compare(c.GT, func(n, limit float64) bool { return n > limit }))
+	}
+	if c.GTE != nil {
+		tests = append(tests, compare(c.GTE, func(n, limit float64) bool { return n >= limit }))
+	}
+	if c.LT != nil {
+		tests = append(tests, compare(c.LT, func(n, limit float64) bool { return n < limit }))
+	}
+	if c.LTE != nil {
+		tests = append(tests, compare(c.LTE, func(n, limit float64) bool { return n <= limit }))
+	}
+	if c.CIDR != "" {
+		network, err := parseNetwork(c.CIDR)
+		if err != nil {
+			return err
+		}
+		tests = append(tests, func(s string) bool {
+			ip := net.ParseIP(strings.TrimPrefix(s, "::ffff:"))
+			return ip != nil && network.Contains(ip)
+		})
+	}
+
+	switch {
+	case len(tests) == 0 && c.Exists == nil:
+		return errors.New("missing operator")
+	case len(tests) > 1 || (len(tests) == 1 && c.Exists != nil):
+		return errors.New("multiple operators")
+	case len(tests) == 1:
+		c.test = tests[0]
+	}
+	return nil
+}
+
+// match tests the condition on an element.
+func (c *alertCondition) match(element []byte) bool {
+	result := gjson.GetBytes(element, c.Path)
+	if c.Exists != nil {
+		return (result.Exists() && result.String() != "") == (*c.Exists != c.Not)
+	}
+
+	matched := false
+	if result.IsArray() {
+		result.ForEach(func(_, value gjson.Result) bool {
+			matched = c.test(value.String())
+			return !matched
+		})
+	} else if result.Exists() {
+		matched = c.test(result.String())
+	}
+	return matched != c.Not
+}
+
+// match tests the conditions of the rule on an element.
+func (r *alertRule) match(element []byte) bool {
+	if len(r.Conditions) == 0 {
+		return true
+	}
+	for i := range r.Conditions {
+		matched := r.Conditions[i].match(element)
+		if matched && r.Match == "any" {
+			return true
+		}
+		if !matched && r.Match == "all" {
+			return false
+		}
+	}
+	return r.Match == "all"
+}
+
+// alertGroup collects the matching elements of a threshold group. Only the
+// first element is kept as a whole, the type and the fields of the alerts
+// are taken from it.
+type alertGroup struct {
+	key      string
+	group    map[string]string
+	element  []byte
+	elements []alertElement
+}
+
+type alertElement struct {
+	id       string
+	time     time.Time
+	distinct string
+}
+
+func alertsFromStore(out pluginlib.LineWriter, store *forensicstore.ForensicStore, filter pluginlib.Filter, rule *alertRule) error {
+	groups := map[string]*alertGroup{}
+	var order []string
+
+	err := pluginlib.ForEach(store, combineFilter(filter, rule.selector), func(element []byte) error {
+		if !rule.match(element) {
+			return nil
+		}
+		id := gjson.GetBytes(element, "id").String()
+		if rule.Threshold == nil {
+			return rule.write(out, element, []alertElement{{id: id}}, nil)
+		}
+
+		group := map[string]string{}
+		var key strings.Builder
+		for _, path := range rule.Threshold.GroupBy {
+			value := gjson.GetBytes(element, path).String()
+			group[path] = value
+			key.WriteString(value + "\x00")
+		}
+		g, ok := groups[key.String()]
+		if !ok {
+			g = &alertGroup{key: key.String(), group: group, element: element}
+			groups[key.String()] = g
+			order = append(order, key.String())
+		}
+		e := alertElement{id: id}
+		if rule.Threshold.Distinct != "" {
+			e.distinct = gjson.GetBytes(element, rule.Threshold.Distinct).String()
+		}
+		if rule.Threshold.Time != "" {
+			e.time = elementTime(gjson.GetBytes(element, rule.Threshold.Time))
+		}
+		g.elements = append(g.elements, e)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, key := range order {
+		g := groups[key]
+		for _, elements := range rule.Threshold.windows(g.elements) {
+			if err := rule.write(out, g.element, elements, g.group); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// windows returns the sets of elements that reach the threshold. Without a
+// window all elements of a group are counted together, otherwise each set
+// spans at most the window and starts after the previous set.
+func (t *alertThreshold) windows(elements []alertElement) [][]alertElement {
+	if t.window == 0 {
+		if t.count(elements) >= t.Count {
+			return [][]alertElement{elements}
+		}
+		return nil
+	}
+
+	sort.SliceStable(elements, func(i, j int) bool { return elements[i].time.Before(elements[j].time) })
+	var windows [][]alertElement
+	for start := 0; start < len(elements); {
+		end := start
+		for end < len(elements) && elements[end].time.Sub(elements[start].time) <= t.window {
+			end++
+		}
+		if t.count(elements[start:end]) >= t.Count {
+			windows = append(windows, elements[start:end])
+			start = end
+			continue
+		}
+		start++
+	}
+	return windows
+}
+
+// count returns the number of elements or the number of distinct values.
+func (t *alertThreshold) count(elements []alertElement) int {
+	if t.Distinct == "" {
+		return len(elements)
+	}
+	values := map[string]bool{}
+	for _, e := range elements {
+		values[e.distinct] = true
+	}
+	return len(values)
+}
+
+func (r *alertRule) write(out pluginlib.LineWriter, element []byte, elements []alertElement, group map[string]string) error {
+	alert := &Alert{
+		Type:        "alert",
+		RuleID:      r.ID,
+		Title:       r.Title,
+		Description: strings.TrimSpace(r.Description),
+		Severity:    r.Severity,
+		Techniques:  r.Techniques,
+		Tactics:     r.Tactics,
+		Tags:        r.Tags,
+		RulePath:    r.path,
+		ElementType: gjson.GetBytes(element, "type").String(),
+		Count:       len(elements),
+		Group:       group,
+	}
+	var first, last time.Time
+	for _, e := range elements {
+		alert.ElementRefs = append(alert.ElementRefs, e.id)
+		if e.time.IsZero() {
+			continue
+		}
+		if first.IsZero() || e.time.Before(first) {
+			first = e.time
+		}
+		if e.time.After(last) {
+			last = e.time
+		}
+	}
+	if !first.IsZero() {
+		alert.FirstTime = first.UTC().Format(time.RFC3339Nano)
+		alert.LastTime = last.UTC().Format(time.RFC3339Nano)
+	}
+	if len(r.Fields) > 0 {
+		alert.Fields = map[string]string{}
+		for _, field := range r.Fields {
+			if value := gjson.GetBytes(element, field); value.Exists() {
+				alert.Fields[field] = value.String()
+			}
+		}
+	}
+
+	b, err := json.Marshal(alert)
+	if err != nil {
+		return err
+	}
+	if b, err = pluginlib.SetSource(b, elements[0].id); err != nil {
+		return err
+	}
+	return out.WriteLine(b)
+}
+
+// elementTime parses RFC 3339 timestamps and unix timestamps in seconds.
+func elementTime(value gjson.Result) time.Time {
+	if value.Type == gjson.Number {
+		return unixTime(value.Float())
+	}
+	t, err := time.Parse(time.RFC3339Nano, value.String())
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
@@ -0,0 +1,182 @@
+// Copyright (c) 2020 Siemens AG
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+//
+// Author(s): Jonas Plum
+
+package builtin
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/tidwall/gjson"
+	"gopkg.in/yaml.v2"
+
+	"github.com/forensicanalysis/forensicstore"
+)
+
+func TestAlertRule_compile(t *testing.T) {
+	tests := []struct {
+		name    string
+		rule    string
+		wantErr bool
+	}{
+		{"valid", "title: a\nselect: [type=service]\nconditions: [{path: name, equals: x}]", false},
+		{"map select", "title: a\nselect: [{type: service, name: x}]", false},
+		{"missing title", "select: [type=service]", true},
+		{"missing select", "title: a", true},
+		{"invalid severity", "title: a\nseverity: urgent\nselect: [type=service]", true},
+		{"missing operator", "title: a\nselect: [type=service]\nconditions: [{path: name}]", true},
+		{"multiple operators", "title: a\nselect: [type=service]\nconditions: [{path: name, equals: x, contains: y}]", true},
+		{"invalid regex", "title: a\nselect: [type=service]\nconditions: [{path: name, regex: '('}]", true},
+		{"invalid window", "title: a\nselect: [type=service]\nthreshold: {count: 2, window: x, time: t}", true},
+		{"window without time", "title: a\nselect: [type=service]\nthreshold: {count: 2, window: 1m}", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule := &alertRule{}
+			if err := yaml.Unmarshal([]byte(tt.rule), rule); err != nil {
+				t.Fatal(err)
+			}
+			if err := rule.compile(); (err != nil) != tt.wantErr {
+				t.Errorf("compile() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestAlertCondition_match(t *testing.T) {
+	element := []byte(`{"name": "Updater", "count": 12, "ip": "10.0.0.5", "values": [{"data": "C:\\Users\\bob\\AppData\\Local\\Temp\\a.exe"}, {"data": "x"}]}`)
+	tests := []struct {
+		name      string
+		condition string
+		want      bool
+	}{
+		{"equals", "{path: name, equals: updater}", true},
+		{"equals cased", "{path: name, equals: updater, cased: true}", false},
+		{"contains array", `{path: values.#.data, contains: '\temp\'}`, true},
+		{"startswith", "{path: name, startswith: Up}", true},
+		{"endswith", "{path: name, endswith: x}", false},
+		{"regex", "{path: name, regex: '^up.*r$'}", true},
+		{"in", "{path: name, in: [foo, updater]}", true},
+		{"gt", "{path: count, gt: 10}", true},
+		{"lte", "{path: count, lte: 10}", false},
+		{"cidr", "{path: ip, cidr: 10.0.0.0/8}", true},
+		{"exists", "{path: name, exists: true}", true},
+		{"not exists", "{path: missing, exists: true}", false},
+		{"not", "{path: name, equals: updater, not: true}", false},
+		{"missing path", "{path: missing, equals: x}", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			condition := &alertCondition{}
+			if err := yaml.Unmarshal([]byte(tt.condition), condition); err != nil {
+				t.Fatal(err)
+			}
+			if err := condition.compile(); err != nil {
+				t.Fatal(err)
+			}
+			if got := condition.match(element); got != tt.want {
+				t.Errorf("match() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAlertsFromStore(t *testing.T) {
+	store, teardown, err := forensicstore.New(filepath.Join(t.TempDir(), "test.forensicstore"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer teardown()
+
+	for _, element := range []string{
+		`{"type": "service", "name": "PSEXESVC", "image": "C:\\Windows\\PSEXESVC.exe"}`,
+		`{"type": "service", "name": "Spooler", "image": "C:\\Windows\\System32\\spoolsv.exe"}`,
+		`{"type": "logon-session", "status": "failed", "source_ip": "10.0.0.5", "user": "a", "authentication_time": "2020-01-01T10:00:00Z"}`,
+		`{"type": "logon-session", "status": "failed", "source_ip": "10.0.0.5", "user": "b", "authentication_time": "2020-01-01T10:00:30Z"}`,
+		`{"type": "logon-session", "status": "failed", "source_ip": "10.0.0.5", "user": "c", "authentication_time": "2020-01-01T10:01:00Z"}`,
+		`{"type": "logon-session", "status": "failed", "source_ip": "10.0.0.5", "user": "d", "authentication_time": "2020-01-01T12:00:00Z"}`,
+		`{"type": "logon-session", "status": "failed", "source_ip": "10.0.0.6", "user": "a", "authentication_time": "2020-01-01T10:00:00Z"}`,
+	} {
+		if _, err := store.Insert([]byte(element)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name       string
+		rule       string
+		wantCounts []int
+	}{
+		{"single", `
+title: PsExec service
+technique: t1569.002
+select: ["type=service,name=PSEXE%"]
+fields: [image]`, []int{1}},
+		{"any", `
+title: Services
+match: any
+select: [type=service]
+conditions:
+  - {path: name, equals: spooler}
+  - {path: image, contains: psexe}`, []int{1, 1}},
+		{"password spraying", `
+title: Password spraying
+select: [{type: logon-session, status: failed}]
+threshold:
+  count: 3
+  group_by: [source_ip]
+  distinct: user
+  window: 5m
+  time: authentication_time`, []int{3}},
+		{"threshold without window", `
+title: Failed logons
+select: [{type: logon-session, status: failed}]
+threshold: {count: 2, group_by: [source_ip]}`, []int{4}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule := &alertRule{path: "test.yml"}
+			if err := yaml.Unmarshal([]byte(tt.rule), rule); err != nil {
+				t.Fatal(err)
+			}
+			if err := rule.compile(); err != nil {
+				t.Fatal(err)
+			}
+			out := &testLineWriter{}
+			if err := alertsFromStore(out, store, nil, rule); err != nil {
+				t.Fatal(err)
+			}
+			var counts []int
+			for _, line := range out.lines {
+				alert := gjson.ParseBytes(line)
+				if alert.Get("type").String() != "alert" || alert.Get("provenance.source").String() == "" {
+					t.Errorf("invalid alert %s", line)
+				}
+				counts = append(counts, int(alert.Get("count").Int()))
+			}
+			if !reflect.DeepEqual(counts, tt.wantCounts) {
+				t.Errorf("counts = %v, want %v", counts, tt.wantCounts)
+			}
+		})
+	}
+
+}
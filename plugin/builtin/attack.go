@@ -0,0 +1,245 @@
+// Copyright (c) 2020 Siemens AG
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+//
+// Author(s): Jonas Plum
+
+package builtin
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+)
+
+// attackTactic is a tactic of the ATT&CK enterprise matrix.
+type attackTactic struct {
+	ID        string
+	ShortName string
+	Name      string
+}
+
+// attackTactics are the tactics of the enterprise matrix in matrix order.
+// They are used if no ATT&CK data is given.
+var attackTactics = []attackTactic{
+	{"TA0043", "reconnaissance", "Reconnaissance"},
+	{"TA0042", "resource-development", "Resource Development"},
+	{"TA0001", "initial-access", "Initial Access"},
+	{"TA0002", "execution", "Execution"},
+	{"TA0003", "persistence", "Persistence"},
+	{"TA0004", "privilege-escalation", "Privilege Escalation"},
+	{"TA0005", "defense-evasion", "Defense Evasion"},
+	{"TA0006", "credential-access", "Credential Access"},
+	{"TA0007", "discovery", "Discovery"},
+	{"TA0008", "lateral-movement", "Lateral Movement"},
+	{"TA0009", "collection", "Collection"},
+	{"TA0011", "command-and-control", "Command and Control"},
+	{"TA0010", "exfiltration", "Exfiltration"},
+	{"TA0040", "impact", "Impact"},
+}
+
+// attackTechnique is a technique or sub-technique with the short names of
+// its tactics.
+type attackTechnique struct {
+	ID      string
+	Name    string
+	Tactics []string
+}
+
+// attackMapping maps the findings of the builtin detections to ATT&CK
+// techniques.
+var attackMapping = map[string]attackTechnique{
+	LogCleared:          {ID: "T1070.001", Tactics: []string{"defense-evasion"}},
+	RecordGap:           {ID: "T1070", Tactics: []string{"defense-evasion"}},
+	DuplicateRecord:     {ID: "T1070", Tactics: []string{"defense-evasion"}},
+	HeaderAnomaly:       {ID: "T1070", Tactics: []string{"defense-evasion"}},
+	ChunkAnomaly:        {ID: "T1070", Tactics: []string{"defense-evasion"}},
+	ServiceInstall:      {ID: "T1569.002", Tactics: []string{"execution"}},
+	RemoteScheduledTask: {ID: "T1053.005", Tactics: []string{"execution", "persistence", "privilege-escalation"}},
+	WMIExecution:        {ID: "T1047", Tactics: []string{"execution"}},
+	AdminShareAccess:    {ID: "T1021.002", Tactics: []string{"lateral-movement"}},
+	UnusualRDPSource:    {ID: "T1021.001", Tactics: []string{"lateral-movement"}},
+}
+
+// findingAttack returns the techniques and tactics of a finding kind.
+func findingAttack(finding string) (techniques, tactics []string) {
+	technique, ok := attackMapping[finding]
+	if !ok {
+		return nil, nil
+	}
+	return []string{technique.ID}, technique.Tactics
+}
+
+// attackTags returns the ATT&CK techniques and tactics of Sigma tags like
+// attack.t1059.001 and attack.execution.
+func attackTags(tags []string) (techniques, tactics []string) {
+	for _, tag := range tags {
+		tag = strings.ToLower(tag)
+		if !strings.HasPrefix(tag, "attack.") {
+			continue
+		}
+		name := strings.TrimPrefix(tag, "attack.")
+		switch {
+		case len(name) > 1 && name[0] == 't' && name[1] >= '0' && name[1] <= '9':
+			techniques = appendUnique(techniques, strings.ToUpper(name))
+		case len(name) > 1 && (name[0] == 'g' || name[0] == 's') && name[1] >= '0' && name[1] <= '9':
+			// groups and software are kept in the tags only
+		default:
+			tactics = appendUnique(tactics, strings.ReplaceAll(name, "_", "-"))
+		}
+	}
+	return techniques, tactics
+}
+
+func appendUnique(list []string, value string) []string {
+	for _, v := range list {
+		if v == value {
+			return list
+		}
+	}
+	return append(list, value)
+}
+
+// attackData contains the tactics and techniques of the ATT&CK enterprise
+// matrix.
+type attackData struct {
+	version    string
+	tactics    []attackTactic
+	techniques map[string]attackTechnique
+}
+
+// defaultAttackData returns the tactics and the builtin mapped techniques
+// without names.
+func defaultAttackData() *attackData {
+	data := &attackData{tactics: attackTactics, techniques: map[string]attackTechnique{}}
+	for _, technique := range attackMapping {
+		data.techniques[technique.ID] = technique
+	}
+	return data
+}
+
+// tactic returns the tactic with the given short name.
+func (d *attackData) tactic(shortName string) (int, attackTactic) {
+	for i, tactic := range d.tactics {
+		if tactic.ShortName == shortName {
+			return i, tactic
+		}
+	}
+	return len(d.tactics), attackTactic{ShortName: shortName}
+}
+
+type stixBundle struct {
+	Objects []stixObject `json:"objects"`
+}
+
+type stixObject struct {
+	Type               string   `json:"type"`
+	ID                 string   `json:"id"`
+	Name               string   `json:"name"`
+	Revoked            bool     `json:"revoked"`
+	CreatedByRef       string   `json:"created_by_ref"`
+	Confidence         int      `json:"confidence"`
+	Labels             []string `json:"labels"`
+	Pattern            string   `json:"pattern"`
+	PatternType        string   `json:"pattern_type"`
+	IndicatorTypes     []string `json:"indicator_types"`
+	Deprecated         bool     `json:"x_mitre_deprecated"`
+	ShortName          string   `json:"x_mitre_shortname"`
+	Version            string   `json:"x_mitre_version"`
+	TacticRefs         []string `json:"tactic_refs"`
+	ExternalReferences []struct {
+		SourceName string `json:"source_name"`
+		ExternalID string `json:"external_id"`
+	} `json:"external_references"`
+	KillChainPhases []struct {
+		KillChainName string `json:"kill_chain_name"`
+		PhaseName     string `json:"phase_name"`
+	} `json:"kill_chain_phases"`
+}
+
+func (o stixObject) externalID() string {
+	for _, reference := range o.ExternalReferences {
+		if reference.SourceName == "mitre-attack" {
+			return reference.ExternalID
+		}
+	}
+	return ""
+}
+
+// loadAttackData loads the tactics and techniques from the STIX bundle of the
+// ATT&CK enterprise matrix, e.g. enterprise-attack.json from
+// https://github.com/mitre-attack/attack-stix-data.
+func loadAttackData(path string) (*attackData, error) {
+	b, err := ioutil.ReadFile(path) // #nosec
+	if err != nil {
+		return nil, err
+	}
+	var bundle stixBundle
+	if err := json.Unmarshal(b, &bundle); err != nil {
+		return nil, fmt.Errorf("could not parse ATT&CK data: %w", err)
+	}
+
+	data := &attackData{techniques: map[string]attackTechnique{}}
+	tactics := map[string]attackTactic{}
+	var tacticRefs []string
+	for _, object := range bundle.Objects {
+		if object.Revoked || object.Deprecated {
+			continue
+		}
+		switch object.Type {
+		case "x-mitre-collection":
+			data.version = object.Version
+		case "x-mitre-matrix":
+			if object.externalID() == "enterprise-attack" || tacticRefs == nil {
+				tacticRefs = object.TacticRefs
+			}
+		case "x-mitre-tactic":
+			tactics[object.ID] = attackTactic{ID: object.externalID(), ShortName: object.ShortName, Name: object.Name}
+		case "attack-pattern":
+			technique := attackTechnique{ID: object.externalID(), Name: object.Name}
+			if technique.ID == "" {
+				continue
+			}
+			for _, phase := range object.KillChainPhases {
+				if phase.KillChainName == "mitre-attack" {
+					technique.Tactics = append(technique.Tactics, phase.PhaseName)
+				}
+			}
+			data.techniques[technique.ID] = technique
+		}
+	}
+
+	for _, ref := range tacticRefs {
+		if tactic, ok := tactics[ref]; ok {
+			data.tactics = append(data.tactics, tactic)
+		}
+	}
+	if len(data.tactics) == 0 {
+		// bundles without a matrix are sorted by tactic id
+		for _, tactic := range tactics {
+			data.tactics = append(data.tactics, tactic)
+		}
+		sort.Slice(data.tactics, func(i, j int) bool { return data.tactics[i].ID < data.tactics[j].ID })
+	}
+	if len(data.techniques) == 0 {
+		return nil, fmt.Errorf("no techniques found in %s", path)
+	}
+	return data, nil
+}
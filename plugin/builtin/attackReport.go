@@ -0,0 +1,293 @@
+// Copyright (c) 2020 Siemens AG
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+//
+// Author(s): Jonas Plum
+
+package builtin
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"github.com/tidwall/gjson"
+
+	"github.com/forensicanalysis/elementary/pluginlib"
+	"github.com/forensicanalysis/forensicstore"
+)
+
+var _ pluginlib.Plugin = &AttackReport{}
+
+// AttackReport aggregates the ATT&CK techniques of findings, Sigma matches
+// and alerts into a tactic and technique matrix.
+type AttackReport struct {
+	parameter pluginlib.ParameterList
+}
+
+func (a *AttackReport) Name() string {
+	return "attack-report"
+}
+
+func (a *AttackReport) Short() string {
+	return "Aggregate findings into an ATT&CK matrix"
+}
+
+func (a *AttackReport) Parameter() pluginlib.ParameterList {
+	if a.parameter == nil {
+		a.parameter = pluginlib.ParameterList{
+			{Name: "forensicstore", Type: pluginlib.Path, Description: "forensicstore", Required: true, Argument: true},
+			Filter,
+			{Name: "attack-data", Type: pluginlib.Path, Description: "ATT&CK enterprise STIX bundle, e.g. enterprise-attack.json", Required: false},
+			{Name: "layer", Type: pluginlib.Path, Description: "write an ATT&CK Navigator layer to this file", Required: false},
+		}
+	}
+	return a.parameter
+}
+
+func (a *AttackReport) Output() *pluginlib.Config {
+	return &pluginlib.Config{Header: []string{"tactic", "technique_id", "technique", "count", "element_types"}}
+}
+
+func (a *AttackReport) Run(p pluginlib.Plugin, out pluginlib.LineWriter) error {
+	data := defaultAttackData()
+	if path := p.Parameter().StringValue("attack-data"); path != "" {
+		var err error
+		if data, err = loadAttackData(path); err != nil {
+			return err
+		}
+	}
+
+	store, teardown, err := getForensicStore(p)
+	if err != nil {
+		return err
+	}
+	defer teardown()
+
+	filter := pluginlib.ExtractFilter(p.Parameter().GetStringArrayValue("filter"))
+	report, err := attackReportFromStore(store, filter, data)
+	if err != nil {
+		return err
+	}
+	if p.Parameter().StringValue("attack-data") == "" {
+		if ids := unnamedTechniques(report); len(ids) > 0 {
+			pluginlib.Warn(out, a.Name(), fmt.Errorf("no names for the techniques %s, set --attack-data to an ATT&CK enterprise bundle", strings.Join(ids, ", ")))
+		}
+	}
+
+	for _, technique := range report {
+		b, err := json.Marshal(technique)
+		if err != nil {
+			return err
+		}
+		if b, err = pluginlib.SetSource(b, technique.ElementRefs[0]); err != nil {
+			return err
+		}
+		if err := out.WriteLine(b); err != nil {
+			return err
+		}
+	}
+
+	if path := p.Parameter().StringValue("layer"); path != "" {
+		return writeNavigatorLayer(path, report, data)
+	}
+	return nil
+}
+
+// AttackReportTechnique is a technique of a tactic with the elements that
+// were mapped to it.
+type AttackReportTechnique struct {
+	Type         string   `json:"type"`
+	Tactic       string   `json:"tactic"`
+	TacticID     string   `json:"tactic_id,omitempty"`
+	TechniqueID  string   `json:"technique_id"`
+	Technique    string   `json:"technique,omitempty"`
+	Count        int      `json:"count"`
+	ElementTypes []string `json:"element_types"`
+	Titles       []string `json:"titles,omitempty"`
+	ElementRefs  []string `json:"element_refs"`
+
+	tacticIndex     int
+	tacticShortName string
+}
+
+// maxReportTitles limits the distinct titles listed per technique.
+const maxReportTitles = 10
+
+func attackReportFromStore(store *forensicstore.ForensicStore, filter pluginlib.Filter, data *attackData) ([]*AttackReportTechnique, error) {
+	techniques := map[string]*AttackReportTechnique{}
+	err := pluginlib.ForEach(store, combineFilter(filter, pluginlib.Filter{{"techniques": "%"}}), func(element []byte) error {
+		id := gjson.GetBytes(element, "id").String()
+		elementType := gjson.GetBytes(element, "type").String()
+		// findings are named by their kind
+		title := gjson.GetBytes(element, "title").String()
+		for _, field := range []string{"kind", "finding"} {
+			if title == "" {
+				title = gjson.GetBytes(element, field).String()
+			}
+		}
+		var elementTactics []string
+		for _, tactic := range gjson.GetBytes(element, "tactics").Array() {
+			elementTactics = append(elementTactics, tactic.String())
+		}
+
+		for _, techniqueID := range gjson.GetBytes(element, "techniques").Array() {
+			technique := data.technique(strings.ToUpper(techniqueID.String()))
+			tactics := technique.Tactics
+			if len(tactics) == 0 {
+				tactics = elementTactics
+			}
+			if len(tactics) == 0 {
+				tactics = []string{""}
+			}
+			for _, shortName := range tactics {
+				key := shortName + "|" + technique.ID
+				t, ok := techniques[key]
+				if !ok {
+					index, tactic := data.tactic(shortName)
+					t = &AttackReportTechnique{
+						Type:        "attack-technique",
+						Tactic:      tactic.Name,
+						TacticID:    tactic.ID,
+						TechniqueID: technique.ID,
+						Technique:   technique.Name,
+
+						tacticIndex:     index,
+						tacticShortName: shortName,
+					}
+					if t.Tactic == "" {
+						t.Tactic = shortName
+					}
+					techniques[key] = t
+				}
+				t.Count++
+				t.ElementTypes = appendUnique(t.ElementTypes, elementType)
+				if title != "" && len(t.Titles) < maxReportTitles {
+					t.Titles = appendUnique(t.Titles, title)
+				}
+				t.ElementRefs = append(t.ElementRefs, id)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	report := make([]*AttackReportTechnique, 0, len(techniques))
+	for _, technique := range techniques {
+		report = append(report, technique)
+	}
+	sort.Slice(report, func(i, j int) bool {
+		if report[i].tacticIndex != report[j].tacticIndex {
+			return report[i].tacticIndex < report[j].tacticIndex
+		}
+		if report[i].Tactic != report[j].Tactic {
+			return report[i].Tactic < report[j].Tactic
+		}
+		return report[i].TechniqueID < report[j].TechniqueID
+	})
+	return report, nil
+}
+
+// unnamedTechniques returns the ids of the reported techniques without a
+// name.
+func unnamedTechniques(report []*AttackReportTechnique) []string {
+	var ids []string
+	for _, technique := range report {
+		if technique.Technique == "" {
+			ids = appendUnique(ids, technique.TechniqueID)
+		}
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// technique returns a technique by id. Unknown sub-techniques get the
+// tactics of their parent technique.
+func (d *attackData) technique(id string) attackTechnique {
+	if technique, ok := d.techniques[id]; ok {
+		return technique
+	}
+	if i := strings.Index(id, "."); i >= 0 {
+		if parent, ok := d.techniques[id[:i]]; ok {
+			return attackTechnique{ID: id, Tactics: parent.Tactics}
+		}
+	}
+	return attackTechnique{ID: id}
+}
+
+// navigatorLayer is an ATT&CK Navigator layer, see
+// https://github.com/mitre-attack/attack-navigator/tree/master/layers.
+type navigatorLayer struct {
+	Name         string               `json:"name"`
+	Versions     map[string]string    `json:"versions"`
+	Domain       string               `json:"domain"`
+	Description  string               `json:"description"`
+	Techniques   []navigatorTechnique `json:"techniques"`
+	Gradient     navigatorGradient    `json:"gradient"`
+	HideDisabled bool                 `json:"hideDisabled"`
+}
+
+type navigatorTechnique struct {
+	TechniqueID string `json:"techniqueID"`
+	Tactic      string `json:"tactic,omitempty"`
+	Score       int    `json:"score"`
+	Comment     string `json:"comment,omitempty"`
+	Enabled     bool   `json:"enabled"`
+}
+
+type navigatorGradient struct {
+	Colors   []string `json:"colors"`
+	MinValue int      `json:"minValue"`
+	MaxValue int      `json:"maxValue"`
+}
+
+func writeNavigatorLayer(path string, report []*AttackReportTechnique, data *attackData) error {
+	layer := navigatorLayer{
+		Name:        "elementary",
+		Versions:    map[string]string{"layer": "4.5", "navigator": "4.9.1"},
+		Domain:      "enterprise-attack",
+		Description: "Techniques of the findings in the forensicstore",
+		Techniques:  []navigatorTechnique{},
+		Gradient:    navigatorGradient{Colors: []string{"#ffe766ff", "#ff6666ff"}, MinValue: 0, MaxValue: 1},
+	}
+	if data.version != "" {
+		layer.Versions["attack"] = strings.SplitN(data.version, ".", 2)[0]
+	}
+	for _, technique := range report {
+		layer.Techniques = append(layer.Techniques, navigatorTechnique{
+			TechniqueID: technique.TechniqueID,
+			Tactic:      technique.tacticShortName,
+			Score:       technique.Count,
+			Comment:     strings.Join(technique.Titles, "\n"),
+			Enabled:     true,
+		})
+		if technique.Count > layer.Gradient.MaxValue {
+			layer.Gradient.MaxValue = technique.Count
+		}
+	}
+
+	b, err := json.MarshalIndent(layer, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, b, 0600)
+}
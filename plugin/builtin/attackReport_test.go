@@ -0,0 +1,221 @@
+// Copyright (c) 2020 Siemens AG
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+//
+// Author(s): Jonas Plum
+
+package builtin
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/tidwall/gjson"
+
+	"github.com/forensicanalysis/forensicstore"
+)
+
+const testAttackBundle = `{"type": "bundle", "objects": [
+	{"type": "x-mitre-collection", "id": "x-mitre-collection--1", "x_mitre_version": "14.1"},
+	{"type": "x-mitre-matrix", "id": "x-mitre-matrix--1", "external_references": [{"source_name": "mitre-attack", "external_id": "enterprise-attack"}],
+	 "tactic_refs": ["x-mitre-tactic--execution", "x-mitre-tactic--persistence", "x-mitre-tactic--defense-evasion"]},
+	{"type": "x-mitre-tactic", "id": "x-mitre-tactic--persistence", "name": "Persistence", "x_mitre_shortname": "persistence",
+	 "external_references": [{"source_name": "mitre-attack", "external_id": "TA0003"}]},
+	{"type": "x-mitre-tactic", "id": "x-mitre-tactic--execution", "name": "Execution", "x_mitre_shortname": "execution",
+	 "external_references": [{"source_name": "mitre-attack", "external_id": "TA0002"}]},
+	{"type": "x-mitre-tactic", "id": "x-mitre-tactic--defense-evasion", "name": "Defense Evasion", "x_mitre_shortname": "defense-evasion",
+	 "external_references": [{"source_name": "mitre-attack", "external_id": "TA0005"}]},
+	{"type": "attack-pattern", "id": "attack-pattern--1", "name": "Scheduled Task",
+	 "external_references": [{"source_name": "mitre-attack", "external_id": "T1053.005"}],
+	 "kill_chain_phases": [{"kill_chain_name": "mitre-attack", "phase_name": "execution"}, {"kill_chain_name": "mitre-attack", "phase_name": "persistence"}]},
+	{"type": "attack-pattern", "id": "attack-pattern--2", "name": "Command and Scripting Interpreter",
+	 "external_references": [{"source_name": "mitre-attack", "external_id": "T1059"}],
+	 "kill_chain_phases": [{"kill_chain_name": "mitre-attack", "phase_name": "execution"}]},
+	{"type": "attack-pattern", "id": "attack-pattern--3", "name": "Clear Windows Event Logs",
+	 "external_references": [{"source_name": "mitre-attack", "external_id": "T1070.001"}],
+	 "kill_chain_phases": [{"kill_chain_name": "mitre-attack", "phase_name": "defense-evasion"}]},
+	{"type": "attack-pattern", "id": "attack-pattern--4", "name": "Old", "revoked": true,
+	 "external_references": [{"source_name": "mitre-attack", "external_id": "T9999"}]}
+]}`
+
+func TestLoadAttackData(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "enterprise-attack.json")
+	if err := ioutil.WriteFile(path, []byte(testAttackBundle), 0600); err != nil {
+		t.Fatal(err)
+	}
+	data, err := loadAttackData(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data.version != "14.1" {
+		t.Errorf("version = %s, want 14.1", data.version)
+	}
+	var tactics []string
+	for _, tactic := range data.tactics {
+		tactics = append(tactics, tactic.ID)
+	}
+	if want := []string{"TA0002", "TA0003", "TA0005"}; !reflect.DeepEqual(tactics, want) {
+		t.Errorf("tactics = %v, want %v", tactics, want)
+	}
+	if len(data.techniques) != 3 {
+		t.Errorf("len(techniques) = %d, want 3", len(data.techniques))
+	}
+	if technique := data.technique("T1059.001"); !reflect.DeepEqual(technique.Tactics, []string{"execution"}) {
+		t.Errorf("technique(T1059.001) = %+v", technique)
+	}
+}
+
+func TestAttackReport(t *testing.T) {
+	dir := t.TempDir()
+	store, teardown, err := forensicstore.New(filepath.Join(dir, "test.forensicstore"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer teardown()
+
+	for _, element := range []string{
+		`{"type": "finding", "kind": "remote-scheduled-task", "techniques": ["T1053.005"], "tactics": ["execution", "persistence", "privilege-escalation"]}`,
+		`{"type": "sigma-match", "title": "Encoded PowerShell", "techniques": ["T1059.001"], "tactics": ["execution"]}`,
+		`{"type": "alert", "title": "Encoded PowerShell", "techniques": ["t1059.001"]}`,
+		`{"type": "eventlog-finding", "finding": "log-cleared", "techniques": ["T1070.001"], "tactics": ["defense-evasion"]}`,
+		`{"type": "alert", "title": "Unknown", "techniques": ["T1234"]}`,
+		`{"type": "service", "name": "no technique"}`,
+	} {
+		if _, err := store.Insert([]byte(element)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	bundle := filepath.Join(dir, "enterprise-attack.json")
+	if err := ioutil.WriteFile(bundle, []byte(testAttackBundle), 0600); err != nil {
+		t.Fatal(err)
+	}
+	data, err := loadAttackData(bundle)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := attackReportFromStore(store, nil, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rows [][]interface{}
+	for _, technique := range report {
+		rows = append(rows, []interface{}{technique.Tactic, technique.TechniqueID, technique.Technique, technique.Count})
+	}
+	want := [][]interface{}{
+		{"Execution", "T1053.005", "Scheduled Task", 1},
+		{"Execution", "T1059.001", "", 2},
+		{"Persistence", "T1053.005", "Scheduled Task", 1},
+		{"Defense Evasion", "T1070.001", "Clear Windows Event Logs", 1},
+		{"", "T1234", "", 1},
+	}
+	if !reflect.DeepEqual(rows, want) {
+		t.Errorf("report = %v, want %v", rows, want)
+	}
+	if types := report[1].ElementTypes; !reflect.DeepEqual(types, []string{"sigma-match", "alert"}) {
+		t.Errorf("element types = %v", types)
+	}
+
+	layer := filepath.Join(dir, "layer.json")
+	if err := writeNavigatorLayer(layer, report, data); err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(layer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version := gjson.GetBytes(b, "versions.attack").String(); version != "14" {
+		t.Errorf("attack version = %s, want 14", version)
+	}
+	if techniques := gjson.GetBytes(b, "techniques.#").Int(); techniques != 5 {
+		t.Errorf("len(techniques) = %d, want 5", techniques)
+	}
+	if tactic := gjson.GetBytes(b, "techniques.1.tactic").String(); tactic != "execution" {
+		t.Errorf("tactic = %s, want execution", tactic)
+	}
+	if max := gjson.GetBytes(b, "gradient.maxValue").Int(); max != 2 {
+		t.Errorf("maxValue = %d, want 2", max)
+	}
+}
+
+func TestFindingAttack(t *testing.T) {
+	techniques, tactics := findingAttack(AdminShareAccess)
+	if !reflect.DeepEqual(techniques, []string{"T1021.002"}) || !reflect.DeepEqual(tactics, []string{"lateral-movement"}) {
+		t.Errorf("findingAttack() = %v, %v", techniques, tactics)
+	}
+	if techniques, _ := findingAttack(TimeJump); techniques != nil {
+		t.Errorf("findingAttack(TimeJump) = %v, want nil", techniques)
+	}
+}
+
+func TestAttackReport_Run(t *testing.T) {
+	dir := t.TempDir()
+	storePath := filepath.Join(dir, "test.forensicstore")
+	store, teardown, err := forensicstore.New(storePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, element := range []string{
+		`{"type": "sigma-match", "title": "Encoded PowerShell", "techniques": ["T1059.001"], "tactics": ["execution"]}`,
+		`{"type": "alert", "title": "Scheduled task", "techniques": ["T1053.005"]}`,
+	} {
+		if _, err := store.Insert([]byte(element)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_ = teardown()
+
+	bundle := filepath.Join(dir, "enterprise-attack.json")
+	if err := ioutil.WriteFile(bundle, []byte(testAttackBundle), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name            string
+		attackData      string
+		wantLines       int
+		wantDiagnostics []string
+	}{
+		{"without attack data", "", 4, []string{"no names for the techniques T1053.005, T1059.001, set --attack-data to an ATT&CK enterprise bundle"}},
+		{"with attack data", bundle, 3, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tlw := &testLineWriter{}
+			command := &AttackReport{}
+			command.Parameter().Set("forensicstore", storePath)
+			command.Parameter().Set("attack-data", tt.attackData)
+			if err := command.Run(command, tlw); err != nil {
+				t.Fatal(err)
+			}
+			if len(tlw.lines) != tt.wantLines {
+				t.Errorf("len(lines) = %d, want %d", len(tlw.lines), tt.wantLines)
+			}
+			var diagnostics []string
+			for _, diagnostic := range tlw.diagnostics {
+				diagnostics = append(diagnostics, diagnostic.Message)
+			}
+			if !reflect.DeepEqual(diagnostics, tt.wantDiagnostics) {
+				t.Errorf("diagnostics = %v, want %v", diagnostics, tt.wantDiagnostics)
+			}
+		})
+	}
+}
@@ -3,6 +3,10 @@ package builtin
 import (
 	"bytes"
 	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 
 	"github.com/tidwall/gjson"
 
@@ -52,3 +56,68 @@ func getForensicStore(p pluginlib.Plugin) (*forensicstore.ForensicStore, func()
 	path := p.Parameter().StringValue("forensicstore")
 	return forensicstore.Open(path)
 }
+
+// eventConditions selects the eventlog elements of a channel with the given
+// event ids.
+func eventConditions(channel string, eventIDs ...int) pluginlib.Filter {
+	var filter pluginlib.Filter
+	for _, eventID := range eventIDs {
+		filter = append(filter, map[string]string{"type": "eventlog", "System.Channel": channel, "System.EventID.Value": strconv.Itoa(eventID)})
+	}
+	return filter
+}
+
+// combineFilter combines every condition of the user filter with every
+// condition of the base filter.
+func combineFilter(filter, base pluginlib.Filter) pluginlib.Filter {
+	if len(filter) == 0 {
+		return base
+	}
+	var combined pluginlib.Filter
+	for _, condition := range filter {
+		for _, baseCondition := range base {
+			c := map[string]string{}
+			for key, value := range condition {
+				c[key] = value
+			}
+			for key, value := range baseCondition {
+				c[key] = value
+			}
+			combined = append(combined, c)
+		}
+	}
+	return combined
+}
+
+// forEachYAMLFile calls fn for the yml and yaml files in the given files and
+// directories in lexical order.
+func forEachYAMLFile(paths []string, fn func(path string)) error {
+	return forEachFile(paths, []string{".yml", ".yaml"}, fn)
+}
+
+// forEachFile calls fn for the files with one of the extensions in the given
+// files and directories in lexical order.
+func forEachFile(paths []string, extensions []string, fn func(path string)) error {
+	for _, root := range paths {
+		err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info.IsDir() {
+				return nil
+			}
+			extension := strings.ToLower(filepath.Ext(path))
+			for _, e := range extensions {
+				if extension == e {
+					fn(path)
+					break
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
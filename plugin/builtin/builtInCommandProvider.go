@@ -5,6 +5,17 @@ import "github.com/forensicanalysis/elementary/pluginlib"
 func List() []pluginlib.Plugin {
 	return []pluginlib.Plugin{
 		&Eventlogs{},
+		&EventlogMessages{},
+		&EventlogTampering{},
+		&Sysmon{},
+		&ProcessTree{},
+		&LogonSessions{},
+		&LateralMovement{},
+		&PowerShell{},
+		&Sigma{},
+		&Alerts{},
+		&AttackReport{},
+		&Yara{},
 		&Export{},
 		&ImportForensicstore{},
 		&JSONImport{},
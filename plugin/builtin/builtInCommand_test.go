@@ -27,6 +27,8 @@ import (
 	"path/filepath"
 
 	"github.com/otiai10/copy"
+
+	"github.com/forensicanalysis/elementary/pluginlib"
 )
 
 func setup(subdirs ...string) (string, error) {
@@ -60,9 +62,15 @@ func cleanup(folders ...string) (err error) {
 }
 
 type testLineWriter struct {
-	lines [][]byte
+	lines       [][]byte
+	diagnostics []pluginlib.Diagnostic
 }
 
-func (t *testLineWriter) WriteLine(b []byte) {
+func (t *testLineWriter) WriteLine(b []byte) error {
 	t.lines = append(t.lines, b)
+	return nil
+}
+
+func (t *testLineWriter) WriteDiagnostic(d pluginlib.Diagnostic) {
+	t.diagnostics = append(t.diagnostics, d)
 }
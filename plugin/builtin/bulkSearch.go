@@ -22,14 +22,13 @@
 package builtin
 
 import (
-	"bufio"
 	"encoding/json"
-	"log"
-	"os"
+	"errors"
 
-	"crawshaw.io/sqlite"
+	"github.com/tidwall/gjson"
 
 	"github.com/forensicanalysis/elementary/pluginlib"
+	"github.com/forensicanalysis/forensicstore"
 )
 
 var _ pluginlib.Plugin = &BulkSearch{}
@@ -50,74 +49,88 @@ func (b *BulkSearch) Parameter() pluginlib.ParameterList {
 	if b.parameter == nil {
 		b.parameter = pluginlib.ParameterList{
 			{Name: "forensicstore", Type: pluginlib.Path, Description: "forensicstore", Required: true, Argument: true},
+			Filter,
 			{Name: "file", Type: pluginlib.Path, Description: "file with IOCs", Required: true},
+			{Name: "ioc-format", Type: pluginlib.String, Description: "format of the IOC file: text, stix, misp, openioc or csv, detected by default", Required: false},
 		}
 	}
 	return b.parameter
 }
 
 func (b *BulkSearch) Output() *pluginlib.Config {
-	return &pluginlib.Config{Header: []string{"type", "ioc", "count"}}
+	return &pluginlib.Config{Header: []string{"indicator_type", "indicator", "source", "element_type", "field", "element_ref"}}
 }
 
 func (b *BulkSearch) Run(p pluginlib.Plugin, out pluginlib.LineWriter) error {
-	store, teardown, err := getForensicStore(p)
+	iocListPath := p.Parameter().StringValue("file")
+	iocs, err := loadIndicators(iocListPath, p.Parameter().StringValue("ioc-format"), func(err error) {
+		pluginlib.Warn(out, iocListPath, err)
+	})
 	if err != nil {
 		return err
 	}
-	defer teardown()
-
-	iocListPath := p.Parameter().StringValue("file")
-
-	file, err := os.Open(iocListPath) // #nosec
-	if err != nil {
-		log.Fatal(err)
+	if len(iocs) == 0 {
+		return errors.New("no indicators found")
 	}
-	defer file.Close()
-
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		ioc := scanner.Text()
-		if ioc == "" {
-			continue
-		}
-		log.Println("search", ioc)
 
-		element, err := getSearchCount(store.Connection(), ioc)
-		if err != nil {
-			return err
-		}
-		out.WriteLine(element) // nolint: errcheck
-	}
-	if err := scanner.Err(); err != nil {
+	store, teardown, err := getForensicStore(p)
+	if err != nil {
 		return err
 	}
+	defer teardown()
 
-	return nil
+	filter := pluginlib.ExtractFilter(p.Parameter().GetStringArrayValue("filter"))
+	return bulkSearchStore(out, store, filter, iocs)
 }
 
-func getSearchCount(conn *sqlite.Conn, term string) ([]byte, error) {
-	stmt, err := conn.Prepare("SELECT count(json) as count FROM elements WHERE json LIKE $search")
-	if err != nil {
-		return nil, err
-	}
-	stmt.SetText("$search", "%"+term+"%")
-	if _, err := stmt.Step(); err != nil {
-		return nil, err
-	}
+// IOCHit is an indicator found in an element.
+type IOCHit struct {
+	Type          string   `json:"type"`
+	Indicator     string   `json:"indicator"`
+	IndicatorType string   `json:"indicator_type"`
+	Source        string   `json:"source,omitempty"`
+	Confidence    int      `json:"confidence,omitempty"`
+	Tags          []string `json:"tags,omitempty"`
+	ElementType   string   `json:"element_type"`
+	Field         string   `json:"field"`
+	Value         string   `json:"value"`
+	ElementRef    string   `json:"element_ref"`
+}
 
-	b, err := json.Marshal(struct {
-		Type  string `json:"type"`
-		IOC   string `json:"ioc"`
-		Count int64  `json:"count"`
-	}{
-		Type:  "bulksearch",
-		IOC:   term,
-		Count: stmt.GetInt64("count"),
+// bulkSearchStore searches all indicators in a single pass over the
+// elements.
+func bulkSearchStore(out pluginlib.LineWriter, store *forensicstore.ForensicStore, filter pluginlib.Filter, iocs []*indicator) error {
+	index := newIOCIndex(iocs)
+	return pluginlib.ForEach(store, filter, func(element []byte) error {
+		elementType := gjson.GetBytes(element, "type").String()
+		if elementType == "ioc-hit" {
+			// hits of previous runs contain the indicators
+			return nil
+		}
+		id := gjson.GetBytes(element, "id").String()
+		for _, match := range index.matchElement(element) {
+			b, err := json.Marshal(&IOCHit{
+				Type:          "ioc-hit",
+				Indicator:     match.indicator.Value,
+				IndicatorType: match.indicator.Type,
+				Source:        match.indicator.Source,
+				Confidence:    match.indicator.Confidence,
+				Tags:          match.indicator.Tags,
+				ElementType:   elementType,
+				Field:         match.field,
+				Value:         match.value,
+				ElementRef:    id,
+			})
+			if err != nil {
+				return err
+			}
+			if b, err = pluginlib.SetSource(b, id); err != nil {
+				return err
+			}
+			if err := out.WriteLine(b); err != nil {
+				return err
+			}
+		}
+		return nil
 	})
-	if err != nil {
-		return nil, err
-	}
-
-	return b, stmt.Finalize()
 }
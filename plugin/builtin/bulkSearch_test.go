@@ -23,39 +23,54 @@ package builtin
 
 import (
 	"io/ioutil"
-	"log"
 	"path/filepath"
+	"reflect"
+	"sort"
 	"testing"
 
 	"github.com/tidwall/gjson"
+
+	"github.com/forensicanalysis/forensicstore"
 )
 
 func TestBulkSearch(t *testing.T) {
-	log.Println("Start setup")
-	storeDir, err := setup()
+	dir := t.TempDir()
+	storePath := filepath.Join(dir, "test.forensicstore")
+	store, teardown, err := forensicstore.New(storePath)
 	if err != nil {
 		t.Fatal(err)
 	}
-	log.Println("Setup done")
-	defer cleanup(storeDir)
-
-	example := filepath.Join(storeDir, "example1.forensicstore")
+	ids := map[string]string{}
+	for name, element := range map[string]string{
+		"file":    `{"type": "file", "id": "file--2d6b0ee5-a6f4-4c4e-9e8a-2c9a1e3b9a41", "name": "cmd.exe", "origin": {"path": "C:\\Windows\\System32\\cmd.exe"}}`,
+		"process": `{"type": "process", "id": "process--7c1f0e52-3b8d-4f5e-a0d4-5e6f2b8c9d10", "name": "cmd", "command_line": "CMD.EXE /c whoami", "arguments": ["/c", "whoami"]}`,
+		"service": `{"type": "service", "id": "service--a4e2b7c9-1d3f-4b6a-8e5c-0f9d2c7b4a13", "name": "spooler"}`,
+	} {
+		if ids[name], err = store.Insert([]byte(element)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_ = teardown()
 
-	iocFile := filepath.Join(storeDir, "ioc.txt")
-	ioutil.WriteFile(iocFile, []byte("exe"), 0o755)
+	iocFile := filepath.Join(dir, "ioc.txt")
+	if err := ioutil.WriteFile(iocFile, []byte("exe"), 0600); err != nil {
+		t.Fatal(err)
+	}
 
 	type args struct {
-		file string
-		url  interface{}
+		file   string
+		url    interface{}
+		filter []string
 	}
 	tests := []struct {
-		name        string
-		args        args
-		wantResults int
-		wantCount   int64
-		wantErr     bool
+		name     string
+		args     args
+		wantHits []string
+		wantErr  bool
 	}{
-		{"ioc search", args{iocFile, example}, 1, 529, false},
+		{"ioc search", args{iocFile, storePath, nil}, []string{"file name", "file origin.path", "process command_line"}, false},
+		{"filter", args{iocFile, storePath, []string{"type=process"}}, []string{"process command_line"}, false},
+		{"missing file", args{filepath.Join(dir, "missing.txt"), storePath, nil}, nil, true},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -63,19 +78,25 @@ func TestBulkSearch(t *testing.T) {
 			command := &BulkSearch{}
 			command.Parameter().Set("file", tt.args.file)
 			command.Parameter().Set("forensicstore", tt.args.url)
+			command.Parameter().Set("filter", tt.args.filter)
 			err = command.Run(command, tlw)
 
 			if (err != nil) != tt.wantErr {
 				t.Errorf("Run() error = %v, wantErr %v", err, tt.wantErr)
 			}
 
-			if len(tlw.lines) != tt.wantResults {
-				t.Errorf("Run() error, wrong number of resuls = %d, want %d", len(tlw.lines), tt.wantResults)
+			var hits []string
+			for _, line := range tlw.lines {
+				hit := gjson.ParseBytes(line)
+				elementType := hit.Get("element_type").String()
+				if hit.Get("indicator").String() != "exe" || hit.Get("element_ref").String() != ids[elementType] {
+					t.Errorf("Run() error, invalid hit %s", line)
+				}
+				hits = append(hits, elementType+" "+hit.Get("field").String())
 			}
-
-			count := gjson.GetBytes(tlw.lines[0], "count").Int()
-			if count != tt.wantCount {
-				t.Errorf("Run() error, wrong count of resuls = %d, want %d", count, tt.wantCount)
+			sort.Strings(hits)
+			if !reflect.DeepEqual(hits, tt.wantHits) {
+				t.Errorf("Run() hits = %v, want %v", hits, tt.wantHits)
 			}
 		})
 	}
@@ -0,0 +1,258 @@
+// Copyright (c) 2020 Siemens AG
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+//
+// Author(s): Jonas Plum
+
+package builtin
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Velocidex/ordereddict"
+	"www.velocidex.com/golang/evtx"
+
+	"github.com/forensicanalysis/elementary/pluginlib"
+)
+
+var eventLevels = map[string][]int64{
+	"critical":    {1},
+	"error":       {2},
+	"warning":     {3},
+	"information": {0, 4},
+	"verbose":     {5},
+}
+
+// eventFilterParameter are the parameters to select single events.
+func eventFilterParameter() pluginlib.ParameterList {
+	return pluginlib.ParameterList{
+		{Name: "channel", Type: pluginlib.StringArray, Description: "only output events of these channels", Required: false},
+		{Name: "provider", Type: pluginlib.StringArray, Description: "only output events of these providers", Required: false},
+		{Name: "event-id", Type: pluginlib.String, Description: "comma separated list of event ids and ranges, e.g. 4624,4625,4720-4738", Value: "", Required: false},
+		{Name: "level", Type: pluginlib.String, Description: "comma separated list of levels, e.g. critical,error,warning or 1,2,3", Value: "", Required: false},
+		{Name: "record-id", Type: pluginlib.String, Description: "range of record ids, e.g. 1000-2000 or 1000-", Value: "", Required: false},
+		{Name: "after", Type: pluginlib.String, Description: "only output events created after this time (RFC 3339)", Value: "", Required: false},
+		{Name: "before", Type: pluginlib.String, Description: "only output events created before this time (RFC 3339)", Value: "", Required: false},
+	}
+}
+
+type idRange struct {
+	min, max int64
+}
+
+func (r idRange) contains(i int64) bool {
+	return i >= r.min && i <= r.max
+}
+
+// eventFilter selects single events while parsing, so events that are not
+// selected are not converted to elements.
+type eventFilter struct {
+	channels  map[string]bool
+	providers map[string]bool
+	eventIDs  []idRange
+	levels    map[int64]bool
+	recordIDs *idRange
+	after     *time.Time
+	before    *time.Time
+}
+
+// newEventFilter creates a filter from the parameters, it returns nil if no
+// filter is set.
+func newEventFilter(parameter pluginlib.ParameterList) (*eventFilter, error) { // nolint: gocyclo
+	f := &eventFilter{}
+	empty := true
+
+	if channels := parameter.GetStringArrayValue("channel"); len(channels) > 0 {
+		f.channels, empty = lowerSet(channels), false
+	}
+	if providers := parameter.GetStringArrayValue("provider"); len(providers) > 0 {
+		f.providers, empty = lowerSet(providers), false
+	}
+	if s := parameter.StringValue("event-id"); s != "" {
+		for _, part := range strings.Split(s, ",") {
+			r, err := parseIDRange(part)
+			if err != nil {
+				return nil, fmt.Errorf("invalid event id %s: %w", part, err)
+			}
+			f.eventIDs = append(f.eventIDs, r)
+		}
+		empty = false
+	}
+	if s := parameter.StringValue("level"); s != "" {
+		f.levels, empty = map[int64]bool{}, false
+		for _, part := range strings.Split(s, ",") {
+			part = strings.ToLower(strings.TrimSpace(part))
+			if levels, ok := eventLevels[part]; ok {
+				for _, level := range levels {
+					f.levels[level] = true
+				}
+				continue
+			}
+			level, err := strconv.ParseInt(part, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid level %s", part)
+			}
+			f.levels[level] = true
+		}
+	}
+	if s := parameter.StringValue("record-id"); s != "" {
+		r, err := parseIDRange(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid record id range %s: %w", s, err)
+		}
+		f.recordIDs, empty = &r, false
+	}
+	for name, target := range map[string]**time.Time{"after": &f.after, "before": &f.before} {
+		if s := parameter.StringValue(name); s != "" {
+			t, err := parseTime(s)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s time %s: %w", name, s, err)
+			}
+			*target, empty = &t, false
+		}
+	}
+
+	if empty {
+		return nil, nil
+	}
+	return f, nil
+}
+
+func lowerSet(values []string) map[string]bool {
+	set := map[string]bool{}
+	for _, value := range values {
+		set[strings.ToLower(value)] = true
+	}
+	return set
+}
+
+// parseIDRange parses a single id like 4624 or a range like 1000-2000, 1000-
+// or -2000.
+func parseIDRange(s string) (idRange, error) {
+	s = strings.TrimSpace(s)
+	r := idRange{min: 0, max: math.MaxInt64}
+	if !strings.Contains(s, "-") {
+		i, err := strconv.ParseInt(s, 10, 64)
+		return idRange{min: i, max: i}, err
+	}
+
+	parts := strings.SplitN(s, "-", 2)
+	var err error
+	if parts[0] != "" {
+		if r.min, err = strconv.ParseInt(parts[0], 10, 64); err != nil {
+			return r, err
+		}
+	}
+	if parts[1] != "" {
+		if r.max, err = strconv.ParseInt(parts[1], 10, 64); err != nil {
+			return r, err
+		}
+	}
+	if r.min > r.max {
+		return r, fmt.Errorf("%d is greater than %d", r.min, r.max)
+	}
+	return r, nil
+}
+
+func parseTime(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return t, nil
+	}
+	return time.Parse("2006-01-02", s)
+}
+
+// matchChunk returns false if the chunk cannot contain selected records.
+func (f *eventFilter) matchChunk(header evtx.ChunkHeader) bool {
+	if f == nil || f.recordIDs == nil {
+		return true
+	}
+	return int64(header.LastEventRecID) >= f.recordIDs.min && int64(header.FirstEventRecID) <= f.recordIDs.max
+}
+
+// match tests if the Event map of a record is selected.
+func (f *eventFilter) match(event *ordereddict.Dict) bool { // nolint: gocyclo
+	if f == nil {
+		return true
+	}
+	if f.channels != nil {
+		channel, _ := ordereddict.GetString(event, "System.Channel")
+		if !f.channels[strings.ToLower(channel)] {
+			return false
+		}
+	}
+	if f.providers != nil {
+		provider, _ := ordereddict.GetString(event, "System.Provider.Name")
+		if !f.providers[strings.ToLower(provider)] {
+			return false
+		}
+	}
+	if f.eventIDs != nil {
+		eventID, _ := ordereddict.GetInt(event, "System.EventID.Value")
+		matched := false
+		for _, r := range f.eventIDs {
+			if r.contains(int64(eventID)) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	if f.levels != nil {
+		level, _ := ordereddict.GetInt(event, "System.Level")
+		if !f.levels[int64(level)] {
+			return false
+		}
+	}
+	if f.recordIDs != nil {
+		recordID, _ := ordereddict.GetInt(event, "System.EventRecordID")
+		if !f.recordIDs.contains(int64(recordID)) {
+			return false
+		}
+	}
+	if f.after != nil || f.before != nil {
+		created, ok := eventTime(event)
+		if !ok || (f.after != nil && created.Before(*f.after)) || (f.before != nil && created.After(*f.before)) {
+			return false
+		}
+	}
+	return true
+}
+
+func eventTime(event *ordereddict.Dict) (time.Time, bool) {
+	value, ok := ordereddict.GetAny(event, "System.TimeCreated.SystemTime")
+	if !ok {
+		return time.Time{}, false
+	}
+	seconds, ok := value.(float64)
+	if !ok {
+		return time.Time{}, false
+	}
+	return unixTime(seconds), true
+}
+
+// unixTime converts the fractional seconds used for System.TimeCreated.
+func unixTime(seconds float64) time.Time {
+	sec, frac := math.Modf(seconds)
+	return time.Unix(int64(sec), int64(frac*1e9)).UTC()
+}
@@ -0,0 +1,82 @@
+// Copyright (c) 2020 Siemens AG
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+//
+// Author(s): Jonas Plum
+
+package builtin
+
+import (
+	"testing"
+
+	"github.com/Velocidex/ordereddict"
+)
+
+func TestEventFilter_Match(t *testing.T) {
+	event := ordereddict.NewDict().Set("System", ordereddict.NewDict().
+		Set("Provider", ordereddict.NewDict().Set("Name", "Microsoft-Windows-Security-Auditing")).
+		Set("EventID", ordereddict.NewDict().Set("Value", uint64(4624))).
+		Set("Level", uint64(0)).
+		Set("TimeCreated", ordereddict.NewDict().Set("SystemTime", 1549462715.946133)).
+		Set("EventRecordID", uint64(31880)).
+		Set("Channel", "Security"))
+
+	tests := []struct {
+		name      string
+		parameter map[string]interface{}
+		want      bool
+		wantErr   bool
+	}{
+		{"no filter", nil, true, false},
+		{"channel", map[string]interface{}{"channel": []string{"security"}}, true, false},
+		{"other channel", map[string]interface{}{"channel": []string{"System"}}, false, false},
+		{"provider", map[string]interface{}{"provider": []string{"Microsoft-Windows-Security-Auditing"}}, true, false},
+		{"event id", map[string]interface{}{"event-id": "4625, 4624"}, true, false},
+		{"event id range", map[string]interface{}{"event-id": "4600-4700"}, true, false},
+		{"other event id", map[string]interface{}{"event-id": "4625"}, false, false},
+		{"invalid event id", map[string]interface{}{"event-id": "foo"}, false, true},
+		{"level name", map[string]interface{}{"level": "information"}, true, false},
+		{"level", map[string]interface{}{"level": "1,2"}, false, false},
+		{"invalid level", map[string]interface{}{"level": "loud"}, false, true},
+		{"record id", map[string]interface{}{"record-id": "31000-"}, true, false},
+		{"other record id", map[string]interface{}{"record-id": "-31000"}, false, false},
+		{"invalid record id", map[string]interface{}{"record-id": "2-1"}, false, true},
+		{"time", map[string]interface{}{"after": "2019-02-06", "before": "2019-02-07T00:00:00Z"}, true, false},
+		{"before", map[string]interface{}{"before": "2019-02-06T14:18:35Z"}, false, false},
+		{"combined", map[string]interface{}{"channel": []string{"Security"}, "event-id": "4625"}, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parameter := (&Eventlogs{}).Parameter()
+			for name, value := range tt.parameter {
+				parameter.Set(name, value)
+			}
+
+			filter, err := newEventFilter(parameter)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("newEventFilter() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if got := filter.match(event); got != tt.want {
+				t.Errorf("match() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,490 @@
+// Copyright (c) 2020 Siemens AG
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+//
+// Author(s): Jonas Plum
+
+package builtin
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/tidwall/gjson"
+
+	"github.com/forensicanalysis/elementary/pluginlib"
+	"github.com/forensicanalysis/forensicstore"
+)
+
+var _ pluginlib.Plugin = &EventlogMessages{}
+
+var (
+	eventlogServiceKey = regexp.MustCompile(`(?i)\\Services\\EventLog\\[^\\]+\\([^\\]+)$`)
+	publisherKey       = regexp.MustCompile(`(?i)\\WINEVT\\Publishers\\(\{[^\\]+\})$`)
+)
+
+// EventlogMessages builds a message catalog from the message files of the
+// eventlog providers. The catalog can be added to the store or saved with
+// --format jsonl and used by the eventlogs plugin.
+type EventlogMessages struct {
+	parameter pluginlib.ParameterList
+}
+
+func (e *EventlogMessages) Name() string {
+	return "eventlog-messages"
+}
+
+func (e *EventlogMessages) Short() string {
+	return "Build an eventlog message catalog from provider message files"
+}
+
+func (e *EventlogMessages) Parameter() pluginlib.ParameterList {
+	if e.parameter == nil {
+		e.parameter = pluginlib.ParameterList{
+			{Name: "forensicstore", Type: pluginlib.Path, Description: "forensicstore", Required: true, Argument: true},
+		}
+	}
+	return e.parameter
+}
+
+func (e *EventlogMessages) Output() *pluginlib.Config {
+	return &pluginlib.Config{Header: []string{"provider", "kind", "event_id", "parameter_id", "message"}}
+}
+
+// EventlogMessage is a single entry of a message catalog.
+type EventlogMessage struct {
+	Type        string `json:"type"`
+	Provider    string `json:"provider"`
+	GUID        string `json:"guid,omitempty"`
+	Kind        string `json:"kind"`
+	EventID     uint32 `json:"event_id,omitempty"`
+	ParameterID uint32 `json:"parameter_id,omitempty"`
+	MessageID   uint32 `json:"message_id"`
+	Message     string `json:"message"`
+}
+
+// eventlogProvider is a classic provider or a manifest based provider. The
+// latter have a guid and their events are mapped to messages by the
+// WEVT_TEMPLATE resource of their resource files.
+type eventlogProvider struct {
+	name           string
+	guid           string
+	messageFiles   []string
+	parameterFiles []string
+	resourceFiles  []string
+}
+
+func (e *EventlogMessages) Run(p pluginlib.Plugin, out pluginlib.LineWriter) error {
+	store, teardown, err := getForensicStore(p)
+	if err != nil {
+		return err
+	}
+	defer teardown()
+
+	providers, err := eventlogProviders(store)
+	if err != nil {
+		return err
+	}
+
+	files, err := messageFiles(store, providers)
+	if err != nil {
+		return err
+	}
+
+	tables := map[string]map[uint32]string{}
+	table := func(name string) map[uint32]string {
+		name = strings.ToLower(name)
+		if messages, ok := tables[name]; ok {
+			return messages
+		}
+		messages := map[uint32]string{}
+		// messages of mui files overwrite the messages of the dll
+		for _, file := range append(files[name], files[name+".mui"]...) {
+			fileMessages, err := fileMessageTable(store, file.String())
+			if err != nil {
+				if !errors.Is(err, errNoResource) {
+					pluginlib.Warn(out, file.String(), fmt.Errorf("could not read message table: %w", err))
+				}
+				continue
+			}
+			for id, message := range fileMessages {
+				messages[id] = message
+			}
+		}
+		tables[name] = messages
+		return messages
+	}
+
+	templates := map[string]map[string]map[uint32]uint32{}
+	template := func(name string) map[string]map[uint32]uint32 {
+		name = strings.ToLower(name)
+		if providerEvents, ok := templates[name]; ok {
+			return providerEvents
+		}
+		providerEvents := map[string]map[uint32]uint32{}
+		for _, file := range files[name] {
+			fileTemplates, err := fileEventTemplates(store, file)
+			if err != nil {
+				if !errors.Is(err, errNoResource) {
+					pluginlib.Warn(out, file.String(), fmt.Errorf("could not read event templates: %w", err))
+				}
+				continue
+			}
+			for guid, events := range fileTemplates {
+				providerEvents[guid] = events
+			}
+		}
+		templates[name] = providerEvents
+		return providerEvents
+	}
+
+	for _, provider := range providers {
+		var elements []EventlogMessage
+		if provider.guid != "" {
+			elements = manifestMessages(provider, table, template)
+		} else {
+			elements = providerMessages(provider, "event", provider.messageFiles, table)
+		}
+		elements = append(elements, providerMessages(provider, "parameter", provider.parameterFiles, table)...)
+		for _, element := range elements {
+			b, err := json.Marshal(element)
+			if err != nil {
+				return err
+			}
+			if err := out.WriteLine(b); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// providerMessages returns the messages of the message files of a provider.
+// The event ids of classic providers are the lower bits of the message ids.
+func providerMessages(provider *eventlogProvider, kind string, names []string, table func(string) map[uint32]string) []EventlogMessage {
+	var elements []EventlogMessage
+	seen := map[uint32]bool{}
+	for _, name := range names {
+		messages := table(name)
+		ids := make([]uint32, 0, len(messages))
+		for messageID := range messages {
+			ids = append(ids, messageID)
+		}
+		sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+		for _, messageID := range ids {
+			element := EventlogMessage{
+				Type:      "eventlog-message",
+				Provider:  provider.name,
+				GUID:      provider.guid,
+				Kind:      kind,
+				MessageID: messageID,
+				Message:   messages[messageID],
+			}
+			id := messageID
+			if kind == "event" {
+				// the upper bits contain severity and facility
+				id = messageID & 0xffff
+				element.EventID = id
+			} else {
+				element.ParameterID = id
+			}
+			if seen[id] {
+				continue
+			}
+			seen[id] = true
+			elements = append(elements, element)
+		}
+	}
+	return elements
+}
+
+// manifestMessages returns the event messages of a manifest based provider.
+// Events that are not found in the WEVT_TEMPLATE resources are left out, as
+// the lower bits of the message ids are not the event ids.
+func manifestMessages(provider *eventlogProvider, table func(string) map[uint32]string, template func(string) map[string]map[uint32]uint32) []EventlogMessage {
+	events := map[uint32]uint32{}
+	for _, name := range append(provider.resourceFiles, provider.messageFiles...) {
+		for eventID, messageID := range template(name)[catalogKey(provider.guid)] {
+			if _, ok := events[eventID]; !ok {
+				events[eventID] = messageID
+			}
+		}
+	}
+	ids := make([]uint32, 0, len(events))
+	for eventID := range events {
+		ids = append(ids, eventID)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	var elements []EventlogMessage
+	for _, eventID := range ids {
+		messageID := events[eventID]
+		if messageID == noMessage {
+			continue
+		}
+		for _, name := range provider.messageFiles {
+			if message, ok := table(name)[messageID]; ok {
+				elements = append(elements, EventlogMessage{
+					Type:      "eventlog-message",
+					Provider:  provider.name,
+					GUID:      provider.guid,
+					Kind:      "event",
+					EventID:   eventID,
+					MessageID: messageID,
+					Message:   message,
+				})
+				break
+			}
+		}
+	}
+	return elements
+}
+
+// eventlogProviders gets the eventlog providers and their message files from
+// the registry.
+func eventlogProviders(store *forensicstore.ForensicStore) ([]*eventlogProvider, error) {
+	providers := map[string]*eventlogProvider{}
+	provider := func(name string) *eventlogProvider {
+		key := strings.ToLower(name)
+		if _, ok := providers[key]; !ok {
+			providers[key] = &eventlogProvider{name: name}
+		}
+		return providers[key]
+	}
+
+	filter := pluginlib.Filter{
+		{"type": "windows-registry-key", "key": `%\Services\EventLog\%`},
+		{"type": "windows-registry-key", "key": `%\WINEVT\Publishers\%`},
+	}
+	err := pluginlib.ForEach(store, filter, func(element []byte) error {
+		key := gjson.GetBytes(element, "key").String()
+		values := map[string]string{}
+		for _, value := range gjson.GetBytes(element, "values").Array() {
+			values[strings.ToLower(value.Get("name").String())] = value.Get("data").String()
+		}
+
+		if match := eventlogServiceKey.FindStringSubmatch(key); match != nil {
+			p := provider(match[1])
+			p.messageFiles = append(p.messageFiles, splitMessageFiles(values["eventmessagefile"])...)
+			p.parameterFiles = append(p.parameterFiles, splitMessageFiles(values["parametermessagefile"])...)
+			if guid := values["providerguid"]; guid != "" {
+				p.guid = guid
+			}
+		} else if match := publisherKey.FindStringSubmatch(key); match != nil {
+			name := values[""]
+			if name == "" {
+				name = values["(default)"]
+			}
+			if name == "" {
+				name = match[1]
+			}
+			p := provider(name)
+			p.guid = match[1]
+			p.messageFiles = append(p.messageFiles, splitMessageFiles(values["messagefilename"])...)
+			p.parameterFiles = append(p.parameterFiles, splitMessageFiles(values["parameterfilename"])...)
+			p.resourceFiles = append(p.resourceFiles, splitMessageFiles(values["resourcefilename"])...)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var list []*eventlogProvider
+	for _, p := range providers {
+		list = append(list, p)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].name < list[j].name })
+	return list, nil
+}
+
+// splitMessageFiles returns the lower case file names of a list of message
+// files like "%SystemRoot%\system32\msobjs.dll;%SystemRoot%\system32\adtschema.dll".
+func splitMessageFiles(s string) []string {
+	var names []string
+	for _, file := range strings.Split(s, ";") {
+		file = strings.TrimSpace(strings.ReplaceAll(file, `\`, "/"))
+		if file != "" {
+			names = append(names, strings.ToLower(path.Base(file)))
+		}
+	}
+	return names
+}
+
+// messageFiles finds the export paths of the message files and their mui
+// files in the store.
+func messageFiles(store *forensicstore.ForensicStore, providers []*eventlogProvider) (map[string][]gjson.Result, error) {
+	names := map[string]bool{}
+	for _, provider := range providers {
+		for _, name := range append(append(provider.messageFiles, provider.parameterFiles...), provider.resourceFiles...) {
+			names[name] = true
+			names[name+".mui"] = true
+		}
+	}
+
+	files := map[string][]gjson.Result{}
+	if len(names) == 0 {
+		return files, nil
+	}
+	err := pluginlib.ForEach(store, pluginlib.Filter{{"type": "file"}}, func(element []byte) error {
+		name := strings.ToLower(gjson.GetBytes(element, "name").String())
+		exportPath := gjson.GetBytes(element, "export_path")
+		if names[name] && exportPath.String() != "" {
+			files[name] = append(files[name], exportPath)
+		}
+		return nil
+	})
+	return files, err
+}
+
+func fileMessageTable(store *forensicstore.ForensicStore, exportPath string) (map[uint32]string, error) {
+	file, teardown, err := store.LoadFile(exportPath)
+	if err != nil {
+		return nil, err
+	}
+	defer teardown() // nolint: errcheck
+
+	b, err := ioutil.ReadAll(file)
+	if err != nil {
+		return nil, err
+	}
+	return messageTable(bytes.NewReader(b))
+}
+
+func fileEventTemplates(store *forensicstore.ForensicStore, exportPath gjson.Result) (map[string]map[uint32]uint32, error) {
+	r, err := fileToReader(store, exportPath)
+	if err != nil {
+		return nil, err
+	}
+	return eventTemplates(r)
+}
+
+// messageCatalog renders eventlog messages from eventlog-message elements. It
+// implements evtx.MessageResolver.
+type messageCatalog struct {
+	messages   map[string]map[int]string
+	parameters map[string]map[int]string
+}
+
+func newMessageCatalog() *messageCatalog {
+	return &messageCatalog{messages: map[string]map[int]string{}, parameters: map[string]map[int]string{}}
+}
+
+// loadMessageCatalog loads the eventlog-message elements from the store and
+// the given catalog files. A catalog file is either a JSON array or contains
+// an element per line. It returns nil if no messages are found.
+func loadMessageCatalog(store *forensicstore.ForensicStore, paths []string) (*messageCatalog, error) {
+	catalog := newMessageCatalog()
+
+	err := pluginlib.ForEach(store, pluginlib.Filter{{"type": "eventlog-message"}}, func(element []byte) error {
+		catalog.add(element)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, p := range paths {
+		b, err := ioutil.ReadFile(p) // #nosec
+		if err != nil {
+			return nil, err
+		}
+		if trimmed := bytes.TrimSpace(b); len(trimmed) > 0 && trimmed[0] == '[' {
+			for _, element := range gjson.ParseBytes(trimmed).Array() {
+				catalog.add([]byte(element.Raw))
+			}
+			continue
+		}
+
+		scanner := bufio.NewScanner(bytes.NewReader(b))
+		scanner.Buffer(nil, 1<<24)
+		for scanner.Scan() {
+			catalog.add(scanner.Bytes())
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, fmt.Errorf("could not read %s: %w", p, err)
+		}
+	}
+
+	if catalog.len() == 0 {
+		return nil, nil
+	}
+	return catalog, nil
+}
+
+func (c *messageCatalog) add(element []byte) {
+	var message EventlogMessage
+	if err := json.Unmarshal(element, &message); err != nil || message.Type != "eventlog-message" {
+		return
+	}
+
+	target, id := c.messages, int(message.EventID)
+	if message.Kind == "parameter" {
+		target, id = c.parameters, int(message.ParameterID)
+	}
+	// parameters are also stored for all providers, as they are often
+	// shared, e.g. the parameters of the security auditing
+	for _, key := range []string{message.Provider, message.GUID, ""} {
+		key = catalogKey(key)
+		if key == "" && message.Kind != "parameter" {
+			continue
+		}
+		if _, ok := target[key]; !ok {
+			target[key] = map[int]string{}
+		}
+		if _, ok := target[key][id]; !ok {
+			target[key][id] = message.Message
+		}
+	}
+}
+
+// catalogKey normalizes provider names and guids, the guids in the registry
+// are enclosed in braces, the guids in the events are not.
+func catalogKey(provider string) string {
+	return strings.ToLower(strings.Trim(provider, "{}"))
+}
+
+func (c *messageCatalog) len() int {
+	return len(c.messages) + len(c.parameters)
+}
+
+func (c *messageCatalog) GetMessage(provider, _ string, eventID int) string {
+	return c.messages[catalogKey(provider)][eventID]
+}
+
+func (c *messageCatalog) GetParameter(provider, _ string, parameterID int) string {
+	if parameter, ok := c.parameters[catalogKey(provider)][parameterID]; ok {
+		return parameter
+	}
+	if parameter, ok := c.parameters[""][parameterID]; ok {
+		return parameter
+	}
+	// keep the unresolved insertion string
+	return fmt.Sprintf("%%%%%d", parameterID)
+}
+
+func (c *messageCatalog) Close() {}
@@ -0,0 +1,208 @@
+// Copyright (c) 2020 Siemens AG
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+//
+// Author(s): Jonas Plum
+
+package builtin
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"testing"
+	"unicode/utf16"
+
+	"github.com/Velocidex/ordereddict"
+	"www.velocidex.com/golang/evtx"
+)
+
+func TestMessageCatalog(t *testing.T) {
+	catalog := newMessageCatalog()
+	for _, element := range []string{
+		`{"type": "eventlog-message", "provider": "Microsoft-Windows-Security-Auditing", "guid": "{54849625-5478-4994-A5BA-3E3B0328C30D}", "kind": "event", "event_id": 4624, "message": "An account was successfully logged on.%n%tAccount Name:%t%1%n%tImpersonation Level:%t%2"}`,
+		`{"type": "eventlog-message", "provider": "Microsoft-Windows-Security-Auditing", "kind": "parameter", "parameter_id": 1833, "message": "Impersonation"}`,
+		`{"type": "file", "name": "foo"}`,
+	} {
+		catalog.add([]byte(element))
+	}
+
+	newEvent := func(provider, guid string, impersonation string) *ordereddict.Dict {
+		return ordereddict.NewDict().
+			Set("System", ordereddict.NewDict().
+				Set("Provider", ordereddict.NewDict().Set("Name", provider).Set("Guid", guid)).
+				Set("EventID", ordereddict.NewDict().Set("Value", 4624)).
+				Set("Channel", "Security")).
+			Set("EventData", ordereddict.NewDict().
+				Set("TargetUserName", "user").
+				Set("ImpersonationLevel", impersonation))
+	}
+
+	tests := []struct {
+		name  string
+		event *ordereddict.Dict
+		want  string
+	}{
+		{"by name", newEvent("Microsoft-Windows-Security-Auditing", "", "%%1833"), "An account was successfully logged on.\n\tAccount Name:\tuser\n\tImpersonation Level:\tImpersonation"},
+		{"by guid", newEvent("Other", "54849625-5478-4994-a5ba-3e3b0328c30d", "%%1833"), "An account was successfully logged on.\n\tAccount Name:\tuser\n\tImpersonation Level:\tImpersonation"},
+		{"unknown parameter", newEvent("Microsoft-Windows-Security-Auditing", "", "%%1"), "An account was successfully logged on.\n\tAccount Name:\tuser\n\tImpersonation Level:\t%%1"},
+		{"unknown provider", newEvent("Other", "", "%%1833"), ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := evtx.ExpandMessage(tt.event, catalog); got != tt.want {
+				t.Errorf("ExpandMessage() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseMessageTable(t *testing.T) {
+	write := func(buf *bytes.Buffer, v interface{}) {
+		if err := binary.Write(buf, binary.LittleEndian, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	entry := func(s string, unicode bool) []byte {
+		text := &bytes.Buffer{}
+		var flags uint16
+		if unicode {
+			flags = 1
+			write(text, utf16.Encode([]rune(s+"\r\n\x00")))
+		} else {
+			text.WriteString(s + "\r\n\x00")
+		}
+		b := &bytes.Buffer{}
+		write(b, uint16(text.Len()+4))
+		write(b, flags)
+		b.Write(text.Bytes())
+		return b.Bytes()
+	}
+
+	// two blocks: 0x10-0x11 and 0x40000100
+	var entries []byte
+	entries = append(entries, entry("first", true)...)
+	entries = append(entries, entry("second", false)...)
+	secondBlock := len(entries)
+	entries = append(entries, entry("third", true)...)
+
+	header := &bytes.Buffer{}
+	offset := uint32(4 + 2*12)
+	write(header, []uint32{2, 0x10, 0x11, offset, 0x40000100, 0x40000100, offset + uint32(secondBlock)})
+
+	got, err := parseMessageTable(append(header.Bytes(), entries...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[uint32]string{0x10: "first", 0x11: "second", 0x40000100: "third"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseMessageTable() = %v, want %v", got, want)
+	}
+
+	if _, err := parseMessageTable(header.Bytes()); err == nil {
+		t.Error("parseMessageTable() expected error for missing entries")
+	}
+}
+
+func TestParseWEVTTemplate(t *testing.T) {
+	write := func(buf *bytes.Buffer, v interface{}) {
+		if err := binary.Write(buf, binary.LittleEndian, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	event := func(buf *bytes.Buffer, id uint16, version uint8, messageID uint32) {
+		write(buf, id)
+		write(buf, []uint8{version, 0, 0, 0})
+		write(buf, uint16(0))
+		write(buf, uint64(0))
+		write(buf, messageID)
+		write(buf, make([]uint32, 7))
+	}
+
+	// CRIM header, one provider, the WEVT provider with one element and the
+	// EVNT element
+	b := &bytes.Buffer{}
+	b.WriteString("CRIM")
+	write(b, []uint32{0, 0x00010003, 1})
+	// {555908d1-a6d7-4695-8e1e-26931d2012f4}
+	write(b, []uint32{0x555908d1, 0x4695a6d7, 0x93261e8e, 0xf412201d})
+	write(b, uint32(36))
+	b.WriteString("WEVT")
+	write(b, []uint32{0, noMessage, 1, 0, 64, 0})
+	b.WriteString("EVNT")
+	write(b, []uint32{0, 3, 0})
+	event(b, 7045, 0, 0x40001b85)
+	event(b, 7045, 1, 0x40001b86)
+	event(b, 100, 0, noMessage)
+
+	got, err := parseWEVTTemplate(b.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]map[uint32]uint32{"555908d1-a6d7-4695-8e1e-26931d2012f4": {7045: 0x40001b86, 100: noMessage}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseWEVTTemplate() = %v, want %v", got, want)
+	}
+
+	if _, err := parseWEVTTemplate(b.Bytes()[:40]); err == nil {
+		t.Error("parseWEVTTemplate() expected error for truncated provider")
+	}
+}
+
+func TestProviderMessages(t *testing.T) {
+	tables := map[string]map[uint32]string{
+		"netevent.dll": {0x40001b86: "A service was installed", 0xc0001b59: "The service terminated"},
+	}
+	table := func(name string) map[uint32]string { return tables[name] }
+	template := func(name string) map[string]map[uint32]uint32 {
+		if name != "services.exe" {
+			return nil
+		}
+		return map[string]map[uint32]uint32{"555908d1-a6d7-4695-8e1e-26931d2012f4": {7045: 0x40001b86, 100: noMessage}}
+	}
+
+	tests := []struct {
+		name     string
+		provider *eventlogProvider
+		want     map[uint32]string
+	}{
+		{"classic", &eventlogProvider{name: "Classic", messageFiles: []string{"netevent.dll"}},
+			map[uint32]string{7046: "A service was installed", 7001: "The service terminated"}},
+		{"manifest", &eventlogProvider{name: "Service Control Manager", guid: "{555908D1-A6D7-4695-8E1E-26931D2012F4}", messageFiles: []string{"netevent.dll"}, resourceFiles: []string{"services.exe"}},
+			map[uint32]string{7045: "A service was installed"}},
+		{"manifest without template", &eventlogProvider{name: "Other", guid: "{00000000-0000-0000-0000-000000000000}", messageFiles: []string{"netevent.dll"}},
+			map[uint32]string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var elements []EventlogMessage
+			if tt.provider.guid != "" {
+				elements = manifestMessages(tt.provider, table, template)
+			} else {
+				elements = providerMessages(tt.provider, "event", tt.provider.messageFiles, table)
+			}
+			got := map[uint32]string{}
+			for _, element := range elements {
+				got[element.EventID] = element.Message
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("messages = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,419 @@
+// Copyright (c) 2020 Siemens AG
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+//
+// Author(s): Jonas Plum
+
+package builtin
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"math"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Velocidex/ordereddict"
+	"github.com/tidwall/gjson"
+	"www.velocidex.com/golang/evtx"
+
+	"github.com/forensicanalysis/elementary/pluginlib"
+	"github.com/forensicanalysis/forensicstore"
+)
+
+var _ pluginlib.Plugin = &EventlogTampering{}
+
+// EventlogTampering detects cleared eventlogs, missing records, backwards
+// time jumps and inconsistent evtx chunks.
+type EventlogTampering struct {
+	parameter pluginlib.ParameterList
+}
+
+func (e *EventlogTampering) Name() string {
+	return "eventlog-tampering"
+}
+
+func (e *EventlogTampering) Short() string {
+	return "Detect eventlog clearing, record gaps, time jumps and chunk anomalies"
+}
+
+func (e *EventlogTampering) Parameter() pluginlib.ParameterList {
+	if e.parameter == nil {
+		e.parameter = pluginlib.ParameterList{
+			{Name: "forensicstore", Type: pluginlib.Path, Description: "forensicstore", Required: true, Argument: true},
+			Filter,
+			{Name: "tolerance", Type: pluginlib.String, Description: "ignore backwards time jumps up to this duration, e.g. 5s", Value: "0s", Required: false},
+		}
+	}
+	return e.parameter
+}
+
+func (e *EventlogTampering) Output() *pluginlib.Config {
+	return &pluginlib.Config{Header: []string{
+		"finding",
+		"channel",
+		"first_record",
+		"last_record",
+		"start_time",
+		"end_time",
+		"description",
+	}}
+}
+
+// EventlogFinding describes a range of eventlog records that indicates
+// tampering.
+type EventlogFinding struct {
+	Type        string            `json:"type"`
+	Finding     string            `json:"finding"`
+	Channel     string            `json:"channel,omitempty"`
+	FirstRecord uint64            `json:"first_record,omitempty"`
+	LastRecord  uint64            `json:"last_record,omitempty"`
+	StartTime   string            `json:"start_time,omitempty"`
+	EndTime     string            `json:"end_time,omitempty"`
+	User        string            `json:"user,omitempty"`
+	Description string            `json:"description"`
+	Techniques  []string          `json:"techniques,omitempty"`
+	Tactics     []string          `json:"tactics,omitempty"`
+	Origin      map[string]string `json:"origin"`
+}
+
+// Kinds of eventlog findings.
+const (
+	LogCleared      = "log-cleared"
+	RecordGap       = "record-gap"
+	DuplicateRecord = "duplicate-record"
+	TimeJump        = "time-jump"
+	HeaderAnomaly   = "header-anomaly"
+	ChunkAnomaly    = "chunk-anomaly"
+)
+
+func (e *EventlogTampering) Run(p pluginlib.Plugin, out pluginlib.LineWriter) error {
+	tolerance, err := time.ParseDuration(p.Parameter().StringValue("tolerance"))
+	if err != nil || tolerance < 0 {
+		return fmt.Errorf("invalid tolerance %s", p.Parameter().StringValue("tolerance"))
+	}
+
+	store, teardown, err := getForensicStore(p)
+	if err != nil {
+		return err
+	}
+	defer teardown()
+
+	filter := pluginlib.ExtractFilter(p.Parameter().GetStringArrayValue("filter"))
+	return eventlogTamperingFromStore(out, store, filter, tolerance)
+}
+
+func eventlogTamperingFromStore(out pluginlib.LineWriter, store *forensicstore.ForensicStore, filter pluginlib.Filter, tolerance time.Duration) error {
+	return forEachEventlog(out, store, filter, func(id, exportPath string, file io.Reader) error {
+		warn := func(err error) {
+			pluginlib.Warn(out, id, fmt.Errorf("%s: %w", exportPath, err))
+		}
+
+		br := bufio.NewReader(file)
+		signature, err := br.Peek(8)
+		if err != nil {
+			warn(fmt.Errorf("could not read signature: %w", err))
+			return nil
+		}
+
+		analysis := &eventlogAnalysis{origin: exportPath, tolerance: tolerance}
+		if err := parseEventlogRecords(exportPath, br, evtxOptions{Workers: runtime.NumCPU(), Render: isLogCleared}, analysis.add, warn); err != nil {
+			warn(fmt.Errorf("could not parse: %w", err))
+		}
+		findings := analysis.findings()
+
+		if string(signature) == evtx.EVTX_HEADER_MAGIC {
+			// the chunks are checked in a second pass over the file
+			chunkFile, chunkTeardown, err := store.LoadFile(exportPath)
+			if err != nil {
+				warn(fmt.Errorf("could not read: %w", err))
+			} else {
+				chunkFindings, err := evtxChunkFindings(chunkFile, analysis)
+				chunkTeardown() // nolint: errcheck
+				if err != nil {
+					warn(fmt.Errorf("could not check chunks: %w", err))
+				}
+				findings = append(findings, chunkFindings...)
+			}
+		}
+
+		for _, finding := range findings {
+			finding.Techniques, finding.Tactics = findingAttack(finding.Finding)
+			b, err := json.Marshal(finding)
+			if err != nil {
+				return err
+			}
+			if b, err = pluginlib.SetSource(b, id); err != nil {
+				return err
+			}
+			if err := out.WriteLine(b); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// eventlogAnalysis collects the record ids and times of a single eventlog
+// file.
+type eventlogAnalysis struct {
+	origin    string
+	tolerance time.Duration
+	channel   string
+	records   []eventlogRecord
+	cleared   []EventlogFinding
+}
+
+func (a *eventlogAnalysis) add(event evtxEvent) error {
+	if event.record.id == 0 {
+		// e.g. the error of an unsupported evtx version
+		return nil
+	}
+	a.records = append(a.records, event.record)
+	if a.channel == "" {
+		a.channel = event.channel
+	}
+	if event.data == nil {
+		return nil
+	}
+
+	if finding, ok := logCleared(event.data, event.record); ok {
+		finding.Origin = map[string]string{"path": a.origin}
+		a.cleared = append(a.cleared, finding)
+	}
+	return nil
+}
+
+// isLogCleared selects the events that logCleared checks, so only they are
+// rendered.
+func isLogCleared(event *ordereddict.Dict) bool {
+	provider, _ := ordereddict.GetString(event, "System.Provider.Name")
+	eventID, _ := ordereddict.GetInt(event, "System.EventID.Value")
+	if eventID == 1102 || eventID == 104 {
+		return strings.EqualFold(provider, "Microsoft-Windows-Eventlog")
+	}
+	return eventID == 517 && provider == "Security"
+}
+
+// logCleared detects the events written when an eventlog is cleared.
+func logCleared(event []byte, record eventlogRecord) (EventlogFinding, bool) {
+	provider := gjson.GetBytes(event, "System.Provider.Name").String()
+	channel := gjson.GetBytes(event, "System.Channel").String()
+	finding := EventlogFinding{
+		Type:        "eventlog-finding",
+		Finding:     LogCleared,
+		Channel:     channel,
+		FirstRecord: record.id,
+		LastRecord:  record.id,
+		StartTime:   formatEventTime(record.time),
+		EndTime:     formatEventTime(record.time),
+	}
+
+	switch eventID := gjson.GetBytes(event, "System.EventID.Value").Int(); {
+	case eventID == 1102 && strings.EqualFold(provider, "Microsoft-Windows-Eventlog"):
+		finding.User = userName(gjson.GetBytes(event, "UserData.LogFileCleared"))
+		finding.Description = "The Security log was cleared"
+	case eventID == 104 && strings.EqualFold(provider, "Microsoft-Windows-Eventlog"):
+		cleared := gjson.GetBytes(event, "UserData.LogFileCleared")
+		finding.User = userName(cleared)
+		finding.Description = fmt.Sprintf("The %s log was cleared", cleared.Get("Channel").String())
+	case eventID == 517 && provider == "Security":
+		// legacy evt files store the client user in the fourth and fifth string
+		data := gjson.GetBytes(event, "EventData.Data").Array()
+		if len(data) > 4 {
+			finding.User = data[4].String() + `\` + data[3].String()
+		}
+		finding.Description = "The Security log was cleared"
+	default:
+		return finding, false
+	}
+	return finding, true
+}
+
+func userName(data gjson.Result) string {
+	user := data.Get("SubjectUserName").String()
+	if domain := data.Get("SubjectDomainName").String(); domain != "" {
+		return domain + `\` + user
+	}
+	return user
+}
+
+// findings returns the cleared logs and the gaps, duplicates and backwards
+// time jumps in the record sequence.
+func (a *eventlogAnalysis) findings() []EventlogFinding {
+	findings := a.cleared
+
+	// chunks of a circular log are not necessarily stored in record order
+	records := append([]eventlogRecord{}, a.records...)
+	sort.SliceStable(records, func(i, j int) bool { return records[i].id < records[j].id })
+
+	for i := 1; i < len(records); i++ {
+		previous, record := records[i-1], records[i]
+		switch {
+		case record.id == previous.id:
+			findings = append(findings, a.finding(DuplicateRecord, previous, record,
+				fmt.Sprintf("Record %d exists multiple times", record.id)))
+		case record.id > previous.id+1:
+			missing := record.id - previous.id - 1
+			finding := a.finding(RecordGap, previous, record,
+				fmt.Sprintf("%d records are missing between record %d and %d", missing, previous.id, record.id))
+			finding.FirstRecord, finding.LastRecord = previous.id+1, record.id-1
+			findings = append(findings, finding)
+		}
+		if previous.time-record.time > a.tolerance.Seconds() {
+			findings = append(findings, a.finding(TimeJump, previous, record,
+				fmt.Sprintf("Time jumps back by %s from record %d to %d", eventDuration(previous.time-record.time), previous.id, record.id)))
+		}
+	}
+	return findings
+}
+
+func (a *eventlogAnalysis) finding(kind string, first, last eventlogRecord, description string) EventlogFinding {
+	return EventlogFinding{
+		Type:        "eventlog-finding",
+		Finding:     kind,
+		Channel:     a.channel,
+		FirstRecord: first.id,
+		LastRecord:  last.id,
+		StartTime:   formatEventTime(first.time),
+		EndTime:     formatEventTime(last.time),
+		Description: description,
+		Origin:      map[string]string{"path": a.origin},
+	}
+}
+
+func formatEventTime(t float64) string {
+	if t == 0 {
+		return ""
+	}
+	return unixTime(t).Format(time.RFC3339Nano)
+}
+
+func eventDuration(seconds float64) time.Duration {
+	return time.Duration(seconds * float64(time.Second)).Round(time.Millisecond)
+}
+
+const (
+	evtxFileFlagDirty   = 0x1
+	evtxChunkHeaderSize = 0x200
+)
+
+type evtxChunkRange struct {
+	offset      int64
+	first, last uint64
+}
+
+// evtxChunkFindings checks the checksums of the file header and the chunks
+// and the record ranges of the chunks. The header checksum covers the first
+// 120 bytes of the file, the chunk header checksum the first 120 and the bytes
+// 128 to 512 of the chunk and the record checksum the records up to the free
+// space offset.
+func evtxChunkFindings(r io.Reader, analysis *eventlogAnalysis) ([]EventlogFinding, error) { // nolint: gocyclo
+	var findings []EventlogFinding
+	anomaly := func(kind string, chunk *evtxChunkRange, description string) {
+		finding := EventlogFinding{
+			Type:        "eventlog-finding",
+			Finding:     kind,
+			Channel:     analysis.channel,
+			Description: description,
+			Origin:      map[string]string{"path": analysis.origin},
+		}
+		if chunk != nil {
+			finding.FirstRecord, finding.LastRecord = chunk.first, chunk.last
+		}
+		findings = append(findings, finding)
+	}
+
+	header := make([]byte, 128)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	flags := binary.LittleEndian.Uint32(header[120:])
+	if crc32.ChecksumIEEE(header[:120]) != binary.LittleEndian.Uint32(header[124:]) {
+		anomaly(HeaderAnomaly, nil, "The checksum of the file header does not match")
+	}
+	headerBlockSize := int64(binary.LittleEndian.Uint16(header[40:]))
+	if _, err := io.CopyN(ioutil.Discard, r, headerBlockSize-int64(len(header))); err != nil {
+		return findings, err
+	}
+
+	var chunks []evtxChunkRange
+	data := make([]byte, evtx.EVTX_CHUNK_SIZE)
+	for offset := headerBlockSize; ; offset += evtx.EVTX_CHUNK_SIZE {
+		if _, err := io.ReadFull(r, data); err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				break
+			}
+			return findings, err
+		}
+		if string(data[:8]) != evtx.EVTX_CHUNK_HEADER_MAGIC {
+			continue
+		}
+		chunk := evtxChunkRange{
+			offset: offset,
+			first:  binary.LittleEndian.Uint64(data[24:]),
+			last:   binary.LittleEndian.Uint64(data[32:]),
+		}
+		if chunk.last == math.MaxUint64 {
+			// unused chunk
+			continue
+		}
+
+		headerChecksum := crc32.NewIEEE()
+		headerChecksum.Write(data[:120])                    // nolint: errcheck
+		headerChecksum.Write(data[128:evtxChunkHeaderSize]) // nolint: errcheck
+		if headerChecksum.Sum32() != binary.LittleEndian.Uint32(data[124:]) {
+			anomaly(ChunkAnomaly, &chunk, fmt.Sprintf("The header checksum of the chunk at offset %d does not match", offset))
+		}
+		freeSpace := int(binary.LittleEndian.Uint32(data[48:]))
+		if freeSpace < evtxChunkHeaderSize || freeSpace > len(data) {
+			anomaly(ChunkAnomaly, &chunk, fmt.Sprintf("The chunk at offset %d has an invalid free space offset %d", offset, freeSpace))
+		} else if crc32.ChecksumIEEE(data[evtxChunkHeaderSize:freeSpace]) != binary.LittleEndian.Uint32(data[52:]) {
+			anomaly(ChunkAnomaly, &chunk, fmt.Sprintf("The record checksum of the chunk at offset %d does not match", offset))
+		}
+		firstNumber, lastNumber := binary.LittleEndian.Uint64(data[8:]), binary.LittleEndian.Uint64(data[16:])
+		if chunk.last < chunk.first || lastNumber-firstNumber != chunk.last-chunk.first {
+			anomaly(ChunkAnomaly, &chunk, fmt.Sprintf("The chunk at offset %d has inconsistent record numbers", offset))
+		}
+		chunks = append(chunks, chunk)
+	}
+
+	sort.SliceStable(chunks, func(i, j int) bool { return chunks[i].first < chunks[j].first })
+	for i := 1; i < len(chunks); i++ {
+		if chunks[i].first <= chunks[i-1].last {
+			anomaly(ChunkAnomaly, &chunks[i], fmt.Sprintf("The records of the chunks at offset %d and %d overlap", chunks[i-1].offset, chunks[i].offset))
+		}
+	}
+
+	// the header of files that were not closed properly is not up to date
+	if len(chunks) > 0 && flags&evtxFileFlagDirty == 0 {
+		next := binary.LittleEndian.Uint64(header[24:])
+		if last := chunks[len(chunks)-1].last; next <= last {
+			anomaly(HeaderAnomaly, nil, fmt.Sprintf("The next record %d of the file header is not after the last record %d", next, last))
+		}
+	}
+	return findings, nil
+}
@@ -0,0 +1,166 @@
+// Copyright (c) 2020 Siemens AG
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+//
+// Author(s): Jonas Plum
+
+package builtin
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"testing"
+	"time"
+
+	"github.com/Velocidex/ordereddict"
+	"www.velocidex.com/golang/evtx"
+)
+
+func TestEventlogAnalysis(t *testing.T) {
+	event := func(id uint64, time float64) evtxEvent {
+		return evtxEvent{record: eventlogRecord{id: id, time: time}, channel: "System"}
+	}
+	cleared := evtxEvent{
+		record:  eventlogRecord{id: 4, time: 1004},
+		channel: "System",
+		data:    []byte(`{"System": {"Channel": "System", "EventRecordID": 4, "TimeCreated": {"SystemTime": 1004}, "EventID": {"Value": 104}, "Provider": {"Name": "Microsoft-Windows-Eventlog"}}, "UserData": {"LogFileCleared": {"SubjectUserName": "admin", "SubjectDomainName": "PC", "Channel": "Application"}}}`),
+	}
+
+	tests := []struct {
+		name   string
+		events []evtxEvent
+		want   []string
+	}{
+		{"no findings", []evtxEvent{event(1, 1000), event(2, 1001), event(3, 1002)}, nil},
+		{"unordered chunks", []evtxEvent{event(3, 1002), event(1, 1000), event(2, 1001)}, nil},
+		{"gap", []evtxEvent{event(1, 1000), event(5, 1005)}, []string{RecordGap}},
+		{"duplicate", []evtxEvent{event(1, 1000), event(1, 1000)}, []string{DuplicateRecord}},
+		{"time jump", []evtxEvent{event(1, 1000), event(2, 900)}, []string{TimeJump}},
+		{"within tolerance", []evtxEvent{event(1, 1000), event(2, 999.5)}, nil},
+		{"cleared", []evtxEvent{event(3, 1003), cleared}, []string{LogCleared}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			analysis := &eventlogAnalysis{origin: "System.evtx", tolerance: time.Second}
+			for _, e := range tt.events {
+				if err := analysis.add(e); err != nil {
+					t.Fatal(err)
+				}
+			}
+			findings := analysis.findings()
+			if len(findings) != len(tt.want) {
+				t.Fatalf("findings = %+v, want %v", findings, tt.want)
+			}
+			for i, finding := range findings {
+				if finding.Finding != tt.want[i] {
+					t.Errorf("finding = %s, want %s", finding.Finding, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestIsLogCleared(t *testing.T) {
+	event := func(provider string, eventID int) *ordereddict.Dict {
+		return ordereddict.NewDict().Set("System", ordereddict.NewDict().
+			Set("Provider", ordereddict.NewDict().Set("Name", provider)).
+			Set("EventID", ordereddict.NewDict().Set("Value", eventID)))
+	}
+	tests := []struct {
+		event *ordereddict.Dict
+		want  bool
+	}{
+		{event("Microsoft-Windows-Eventlog", 1102), true},
+		{event("Microsoft-Windows-EventLog", 104), true},
+		{event("Security", 517), true},
+		{event("Service Control Manager", 104), false},
+		{event("Microsoft-Windows-Eventlog", 7036), false},
+	}
+	for _, tt := range tests {
+		if got := isLogCleared(tt.event); got != tt.want {
+			t.Errorf("isLogCleared(%v) = %v, want %v", tt.event, got, tt.want)
+		}
+	}
+}
+
+func TestEventlogAnalysis_Gap(t *testing.T) {
+	analysis := &eventlogAnalysis{origin: "System.evtx"}
+	for _, e := range []evtxEvent{
+		{record: eventlogRecord{id: 10, time: 1000}, channel: "System"},
+		{record: eventlogRecord{id: 20, time: 2000}, channel: "System"},
+	} {
+		analysis.add(e) // nolint: errcheck
+	}
+	findings := analysis.findings()
+	if len(findings) != 1 {
+		t.Fatalf("findings = %+v", findings)
+	}
+	finding := findings[0]
+	if finding.FirstRecord != 11 || finding.LastRecord != 19 || finding.Channel != "System" ||
+		finding.StartTime != "1970-01-01T00:16:40Z" || finding.EndTime != "1970-01-01T00:33:20Z" {
+		t.Errorf("finding = %+v", finding)
+	}
+}
+
+func evtxTestChunk(first, last uint64, valid bool) []byte {
+	chunk := make([]byte, evtx.EVTX_CHUNK_SIZE)
+	copy(chunk, evtx.EVTX_CHUNK_HEADER_MAGIC)
+	binary.LittleEndian.PutUint64(chunk[8:], first)
+	binary.LittleEndian.PutUint64(chunk[16:], last)
+	binary.LittleEndian.PutUint64(chunk[24:], first)
+	binary.LittleEndian.PutUint64(chunk[32:], last)
+	binary.LittleEndian.PutUint32(chunk[48:], evtxChunkHeaderSize)
+	binary.LittleEndian.PutUint32(chunk[52:], crc32.ChecksumIEEE(nil))
+
+	checksum := crc32.NewIEEE()
+	checksum.Write(chunk[:120])                    // nolint: errcheck
+	checksum.Write(chunk[128:evtxChunkHeaderSize]) // nolint: errcheck
+	binary.LittleEndian.PutUint32(chunk[124:], checksum.Sum32())
+	if !valid {
+		chunk[130] = 0xff
+	}
+	return chunk
+}
+
+func TestEvtxChunkFindings(t *testing.T) {
+	tests := []struct {
+		name   string
+		chunks [][]byte
+		want   int
+	}{
+		{"valid", [][]byte{evtxTestChunk(1, 10, true), evtxTestChunk(11, 20, true)}, 0},
+		{"checksum", [][]byte{evtxTestChunk(1, 10, true), evtxTestChunk(11, 20, false)}, 1},
+		{"overlap", [][]byte{evtxTestChunk(1, 10, true), evtxTestChunk(5, 20, true)}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := evtxFile(t, 3, tt.chunks...)
+			binary.LittleEndian.PutUint64(data[24:], 21)
+			binary.LittleEndian.PutUint32(data[124:], crc32.ChecksumIEEE(data[:120]))
+
+			findings, err := evtxChunkFindings(bytes.NewReader(data), &eventlogAnalysis{origin: "System.evtx"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(findings) != tt.want {
+				t.Errorf("findings = %+v, want %d", findings, tt.want)
+			}
+		})
+	}
+}
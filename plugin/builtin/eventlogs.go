@@ -22,10 +22,12 @@
 package builtin
 
 import (
-	"encoding/json"
+	"bufio"
+	"errors"
+	"fmt"
 	"io"
+	"runtime"
 
-	"github.com/Velocidex/ordereddict"
 	"github.com/tidwall/gjson"
 	"www.velocidex.com/golang/evtx"
 
@@ -52,7 +54,9 @@ func (e *Eventlogs) Parameter() pluginlib.ParameterList {
 		e.parameter = pluginlib.ParameterList{
 			{Name: "forensicstore", Type: pluginlib.Path, Description: "forensicstore", Required: true, Argument: true},
 			Filter,
+			{Name: "messages", Type: pluginlib.PathArray, Description: "message catalogs used to render eventlog messages", Required: false},
 		}
+		e.parameter = append(e.parameter, eventFilterParameter()...)
 	}
 	return e.parameter
 }
@@ -76,88 +80,97 @@ func (e *Eventlogs) Run(p pluginlib.Plugin, out pluginlib.LineWriter) error {
 	}
 	defer teardown()
 
-	filter := pluginlib.ExtractFilter(p.Parameter().GetStringArrayValue("filter"))
-	return eventlogsFromStore(out, store, filter)
-}
-
-func eventlogsFromStore(out pluginlib.LineWriter, store *forensicstore.ForensicStore, filter pluginlib.Filter) error {
-	for idx := range filter {
-		filter[idx]["type"] = "file"
-		filter[idx]["name"] = "%.evtx"
+	options := evtxOptions{Workers: runtime.NumCPU()}
+	catalog, err := loadMessageCatalog(store, p.Parameter().GetStringArrayValue("messages"))
+	if err != nil {
+		return err
 	}
-
-	if len(filter) == 0 {
-		filter = pluginlib.Filter{{"type": "file", "name": "%.evtx"}}
+	if catalog != nil {
+		options.Resolver = catalog
 	}
-
-	fileElements, err := store.Select(filter)
-	if err != nil {
+	if options.Filter, err = newEventFilter(p.Parameter()); err != nil {
 		return err
 	}
 
-	for _, element := range fileElements {
-		exportPath := gjson.GetBytes(element, "export_path")
-		if exportPath.Exists() && exportPath.String() != "" {
-			r, err := fileToReader(store, exportPath)
-			if err != nil {
-				return err
-			}
-
-			events, err := getEvents(exportPath.String(), r)
-			if err != nil {
-				return err
-			}
+	filter := pluginlib.ExtractFilter(p.Parameter().GetStringArrayValue("filter"))
+	return eventlogsFromStore(out, store, filter, options)
+}
 
-			for _, event := range events {
-				out.WriteLine(event) // nolint: errcheck
+func eventlogsFromStore(out pluginlib.LineWriter, store *forensicstore.ForensicStore, filter pluginlib.Filter, options evtxOptions) error {
+	return forEachEventlog(out, store, filter, func(id, exportPath string, file io.Reader) error {
+		var writeErr error
+		err := parseEventlog(exportPath, file, options, func(event []byte) error {
+			if event, writeErr = pluginlib.SetSource(event, id); writeErr != nil {
+				return writeErr
 			}
+			writeErr = out.WriteLine(event)
+			return writeErr
+		}, func(err error) {
+			pluginlib.Warn(out, id, fmt.Errorf("%s: %w", exportPath, err))
+		})
+		if writeErr != nil {
+			return writeErr
 		}
-	}
-	return nil
+		if err != nil {
+			pluginlib.Warn(out, id, fmt.Errorf("could not parse %s: %w", exportPath, err))
+		}
+		return nil
+	})
 }
 
-func getEvents(originPath string, file io.ReadSeeker) ([]forensicstore.JSONElement, error) {
-	var elements []forensicstore.JSONElement
+// forEachEventlog calls fn for every evtx and evt file in the store with the
+// element id, the export path and the content of the file.
+func forEachEventlog(out pluginlib.LineWriter, store *forensicstore.ForensicStore, filter pluginlib.Filter, fn func(id, exportPath string, file io.Reader) error) error {
+	for idx := range filter {
+		filter[idx]["type"] = "file"
+		filter[idx]["name"] = "%.evt%"
+	}
 
-	chunks, err := evtx.GetChunks(file)
-	if err != nil && err.Error() == "Unsupported EVTX version." {
-		evtxVersionError, _ := json.Marshal(map[string]interface{}{
-			"origin": map[string]string{"path": originPath},
-			"type":   "eventlog",
-			"errors": []string{err.Error()},
-		})
-		return []forensicstore.JSONElement{evtxVersionError}, nil
-	} else if err != nil {
-		return nil, err
+	// matches evtx and legacy evt files, the parser is selected by signature
+	if len(filter) == 0 {
+		filter = pluginlib.Filter{{"type": "file", "name": "%.evt%"}}
 	}
 
-	for _, chunk := range chunks {
-		records, err := chunk.Parse(int(chunk.Header.FirstEventRecID))
-		if err != nil {
-			return nil, err
+	return pluginlib.ForEach(store, filter, func(element []byte) error {
+		exportPath := gjson.GetBytes(element, "export_path")
+		if !exportPath.Exists() || exportPath.String() == "" {
+			return nil
 		}
+		id := gjson.GetBytes(element, "id").String()
 
-		for _, i := range records {
-			eventMap, ok := i.Event.(*ordereddict.Dict)
-			if ok {
-				event, ok := ordereddict.GetMap(eventMap, "Event")
-				if !ok {
-					continue
-				}
+		file, teardown, err := store.LoadFile(exportPath.String())
+		if err != nil {
+			pluginlib.Warn(out, id, fmt.Errorf("could not read %s: %w", exportPath.String(), err))
+			return nil
+		}
+		defer teardown() // nolint: errcheck
 
-				event.Set("type", "eventlog")
-				event.Set("origin", map[string]string{"path": originPath})
-				// self.maybeExpandMessage(event)
+		return fn(id, exportPath.String(), file)
+	})
+}
 
-				serialized, err := json.Marshal(event)
-				if err != nil {
-					return nil, err
-				}
+// parseEventlog selects the evtx or the legacy evt parser by the file
+// signature.
+func parseEventlog(originPath string, r io.Reader, options evtxOptions, fn func(event []byte) error, warn func(error)) error {
+	return parseEventlogRecords(originPath, r, options, func(event evtxEvent) error {
+		return fn(event.data)
+	}, warn)
+}
 
-				elements = append(elements, serialized)
-			}
-		}
+// parseEventlogRecords is like parseEventlog, but passes the records as well
+// as the events selected by options.Render.
+func parseEventlogRecords(originPath string, r io.Reader, options evtxOptions, fn func(event evtxEvent) error, warn func(error)) error {
+	br := bufio.NewReader(r)
+	signature, err := br.Peek(8)
+	if err != nil {
+		return fmt.Errorf("could not read signature: %w", err)
+	}
+	switch {
+	case string(signature) == evtx.EVTX_HEADER_MAGIC:
+		return parseEvtx(originPath, br, options, fn, warn)
+	case string(signature[4:]) == evtSignature:
+		return parseEvt(originPath, br, options, fn, warn)
+	default:
+		return errors.New("unknown eventlog format")
 	}
-
-	return elements, nil
 }
@@ -0,0 +1,320 @@
+// Copyright (c) 2020 Siemens AG
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+//
+// Author(s): Jonas Plum
+
+package builtin
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/Velocidex/ordereddict"
+	"www.velocidex.com/golang/evtx"
+)
+
+// evtSignature is the signature of the header and the records of the legacy
+// Windows eventlog format used up to Windows XP and Server 2003.
+const evtSignature = "LfLe"
+
+const (
+	evtHeaderSize       = 0x30
+	evtRecordHeaderSize = 0x38
+	evtEOFRecordSize    = 0x28
+)
+
+type evtHeader struct {
+	HeaderSize          uint32
+	Signature           [4]byte
+	MajorVersion        uint32
+	MinorVersion        uint32
+	StartOffset         uint32
+	EndOffset           uint32
+	CurrentRecordNumber uint32
+	OldestRecordNumber  uint32
+	MaxSize             uint32
+	Flags               uint32
+	Retention           uint32
+	EndHeaderSize       uint32
+}
+
+type evtRecordHeader struct {
+	Length              uint32
+	Signature           [4]byte
+	RecordNumber        uint32
+	TimeGenerated       uint32
+	TimeWritten         uint32
+	EventID             uint32
+	EventType           uint16
+	NumStrings          uint16
+	EventCategory       uint16
+	ReservedFlags       uint16
+	ClosingRecordNumber uint32
+	StringOffset        uint32
+	UserSidLength       uint32
+	UserSidOffset       uint32
+	DataLength          uint32
+	DataOffset          uint32
+}
+
+var evtChannels = map[string]string{
+	"appevent": "Application",
+	"secevent": "Security",
+	"sysevent": "System",
+}
+
+// parseEvt parses a legacy evt file and passes the events in the same layout
+// as evtx events to fn. Corrupt records are passed to warn and skipped.
+func parseEvt(originPath string, r io.Reader, options evtxOptions, fn func(event evtxEvent) error, warn func(error)) error {
+	// evt files are small ring buffers, so they are read completely
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	var header evtHeader
+	if err := binary.Read(bytes.NewReader(data), binary.LittleEndian, &header); err != nil {
+		return fmt.Errorf("could not read header: %w", err)
+	}
+	if string(header.Signature[:]) != evtSignature {
+		return errors.New("file is not an evt file")
+	}
+
+	records := evtRecords(data, header)
+	channel := evtChannel(originPath)
+
+	for offset := 0; offset+evtRecordHeaderSize <= len(records); {
+		length := int(binary.LittleEndian.Uint32(records[offset:]))
+		if string(records[offset+4:offset+8]) != evtSignature || length < evtRecordHeaderSize || offset+length > len(records) {
+			if length == evtEOFRecordSize && binary.LittleEndian.Uint32(records[offset+4:]) == 0x11111111 {
+				break
+			}
+			next := nextEvtRecord(records, offset+1)
+			warn(fmt.Errorf("invalid record at offset %d, skipped %d bytes", offset, next-offset))
+			offset = next
+			continue
+		}
+
+		record := records[offset : offset+length]
+		event, err := parseEvtRecord(record, channel)
+		if err != nil {
+			warn(fmt.Errorf("could not parse record at offset %d: %w", offset, err))
+			offset += length
+			continue
+		}
+		offset += length
+
+		if !options.Filter.match(event) {
+			continue
+		}
+		e := evtxEvent{
+			record: eventlogRecord{
+				id:   uint64(binary.LittleEndian.Uint32(record[8:])),
+				time: float64(binary.LittleEndian.Uint32(record[12:])),
+			},
+			channel: channel,
+		}
+		if options.Render == nil || options.Render(event) {
+			if options.Resolver != nil {
+				if message := evtx.ExpandMessage(event, options.Resolver); message != "" {
+					event.Set("Message", message)
+				}
+			}
+			event.Set("type", "eventlog")
+			event.Set("origin", map[string]string{"path": originPath})
+
+			if e.data, err = json.Marshal(event); err != nil {
+				return err
+			}
+		}
+		if err := fn(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// evtRecords returns the records of the ring buffer in the order they were
+// written.
+func evtRecords(data []byte, header evtHeader) []byte {
+	start, end := int(header.StartOffset), int(header.EndOffset)
+	if start < evtHeaderSize || start > len(data) || end < evtHeaderSize || end > len(data) {
+		// corrupt header, the records are searched in the whole file
+		return data[evtHeaderSize:]
+	}
+	if start <= end {
+		return data[start:end]
+	}
+	// the records wrap around at the end of the file
+	records := append([]byte{}, data[start:]...)
+	return append(records, data[evtHeaderSize:end]...)
+}
+
+// nextEvtRecord finds the next record that starts at or after offset.
+func nextEvtRecord(records []byte, offset int) int {
+	if offset+4 >= len(records) {
+		return len(records)
+	}
+	i := bytes.Index(records[offset+4:], []byte(evtSignature))
+	if i < 0 {
+		return len(records)
+	}
+	return offset + i
+}
+
+func evtChannel(originPath string) string {
+	name := strings.ToLower(path.Base(strings.ReplaceAll(originPath, `\`, "/")))
+	name = strings.TrimSuffix(name, path.Ext(name))
+	if channel, ok := evtChannels[name]; ok {
+		return channel
+	}
+	return name
+}
+
+func parseEvtRecord(record []byte, channel string) (*ordereddict.Dict, error) {
+	var header evtRecordHeader
+	if err := binary.Read(bytes.NewReader(record), binary.LittleEndian, &header); err != nil {
+		return nil, err
+	}
+
+	source, n := utf16String(record[evtRecordHeaderSize:])
+	computer, _ := utf16String(record[evtRecordHeaderSize+n:])
+
+	system := ordereddict.NewDict().
+		Set("Provider", ordereddict.NewDict().Set("Name", source)).
+		Set("EventID", ordereddict.NewDict().
+			Set("Qualifiers", header.EventID>>16).
+			Set("Value", header.EventID&0xffff)).
+		Set("Level", evtLevel(header.EventType))
+	if keywords := evtKeywords(header.EventType); keywords != 0 {
+		system.Set("Keywords", keywords)
+	}
+	system.
+		Set("Task", header.EventCategory).
+		Set("TimeCreated", ordereddict.NewDict().Set("SystemTime", float64(header.TimeGenerated))).
+		Set("EventRecordID", uint64(header.RecordNumber)).
+		Set("Channel", channel).
+		Set("Computer", computer)
+
+	security := ordereddict.NewDict()
+	if header.UserSidLength > 0 {
+		sid, err := evtSection(record, header.UserSidOffset, header.UserSidLength)
+		if err != nil {
+			return nil, fmt.Errorf("invalid sid: %w", err)
+		}
+		security.Set("UserID", sidString(sid))
+	}
+	system.Set("Security", security)
+
+	eventData := ordereddict.NewDict()
+	var strs []string
+	if header.NumStrings > 0 {
+		if int(header.StringOffset) > len(record) {
+			return nil, errors.New("invalid string offset")
+		}
+		rest := record[header.StringOffset:]
+		for i := 0; i < int(header.NumStrings); i++ {
+			s, n := utf16String(rest)
+			strs = append(strs, s)
+			rest = rest[n:]
+		}
+	}
+	eventData.Set("Data", strs)
+	if header.DataLength > 0 {
+		data, err := evtSection(record, header.DataOffset, header.DataLength)
+		if err != nil {
+			return nil, fmt.Errorf("invalid data: %w", err)
+		}
+		eventData.Set("Binary", strings.ToUpper(hex.EncodeToString(data)))
+	}
+
+	return ordereddict.NewDict().Set("System", system).Set("EventData", eventData), nil
+}
+
+func evtSection(record []byte, offset, length uint32) ([]byte, error) {
+	if uint64(offset)+uint64(length) > uint64(len(record)) {
+		return nil, fmt.Errorf("%d bytes at %d exceed record", length, offset)
+	}
+	return record[offset : offset+length], nil
+}
+
+// evtLevel maps the event type to the level used in evtx files.
+func evtLevel(eventType uint16) int {
+	switch eventType {
+	case 0x1: // error
+		return 2
+	case 0x2: // warning
+		return 3
+	case 0x4: // information
+		return 4
+	default: // success and failure audits
+		return 0
+	}
+}
+
+func evtKeywords(eventType uint16) uint64 {
+	switch eventType {
+	case 0x8: // audit success
+		return 0x8020000000000000
+	case 0x10: // audit failure
+		return 0x8010000000000000
+	default:
+		return 0
+	}
+}
+
+// utf16String reads a null terminated UTF-16LE string and returns the string
+// and the number of consumed bytes.
+func utf16String(b []byte) (string, int) {
+	var u []uint16
+	i := 0
+	for ; i+1 < len(b); i += 2 {
+		c := binary.LittleEndian.Uint16(b[i:])
+		if c == 0 {
+			return string(utf16.Decode(u)), i + 2
+		}
+		u = append(u, c)
+	}
+	return string(utf16.Decode(u)), len(b)
+}
+
+// sidString formats a binary security identifier, e.g. S-1-5-18.
+func sidString(b []byte) string {
+	if len(b) < 8 {
+		return hex.EncodeToString(b)
+	}
+	var authority uint64
+	for _, c := range b[2:8] {
+		authority = authority<<8 | uint64(c)
+	}
+	sid := fmt.Sprintf("S-%d-%d", b[0], authority)
+	for i := 0; i < int(b[1]) && 8+i*4+4 <= len(b); i++ {
+		sid += fmt.Sprintf("-%d", binary.LittleEndian.Uint32(b[8+i*4:]))
+	}
+	return sid
+}
@@ -0,0 +1,199 @@
+// Copyright (c) 2020 Siemens AG
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+//
+// Author(s): Jonas Plum
+
+package builtin
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"unicode/utf16"
+
+	"github.com/tidwall/gjson"
+)
+
+func evtString(s string) []byte {
+	buf := &bytes.Buffer{}
+	binary.Write(buf, binary.LittleEndian, append(utf16.Encode([]rune(s)), 0)) // nolint: errcheck
+	return buf.Bytes()
+}
+
+func evtRecord(number, eventID uint32, eventType uint16, strs ...string) []byte {
+	variable := &bytes.Buffer{}
+	variable.Write(evtString("Service Control Manager"))
+	variable.Write(evtString("PC"))
+	sidOffset := evtRecordHeaderSize + variable.Len()
+	variable.Write([]byte{1, 1, 0, 0, 0, 0, 0, 5, 18, 0, 0, 0}) // S-1-5-18
+	stringOffset := evtRecordHeaderSize + variable.Len()
+	for _, s := range strs {
+		variable.Write(evtString(s))
+	}
+	for variable.Len()%4 != 0 {
+		variable.WriteByte(0)
+	}
+
+	length := evtRecordHeaderSize + variable.Len() + 4
+	header := evtRecordHeader{
+		Length:        uint32(length),
+		RecordNumber:  number,
+		TimeGenerated: 1000000000 + number,
+		TimeWritten:   1000000000 + number,
+		EventID:       eventID,
+		EventType:     eventType,
+		NumStrings:    uint16(len(strs)),
+		StringOffset:  uint32(stringOffset),
+		UserSidLength: 12,
+		UserSidOffset: uint32(sidOffset),
+	}
+	copy(header.Signature[:], evtSignature)
+
+	buf := &bytes.Buffer{}
+	binary.Write(buf, binary.LittleEndian, header) // nolint: errcheck
+	buf.Write(variable.Bytes())
+	binary.Write(buf, binary.LittleEndian, uint32(length)) // nolint: errcheck
+	return buf.Bytes()
+}
+
+func evtEOFRecord() []byte {
+	buf := &bytes.Buffer{}
+	binary.Write(buf, binary.LittleEndian, []uint32{ // nolint: errcheck
+		evtEOFRecordSize, 0x11111111, 0x22222222, 0x33333333, 0x44444444, 0, 0, 0, 0, evtEOFRecordSize,
+	})
+	return buf.Bytes()
+}
+
+// evtFile creates an evt file, the records are written to the ring buffer
+// starting at offset.
+func evtFile(offset int, records ...[]byte) []byte {
+	content := bytes.Join(append(records, evtEOFRecord()), nil)
+
+	size := evtHeaderSize + len(content) + 64
+	data := make([]byte, size)
+	start := evtHeaderSize + offset
+	for i, b := range content {
+		pos := start + i
+		if pos >= size {
+			pos = pos - size + evtHeaderSize
+		}
+		data[pos] = b
+	}
+	end := start + len(content) - evtEOFRecordSize
+	if end >= size {
+		end = end - size + evtHeaderSize
+	}
+
+	header := evtHeader{
+		HeaderSize: evtHeaderSize, MajorVersion: 1, MinorVersion: 1,
+		StartOffset: uint32(start), EndOffset: uint32(end), MaxSize: uint32(size), EndHeaderSize: evtHeaderSize,
+	}
+	copy(header.Signature[:], evtSignature)
+	buf := &bytes.Buffer{}
+	binary.Write(buf, binary.LittleEndian, header) // nolint: errcheck
+	copy(data, buf.Bytes())
+	return data
+}
+
+func TestParseEvt(t *testing.T) {
+	records := [][]byte{
+		evtRecord(1, 7036, 4, "Telephony", "running"),
+		evtRecord(2, 0xc0001b58, 1, "Foo"),
+		evtRecord(3, 7035, 4),
+	}
+	corrupt := append(append([]byte{}, records[0]...), bytes.Repeat([]byte{0xff}, 16)...)
+
+	tests := []struct {
+		name         string
+		data         []byte
+		filter       *eventFilter
+		wantIDs      []int64
+		wantWarnings int
+		wantErr      bool
+	}{
+		{"no evt", []byte("foo"), nil, nil, 0, true},
+		{"no records", evtFile(0), nil, nil, 0, false},
+		{"records", evtFile(0, records...), nil, []int64{1, 2, 3}, 0, false},
+		{"wrapped records", evtFile(100, records...), nil, []int64{1, 2, 3}, 0, false},
+		{"corrupt record", evtFile(0, corrupt, records[1]), nil, []int64{1, 2}, 1, false},
+		{"filter", evtFile(0, records...), &eventFilter{eventIDs: []idRange{{7035, 7036}}}, []int64{1, 3}, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var ids []int64
+			var warnings []error
+			err := parseEvt(`C:\Windows\System32\config\SysEvent.Evt`, bytes.NewReader(tt.data), evtxOptions{Filter: tt.filter}, func(event evtxEvent) error {
+				if id := gjson.GetBytes(event.data, "System.EventRecordID").Int(); id != int64(event.record.id) {
+					t.Errorf("record id = %d, want %d", event.record.id, id)
+				}
+				ids = append(ids, int64(event.record.id))
+				return nil
+			}, func(err error) {
+				warnings = append(warnings, err)
+			})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseEvt() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(ids) != len(tt.wantIDs) {
+				t.Fatalf("ids = %v, want %v", ids, tt.wantIDs)
+			}
+			for i := range ids {
+				if ids[i] != tt.wantIDs[i] {
+					t.Errorf("ids = %v, want %v", ids, tt.wantIDs)
+				}
+			}
+			if len(warnings) != tt.wantWarnings {
+				t.Errorf("warnings = %v, want %d", warnings, tt.wantWarnings)
+			}
+		})
+	}
+}
+
+func TestParseEvtRecord(t *testing.T) {
+	event, err := parseEvtRecord(evtRecord(2, 0xc0001b58, 1, "Foo", "Bar"), "System")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := event.MarshalJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]interface{}{
+		"System.Provider.Name":          "Service Control Manager",
+		"System.EventID.Value":          int64(7000),
+		"System.EventID.Qualifiers":     int64(0xc000),
+		"System.Level":                  int64(2),
+		"System.Channel":                "System",
+		"System.Computer":               "PC",
+		"System.Security.UserID":        "S-1-5-18",
+		"System.TimeCreated.SystemTime": int64(1000000002),
+		"EventData.Data.1":              "Bar",
+	}
+	for path, value := range want {
+		result := gjson.GetBytes(b, path)
+		var got interface{} = result.String()
+		if _, ok := value.(int64); ok {
+			got = result.Int()
+		}
+		if got != value {
+			t.Errorf("%s = %v, want %v", path, got, value)
+		}
+	}
+}
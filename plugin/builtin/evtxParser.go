@@ -0,0 +1,241 @@
+// Copyright (c) 2020 Siemens AG
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+//
+// Author(s): Jonas Plum
+
+package builtin
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"sync"
+
+	"github.com/Velocidex/ordereddict"
+	"www.velocidex.com/golang/evtx"
+)
+
+// evtxOptions configure the parsing of evtx files.
+type evtxOptions struct {
+	// Resolver renders the messages of the events if it is set.
+	Resolver evtx.MessageResolver
+	// Filter selects single events.
+	Filter *eventFilter
+	// Workers is the number of chunks parsed in parallel.
+	Workers int
+	// Render selects the events that are rendered, all events are rendered
+	// if it is not set. Only the record of the other events is passed on.
+	Render func(event *ordereddict.Dict) bool
+}
+
+// eventlogRecord is the id and the time of an event record, both are read
+// from the record header.
+type eventlogRecord struct {
+	id   uint64
+	time float64
+}
+
+// evtxEvent is a parsed event. The data is nil if the event was not
+// rendered.
+type evtxEvent struct {
+	record  eventlogRecord
+	channel string
+	data    []byte
+}
+
+type evtxChunk struct {
+	offset int64
+	data   []byte
+	result chan evtxChunkResult
+}
+
+type evtxChunkResult struct {
+	events []evtxEvent
+	err    error
+}
+
+// parseEvtx reads an evtx file sequentially and parses its chunks with a pool
+// of workers. The events are passed to fn in record order. Chunks that cannot
+// be parsed are passed to warn and skipped.
+func parseEvtx(originPath string, r io.Reader, options evtxOptions, fn func(event evtxEvent) error, warn func(error)) error { // nolint: gocyclo
+	var header evtx.EVTXHeader
+	if err := binary.Read(r, binary.LittleEndian, &header); err != nil {
+		return fmt.Errorf("could not read header: %w", err)
+	}
+	if string(header.Magic[:]) != evtx.EVTX_HEADER_MAGIC {
+		return errors.New("file is not an evtx file")
+	}
+	if header.MajorVersion != 3 || header.MinorVersion > 2 {
+		evtxVersionError, _ := json.Marshal(map[string]interface{}{
+			"origin": map[string]string{"path": originPath},
+			"type":   "eventlog",
+			"errors": []string{"Unsupported EVTX version."},
+		})
+		return fn(evtxEvent{data: evtxVersionError})
+	}
+	if _, err := io.CopyN(ioutil.Discard, r, int64(int(header.HeaderBlockSize)-binary.Size(header))); err != nil {
+		return fmt.Errorf("could not read header: %w", err)
+	}
+
+	workers := options.Workers
+	if workers < 1 {
+		workers = 1
+	}
+	jobs := make(chan *evtxChunk)
+	chunks := make(chan *evtxChunk, workers)
+
+	// the reader uses the connection of the store, so it must be finished
+	// before the caller continues to use the store
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+	defer func() {
+		close(done)
+		for range chunks {
+			// the reader stops at done, the remaining chunks are discarded
+		}
+		wg.Wait()
+	}()
+
+	wg.Add(workers + 1)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+			for chunk := range jobs {
+				events, err := parseEvtxChunk(originPath, chunk.data, options)
+				chunk.result <- evtxChunkResult{events: events, err: err}
+			}
+		}()
+	}
+
+	var readErr error
+	go func() {
+		defer wg.Done()
+		defer close(jobs)
+		defer close(chunks)
+		for offset := int64(header.HeaderBlockSize); ; offset += evtx.EVTX_CHUNK_SIZE {
+			chunk := &evtxChunk{offset: offset, data: make([]byte, evtx.EVTX_CHUNK_SIZE), result: make(chan evtxChunkResult, 1)}
+			if _, err := io.ReadFull(r, chunk.data); err != nil {
+				if !errors.Is(err, io.EOF) {
+					readErr = fmt.Errorf("could not read chunk at offset %d: %w", offset, err)
+				}
+				return
+			}
+			select {
+			case jobs <- chunk:
+			case <-done:
+				return
+			}
+			select {
+			case chunks <- chunk:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	for chunk := range chunks {
+		result := <-chunk.result
+		if result.err != nil {
+			// the events parsed before the error are still returned
+			warn(fmt.Errorf("could not parse chunk at offset %d: %w", chunk.offset, result.err))
+		}
+		for _, event := range result.events {
+			if err := fn(event); err != nil {
+				return err
+			}
+		}
+	}
+	if readErr != nil {
+		// a truncated file still contains all events of the previous chunks
+		warn(readErr)
+	}
+	return nil
+}
+
+func parseEvtxChunk(originPath string, data []byte, options evtxOptions) (events []evtxEvent, err error) { // nolint: gocyclo
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+
+	chunk := &evtx.Chunk{Fd: bytes.NewReader(data)}
+	if err := binary.Read(bytes.NewReader(data), binary.LittleEndian, &chunk.Header); err != nil {
+		return nil, err
+	}
+	if string(chunk.Header.Magic[:]) != evtx.EVTX_CHUNK_HEADER_MAGIC || chunk.Header.LastEventRecID == math.MaxUint64 {
+		// unused chunk
+		return nil, nil
+	}
+	if !options.Filter.matchChunk(chunk.Header) {
+		return nil, nil
+	}
+
+	records, err := chunk.Parse(int(chunk.Header.FirstEventRecID))
+	if err != nil {
+		return nil, err
+	}
+
+	var parseErr error
+	if expected := chunk.Header.LastEventRecID - chunk.Header.FirstEventRecID + 1; uint64(len(records)) < expected {
+		parseErr = fmt.Errorf("parsed %d of %d records", len(records), expected)
+	}
+
+	for _, i := range records {
+		eventMap, ok := i.Event.(*ordereddict.Dict)
+		if ok {
+			event, ok := ordereddict.GetMap(eventMap, "Event")
+			if !ok || !options.Filter.match(event) {
+				continue
+			}
+
+			channel, _ := ordereddict.GetString(event, "System.Channel")
+			e := evtxEvent{
+				record:  eventlogRecord{id: i.Header.RecordID, time: filetimeToUnix(i.Header.FileTime)},
+				channel: channel,
+			}
+			if options.Render == nil || options.Render(event) {
+				event.Set("type", "eventlog")
+				event.Set("origin", map[string]string{"path": originPath})
+				if options.Resolver != nil {
+					if message := evtx.ExpandMessage(event, options.Resolver); message != "" {
+						event.Set("Message", message)
+					}
+				}
+
+				if e.data, err = json.Marshal(event); err != nil {
+					return nil, err
+				}
+			}
+			events = append(events, e)
+		}
+	}
+	return events, parseErr
+}
+
+// filetimeToUnix converts a FILETIME to the fractional seconds used for
+// System.TimeCreated.
+func filetimeToUnix(filetime uint64) float64 {
+	return (float64(filetime) - 116444736000000000) / 10000000
+}
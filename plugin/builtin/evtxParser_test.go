@@ -0,0 +1,134 @@
+// Copyright (c) 2020 Siemens AG
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+//
+// Author(s): Jonas Plum
+
+package builtin
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/tidwall/gjson"
+	"www.velocidex.com/golang/evtx"
+)
+
+func evtxFile(t *testing.T, major uint16, chunks ...[]byte) []byte {
+	header := evtx.EVTXHeader{MajorVersion: major, MinorVersion: 1, HeaderBlockSize: 4096}
+	copy(header.Magic[:], evtx.EVTX_HEADER_MAGIC)
+
+	buf := &bytes.Buffer{}
+	if err := binary.Write(buf, binary.LittleEndian, header); err != nil {
+		t.Fatal(err)
+	}
+	buf.Write(make([]byte, 4096-buf.Len()))
+	for _, chunk := range chunks {
+		buf.Write(chunk)
+	}
+	return buf.Bytes()
+}
+
+func TestParseEvtx(t *testing.T) {
+	emptyChunk := make([]byte, evtx.EVTX_CHUNK_SIZE)
+
+	tests := []struct {
+		name         string
+		data         []byte
+		wantEvents   int
+		wantWarnings int
+		wantErr      bool
+	}{
+		{"no evtx", []byte("foo"), 0, 0, true},
+		{"wrong magic", bytes.Repeat([]byte("x"), 4096), 0, 0, true},
+		{"unsupported version", evtxFile(t, 2), 1, 0, false},
+		{"no chunks", evtxFile(t, 3), 0, 0, false},
+		{"unused chunks", evtxFile(t, 3, emptyChunk, emptyChunk), 0, 0, false},
+		{"truncated chunk", evtxFile(t, 3, emptyChunk, emptyChunk[:100]), 0, 1, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var events [][]byte
+			var warnings []error
+			err := parseEvtx("test.evtx", bytes.NewReader(tt.data), evtxOptions{Workers: 2}, func(event evtxEvent) error {
+				events = append(events, event.data)
+				return nil
+			}, func(err error) {
+				warnings = append(warnings, err)
+			})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseEvtx() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(events) != tt.wantEvents {
+				t.Errorf("len(events) = %d, want %d", len(events), tt.wantEvents)
+			}
+			if len(warnings) != tt.wantWarnings {
+				t.Errorf("len(warnings) = %d, want %d: %v", len(warnings), tt.wantWarnings, warnings)
+			}
+		})
+	}
+}
+
+func TestParseEvtx_Records(t *testing.T) {
+	// the first three chunks of the Security.evtx of www.velocidex.com/golang/evtx
+	data, err := ioutil.ReadFile(filepath.Join("testdata", "Security.evtx"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	emptyChunk := make([]byte, evtx.EVTX_CHUNK_SIZE)
+
+	var want []uint64
+	for id := uint64(31878); id <= 32109; id++ {
+		want = append(want, id)
+	}
+
+	tests := []struct {
+		name    string
+		data    []byte
+		workers int
+	}{
+		{"single worker", data, 1},
+		{"workers", data, 3},
+		{"more workers than chunks", data, 8},
+		{"unused chunk", append(append([]byte{}, data...), emptyChunk...), 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var ids []uint64
+			err := parseEvtx("Security.evtx", bytes.NewReader(tt.data), evtxOptions{Workers: tt.workers}, func(event evtxEvent) error {
+				ids = append(ids, event.record.id)
+				if id := gjson.GetBytes(event.data, "System.EventRecordID").Uint(); id != event.record.id {
+					t.Errorf("EventRecordID = %d, want %d", id, event.record.id)
+				}
+				return nil
+			}, func(err error) {
+				t.Error(err)
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(ids, want) {
+				t.Errorf("ids = %v, want %d records from %d to %d", ids, len(want), want[0], want[len(want)-1])
+			}
+		})
+	}
+}
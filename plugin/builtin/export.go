@@ -52,25 +52,5 @@ func (e *Export) Run(p pluginlib.Plugin, out pluginlib.LineWriter) error {
 	}
 	defer teardown()
 
-	elements, err := store.Select(filter)
-	if err != nil {
-		return err
-	}
-	if len(elements) == 0 {
-		return nil
-	}
-
-	/*
-		var header []string
-		gjson.GetBytes(elements[0], "@this").ForEach(func(key, _ gjson.Result) bool {
-			header = append(header, key.String())
-			return true
-		})
-		out.SetConfig(&output.Config{Header: header})
-	*/
-
-	for _, element := range elements {
-		out.WriteLine(element) // nolint: errcheck
-	}
-	return nil
+	return pluginlib.ForEach(store, filter, out.WriteLine)
 }
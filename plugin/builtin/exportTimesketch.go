@@ -22,6 +22,7 @@
 package builtin
 
 import (
+	"bufio"
 	"encoding/json"
 	"fmt"
 	"log"
@@ -75,23 +76,32 @@ func (e *ExportTimesketch) Run(p pluginlib.Plugin, out pluginlib.LineWriter) err
 	return exportStore(out, store, filter, timesketch)
 }
 
-func exportStore(out pluginlib.LineWriter, store *forensicstore.ForensicStore, filter pluginlib.Filter, timesketch string) error {
-	elements, err := store.Select(filter)
+func exportStore(out pluginlib.LineWriter, store *forensicstore.ForensicStore, filter pluginlib.Filter, timesketch string) (err error) {
+	it, err := pluginlib.Iterate(store, filter)
 	if err != nil {
 		return err
 	}
-	if len(elements) == 0 {
-		return nil
+	defer it.Close()
+
+	// the file is only created if there are elements
+	if !it.Next() {
+		return it.Err()
 	}
 
 	f, err := os.Create(timesketch)
 	if err != nil {
 		return err
 	}
-	defer f.Close()
+	defer func() {
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}()
 
-	for _, element := range elements {
-		element := element
+	w := bufio.NewWriter(f)
+	for ok := true; ok; ok = it.Next() {
+		element := it.Element()
+		var werr error
 		gjson.GetBytes(element, "@this").ForEach(func(key, value gjson.Result) bool {
 			field := key.String()
 			if field == "atime" || field == "ctime" || field == "mtime" || strings.HasSuffix(field, "_time") {
@@ -117,14 +127,21 @@ func exportStore(out pluginlib.LineWriter, store *forensicstore.ForensicStore, f
 					log.Println(err)
 					return true
 				}
-				f.Write(b)          // nolint: errcheck
-				f.WriteString("\n") // nolint: errcheck
+				if _, werr = w.Write(append(b, '\n')); werr != nil {
+					return false
+				}
 			}
 			return true
 		})
+		if werr != nil {
+			return werr
+		}
+	}
+	if err := it.Err(); err != nil {
+		return err
 	}
 
-	return nil
+	return w.Flush()
 }
 
 func jsonToText(element *gjson.Result) string {
@@ -0,0 +1,389 @@
+// Copyright (c) 2020 Siemens AG
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+//
+// Author(s): Jonas Plum
+
+package builtin
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/tidwall/gjson"
+)
+
+// The indicator types of the bulk search.
+const (
+	iocHash     = "hash"
+	iocIP       = "ip"
+	iocCIDR     = "cidr"
+	iocDomain   = "domain"
+	iocURL      = "url"
+	iocRegex    = "regex"
+	iocFilename = "filename"
+	// iocString is a case insensitive substring, it is used for indicators
+	// that have no recognizable type.
+	iocString = "string"
+)
+
+var iocTypes = map[string]bool{
+	iocHash: true, iocIP: true, iocCIDR: true, iocDomain: true, iocURL: true,
+	iocRegex: true, iocFilename: true, iocString: true,
+}
+
+var (
+	hashPattern     = regexp.MustCompile(`\b[0-9a-fA-F]{32,128}\b`)
+	ipv4Pattern     = regexp.MustCompile(`\b(?:\d{1,3}\.){3}\d{1,3}\b`)
+	ipv6Pattern     = regexp.MustCompile(`[0-9a-fA-F]{0,4}(?::[0-9a-fA-F]{0,4}){2,7}(?:%\w+)?|::ffff:(?:\d{1,3}\.){3}\d{1,3}`)
+	domainPattern   = regexp.MustCompile(`(?i)\b(?:[a-z0-9](?:[a-z0-9-]{0,61}[a-z0-9])?\.)+[a-z][a-z0-9-]{0,62}\b`)
+	urlPattern      = regexp.MustCompile(`(?i)\b[a-z][a-z0-9+.-]*://[^\s"'<>]+`)
+	filenameEscapes = strings.NewReplacer("\\", "/", "\"", " ", "'", " ")
+	fieldEscapes    = strings.NewReplacer(".", `\.`, "*", `\*`, "?", `\?`)
+)
+
+// fileExtensions are the extensions that make a value a filename instead
+// of a domain.
+var fileExtensions = map[string]bool{
+	"exe": true, "dll": true, "sys": true, "ps1": true, "psm1": true, "bat": true, "cmd": true,
+	"vbs": true, "vbe": true, "js": true, "jse": true, "hta": true, "scr": true, "lnk": true,
+	"msi": true, "jar": true, "py": true, "sh": true, "zip": true, "rar": true, "7z": true,
+	"iso": true, "img": true, "doc": true, "docx": true, "docm": true, "xls": true, "xlsx": true,
+	"xlsm": true, "ppt": true, "pptx": true, "pdf": true, "rtf": true, "txt": true, "tmp": true,
+	"dat": true, "log": true, "bin": true, "pf": true, "evtx": true,
+}
+
+// indicator is a single IOC of the bulk search.
+type indicator struct {
+	Type       string
+	Value      string
+	Source     string
+	Confidence int
+	Tags       []string
+	// normalized is the value used for matching.
+	normalized string
+	network    *net.IPNet
+	regex      *regexp.Regexp
+}
+
+// refang reverts common defanging like hxxp and [.].
+var refang = strings.NewReplacer("[.]", ".", "(.)", ".", "{.}", ".", "[:]", ":", "hxxp", "http", "hXXp", "http", "[://]", "://")
+
+// newIndicator creates an indicator of the given type. The type is guessed
+// from the value if it is empty.
+func newIndicator(iocType, value string) (*indicator, error) { // nolint: gocyclo
+	value = strings.TrimSpace(value)
+	if iocType != iocRegex {
+		value = refang.Replace(value)
+	}
+	if value == "" {
+		return nil, fmt.Errorf("empty indicator")
+	}
+	if iocType == "" {
+		iocType = indicatorType(value)
+	}
+	ioc := &indicator{Type: strings.ToLower(iocType), Value: value}
+
+	switch ioc.Type {
+	case iocHash:
+		ioc.normalized = strings.ToLower(value)
+		if !isHash(ioc.normalized) {
+			return nil, fmt.Errorf("invalid hash %s", value)
+		}
+	case iocIP:
+		ip := net.ParseIP(value)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid ip %s", value)
+		}
+		ioc.normalized = ip.String()
+	case iocCIDR:
+		_, network, err := net.ParseCIDR(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cidr %s", value)
+		}
+		ioc.network = network
+		ioc.normalized = network.String()
+	case iocDomain:
+		ioc.normalized = strings.TrimPrefix(strings.TrimSuffix(strings.ToLower(value), "."), "*.")
+		if !domainPattern.MatchString(ioc.normalized) {
+			return nil, fmt.Errorf("invalid domain %s", value)
+		}
+	case iocURL:
+		normalized, ok := normalizeURL(value)
+		if !ok {
+			return nil, fmt.Errorf("invalid url %s", value)
+		}
+		ioc.normalized = normalized
+	case iocRegex:
+		re, err := regexp.Compile(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regex %s: %w", value, err)
+		}
+		ioc.regex = re
+		ioc.normalized = value
+	case iocFilename:
+		ioc.normalized = strings.ToLower(path.Base(strings.ReplaceAll(value, "\\", "/")))
+	case iocString:
+		ioc.normalized = strings.ToLower(value)
+	default:
+		return nil, fmt.Errorf("unknown indicator type %s", iocType)
+	}
+	return ioc, nil
+}
+
+// indicatorType guesses the type of an indicator from its value.
+func indicatorType(value string) string {
+	switch {
+	case isHash(strings.ToLower(value)):
+		return iocHash
+	case net.ParseIP(value) != nil:
+		return iocIP
+	case strings.Contains(value, "/") && !strings.Contains(value, "://"):
+		if _, _, err := net.ParseCIDR(value); err == nil {
+			return iocCIDR
+		}
+		return iocFilename
+	case strings.Contains(value, "://"):
+		return iocURL
+	case strings.Contains(value, "\\"):
+		return iocFilename
+	case domainPattern.FindString(value) == value:
+		if fileExtensions[strings.ToLower(strings.TrimPrefix(path.Ext(value), "."))] {
+			return iocFilename
+		}
+		return iocDomain
+	}
+	return iocString
+}
+
+func isHash(s string) bool {
+	switch len(s) {
+	case 32, 40, 64, 128:
+	default:
+		return false
+	}
+	for _, c := range s {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// normalizeURL lowercases the scheme and the host and removes the fragment
+// and a trailing slash.
+func normalizeURL(s string) (string, bool) {
+	u, err := url.Parse(strings.TrimSpace(s))
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return "", false
+	}
+	u.Scheme = strings.ToLower(u.Scheme)
+	u.Host = strings.ToLower(u.Host)
+	u.Fragment = ""
+	return strings.TrimSuffix(u.String(), "/"), true
+}
+
+// readIndicators reads one indicator per line. The type can be given as
+// prefix like domain:example.org, otherwise it is guessed. Empty lines and
+// lines starting with # are skipped. Invalid indicators are passed to warn.
+func readIndicators(r io.Reader, warn func(err error)) ([]*indicator, error) {
+	var iocs []*indicator
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		iocType := ""
+		if parts := strings.SplitN(text, ":", 2); len(parts) == 2 && iocTypes[strings.ToLower(parts[0])] {
+			iocType, text = parts[0], parts[1]
+		}
+		ioc, err := newIndicator(iocType, text)
+		if err != nil {
+			warn(fmt.Errorf("line %d: %w", line, err))
+			continue
+		}
+		iocs = append(iocs, ioc)
+	}
+	return iocs, scanner.Err()
+}
+
+// iocIndex indexes the indicators by type, so every value is only tested
+// against the indicators that can match it.
+type iocIndex struct {
+	exact    map[string]map[string][]*indicator
+	networks []*indicator
+	regexes  []*indicator
+	strings  []*indicator
+}
+
+func newIOCIndex(iocs []*indicator) *iocIndex {
+	idx := &iocIndex{exact: map[string]map[string][]*indicator{}}
+	for _, ioc := range iocs {
+		switch ioc.Type {
+		case iocCIDR:
+			idx.networks = append(idx.networks, ioc)
+		case iocRegex:
+			idx.regexes = append(idx.regexes, ioc)
+		case iocString:
+			idx.strings = append(idx.strings, ioc)
+		default:
+			if idx.exact[ioc.Type] == nil {
+				idx.exact[ioc.Type] = map[string][]*indicator{}
+			}
+			idx.exact[ioc.Type][ioc.normalized] = append(idx.exact[ioc.Type][ioc.normalized], ioc)
+		}
+	}
+	return idx
+}
+
+// match returns the indicators that match a value. Hashes, ips, domains and
+// urls are extracted from the value, so they are also found in e.g. command
+// lines.
+func (idx *iocIndex) match(value string) []*indicator { // nolint: gocyclo
+	var matches []*indicator
+	seen := map[*indicator]bool{}
+	add := func(iocs ...*indicator) {
+		for _, ioc := range iocs {
+			if !seen[ioc] {
+				seen[ioc] = true
+				matches = append(matches, ioc)
+			}
+		}
+	}
+
+	if hashes := idx.exact[iocHash]; hashes != nil {
+		for _, hash := range hashPattern.FindAllString(value, -1) {
+			add(hashes[strings.ToLower(hash)]...)
+		}
+	}
+	if ips := idx.exact[iocIP]; ips != nil || len(idx.networks) > 0 {
+		for _, ip := range extractIPs(value) {
+			add(ips[ip.String()]...)
+			for _, ioc := range idx.networks {
+				if ioc.network.Contains(ip) {
+					add(ioc)
+				}
+			}
+		}
+	}
+	if domains := idx.exact[iocDomain]; domains != nil {
+		for _, domain := range domainPattern.FindAllString(value, -1) {
+			// subdomains match the indicator as well
+			labels := strings.Split(strings.ToLower(domain), ".")
+			for i := 0; i < len(labels)-1; i++ {
+				add(domains[strings.Join(labels[i:], ".")]...)
+			}
+		}
+	}
+	if urls := idx.exact[iocURL]; urls != nil {
+		for _, u := range urlPattern.FindAllString(value, -1) {
+			if normalized, ok := normalizeURL(u); ok {
+				add(urls[normalized]...)
+			}
+		}
+	}
+	if filenames := idx.exact[iocFilename]; filenames != nil {
+		for _, token := range strings.Fields(filenameEscapes.Replace(value)) {
+			add(filenames[strings.ToLower(path.Base(token))]...)
+		}
+	}
+	for _, ioc := range idx.regexes {
+		if ioc.regex.MatchString(value) {
+			add(ioc)
+		}
+	}
+	if len(idx.strings) > 0 {
+		lower := strings.ToLower(value)
+		for _, ioc := range idx.strings {
+			if strings.Contains(lower, ioc.normalized) {
+				add(ioc)
+			}
+		}
+	}
+	return matches
+}
+
+func extractIPs(value string) []net.IP {
+	var ips []net.IP
+	for _, candidate := range ipv4Pattern.FindAllString(value, -1) {
+		if ip := net.ParseIP(candidate); ip != nil {
+			ips = append(ips, ip)
+		}
+	}
+	if strings.Contains(value, ":") {
+		for _, candidate := range ipv6Pattern.FindAllString(value, -1) {
+			if i := strings.IndexByte(candidate, '%'); i >= 0 {
+				candidate = candidate[:i]
+			}
+			if ip := net.ParseIP(candidate); ip != nil && ip.To4() == nil {
+				ips = append(ips, ip)
+			}
+		}
+	}
+	return ips
+}
+
+// iocMatch is an indicator found in a field of an element.
+type iocMatch struct {
+	indicator *indicator
+	field     string
+	value     string
+}
+
+// matchElement tests all string values of an element. Every indicator is
+// reported once per field.
+func (idx *iocIndex) matchElement(element []byte) []iocMatch {
+	var matches []iocMatch
+	var walk func(prefix string, value gjson.Result)
+	walk = func(prefix string, value gjson.Result) {
+		switch {
+		case value.IsObject() || value.IsArray():
+			i := 0
+			value.ForEach(func(key, child gjson.Result) bool {
+				name := key.String()
+				if value.IsArray() {
+					name = fmt.Sprint(i)
+					i++
+				}
+				name = fieldEscapes.Replace(name)
+				if prefix != "" {
+					name = prefix + "." + name
+				}
+				if name != "id" && name != "type" {
+					walk(name, child)
+				}
+				return true
+			})
+		case value.Type == gjson.String:
+			for _, ioc := range idx.match(value.Str) {
+				matches = append(matches, iocMatch{indicator: ioc, field: prefix, value: value.Str})
+			}
+		}
+	}
+	walk("", gjson.ParseBytes(element))
+	return matches
+}
@@ -0,0 +1,641 @@
+// Copyright (c) 2020 Siemens AG
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+//
+// Author(s): Jonas Plum
+
+package builtin
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// The formats of indicator files.
+const (
+	feedText    = "text"
+	feedSTIX    = "stix"
+	feedMISP    = "misp"
+	feedOpenIOC = "openioc"
+	feedCSV     = "csv"
+)
+
+// iocTypeAliases maps the indicator types of MISP, STIX, OpenIOC and csv
+// files to the indicator types of the bulk search.
+var iocTypeAliases = map[string]string{
+	"md5": iocHash, "sha1": iocHash, "sha224": iocHash, "sha256": iocHash, "sha384": iocHash, "sha512": iocHash,
+	"sha-1": iocHash, "sha-256": iocHash, "sha-512": iocHash, "imphash": iocHash, "authentihash": iocHash,
+	"ip": iocIP, "ip-src": iocIP, "ip-dst": iocIP, "ipv4": iocIP, "ipv6": iocIP, "ipv4-addr": iocIP, "ipv6-addr": iocIP,
+	"hostname": iocDomain, "domain-name": iocDomain, "fqdn": iocDomain,
+	"uri": iocURL, "link": iocURL, "regexp": iocRegex,
+	"file": iocFilename, "file-name": iocFilename, "filepath": iocFilename, "path": iocFilename,
+	"text": iocString, "mutex": iocString, "regkey": iocString, "user-agent": iocString, "named pipe": iocString,
+	"email": iocString, "email-src": iocString, "email-dst": iocString, "email-subject": iocString,
+}
+
+// iocType returns the bulk search type of a feed type. Unknown types return
+// an empty string.
+func iocType(feedType string) string {
+	feedType = strings.ToLower(strings.TrimSpace(feedType))
+	if iocTypes[feedType] {
+		return feedType
+	}
+	return iocTypeAliases[feedType]
+}
+
+// feedFormat detects the format of an indicator file by the extension and
+// the content.
+func feedFormat(path string, b []byte) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv":
+		return feedCSV
+	case ".ioc":
+		return feedOpenIOC
+	}
+	trimmed := bytes.TrimSpace(bytes.TrimPrefix(b, []byte("\ufeff")))
+	switch {
+	case bytes.HasPrefix(trimmed, []byte("<")):
+		return feedOpenIOC
+	case bytes.HasPrefix(trimmed, []byte("{")) || bytes.HasPrefix(trimmed, []byte("[")):
+		var bundle struct {
+			Type string `json:"type"`
+		}
+		if json.Unmarshal(trimmed, &bundle) == nil && bundle.Type == "bundle" {
+			return feedSTIX
+		}
+		return feedMISP
+	}
+	return feedText
+}
+
+// loadIndicators reads an indicator file in the given format or detects the
+// format if it is empty. Indicators without a source get the file name as
+// source. Invalid indicators are passed to warn and skipped.
+func loadIndicators(path, format string, warn func(err error)) ([]*indicator, error) {
+	b, err := ioutil.ReadFile(path) // #nosec
+	if err != nil {
+		return nil, err
+	}
+	if format == "" {
+		format = feedFormat(path, b)
+	}
+
+	var iocs []*indicator
+	switch format {
+	case feedText:
+		iocs, err = readIndicators(bytes.NewReader(b), warn)
+	case feedSTIX:
+		iocs, err = readSTIXIndicators(b, warn)
+	case feedMISP:
+		iocs, err = readMISPIndicators(b, warn)
+	case feedOpenIOC:
+		iocs, err = readOpenIOCIndicators(bytes.NewReader(b), warn)
+	case feedCSV:
+		iocs, err = readCSVIndicators(bytes.NewReader(b), warn)
+	default:
+		return nil, fmt.Errorf("unknown ioc format %s", format)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not read %s indicators: %w", format, err)
+	}
+	for _, ioc := range iocs {
+		if ioc.Source == "" {
+			ioc.Source = filepath.Base(path)
+		}
+	}
+	return iocs, nil
+}
+
+// newFeedIndicator creates an indicator with the metadata of a feed.
+func newFeedIndicator(feedType, value, source string, confidence int, tags []string) (*indicator, error) {
+	t := iocType(feedType)
+	if t == "" {
+		return nil, fmt.Errorf("unsupported indicator type %s", feedType)
+	}
+	if t == iocIP && strings.Contains(value, "/") {
+		t = iocCIDR
+	}
+	ioc, err := newIndicator(t, value)
+	if err != nil {
+		return nil, err
+	}
+	ioc.Source, ioc.Confidence, ioc.Tags = source, confidence, tags
+	return ioc, nil
+}
+
+// stixComparison matches comparisons in STIX patterns like
+// [file:hashes.'SHA-256' = '...'].
+var stixComparison = regexp.MustCompile(`([a-z0-9-]+):([\w.'-]+)\s+(NOT\s+)?(=|MATCHES|LIKE|ISSUBSET)\s+'((?:[^'\\]|\\.)*)'`)
+
+// readSTIXIndicators reads the indicators of a STIX 2.1 bundle. Every
+// comparison of a pattern that is only joined by OR is used as indicator,
+// like for OpenIOC files comparisons joined by AND are skipped with a
+// warning. Negated comparisons are skipped.
+func readSTIXIndicators(b []byte, warn func(err error)) ([]*indicator, error) {
+	var bundle stixBundle
+	if err := json.Unmarshal(b, &bundle); err != nil {
+		return nil, err
+	}
+
+	identities := map[string]string{}
+	for _, object := range bundle.Objects {
+		if object.Type == "identity" {
+			identities[object.ID] = object.Name
+		}
+	}
+
+	var iocs []*indicator
+	for _, object := range bundle.Objects {
+		if object.Type != "indicator" || object.Revoked {
+			continue
+		}
+		if object.PatternType != "" && object.PatternType != "stix" {
+			warn(fmt.Errorf("%s: unsupported pattern type %s", object.ID, object.PatternType))
+			continue
+		}
+		var tags []string
+		for _, tag := range append(object.Labels, object.IndicatorTypes...) {
+			tags = appendUnique(tags, tag)
+		}
+		comparisons, skipped := stixORComparisons(object.Pattern)
+		if skipped {
+			warn(fmt.Errorf("%s: skipped comparisons joined by AND", object.ID))
+		}
+		for _, comparison := range comparisons {
+			if comparison[3] != "" {
+				continue
+			}
+			feedType := stixType(comparison[1], comparison[2], comparison[4], comparison[5])
+			value := strings.NewReplacer(`\'`, `'`, `\\`, `\`).Replace(comparison[5])
+			ioc, err := newFeedIndicator(feedType, value, identities[object.CreatedByRef], object.Confidence, tags)
+			if err != nil {
+				warn(fmt.Errorf("%s: %w", object.ID, err))
+				continue
+			}
+			iocs = append(iocs, ioc)
+		}
+	}
+	return iocs, nil
+}
+
+type stixToken struct {
+	kind       string
+	comparison []string
+}
+
+// stixTerm are the nodes of a STIX pattern joined by AND or FOLLOWEDBY.
+type stixTerm struct {
+	nodes  []*stixNode
+	joined bool
+}
+
+// stixNode is a comparison or a group of terms joined by OR.
+type stixNode struct {
+	comparison []string
+	terms      []*stixTerm
+}
+
+// stixORComparisons returns the comparisons of a pattern that are only
+// joined by OR and if other comparisons were skipped. Comparisons that
+// stixComparison does not match still join the comparisons next to them.
+func stixORComparisons(pattern string) ([][]string, bool) {
+	var tokens []stixToken
+	matches := stixComparison.FindAllStringSubmatchIndex(pattern, -1)
+	for i := 0; i < len(pattern); {
+		for len(matches) > 0 && matches[0][0] < i {
+			// the comparison starts within a word
+			matches = matches[1:]
+		}
+		if len(matches) > 0 && matches[0][0] == i {
+			comparison := make([]string, len(matches[0])/2)
+			for j := range comparison {
+				if start := matches[0][2*j]; start >= 0 {
+					comparison[j] = pattern[start:matches[0][2*j+1]]
+				}
+			}
+			tokens = append(tokens, stixToken{kind: "comparison", comparison: comparison})
+			i, matches = matches[0][1], matches[1:]
+			continue
+		}
+		switch c := pattern[i]; {
+		case c == '[' || c == '(':
+			tokens = append(tokens, stixToken{kind: "("})
+			i++
+		case c == ']' || c == ')':
+			tokens = append(tokens, stixToken{kind: ")"})
+			i++
+		case c == '\'':
+			// strings of qualifiers like START t'2020-01-01T00:00:00Z'
+			for i++; i < len(pattern) && pattern[i] != '\''; i++ {
+				if pattern[i] == '\\' {
+					i++
+				}
+			}
+			i++
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n':
+			i++
+		default:
+			j := i
+			for j < len(pattern) && !strings.ContainsRune(" \t\r\n[]()'", rune(pattern[j])) {
+				j++
+			}
+			tokens = append(tokens, stixToken{kind: strings.ToUpper(pattern[i:j])})
+			i = j
+		}
+	}
+
+	root, _ := parseSTIXGroup(tokens, 0)
+	var comparisons [][]string
+	skipped := false
+	var walk func(node *stixNode, or bool)
+	walk = func(node *stixNode, or bool) {
+		if node.comparison != nil {
+			if or {
+				comparisons = append(comparisons, node.comparison)
+			} else {
+				skipped = true
+			}
+			return
+		}
+		for _, term := range node.terms {
+			for _, child := range term.nodes {
+				walk(child, or && !term.joined)
+			}
+		}
+	}
+	walk(root, true)
+	return comparisons, skipped
+}
+
+// parseSTIXGroup parses the tokens up to the end of the group. AND binds
+// stronger than OR, so the group is split into terms at every OR.
+func parseSTIXGroup(tokens []stixToken, i int) (*stixNode, int) {
+	group := &stixNode{terms: []*stixTerm{{}}}
+	for ; i < len(tokens); i++ {
+		term := group.terms[len(group.terms)-1]
+		switch tokens[i].kind {
+		case "(":
+			var child *stixNode
+			child, i = parseSTIXGroup(tokens, i+1)
+			term.nodes = append(term.nodes, child)
+		case ")":
+			return group, i
+		case "OR":
+			group.terms = append(group.terms, &stixTerm{})
+		case "comparison":
+			term.nodes = append(term.nodes, &stixNode{comparison: tokens[i].comparison})
+		case "AND", "FOLLOWEDBY":
+			term.joined = true
+		}
+	}
+	return group, i
+}
+
+// stixType returns the indicator type of a STIX comparison.
+func stixType(object, property, operator, value string) string {
+	switch {
+	case operator == "MATCHES":
+		return iocRegex
+	case operator == "LIKE":
+		// LIKE patterns are only supported without wildcards
+		if strings.ContainsAny(value, "%_") {
+			return ""
+		}
+		return iocString
+	case operator == "ISSUBSET" || ((object == "ipv4-addr" || object == "ipv6-addr") && strings.Contains(value, "/")):
+		return iocCIDR
+	case object == "file" && strings.HasPrefix(property, "hashes."):
+		return iocHash
+	case object == "file" && (property == "name" || strings.HasSuffix(property, "path")):
+		return iocFilename
+	case object == "ipv4-addr" || object == "ipv6-addr":
+		return iocIP
+	case object == "domain-name":
+		return iocDomain
+	case object == "url":
+		return iocURL
+	}
+	return iocString
+}
+
+type mispTag struct {
+	Name string `json:"name"`
+}
+
+type mispAttribute struct {
+	Type    string    `json:"type"`
+	Value   string    `json:"value"`
+	ToIDS   bool      `json:"to_ids"`
+	Deleted bool      `json:"deleted"`
+	Tag     []mispTag `json:"Tag"`
+}
+
+type mispEvent struct {
+	Info string `json:"info"`
+	Orgc struct {
+		Name string `json:"name"`
+	} `json:"Orgc"`
+	Org struct {
+		Name string `json:"name"`
+	} `json:"Org"`
+	Tag       []mispTag       `json:"Tag"`
+	Attribute []mispAttribute `json:"Attribute"`
+	Object    []struct {
+		Attribute []mispAttribute `json:"Attribute"`
+	} `json:"Object"`
+}
+
+// mispConfidence matches the confidence tags of the MISP taxonomies.
+var mispConfidence = regexp.MustCompile(`^(?:misp:confidence-level|estimative-language:confidence-in-analytic-judgment)="?([a-z-]+)"?$`)
+
+var mispConfidenceLevels = map[string]int{
+	"completely-confident": 100, "usually-confident": 75, "fairly-confident": 50,
+	"rarely-confident": 25, "unconfident": 0, "high": 85, "moderate": 50, "low": 15,
+}
+
+// readMISPIndicators reads the attributes of MISP JSON event exports. A
+// file can contain a single event, a list of events or a search response.
+// Only attributes marked for detection (to_ids) are used.
+func readMISPIndicators(b []byte, warn func(err error)) ([]*indicator, error) { // nolint: gocyclo
+	type wrapper struct {
+		Event mispEvent `json:"Event"`
+	}
+	var events []mispEvent
+	var single wrapper
+	var list []wrapper
+	var response struct {
+		Response []wrapper `json:"response"`
+	}
+	switch {
+	case json.Unmarshal(b, &list) == nil:
+		for _, w := range list {
+			events = append(events, w.Event)
+		}
+	case json.Unmarshal(b, &response) == nil && response.Response != nil:
+		for _, w := range response.Response {
+			events = append(events, w.Event)
+		}
+	case json.Unmarshal(b, &single) == nil:
+		events = append(events, single.Event)
+	default:
+		return nil, errors.New("invalid MISP event")
+	}
+
+	var iocs []*indicator
+	for _, event := range events {
+		source := event.Orgc.Name
+		if source == "" {
+			source = event.Org.Name
+		}
+		attributes := event.Attribute
+		for _, object := range event.Object {
+			attributes = append(attributes, object.Attribute...)
+		}
+		for _, attribute := range attributes {
+			if !attribute.ToIDS || attribute.Deleted {
+				continue
+			}
+			var tags []string
+			confidence := 0
+			for _, tag := range append(event.Tag, attribute.Tag...) {
+				if match := mispConfidence.FindStringSubmatch(tag.Name); match != nil {
+					confidence = mispConfidenceLevels[match[1]]
+				}
+				tags = appendUnique(tags, tag.Name)
+			}
+
+			// composite attributes like filename|md5 contain multiple values
+			types := strings.Split(attribute.Type, "|")
+			values := strings.Split(attribute.Value, "|")
+			if len(types) != len(values) {
+				warn(fmt.Errorf("%s: invalid value %s", event.Info, attribute.Value))
+				continue
+			}
+			for i := range types {
+				if types[i] == "port" {
+					continue
+				}
+				ioc, err := newFeedIndicator(types[i], values[i], source, confidence, tags)
+				if err != nil {
+					warn(fmt.Errorf("%s: %w", event.Info, err))
+					continue
+				}
+				iocs = append(iocs, ioc)
+			}
+		}
+	}
+	return iocs, nil
+}
+
+type openIOCItem struct {
+	Condition string `xml:"condition,attr"`
+	Negate    string `xml:"negate,attr"`
+	Context   struct {
+		Search string `xml:"search,attr"`
+	} `xml:"Context"`
+	Content struct {
+		Type  string `xml:"type,attr"`
+		Value string `xml:",chardata"`
+	} `xml:"Content"`
+}
+
+// readOpenIOCIndicators reads the indicator items of OpenIOC 1.0 and 1.1
+// files. Negated items are skipped. Single items can only be searched for
+// OR branches, so AND branches are skipped with a warning.
+func readOpenIOCIndicators(r io.Reader, warn func(err error)) ([]*indicator, error) {
+	var iocs []*indicator
+	var source string
+	var tags []string
+	decoder := xml.NewDecoder(r)
+	decoder.CharsetReader = func(charset string, input io.Reader) (io.Reader, error) {
+		// OpenIOC files are usually declared as us-ascii
+		switch strings.ToLower(charset) {
+		case "us-ascii", "ascii", "utf-8":
+			return input, nil
+		}
+		return nil, fmt.Errorf("unsupported charset %s", charset)
+	}
+	for {
+		token, err := decoder.Token()
+		if errors.Is(err, io.EOF) {
+			return iocs, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		start, ok := token.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		switch start.Name.Local {
+		case "Indicator":
+			if operator := xmlAttr(start, "operator"); !strings.EqualFold(operator, "OR") {
+				warn(fmt.Errorf("indicator %s: skipped %s branch", xmlAttr(start, "id"), operator))
+				if err := decoder.Skip(); err != nil {
+					return nil, err
+				}
+			}
+		case "authored_by":
+			if err := decoder.DecodeElement(&source, &start); err != nil {
+				return nil, err
+			}
+		case "keywords", "short_description":
+			var text string
+			if err := decoder.DecodeElement(&text, &start); err != nil {
+				return nil, err
+			}
+			if text = strings.TrimSpace(text); text != "" {
+				tags = appendUnique(tags, text)
+			}
+		case "IndicatorItem":
+			var item openIOCItem
+			if err := decoder.DecodeElement(&item, &start); err != nil {
+				return nil, err
+			}
+			if strings.Contains(item.Condition, "not") || item.Negate == "true" {
+				continue
+			}
+			feedType := openIOCType(item.Condition, item.Context.Search, item.Content.Type)
+			ioc, err := newFeedIndicator(feedType, item.Content.Value, strings.TrimSpace(source), 0, tags)
+			if err != nil {
+				warn(fmt.Errorf("%s: %w", item.Context.Search, err))
+				continue
+			}
+			iocs = append(iocs, ioc)
+		}
+	}
+}
+
+func xmlAttr(start xml.StartElement, name string) string {
+	for _, attr := range start.Attr {
+		if attr.Name.Local == name {
+			return attr.Value
+		}
+	}
+	return ""
+}
+
+// openIOCType returns the indicator type of an OpenIOC search term like
+// FileItem/Md5sum.
+func openIOCType(condition, search, contentType string) string {
+	search = strings.ToLower(search)
+	switch {
+	case condition == "matches":
+		return iocRegex
+	case condition == "contains" || condition == "starts-with" || condition == "ends-with":
+		return iocString
+	case contentType == "md5" || contentType == "sha1" || contentType == "sha256" || strings.HasSuffix(search, "sum"):
+		return iocHash
+	case contentType == "IP":
+		return iocIP
+	case strings.HasSuffix(search, "/url") || strings.HasSuffix(search, "/uri"):
+		return iocURL
+	case strings.Contains(search, "dns") || strings.HasSuffix(search, "hostname") || strings.HasSuffix(search, "/host"):
+		return iocDomain
+	case (strings.HasPrefix(search, "fileitem/") || strings.HasPrefix(search, "processitem/")) &&
+		(strings.HasSuffix(search, "name") || strings.HasSuffix(search, "path")):
+		return iocFilename
+	}
+	return iocString
+}
+
+// readCSVIndicators reads a csv file with a header. The columns are
+// detected by their names, only the value column is required.
+func readCSVIndicators(r io.Reader, warn func(err error)) ([]*indicator, error) { // nolint: gocyclo
+	reader := csv.NewReader(bufio.NewReader(r))
+	reader.FieldsPerRecord = -1
+	reader.Comment = '#'
+	header, err := reader.Read()
+	if err != nil {
+		return nil, err
+	}
+	columns := map[string]int{}
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))
+		for column, names := range map[string][]string{
+			"type":       {"type", "indicator_type", "ioc_type", "attribute_type"},
+			"value":      {"value", "indicator", "ioc", "observable"},
+			"source":     {"source", "feed", "org"},
+			"confidence": {"confidence", "score"},
+			"tags":       {"tags", "tag", "labels"},
+		} {
+			for _, n := range names {
+				if _, ok := columns[column]; !ok && name == n {
+					columns[column] = i
+				}
+			}
+		}
+	}
+	if _, ok := columns["value"]; !ok {
+		return nil, errors.New("missing value column")
+	}
+
+	get := func(record []string, column string) string {
+		if i, ok := columns[column]; ok && i < len(record) {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+	var iocs []*indicator
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return iocs, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		value := get(record, "value")
+		if value == "" {
+			continue
+		}
+		confidence := 0
+		if c := get(record, "confidence"); c != "" {
+			if confidence, err = strconv.Atoi(c); err != nil {
+				warn(fmt.Errorf("line %d: invalid confidence %s", line, c))
+			}
+		}
+		var tags []string
+		for _, tag := range strings.FieldsFunc(get(record, "tags"), func(r rune) bool { return r == ';' || r == ',' || r == '|' }) {
+			tags = appendUnique(tags, strings.TrimSpace(tag))
+		}
+
+		var ioc *indicator
+		if feedType := get(record, "type"); feedType != "" {
+			ioc, err = newFeedIndicator(feedType, value, get(record, "source"), confidence, tags)
+		} else if ioc, err = newIndicator("", value); err == nil {
+			ioc.Source, ioc.Confidence, ioc.Tags = get(record, "source"), confidence, tags
+		}
+		if err != nil {
+			warn(fmt.Errorf("line %d: %w", line, err))
+			continue
+		}
+		iocs = append(iocs, ioc)
+	}
+}
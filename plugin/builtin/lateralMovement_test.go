@@ -0,0 +1,101 @@
+// Copyright (c) 2020 Siemens AG
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+//
+// Author(s): Jonas Plum
+
+package builtin
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestLateralMovementDetector(t *testing.T) {
+	event := func(id int, channel string, eventID int, data string) logonEvent {
+		return logonEvent{
+			id:      fmt.Sprintf("eventlog--%d", id),
+			key:     fmt.Sprintf("%s/%d", channel, eventID),
+			time:    time.Unix(int64(id), 0).UTC(),
+			element: []byte(fmt.Sprintf(`{"System": {"Computer": "SRV"}, "EventData": %s}`, data)),
+		}
+	}
+	events := []logonEvent{
+		event(1, "Security", 4624, `{"TargetUserName": "admin", "TargetDomainName": "CORP", "TargetLogonId": 256, "LogonType": 3, "IpAddress": "10.0.0.5"}`),
+		event(2, "Security", 5140, `{"SubjectUserName": "admin", "SubjectDomainName": "CORP", "SubjectLogonId": 256, "ShareName": "\\\\*\\ADMIN$", "IpAddress": "10.0.0.5"}`),
+		event(3, "Security", 5145, `{"SubjectUserName": "admin", "SubjectDomainName": "CORP", "SubjectLogonId": 256, "ShareName": "\\\\*\\ADMIN$", "RelativeTargetName": "PSEXESVC.exe", "IpAddress": "10.0.0.5"}`),
+		event(4, "System", 7045, `{"ServiceName": "PSEXESVC", "ImagePath": "%SystemRoot%\\PSEXESVC.exe", "AccountName": "LocalSystem"}`),
+		event(5, "System", 7045, `{"ServiceName": "Updater", "ImagePath": "C:\\Program Files\\Updater\\updater.exe"}`),
+		event(6, "Security", 4698, `{"SubjectUserName": "admin", "SubjectLogonId": "0x100", "TaskName": "\\Evil", "TaskContent": "<Exec><Command>cmd.exe</Command><Arguments>/c whoami</Arguments></Exec>"}`),
+		event(7, "Security", 4688, `{"SubjectLogonId": "0x3e7", "TargetLogonId": "0x100", "NewProcessName": "C:\\Windows\\System32\\cmd.exe", "ParentProcessName": "C:\\Windows\\System32\\wbem\\WmiPrvSE.exe", "CommandLine": "cmd.exe /Q /c whoami"}`),
+		event(8, "Security", 4688, `{"SubjectLogonId": "0x3e7", "NewProcessName": "C:\\Windows\\System32\\cmd.exe", "ParentProcessName": "C:\\Windows\\explorer.exe"}`),
+		event(9, "Security", 4624, `{"TargetUserName": "admin", "TargetDomainName": "CORP", "TargetLogonId": 512, "LogonType": 10, "IpAddress": "8.8.8.8"}`),
+		event(10, "Security", 4624, `{"TargetUserName": "admin", "TargetDomainName": "CORP", "TargetLogonId": 768, "LogonType": 10, "IpAddress": "10.0.0.5"}`),
+		event(11, "Security", 4624, `{"TargetUserName": "admin", "TargetDomainName": "CORP", "TargetLogonId": 1024, "LogonType": 10, "IpAddress": "10.0.1.5"}`),
+	}
+
+	detector := &lateralMovementDetector{
+		sessions:    &logonSessionBuilder{sessions: map[string]*LogonSession{}},
+		shareAccess: map[string]*Finding{},
+		rdpSources:  []*net.IPNet{mustParseNetwork("10.0.0.0/24")},
+	}
+	for _, e := range events {
+		detector.add(e)
+	}
+	findings := detector.build()
+
+	want := []struct {
+		finding   string
+		source    string
+		eventRefs int
+	}{
+		{AdminShareAccess, "10.0.0.5", 3},
+		{ServiceInstall, "", 1},
+		{RemoteScheduledTask, "10.0.0.5", 2},
+		{WMIExecution, "10.0.0.5", 2},
+		{UnusualRDPSource, "8.8.8.8", 1},
+		{UnusualRDPSource, "10.0.1.5", 1},
+	}
+	if len(findings) != len(want) {
+		for _, f := range findings {
+			t.Logf("%+v", f)
+		}
+		t.Fatalf("len(findings) = %d, want %d", len(findings), len(want))
+	}
+	for i, w := range want {
+		if findings[i].Kind != w.finding || findings[i].SourceIP != w.source || len(findings[i].EventRefs) != w.eventRefs {
+			t.Errorf("findings[%d] = %+v, want %+v", i, findings[i], w)
+		}
+	}
+	if findings[0].Details["files"] != "PSEXESVC.exe" {
+		t.Errorf("files = %s", findings[0].Details["files"])
+	}
+	if findings[2].Details["command"] != "cmd.exe /c whoami" {
+		t.Errorf("command = %s", findings[2].Details["command"])
+	}
+}
+
+func TestIsPublicIP(t *testing.T) {
+	for ip, want := range map[string]bool{"8.8.8.8": true, "10.1.2.3": false, "192.168.0.1": false, "127.0.0.1": false, "::1": false, "2001:4860::8888": true} {
+		if got := isPublicIP(net.ParseIP(ip)); got != want {
+			t.Errorf("isPublicIP(%s) = %v, want %v", ip, got, want)
+		}
+	}
+}
@@ -0,0 +1,465 @@
+// Copyright (c) 2020 Siemens AG
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+//
+// Author(s): Jonas Plum
+
+package builtin
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/tidwall/gjson"
+
+	"github.com/forensicanalysis/elementary/pluginlib"
+	"github.com/forensicanalysis/forensicstore"
+)
+
+var _ pluginlib.Plugin = &LogonSessions{}
+
+const (
+	rdpConnectionChannel = "Microsoft-Windows-TerminalServices-RemoteConnectionManager/Operational"
+	rdpSessionChannel    = "Microsoft-Windows-TerminalServices-LocalSessionManager/Operational"
+)
+
+// rdpAuthenticationWindow is the maximal time between the RDP authentication
+// and the logon of the session.
+const rdpAuthenticationWindow = 2 * time.Minute
+
+// LogonSessions correlates logon, logoff, privilege and RDP events into
+// logon sessions.
+type LogonSessions struct {
+	parameter pluginlib.ParameterList
+}
+
+func (l *LogonSessions) Name() string {
+	return "logon-sessions"
+}
+
+func (l *LogonSessions) Short() string {
+	return "Reconstruct logon sessions from Security and RDP events"
+}
+
+func (l *LogonSessions) Parameter() pluginlib.ParameterList {
+	if l.parameter == nil {
+		l.parameter = pluginlib.ParameterList{
+			{Name: "forensicstore", Type: pluginlib.Path, Description: "forensicstore", Required: true, Argument: true},
+			Filter,
+		}
+	}
+	return l.parameter
+}
+
+func (l *LogonSessions) Output() *pluginlib.Config {
+	return &pluginlib.Config{Header: []string{
+		"start_time",
+		"end_time",
+		"computer",
+		"user",
+		"logon_type_name",
+		"status",
+		"source_ip",
+		"source_host",
+	}}
+}
+
+func (l *LogonSessions) Run(p pluginlib.Plugin, out pluginlib.LineWriter) error {
+	store, teardown, err := getForensicStore(p)
+	if err != nil {
+		return err
+	}
+	defer teardown()
+
+	filter := pluginlib.ExtractFilter(p.Parameter().GetStringArrayValue("filter"))
+	return logonSessionsFromStore(out, store, filter)
+}
+
+// LogonSession is a successful or failed logon.
+type LogonSession struct {
+	Type                  string               `json:"type"`
+	Computer              string               `json:"computer,omitempty"`
+	User                  string               `json:"user,omitempty"`
+	UserSID               string               `json:"user_sid,omitempty"`
+	LogonID               string               `json:"logon_id,omitempty"`
+	LinkedLogonID         string               `json:"linked_logon_id,omitempty"`
+	SessionID             string               `json:"session_id,omitempty"`
+	LogonType             int64                `json:"logon_type,omitempty"`
+	LogonTypeName         string               `json:"logon_type_name,omitempty"`
+	Status                string               `json:"status"`
+	FailureStatus         string               `json:"failure_status,omitempty"`
+	FailureReason         string               `json:"failure_reason,omitempty"`
+	SourceIP              string               `json:"source_ip,omitempty"`
+	SourcePort            int64                `json:"source_port,omitempty"`
+	SourceHost            string               `json:"source_host,omitempty"`
+	LogonProcess          string               `json:"logon_process,omitempty"`
+	AuthenticationPackage string               `json:"authentication_package,omitempty"`
+	ProcessName           string               `json:"process_name,omitempty"`
+	AuthenticationTime    string               `json:"authentication_time,omitempty"`
+	StartTime             string               `json:"start_time,omitempty"`
+	EndTime               string               `json:"end_time,omitempty"`
+	Logoff                string               `json:"logoff,omitempty"`
+	Admin                 bool                 `json:"admin,omitempty"`
+	Privileges            []string             `json:"privileges,omitempty"`
+	ExplicitCredentials   []ExplicitCredential `json:"explicit_credentials,omitempty"`
+	Disconnects           []string             `json:"disconnects,omitempty"`
+	Reconnects            []SessionReconnect   `json:"reconnects,omitempty"`
+	EventRefs             []string             `json:"event_refs"`
+
+	first time.Time
+}
+
+// ExplicitCredential is the use of explicit credentials within a session.
+type ExplicitCredential struct {
+	Time        string `json:"time"`
+	User        string `json:"user"`
+	Server      string `json:"server,omitempty"`
+	ProcessName string `json:"process_name,omitempty"`
+	TargetIP    string `json:"target_ip,omitempty"`
+}
+
+// SessionReconnect is a reconnection to a disconnected RDP session.
+type SessionReconnect struct {
+	Time     string `json:"time"`
+	SourceIP string `json:"source_ip,omitempty"`
+}
+
+var logonTypeNames = map[int64]string{
+	2:  "Interactive",
+	3:  "Network",
+	4:  "Batch",
+	5:  "Service",
+	7:  "Unlock",
+	8:  "NetworkCleartext",
+	9:  "NewCredentials",
+	10: "RemoteInteractive",
+	11: "CachedInteractive",
+	12: "CachedRemoteInteractive",
+	13: "CachedUnlock",
+}
+
+var logonFailureReasons = map[string]string{
+	"0xc0000064": "unknown user name",
+	"0xc000006a": "wrong password",
+	"0xc000006d": "unknown user name or bad password",
+	"0xc000006f": "outside of the allowed logon hours",
+	"0xc0000070": "workstation restriction",
+	"0xc0000071": "expired password",
+	"0xc0000072": "account disabled",
+	"0xc000015b": "logon type not granted",
+	"0xc0000193": "account expired",
+	"0xc0000224": "password must be changed",
+	"0xc0000234": "account locked out",
+}
+
+// logonEventFilter selects the logon, logoff and RDP events.
+var logonEventFilter = append(append(
+	eventConditions("Security", 4624, 4625, 4634, 4647, 4648, 4672),
+	eventConditions(rdpConnectionChannel, 1149)...),
+	eventConditions(rdpSessionChannel, 21, 23, 24, 25)...,
+)
+
+type logonEvent struct {
+	id      string
+	key     string
+	time    time.Time
+	element []byte
+}
+
+func logonSessionsFromStore(out pluginlib.LineWriter, store *forensicstore.ForensicStore, filter pluginlib.Filter) error {
+	builder := &logonSessionBuilder{sessions: map[string]*LogonSession{}}
+	err := forEachSortedEvent(store, combineFilter(filter, logonEventFilter), func(event logonEvent) error {
+		builder.add(event)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, session := range builder.build() {
+		b, err := json.Marshal(session)
+		if err != nil {
+			return err
+		}
+		if b, err = pluginlib.SetSource(b, session.EventRefs[0]); err != nil {
+			return err
+		}
+		if err := out.WriteLine(b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// forEachSortedEvent calls fn for the eventlog elements ordered by time, as
+// events of different channels are stored in separate files. The events are
+// sorted by SQLite, so they are not loaded into memory.
+func forEachSortedEvent(store *forensicstore.ForensicStore, filter pluginlib.Filter, fn func(event logonEvent) error) (err error) {
+	it, err := pluginlib.IterateSorted(store, filter, "System.TimeCreated.SystemTime")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := it.Close(); err == nil {
+			err = cerr
+		}
+	}()
+
+	for it.Next() {
+		element := it.Element()
+		system := gjson.GetBytes(element, "System")
+		err := fn(logonEvent{
+			id:      gjson.GetBytes(element, "id").String(),
+			key:     system.Get("Channel").String() + "/" + system.Get("EventID.Value").String(),
+			time:    unixTime(system.Get("TimeCreated.SystemTime").Float()),
+			element: element,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return it.Err()
+}
+
+type logonSessionBuilder struct {
+	all             []*LogonSession
+	sessions        map[string]*LogonSession
+	authentications []*LogonSession
+}
+
+// session returns the open session with the key or creates a session for
+// events whose logon is not contained in the eventlog.
+func (b *logonSessionBuilder) session(key string, event logonEvent, create func() *LogonSession) *LogonSession {
+	if session, ok := b.sessions[key]; ok {
+		session.EventRefs = append(session.EventRefs, event.id)
+		return session
+	}
+	session := create()
+	b.start(key, session, event)
+	return session
+}
+
+func (b *logonSessionBuilder) start(key string, session *LogonSession, event logonEvent) {
+	session.Type = "logon-session"
+	session.EventRefs = append(session.EventRefs, event.id)
+	session.first = event.time
+	b.all = append(b.all, session)
+	if key != "" {
+		b.sessions[key] = session
+	}
+}
+
+func (b *logonSessionBuilder) add(event logonEvent) { // nolint: gocyclo
+	computer := gjson.GetBytes(event.element, "System.Computer").String()
+	data := gjson.GetBytes(event.element, "EventData")
+	eventXML := gjson.GetBytes(event.element, "UserData.EventXML")
+	t := event.time.Format(time.RFC3339Nano)
+	securityKey := func(field string) string {
+		return "security|" + computer + "|" + hexString(data.Get(field))
+	}
+	orphan := func(prefix, logonIDField string) func() *LogonSession {
+		return func() *LogonSession {
+			return &LogonSession{
+				Computer: computer,
+				User:     securityUser(data, prefix),
+				UserSID:  data.Get(prefix + "UserSid").String(),
+				LogonID:  hexString(data.Get(logonIDField)),
+				Status:   "success",
+			}
+		}
+	}
+
+	switch event.key {
+	case "Security/4624":
+		logonType := data.Get("LogonType").Int()
+		session := &LogonSession{
+			Computer:              computer,
+			User:                  securityUser(data, "Target"),
+			UserSID:               data.Get("TargetUserSid").String(),
+			LogonID:               hexString(data.Get("TargetLogonId")),
+			LinkedLogonID:         nonZeroLogonID(hexString(data.Get("TargetLinkedLogonId"))),
+			LogonType:             logonType,
+			LogonTypeName:         logonTypeNames[logonType],
+			Status:                "success",
+			SourceIP:              eventIP(data.Get("IpAddress").String()),
+			SourcePort:            data.Get("IpPort").Int(),
+			SourceHost:            eventValue(data.Get("WorkstationName").String()),
+			LogonProcess:          strings.TrimSpace(data.Get("LogonProcessName").String()),
+			AuthenticationPackage: data.Get("AuthenticationPackageName").String(),
+			ProcessName:           eventValue(data.Get("ProcessName").String()),
+			StartTime:             t,
+		}
+		b.start(securityKey("TargetLogonId"), session, event)
+	case "Security/4625":
+		logonType := data.Get("LogonType").Int()
+		status := hexString(data.Get("SubStatus"))
+		if status == "" || status == "0x0" {
+			status = hexString(data.Get("Status"))
+		}
+		b.start("", &LogonSession{
+			Computer:              computer,
+			User:                  securityUser(data, "Target"),
+			UserSID:               data.Get("TargetUserSid").String(),
+			LogonType:             logonType,
+			LogonTypeName:         logonTypeNames[logonType],
+			Status:                "failed",
+			FailureStatus:         status,
+			FailureReason:         logonFailureReasons[status],
+			SourceIP:              eventIP(data.Get("IpAddress").String()),
+			SourcePort:            data.Get("IpPort").Int(),
+			SourceHost:            eventValue(data.Get("WorkstationName").String()),
+			LogonProcess:          strings.TrimSpace(data.Get("LogonProcessName").String()),
+			AuthenticationPackage: data.Get("AuthenticationPackageName").String(),
+			ProcessName:           eventValue(data.Get("ProcessName").String()),
+			StartTime:             t,
+		}, event)
+	case "Security/4634", "Security/4647":
+		session := b.session(securityKey("TargetLogonId"), event, orphan("Target", "TargetLogonId"))
+		if session.EndTime == "" {
+			session.EndTime = t
+			session.Logoff = "logoff"
+			if event.key == "Security/4647" {
+				session.Logoff = "user"
+			}
+		}
+		delete(b.sessions, securityKey("TargetLogonId"))
+	case "Security/4648":
+		session := b.session(securityKey("SubjectLogonId"), event, orphan("Subject", "SubjectLogonId"))
+		session.ExplicitCredentials = append(session.ExplicitCredentials, ExplicitCredential{
+			Time:        t,
+			User:        securityUser(data, "Target"),
+			Server:      eventValue(data.Get("TargetServerName").String()),
+			ProcessName: eventValue(data.Get("ProcessName").String()),
+			TargetIP:    eventIP(data.Get("IpAddress").String()),
+		})
+	case "Security/4672":
+		session := b.session(securityKey("SubjectLogonId"), event, orphan("Subject", "SubjectLogonId"))
+		session.Admin = true
+		session.Privileges = strings.Fields(data.Get("PrivilegeList").String())
+	case rdpConnectionChannel + "/1149":
+		user := eventXML.Get("Param1").String()
+		if domain := eventXML.Get("Param2").String(); domain != "" {
+			user = domain + `\` + user
+		}
+		session := &LogonSession{
+			Computer:           computer,
+			User:               user,
+			LogonType:          10,
+			LogonTypeName:      logonTypeNames[10],
+			Status:             "authenticated",
+			SourceIP:           eventIP(eventXML.Get("Param3").String()),
+			AuthenticationTime: t,
+		}
+		b.start("", session, event)
+		b.authentications = append(b.authentications, session)
+	case rdpSessionChannel + "/21":
+		session := &LogonSession{
+			Computer:      computer,
+			User:          eventXML.Get("User").String(),
+			SessionID:     eventXML.Get("SessionID").String(),
+			LogonType:     10,
+			LogonTypeName: logonTypeNames[10],
+			Status:        "success",
+			SourceIP:      eventIP(eventXML.Get("Address").String()),
+			StartTime:     t,
+		}
+		if auth := b.authentication(session, event.time); auth != nil {
+			// the authentication becomes part of the session
+			session.AuthenticationTime = auth.AuthenticationTime
+			session.EventRefs = auth.EventRefs
+			b.remove(auth)
+		}
+		b.start(rdpKey(computer, eventXML), session, event)
+	case rdpSessionChannel + "/23", rdpSessionChannel + "/24", rdpSessionChannel + "/25":
+		session := b.session(rdpKey(computer, eventXML), event, func() *LogonSession {
+			return &LogonSession{
+				Computer:      computer,
+				User:          eventXML.Get("User").String(),
+				SessionID:     eventXML.Get("SessionID").String(),
+				LogonType:     10,
+				LogonTypeName: logonTypeNames[10],
+				Status:        "success",
+			}
+		})
+		switch event.key {
+		case rdpSessionChannel + "/23":
+			session.EndTime = t
+			session.Logoff = "logoff"
+			delete(b.sessions, rdpKey(computer, eventXML))
+		case rdpSessionChannel + "/24":
+			session.Disconnects = append(session.Disconnects, t)
+		case rdpSessionChannel + "/25":
+			session.Reconnects = append(session.Reconnects, SessionReconnect{Time: t, SourceIP: eventIP(eventXML.Get("Address").String())})
+		}
+	}
+}
+
+func rdpKey(computer string, eventXML gjson.Result) string {
+	return "rdp|" + computer + "|" + strings.ToLower(eventXML.Get("User").String()) + "|" + eventXML.Get("SessionID").String()
+}
+
+// authentication finds the latest unused RDP authentication of the session.
+func (b *logonSessionBuilder) authentication(session *LogonSession, t time.Time) *LogonSession {
+	for i := len(b.authentications) - 1; i >= 0; i-- {
+		auth := b.authentications[i]
+		if t.Sub(auth.first) > rdpAuthenticationWindow {
+			break
+		}
+		if auth.Computer == session.Computer && auth.SourceIP == session.SourceIP && strings.EqualFold(auth.User, session.User) {
+			b.authentications = append(b.authentications[:i], b.authentications[i+1:]...)
+			return auth
+		}
+	}
+	return nil
+}
+
+func (b *logonSessionBuilder) remove(session *LogonSession) {
+	for i, s := range b.all {
+		if s == session {
+			b.all = append(b.all[:i], b.all[i+1:]...)
+			return
+		}
+	}
+}
+
+// build returns all sessions ordered by their first event.
+func (b *logonSessionBuilder) build() []*LogonSession {
+	sort.SliceStable(b.all, func(i, j int) bool { return b.all[i].first.Before(b.all[j].first) })
+	return b.all
+}
+
+// eventValue removes the placeholder used for empty values.
+func eventValue(s string) string {
+	if s == "-" {
+		return ""
+	}
+	return s
+}
+
+func eventIP(s string) string {
+	return strings.TrimPrefix(eventValue(s), "::ffff:")
+}
+
+func nonZeroLogonID(s string) string {
+	if s == "0x0" {
+		return ""
+	}
+	return s
+}
@@ -0,0 +1,121 @@
+// Copyright (c) 2020 Siemens AG
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+//
+// Author(s): Jonas Plum
+
+package builtin
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/tidwall/gjson"
+
+	"github.com/forensicanalysis/forensicstore"
+)
+
+func TestLogonSessionBuilder(t *testing.T) {
+	event := func(id int, channel string, eventID int, seconds int, data string) logonEvent {
+		return logonEvent{
+			id:      fmt.Sprintf("eventlog--%d", id),
+			key:     fmt.Sprintf("%s/%d", channel, eventID),
+			time:    time.Unix(int64(seconds), 0).UTC(),
+			element: []byte(fmt.Sprintf(`{"System": {"Computer": "PC"}, %s}`, data)),
+		}
+	}
+	events := []logonEvent{
+		event(1, "Security", 4624, 100, `"EventData": {"TargetUserSid": "S-1-5-21-1", "TargetUserName": "admin", "TargetDomainName": "CORP", "TargetLogonId": "0x1234", "LogonType": "3", "IpAddress": "10.0.0.5", "IpPort": "50000", "WorkstationName": "WS01", "LogonProcessName": "NtLmSsp ", "AuthenticationPackageName": "NTLM"}`),
+		event(2, "Security", 4672, 100, `"EventData": {"SubjectLogonId": "0x1234", "PrivilegeList": "SeDebugPrivilege\n\t\t\tSeBackupPrivilege"}`),
+		event(3, "Security", 4648, 110, `"EventData": {"SubjectLogonId": "0x1234", "TargetUserName": "svc", "TargetDomainName": "CORP", "TargetServerName": "srv01", "ProcessName": "C:\\Windows\\System32\\cmd.exe", "IpAddress": "-"}`),
+		event(4, "Security", 4625, 120, `"EventData": {"TargetUserName": "guest", "TargetDomainName": "PC", "LogonType": "3", "Status": "0xC000006D", "SubStatus": "0xC000006A", "IpAddress": "10.0.0.6"}`),
+		event(5, "Security", 4634, 130, `"EventData": {"TargetLogonId": 4660}`),
+		event(6, "Security", 4634, 140, `"EventData": {"TargetUserName": "bob", "TargetDomainName": "PC", "TargetLogonId": "0x9999"}`),
+		event(7, rdpConnectionChannel, 1149, 200, `"UserData": {"EventXML": {"Param1": "admin", "Param2": "CORP", "Param3": "10.0.0.7"}}`),
+		event(8, rdpSessionChannel, 21, 205, `"UserData": {"EventXML": {"User": "CORP\\admin", "SessionID": "2", "Address": "10.0.0.7"}}`),
+		event(9, rdpSessionChannel, 24, 300, `"UserData": {"EventXML": {"User": "CORP\\admin", "SessionID": "2", "Address": "10.0.0.7"}}`),
+		event(10, rdpSessionChannel, 25, 400, `"UserData": {"EventXML": {"User": "CORP\\admin", "SessionID": "2", "Address": "10.0.0.8"}}`),
+		event(11, rdpSessionChannel, 23, 500, `"UserData": {"EventXML": {"User": "CORP\\admin", "SessionID": "2", "Address": "LOCAL"}}`),
+	}
+
+	builder := &logonSessionBuilder{sessions: map[string]*LogonSession{}}
+	for _, e := range events {
+		builder.add(e)
+	}
+	sessions := builder.build()
+	if len(sessions) != 4 {
+		t.Fatalf("len(sessions) = %d, want 4", len(sessions))
+	}
+
+	network := sessions[0]
+	if network.User != `CORP\admin` || network.LogonTypeName != "Network" || network.SourceIP != "10.0.0.5" || network.SourcePort != 50000 ||
+		network.SourceHost != "WS01" || network.LogonProcess != "NtLmSsp" || !network.Admin || len(network.Privileges) != 2 ||
+		len(network.ExplicitCredentials) != 1 || network.ExplicitCredentials[0].Server != "srv01" ||
+		network.StartTime != "1970-01-01T00:01:40Z" || network.EndTime != "1970-01-01T00:02:10Z" || len(network.EventRefs) != 4 {
+		t.Errorf("network session = %+v", network)
+	}
+
+	failed := sessions[1]
+	if failed.Status != "failed" || failed.FailureReason != "wrong password" || failed.User != `PC\guest` {
+		t.Errorf("failed session = %+v", failed)
+	}
+
+	orphan := sessions[2]
+	if orphan.User != `PC\bob` || orphan.StartTime != "" || orphan.EndTime != "1970-01-01T00:02:20Z" {
+		t.Errorf("orphan session = %+v", orphan)
+	}
+
+	rdp := sessions[3]
+	if rdp.AuthenticationTime != "1970-01-01T00:03:20Z" || rdp.SessionID != "2" || rdp.SourceIP != "10.0.0.7" ||
+		len(rdp.Disconnects) != 1 || len(rdp.Reconnects) != 1 || rdp.Reconnects[0].SourceIP != "10.0.0.8" ||
+		rdp.EndTime != "1970-01-01T00:08:20Z" || len(rdp.EventRefs) != 5 || rdp.EventRefs[0] != "eventlog--7" {
+		t.Errorf("rdp session = %+v", rdp)
+	}
+}
+
+func TestLogonSessionsFromStore(t *testing.T) {
+	store, teardown, err := forensicstore.New(filepath.Join(t.TempDir(), "test.forensicstore"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer teardown()
+
+	// the logoff is stored before the logon, like events of separate files
+	for _, element := range []string{
+		`{"type": "eventlog", "System": {"Channel": "Security", "Computer": "PC", "EventID": {"Value": 4634}, "TimeCreated": {"SystemTime": 130.5}}, "EventData": {"TargetLogonId": "0x1234"}}`,
+		`{"type": "eventlog", "System": {"Channel": "Security", "Computer": "PC", "EventID": {"Value": 4624}, "TimeCreated": {"SystemTime": 100}}, "EventData": {"TargetUserName": "admin", "TargetLogonId": "0x1234", "LogonType": "2"}}`,
+	} {
+		if _, err := store.Insert([]byte(element)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tlw := &testLineWriter{}
+	if err := logonSessionsFromStore(tlw, store, nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(tlw.lines) != 1 {
+		t.Fatalf("len(sessions) = %d, want 1: %q", len(tlw.lines), tlw.lines)
+	}
+	session := gjson.ParseBytes(tlw.lines[0])
+	if session.Get("start_time").String() != "1970-01-01T00:01:40Z" || session.Get("end_time").String() != "1970-01-01T00:02:10.5Z" {
+		t.Errorf("session = %s", tlw.lines[0])
+	}
+}
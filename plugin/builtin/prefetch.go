@@ -23,6 +23,7 @@ package builtin
 
 import (
 	"encoding/json"
+	"fmt"
 
 	"github.com/tidwall/gjson"
 	goprefetch "www.velocidex.com/golang/go-prefetch"
@@ -79,33 +80,37 @@ func prefetchFromStore(out pluginlib.LineWriter, store *forensicstore.ForensicSt
 		filter = pluginlib.Filter{{"type": "file", "name": "%.pf"}}
 	}
 
-	fileElements, err := store.Select(filter)
-	if err != nil {
-		return err
-	}
-
-	for _, element := range fileElements {
+	return pluginlib.ForEach(store, filter, func(element []byte) error {
 		exportPath := gjson.GetBytes(element, "export_path")
 		if exportPath.Exists() && exportPath.String() != "" {
+			id := gjson.GetBytes(element, "id").String()
+
 			buff, err := fileToReader(store, exportPath)
 			if err != nil {
-				return err
+				pluginlib.Warn(out, id, fmt.Errorf("could not read %s: %w", exportPath.String(), err))
+				return nil
 			}
 
 			prefetchInfo, err := goprefetch.LoadPrefetch(buff)
 			if err != nil {
-				return err
+				pluginlib.Warn(out, id, fmt.Errorf("could not parse %s: %w", exportPath.String(), err))
+				return nil
 			}
 
 			elem, err := prefetchToElement(prefetchInfo)
 			if err != nil {
 				return err
 			}
+			if elem, err = pluginlib.SetSource(elem, id); err != nil {
+				return err
+			}
 
-			out.WriteLine(elem) // nolint: errcheck
+			if err := out.WriteLine(elem); err != nil {
+				return err
+			}
 		}
-	}
-	return nil
+		return nil
+	})
 }
 
 func prefetchToElement(prefetchInfo *goprefetch.PrefetchInfo) (forensicstore.JSONElement, error) {
@@ -25,6 +25,8 @@ import (
 	"log"
 	"path/filepath"
 	"testing"
+
+	"github.com/forensicanalysis/forensicstore"
 )
 
 func TestPrefetchPlugin_Run(t *testing.T) {
@@ -70,3 +72,44 @@ func TestPrefetchPlugin_Run(t *testing.T) {
 		})
 	}
 }
+
+func TestPrefetchPlugin_RunCorrupt(t *testing.T) {
+	storePath := filepath.Join(t.TempDir(), "corrupt.forensicstore")
+	store, teardown, err := forensicstore.New(storePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"A.pf", "B.pf"} {
+		exportPath, f, fileTeardown, err := store.StoreFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _ = f.Write([]byte("no prefetch"))
+		_ = fileTeardown()
+
+		file := forensicstore.NewFile()
+		file.Name = name
+		file.ExportPath = exportPath
+		if _, err := store.InsertStruct(file); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_ = teardown()
+
+	tlw := &testLineWriter{}
+	command := &Prefetch{}
+	command.Parameter().Set("forensicstore", storePath)
+	if err := command.Run(command, tlw); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	if len(tlw.lines) != 0 {
+		t.Errorf("len(elements) = %v, want 0", len(tlw.lines))
+	}
+	if len(tlw.diagnostics) != 2 {
+		t.Fatalf("len(diagnostics) = %v, want 2", len(tlw.diagnostics))
+	}
+	if tlw.diagnostics[0].Source == "" {
+		t.Errorf("diagnostic %v has no source", tlw.diagnostics[0])
+	}
+}
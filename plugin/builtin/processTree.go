@@ -0,0 +1,493 @@
+// Copyright (c) 2020 Siemens AG
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+//
+// Author(s): Jonas Plum
+
+package builtin
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/tidwall/gjson"
+
+	"github.com/forensicanalysis/elementary/pluginlib"
+	"github.com/forensicanalysis/forensicstore"
+)
+
+var _ pluginlib.Plugin = &ProcessTree{}
+
+// processNamespace is used to derive the ids of process elements, so parent
+// and child references are stable between runs.
+var processNamespace = uuid.MustParse("5c4f2b8e-3f2a-4d8b-9a55-1f8c2e7d6b90")
+
+// processMergeWindow is the maximal time between the Security and the Sysmon
+// event of the same process creation.
+const processMergeWindow = time.Second
+
+// ProcessTree reconstructs process trees from the process creation and
+// termination events of the Security and the Sysmon eventlog.
+type ProcessTree struct {
+	parameter pluginlib.ParameterList
+}
+
+func (pt *ProcessTree) Name() string {
+	return "process-tree"
+}
+
+func (pt *ProcessTree) Short() string {
+	return "Reconstruct process trees from Security and Sysmon events"
+}
+
+func (pt *ProcessTree) Parameter() pluginlib.ParameterList {
+	if pt.parameter == nil {
+		pt.parameter = pluginlib.ParameterList{
+			{Name: "forensicstore", Type: pluginlib.Path, Description: "forensicstore", Required: true, Argument: true},
+			Filter,
+			{Name: "window", Type: pluginlib.String, Description: "maximum lifetime of a parent process without termination event, e.g. 24h", Value: "", Required: false},
+		}
+	}
+	return pt.parameter
+}
+
+func (pt *ProcessTree) Output() *pluginlib.Config {
+	return &pluginlib.Config{Header: []string{"name", "pid", "user", "created", "command_line"}}
+}
+
+func (pt *ProcessTree) Run(p pluginlib.Plugin, out pluginlib.LineWriter) error {
+	var window time.Duration
+	if value := p.Parameter().StringValue("window"); value != "" {
+		var err error
+		if window, err = time.ParseDuration(value); err != nil || window < 0 {
+			return fmt.Errorf("invalid window %s", value)
+		}
+	}
+
+	store, teardown, err := getForensicStore(p)
+	if err != nil {
+		return err
+	}
+	defer teardown()
+
+	filter := pluginlib.ExtractFilter(p.Parameter().GetStringArrayValue("filter"))
+	return processTreeFromStore(out, store, filter, window)
+}
+
+// processEventFilter selects the process creation and termination events.
+var processEventFilter = append(
+	eventConditions("Security", 4688, 4689),
+	eventConditions(sysmonChannel, 1, 5)...,
+)
+
+func processTreeFromStore(out pluginlib.LineWriter, store *forensicstore.ForensicStore, filter pluginlib.Filter, window time.Duration) error {
+	tree := &processTreeBuilder{window: window, guids: map[string]*processNode{}, pids: map[string][]*processNode{}, inferred: map[string]*processNode{}}
+	err := pluginlib.ForEach(store, combineFilter(filter, processEventFilter), func(element []byte) error {
+		tree.add(element)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, node := range tree.build() {
+		b, err := json.Marshal(node.element())
+		if err != nil {
+			return err
+		}
+		if b, err = pluginlib.SetSource(b, node.source); err != nil {
+			return err
+		}
+		if err := out.WriteLine(b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type processNode struct {
+	id          string
+	source      string
+	sources     []string
+	computer    string
+	pid         int64
+	image       string
+	commandLine string
+	created     time.Time
+	terminated  time.Time
+	exitStatus  string
+	user        string
+	userSID     string
+	logonID     string
+	processGUID string
+	inferred    bool
+
+	parentPID     int64
+	parentImage   string
+	parentCommand string
+	parentGUID    string
+	parentLogonID string
+
+	parent   *processNode
+	children []*processNode
+}
+
+type processTermination struct {
+	computer    string
+	pid         int64
+	image       string
+	processGUID string
+	time        time.Time
+	exitStatus  string
+}
+
+type processTreeBuilder struct {
+	window       time.Duration
+	nodes        []*processNode
+	terminations []processTermination
+	guids        map[string]*processNode
+	pids         map[string][]*processNode
+	inferred     map[string]*processNode
+}
+
+func pidKey(computer string, pid int64) string {
+	return computer + "|" + strconv.FormatInt(pid, 10)
+}
+
+// add parses a process creation or termination event.
+func (b *processTreeBuilder) add(event []byte) {
+	system := gjson.GetBytes(event, "System")
+	data := gjson.GetBytes(event, "EventData")
+	computer := system.Get("Computer").String()
+	created := unixTime(system.Get("TimeCreated.SystemTime").Float())
+	if t, err := time.Parse("2006-01-02 15:04:05.999999999", data.Get("UtcTime").String()); err == nil {
+		created = t.UTC()
+	}
+
+	switch system.Get("Channel").String() + "/" + system.Get("EventID.Value").String() {
+	case "Security/4688":
+		node := &processNode{
+			source:        gjson.GetBytes(event, "id").String(),
+			sources:       []string{"security"},
+			computer:      computer,
+			pid:           hexValue(data.Get("NewProcessId")),
+			image:         data.Get("NewProcessName").String(),
+			commandLine:   data.Get("CommandLine").String(),
+			created:       created,
+			user:          securityUser(data, "Subject"),
+			userSID:       data.Get("SubjectUserSid").String(),
+			logonID:       hexString(data.Get("SubjectLogonId")),
+			parentPID:     hexValue(data.Get("ProcessId")),
+			parentImage:   data.Get("ParentProcessName").String(),
+			parentLogonID: hexString(data.Get("SubjectLogonId")),
+		}
+		// the target fields are set if the process runs as another user
+		if target := data.Get("TargetUserSid").String(); target != "" && target != "S-1-0-0" {
+			node.user = securityUser(data, "Target")
+			node.userSID = target
+		}
+		if logonID := hexString(data.Get("TargetLogonId")); logonID != "" && logonID != "0x0" {
+			node.logonID = logonID
+		}
+		b.nodes = append(b.nodes, node)
+	case sysmonChannel + "/1":
+		b.nodes = append(b.nodes, &processNode{
+			source:        gjson.GetBytes(event, "id").String(),
+			sources:       []string{"sysmon"},
+			computer:      computer,
+			pid:           data.Get("ProcessId").Int(),
+			image:         data.Get("Image").String(),
+			commandLine:   data.Get("CommandLine").String(),
+			created:       created,
+			user:          data.Get("User").String(),
+			logonID:       hexString(data.Get("LogonId")),
+			processGUID:   data.Get("ProcessGuid").String(),
+			parentPID:     data.Get("ParentProcessId").Int(),
+			parentImage:   data.Get("ParentImage").String(),
+			parentCommand: data.Get("ParentCommandLine").String(),
+			parentGUID:    data.Get("ParentProcessGuid").String(),
+		})
+	case "Security/4689":
+		b.terminations = append(b.terminations, processTermination{
+			computer:   computer,
+			pid:        hexValue(data.Get("ProcessId")),
+			image:      data.Get("ProcessName").String(),
+			time:       created,
+			exitStatus: hexString(data.Get("Status")),
+		})
+	case sysmonChannel + "/5":
+		b.terminations = append(b.terminations, processTermination{
+			computer:    computer,
+			pid:         data.Get("ProcessId").Int(),
+			image:       data.Get("Image").String(),
+			processGUID: data.Get("ProcessGuid").String(),
+			time:        created,
+		})
+	}
+}
+
+// build merges duplicate processes, adds the terminations and links the
+// processes to their parents. It returns the processes ordered by creation.
+func (b *processTreeBuilder) build() []*processNode {
+	sort.SliceStable(b.nodes, func(i, j int) bool { return b.nodes[i].created.Before(b.nodes[j].created) })
+	b.merge()
+
+	for _, node := range b.nodes {
+		if node.processGUID != "" {
+			b.guids[node.processGUID] = node
+		}
+		key := pidKey(node.computer, node.pid)
+		b.pids[key] = append(b.pids[key], node)
+	}
+	for _, termination := range b.terminations {
+		if node := b.terminated(termination); node != nil && node.terminated.IsZero() {
+			node.terminated = termination.time
+			node.exitStatus = termination.exitStatus
+		}
+	}
+
+	var nodes []*processNode
+	for _, node := range b.nodes {
+		node.id = processID(node.computer, strconv.FormatInt(node.pid, 10), node.created.Format(time.RFC3339Nano), node.processGUID)
+	}
+	for _, node := range b.nodes {
+		parent := b.findParent(node)
+		if parent != nil {
+			if parent.inferred && len(parent.children) == 0 {
+				// inferred parents are placed before their first child
+				nodes = append(nodes, parent)
+			}
+			node.parent = parent
+			parent.children = append(parent.children, node)
+		}
+		nodes = append(nodes, node)
+	}
+	return nodes
+}
+
+// merge combines the Security and the Sysmon event of the same process.
+func (b *processTreeBuilder) merge() {
+	var merged []*processNode
+	for i, node := range b.nodes {
+		if node == nil {
+			continue
+		}
+		for j := i + 1; j < len(b.nodes); j++ {
+			other := b.nodes[j]
+			if other == nil {
+				continue
+			}
+			if other.created.Sub(node.created) > processMergeWindow {
+				break
+			}
+			if other.computer == node.computer && other.pid == node.pid && strings.EqualFold(other.image, node.image) &&
+				other.sources[0] != node.sources[0] {
+				node.mergeWith(other)
+				b.nodes[j] = nil
+				break
+			}
+		}
+		merged = append(merged, node)
+	}
+	b.nodes = merged
+}
+
+func (n *processNode) mergeWith(other *processNode) {
+	if n.sources[0] != "sysmon" {
+		// the Sysmon event has more details and the exact time
+		*n, *other = *other, *n
+	}
+	n.sources = []string{"security", "sysmon"}
+	for _, field := range []struct{ value, other *string }{
+		{&n.commandLine, &other.commandLine},
+		{&n.user, &other.user},
+		{&n.userSID, &other.userSID},
+		{&n.logonID, &other.logonID},
+		{&n.parentImage, &other.parentImage},
+		{&n.parentLogonID, &other.parentLogonID},
+	} {
+		if *field.value == "" {
+			*field.value = *field.other
+		}
+	}
+}
+
+// terminated finds the process of a termination event, the latest process
+// with the process id created before the termination.
+func (b *processTreeBuilder) terminated(termination processTermination) *processNode {
+	if node, ok := b.guids[termination.processGUID]; ok && termination.processGUID != "" {
+		return node
+	}
+	var found *processNode
+	for _, node := range b.pids[pidKey(termination.computer, termination.pid)] {
+		if node.created.After(termination.time) {
+			break
+		}
+		if termination.image == "" || strings.EqualFold(node.image, termination.image) {
+			found = node
+		}
+	}
+	return found
+}
+
+// findParent links by the parent process guid of Sysmon or by the process id.
+// Of all processes with the parent process id, that were running when the
+// child was created, the latest is used. Processes with the same logon id or
+// image are preferred. If no parent is found, an inferred parent is created
+// from the information of the child.
+func (b *processTreeBuilder) findParent(node *processNode) *processNode { // nolint: gocyclo
+	if parent, ok := b.guids[node.parentGUID]; ok && node.parentGUID != "" && parent != node {
+		return parent
+	}
+	if node.parentPID == 0 && node.parentImage == "" {
+		return nil
+	}
+
+	var best *processNode
+	bestScore := -1
+	for _, candidate := range b.pids[pidKey(node.computer, node.parentPID)] {
+		if !candidate.created.Before(node.created) {
+			break
+		}
+		if !candidate.terminated.IsZero() && candidate.terminated.Before(node.created) {
+			continue
+		}
+		if candidate.terminated.IsZero() && b.window > 0 && node.created.Sub(candidate.created) > b.window {
+			continue
+		}
+		score := 0
+		if node.parentLogonID != "" && strings.EqualFold(candidate.logonID, node.parentLogonID) {
+			score++
+		}
+		if node.parentImage != "" && strings.EqualFold(candidate.image, node.parentImage) {
+			score++
+		}
+		// later candidates win on the same score
+		if score >= bestScore {
+			best, bestScore = candidate, score
+		}
+	}
+	if best != nil {
+		return best
+	}
+
+	key := strings.ToLower(strings.Join([]string{node.computer, strconv.FormatInt(node.parentPID, 10), node.parentGUID, node.parentImage}, "|"))
+	if parent, ok := b.inferred[key]; ok {
+		return parent
+	}
+	parent := &processNode{
+		id:          processID(key),
+		source:      node.source,
+		sources:     node.sources,
+		computer:    node.computer,
+		pid:         node.parentPID,
+		image:       node.parentImage,
+		commandLine: node.parentCommand,
+		processGUID: node.parentGUID,
+		inferred:    true,
+	}
+	b.inferred[key] = parent
+	return parent
+}
+
+func processID(parts ...string) string {
+	return "process--" + uuid.NewSHA1(processNamespace, []byte(strings.Join(parts, "\x00"))).String()
+}
+
+func (n *processNode) element() map[string]interface{} {
+	element := normalizedElement{"id": n.id, "type": "process"}
+	element.set("name", windowsBase(n.image))
+	element.set("computer", n.computer)
+	if n.pid != 0 || !n.inferred {
+		element["pid"] = n.pid
+	}
+	element.set("image", n.image)
+	element.set("command_line", n.commandLine)
+	if !n.created.IsZero() {
+		element["created"] = n.created.Format(time.RFC3339Nano)
+	}
+	if !n.terminated.IsZero() {
+		element["terminated"] = n.terminated.Format(time.RFC3339Nano)
+	}
+	element.set("exit_status", n.exitStatus)
+	element.set("user", n.user)
+	element.set("user_sid", n.userSID)
+	element.set("logon_id", n.logonID)
+	element.set("process_guid", n.processGUID)
+	if n.parent != nil {
+		element["parent_ref"] = n.parent.id
+	}
+	if !n.inferred {
+		element["parent_pid"] = n.parentPID
+		element.set("parent_image", n.parentImage)
+	}
+	if len(n.children) > 0 {
+		var refs []string
+		for _, child := range n.children {
+			refs = append(refs, child.id)
+		}
+		element["child_refs"] = refs
+	}
+	element["sources"] = n.sources
+	if n.inferred {
+		element["inferred"] = true
+	}
+	return element
+}
+
+// hexValue parses the hexadecimal values of the Security eventlog, like
+// process and logon ids. They are either numbers or strings like 0x3e7.
+func hexValue(value gjson.Result) int64 {
+	if value.Type == gjson.Number {
+		return value.Int()
+	}
+	s := strings.ToLower(value.String())
+	if !strings.HasPrefix(s, "0x") {
+		i, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return 0
+		}
+		return i
+	}
+	i, err := strconv.ParseUint(strings.TrimPrefix(s, "0x"), 16, 64)
+	if err != nil {
+		return 0
+	}
+	return int64(i)
+}
+
+// hexString formats a hexadecimal value like Windows, e.g. 0x3e7.
+func hexString(value gjson.Result) string {
+	if !value.Exists() || value.String() == "" || value.String() == "-" {
+		return ""
+	}
+	return "0x" + strconv.FormatUint(uint64(hexValue(value)), 16)
+}
+
+func securityUser(data gjson.Result, prefix string) string {
+	user := data.Get(prefix + "UserName").String()
+	if domain := data.Get(prefix + "DomainName").String(); domain != "" && domain != "-" {
+		return domain + `\` + user
+	}
+	return user
+}
@@ -0,0 +1,118 @@
+// Copyright (c) 2020 Siemens AG
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+//
+// Author(s): Jonas Plum
+
+package builtin
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/tidwall/gjson"
+)
+
+func securityProcessEvent(id string, t float64, pid, parentPID, image, logonID string) []byte {
+	return []byte(fmt.Sprintf(`{"id": %q, "type": "eventlog", "System": {"Channel": "Security", "EventID": {"Value": 4688}, "Computer": "PC", "TimeCreated": {"SystemTime": %f}},
+		"EventData": {"SubjectUserSid": "S-1-5-21-1", "SubjectUserName": "admin", "SubjectDomainName": "PC", "SubjectLogonId": %q,
+		"NewProcessId": %q, "NewProcessName": %q, "ProcessId": %q, "CommandLine": "", "TargetUserSid": "S-1-0-0", "TargetLogonId": "0x0"}}`,
+		id, t, logonID, pid, image, parentPID))
+}
+
+func TestProcessTreeBuilder(t *testing.T) {
+	events := [][]byte{
+		securityProcessEvent("eventlog--1", 1000, "0x10", "0x4", `C:\Windows\explorer.exe`, "0x1000"),
+		securityProcessEvent("eventlog--2", 1010, "0x20", "0x10", `C:\Office\WINWORD.EXE`, "0x1000"),
+		[]byte(`{"id": "eventlog--3", "type": "eventlog", "System": {"Channel": "Microsoft-Windows-Sysmon/Operational", "EventID": {"Value": 1}, "Computer": "PC", "TimeCreated": {"SystemTime": 1020}},
+			"EventData": {"UtcTime": "1970-01-01 00:17:00.000", "ProcessGuid": "{P3}", "ProcessId": 48, "Image": "C:\\Windows\\powershell.exe", "CommandLine": "powershell -enc AAAA", "User": "PC\\admin", "LogonId": "0x1000", "ParentProcessId": 32, "ParentImage": "C:\\Office\\WINWORD.EXE"}}`),
+		securityProcessEvent("eventlog--4", 1020.2, "0x30", "0x20", `C:\Windows\powershell.exe`, "0x1000"),
+		[]byte(`{"id": "eventlog--5", "type": "eventlog", "System": {"Channel": "Security", "EventID": {"Value": 4689}, "Computer": "PC", "TimeCreated": {"SystemTime": 1030}},
+			"EventData": {"ProcessId": "0x20", "ProcessName": "C:\\Office\\WINWORD.EXE", "Status": "0x0"}}`),
+		// the process id of winword is reused after it terminated
+		securityProcessEvent("eventlog--6", 1040, "0x20", "0x10", `C:\Windows\notepad.exe`, "0x1000"),
+		securityProcessEvent("eventlog--7", 1050, "0x50", "0x20", `C:\Windows\cmd.exe`, "0x1000"),
+	}
+
+	builder := &processTreeBuilder{guids: map[string]*processNode{}, pids: map[string][]*processNode{}, inferred: map[string]*processNode{}}
+	for _, event := range events {
+		builder.add(event)
+	}
+	nodes := builder.build()
+
+	byName := map[string]*processNode{}
+	for _, node := range nodes {
+		byName[windowsBase(node.image)] = node
+	}
+
+	if len(nodes) != 6 {
+		t.Fatalf("len(nodes) = %d, want 6 (5 processes and 1 inferred)", len(nodes))
+	}
+	if !nodes[0].inferred || nodes[0].pid != 4 {
+		t.Errorf("nodes[0] = %+v, want inferred parent 4", nodes[0])
+	}
+	for child, parent := range map[string]string{
+		"WINWORD.EXE":    "explorer.exe",
+		"powershell.exe": "WINWORD.EXE",
+		"notepad.exe":    "explorer.exe",
+		"cmd.exe":        "notepad.exe",
+	} {
+		if byName[child].parent != byName[parent] {
+			t.Errorf("parent of %s = %v, want %s", child, byName[child].parent, parent)
+		}
+	}
+
+	powershell := byName["powershell.exe"]
+	if len(powershell.sources) != 2 || powershell.processGUID != "{P3}" || powershell.userSID != "S-1-5-21-1" {
+		t.Errorf("powershell = %+v, want merged process", powershell)
+	}
+	if byName["WINWORD.EXE"].terminated.IsZero() || byName["WINWORD.EXE"].exitStatus != "0x0" {
+		t.Errorf("winword = %+v, want terminated", byName["WINWORD.EXE"])
+	}
+
+	element := byName["WINWORD.EXE"].element()
+	if element["parent_ref"] != byName["explorer.exe"].id || len(element["child_refs"].([]string)) != 1 {
+		t.Errorf("element = %v", element)
+	}
+}
+
+func TestHexValue(t *testing.T) {
+	tests := []struct {
+		value      string
+		want       int64
+		wantString string
+	}{
+		{`999`, 999, "0x3e7"},
+		{`"0x3e7"`, 999, "0x3e7"},
+		{`"0X3E7"`, 999, "0x3e7"},
+		{`"999"`, 999, "0x3e7"},
+		{`3221225578`, 3221225578, "0xc000006a"},
+		{`"-"`, 0, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			value := gjson.Parse(tt.value)
+			if got := hexValue(value); got != tt.want {
+				t.Errorf("hexValue() = %v, want %v", got, tt.want)
+			}
+			if got := hexString(value); got != tt.wantString {
+				t.Errorf("hexString() = %v, want %v", got, tt.wantString)
+			}
+		})
+	}
+}
@@ -0,0 +1,272 @@
+// Copyright (c) 2020 Siemens AG
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+//
+// Author(s): Jonas Plum
+
+package builtin
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/tidwall/gjson"
+	"gopkg.in/yaml.v2"
+
+	"github.com/forensicanalysis/elementary/pluginlib"
+	"github.com/forensicanalysis/forensicstore"
+)
+
+var _ pluginlib.Plugin = &Sigma{}
+
+// Sigma evaluates Sigma rules over eventlog elements.
+type Sigma struct {
+	parameter pluginlib.ParameterList
+}
+
+func (s *Sigma) Name() string {
+	return "sigma"
+}
+
+func (s *Sigma) Short() string {
+	return "Run Sigma rules on eventlogs"
+}
+
+func (s *Sigma) Parameter() pluginlib.ParameterList {
+	if s.parameter == nil {
+		s.parameter = pluginlib.ParameterList{
+			{Name: "forensicstore", Type: pluginlib.Path, Description: "forensicstore", Required: true, Argument: true},
+			Filter,
+			{Name: "rules", Type: pluginlib.PathArray, Description: "Sigma rule files or directories", Required: true},
+			{Name: "level", Type: pluginlib.String, Description: "minimal level of the rules, e.g. high", Value: "informational", Required: false},
+		}
+	}
+	return s.parameter
+}
+
+func (s *Sigma) Output() *pluginlib.Config {
+	return &pluginlib.Config{Header: []string{"event_time", "level", "title", "computer", "channel", "event_id"}}
+}
+
+func (s *Sigma) Run(p pluginlib.Plugin, out pluginlib.LineWriter) error {
+	level := p.Parameter().StringValue("level")
+	if _, ok := severityLevels[level]; !ok {
+		return fmt.Errorf("invalid level %s", level)
+	}
+
+	rules, err := loadSigmaRules(p.Parameter().GetStringArrayValue("rules"), level, func(path string, err error) {
+		pluginlib.Warn(out, path, err)
+	})
+	if err != nil {
+		return err
+	}
+	if len(rules) == 0 {
+		return errors.New("no sigma rules found")
+	}
+
+	store, teardown, err := getForensicStore(p)
+	if err != nil {
+		return err
+	}
+	defer teardown()
+
+	filter := pluginlib.ExtractFilter(p.Parameter().GetStringArrayValue("filter"))
+	return sigmaFromStore(out, store, filter, rules)
+}
+
+// SigmaMatch is an eventlog element matched by a Sigma rule.
+type SigmaMatch struct {
+	Type           string            `json:"type"`
+	RuleID         string            `json:"rule_id,omitempty"`
+	Title          string            `json:"title"`
+	Level          string            `json:"level,omitempty"`
+	Status         string            `json:"status,omitempty"`
+	Description    string            `json:"description,omitempty"`
+	Author         string            `json:"author,omitempty"`
+	Tags           []string          `json:"tags,omitempty"`
+	Techniques     []string          `json:"techniques,omitempty"`
+	Tactics        []string          `json:"tactics,omitempty"`
+	References     []string          `json:"references,omitempty"`
+	FalsePositives []string          `json:"false_positives,omitempty"`
+	RulePath       string            `json:"rule_path"`
+	EventTime      string            `json:"event_time,omitempty"`
+	Computer       string            `json:"computer,omitempty"`
+	Channel        string            `json:"channel,omitempty"`
+	EventID        int64             `json:"event_id,omitempty"`
+	RecordID       int64             `json:"record_id,omitempty"`
+	Fields         map[string]string `json:"fields,omitempty"`
+	EventRef       string            `json:"event_ref"`
+}
+
+func sigmaFromStore(out pluginlib.LineWriter, store *forensicstore.ForensicStore, filter pluginlib.Filter, rules []*sigmaRule) error {
+	// rules are indexed by channel, rules without a channel apply to all events
+	index := map[string][]*sigmaRule{}
+	for _, rule := range rules {
+		channels := map[string]bool{}
+		for _, source := range rule.sources {
+			channels[strings.ToLower(source.channel)] = true
+		}
+		for channel := range channels {
+			index[channel] = append(index[channel], rule)
+		}
+	}
+
+	return pluginlib.ForEach(store, combineFilter(filter, pluginlib.Filter{{"type": "eventlog"}}), func(element []byte) error {
+		system := gjson.GetBytes(element, "System")
+		channel := system.Get("Channel").String()
+		eventID := system.Get("EventID.Value").Int()
+
+		var candidates []*sigmaRule
+		if channel != "" {
+			candidates = append(candidates, index[strings.ToLower(channel)]...)
+		}
+		candidates = append(candidates, index[""]...)
+		for _, rule := range candidates {
+			if !rule.match(channel, eventID, element) {
+				continue
+			}
+
+			id := gjson.GetBytes(element, "id").String()
+			techniques, tactics := attackTags(rule.Tags)
+			match := &SigmaMatch{
+				Type:           "sigma-match",
+				RuleID:         rule.ID,
+				Title:          rule.Title,
+				Level:          rule.Level,
+				Status:         rule.Status,
+				Description:    strings.TrimSpace(rule.Description),
+				Author:         rule.Author,
+				Tags:           rule.Tags,
+				Techniques:     techniques,
+				Tactics:        tactics,
+				References:     rule.References,
+				FalsePositives: rule.FalsePositives,
+				RulePath:       rule.path,
+				EventTime:      formatEventTime(system.Get("TimeCreated.SystemTime").Float()),
+				Computer:       system.Get("Computer").String(),
+				Channel:        channel,
+				EventID:        eventID,
+				RecordID:       system.Get("EventRecordID").Int(),
+				EventRef:       id,
+			}
+			if len(rule.Fields) > 0 {
+				match.Fields = map[string]string{}
+				event := sigmaEvent{element: element, fields: rule.fieldMapping(channel, eventID)}
+				for _, field := range rule.Fields {
+					if value := event.value(field); value.Exists() {
+						match.Fields[field] = value.String()
+					}
+				}
+			}
+
+			b, err := json.Marshal(match)
+			if err != nil {
+				return err
+			}
+			if b, err = pluginlib.SetSource(b, id); err != nil {
+				return err
+			}
+			if err := out.WriteLine(b); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// fieldMapping returns the field mapping of the first source that matches the
+// event.
+func (r *sigmaRule) fieldMapping(channel string, eventID int64) map[string]string {
+	for _, source := range r.sources {
+		if source.match(channel, eventID) {
+			return source.fields
+		}
+	}
+	return nil
+}
+
+// loadSigmaRules loads the Sigma rules from files and directories. Rules that
+// cannot be compiled are passed to warn and skipped.
+func loadSigmaRules(paths []string, level string, warn func(path string, err error)) ([]*sigmaRule, error) {
+	var rules []*sigmaRule
+	err := forEachYAMLFile(paths, func(path string) {
+		fileRules, err := loadSigmaFile(path)
+		if err != nil {
+			warn(path, err)
+		}
+		for _, rule := range fileRules {
+			if severityLevels[strings.ToLower(rule.Level)] >= severityLevels[level] {
+				rules = append(rules, rule)
+			}
+		}
+	})
+	return rules, err
+}
+
+// loadSigmaFile loads a Sigma rule file. Rule collections with global
+// documents are supported.
+func loadSigmaFile(path string) ([]*sigmaRule, error) {
+	f, err := os.Open(path) // #nosec
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close() // nolint: errcheck
+
+	var rules []*sigmaRule
+	var global sigmaDocument
+	decoder := yaml.NewDecoder(f)
+	for {
+		var document sigmaDocument
+		if err := decoder.Decode(&document); err != nil {
+			if errors.Is(err, io.EOF) {
+				return rules, nil
+			}
+			return rules, err
+		}
+
+		switch document.Action {
+		case "global":
+			global = document
+			continue
+		case "reset":
+			global = sigmaDocument{}
+			continue
+		case "", "repeat":
+		default:
+			return rules, fmt.Errorf("unknown action %s", document.Action)
+		}
+
+		document = document.merge(global)
+		if document.Detection == nil {
+			// e.g. correlation rules or filters
+			continue
+		}
+		rule, err := compileSigma(path, document)
+		if errors.Is(err, errSigmaProduct) {
+			continue
+		}
+		if err != nil {
+			return rules, fmt.Errorf("%s: %w", document.Title, err)
+		}
+		rules = append(rules, rule)
+	}
+}
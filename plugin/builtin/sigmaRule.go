@@ -0,0 +1,979 @@
+// Copyright (c) 2020 Siemens AG
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+//
+// Author(s): Jonas Plum
+
+package builtin
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/tidwall/gjson"
+)
+
+// sigmaDocument is a single document of a Sigma rule file.
+type sigmaDocument struct {
+	Action         string                 `yaml:"action"`
+	Title          string                 `yaml:"title"`
+	ID             string                 `yaml:"id"`
+	Status         string                 `yaml:"status"`
+	Description    string                 `yaml:"description"`
+	Author         string                 `yaml:"author"`
+	References     []string               `yaml:"references"`
+	Tags           []string               `yaml:"tags"`
+	Level          string                 `yaml:"level"`
+	FalsePositives []string               `yaml:"falsepositives"`
+	Fields         []string               `yaml:"fields"`
+	LogSource      map[string]string      `yaml:"logsource"`
+	Detection      map[string]interface{} `yaml:"detection"`
+}
+
+// merge adds the values of a global document that are not set in the
+// document.
+func (d sigmaDocument) merge(global sigmaDocument) sigmaDocument {
+	merged := d
+	if merged.Title == "" {
+		merged.Title = global.Title
+	}
+	if merged.ID == "" {
+		merged.ID = global.ID
+	}
+	if merged.Status == "" {
+		merged.Status = global.Status
+	}
+	if merged.Description == "" {
+		merged.Description = global.Description
+	}
+	if merged.Author == "" {
+		merged.Author = global.Author
+	}
+	if merged.Level == "" {
+		merged.Level = global.Level
+	}
+	merged.References = append(append([]string{}, global.References...), d.References...)
+	merged.Tags = append(append([]string{}, global.Tags...), d.Tags...)
+	merged.FalsePositives = append(append([]string{}, global.FalsePositives...), d.FalsePositives...)
+	merged.Fields = append(append([]string{}, global.Fields...), d.Fields...)
+	merged.LogSource = map[string]string{}
+	for key, value := range global.LogSource {
+		merged.LogSource[key] = value
+	}
+	for key, value := range d.LogSource {
+		merged.LogSource[key] = value
+	}
+	merged.Detection = map[string]interface{}{}
+	for key, value := range global.Detection {
+		merged.Detection[key] = value
+	}
+	for key, value := range d.Detection {
+		merged.Detection[key] = value
+	}
+	return merged
+}
+
+// severityLevels orders the levels of rules.
+var severityLevels = map[string]int{"informational": 0, "low": 1, "medium": 2, "high": 3, "critical": 4}
+
+// sigmaSource selects the events of a Sigma log source and maps the Sigma
+// field names to the field names of these events.
+type sigmaSource struct {
+	channel  string
+	eventIDs []int64
+	fields   map[string]string
+}
+
+func (s sigmaSource) match(channel string, eventID int64) bool {
+	if s.channel != "" && !strings.EqualFold(s.channel, channel) {
+		return false
+	}
+	if len(s.eventIDs) == 0 {
+		return true
+	}
+	for _, id := range s.eventIDs {
+		if id == eventID {
+			return true
+		}
+	}
+	return false
+}
+
+// sigmaServices maps the Windows services of Sigma log sources to channels.
+var sigmaServices = map[string]string{
+	"application":                          "Application",
+	"security":                             "Security",
+	"system":                               "System",
+	"sysmon":                               sysmonChannel,
+	"powershell":                           powershellChannel,
+	"powershell-classic":                   windowsPowershellChannel,
+	"taskscheduler":                        "Microsoft-Windows-TaskScheduler/Operational",
+	"wmi":                                  "Microsoft-Windows-WMI-Activity/Operational",
+	"dns-server":                           "DNS Server",
+	"driver-framework":                     "Microsoft-Windows-DriverFrameworks-UserMode/Operational",
+	"windefend":                            "Microsoft-Windows-Windows Defender/Operational",
+	"firewall-as":                          "Microsoft-Windows-Windows Firewall With Advanced Security/Firewall",
+	"bits-client":                          "Microsoft-Windows-Bits-Client/Operational",
+	"codeintegrity-operational":            "Microsoft-Windows-CodeIntegrity/Operational",
+	"ntlm":                                 "Microsoft-Windows-NTLM/Operational",
+	"dhcp":                                 "Microsoft-Windows-DHCP-Server/Operational",
+	"terminalservices-localsessionmanager": rdpSessionChannel,
+	"printservice-admin":                   "Microsoft-Windows-PrintService/Admin",
+	"printservice-operational":             "Microsoft-Windows-PrintService/Operational",
+	"smbclient-security":                   "Microsoft-Windows-SmbClient/Security",
+	"openssh":                              "OpenSSH/Operational",
+	"appxdeployment-server":                "Microsoft-Windows-AppXDeploymentServer/Operational",
+	"lsa-server":                           "Microsoft-Windows-LSA/Operational",
+	"msexchange-management":                "MSExchange Management",
+	"diagnosis-scripted":                   "Microsoft-Windows-Diagnosis-Scripted/Operational",
+	"shell-core":                           "Microsoft-Windows-Shell-Core/Operational",
+	"security-mitigations":                 "Microsoft-Windows-Security-Mitigations/KernelMode",
+}
+
+// securityProcessCreation maps the Sysmon process creation fields to the
+// fields of Security 4688 events.
+var securityProcessCreation = map[string]string{
+	"Image":       "NewProcessName",
+	"ParentImage": "ParentProcessName",
+	"User":        "SubjectUserName",
+	"LogonId":     "SubjectLogonId",
+}
+
+// sigmaCategories maps the Windows categories of Sigma log sources to the
+// events that provide them.
+var sigmaCategories = map[string][]sigmaSource{
+	"process_creation": {
+		{channel: sysmonChannel, eventIDs: []int64{1}},
+		{channel: "Security", eventIDs: []int64{4688}, fields: securityProcessCreation},
+	},
+	"process_termination":       {{channel: sysmonChannel, eventIDs: []int64{5}}},
+	"network_connection":        {{channel: sysmonChannel, eventIDs: []int64{3}}},
+	"driver_load":               {{channel: sysmonChannel, eventIDs: []int64{6}}},
+	"image_load":                {{channel: sysmonChannel, eventIDs: []int64{7}}},
+	"create_remote_thread":      {{channel: sysmonChannel, eventIDs: []int64{8}}},
+	"raw_access_thread":         {{channel: sysmonChannel, eventIDs: []int64{9}}},
+	"process_access":            {{channel: sysmonChannel, eventIDs: []int64{10}}},
+	"file_event":                {{channel: sysmonChannel, eventIDs: []int64{11}}},
+	"file_change":               {{channel: sysmonChannel, eventIDs: []int64{2}}},
+	"registry_event":            {{channel: sysmonChannel, eventIDs: []int64{12, 13, 14}}},
+	"registry_add":              {{channel: sysmonChannel, eventIDs: []int64{12}}},
+	"registry_delete":           {{channel: sysmonChannel, eventIDs: []int64{12}}},
+	"registry_set":              {{channel: sysmonChannel, eventIDs: []int64{13}}},
+	"registry_rename":           {{channel: sysmonChannel, eventIDs: []int64{14}}},
+	"create_stream_hash":        {{channel: sysmonChannel, eventIDs: []int64{15}}},
+	"pipe_created":              {{channel: sysmonChannel, eventIDs: []int64{17, 18}}},
+	"wmi_event":                 {{channel: sysmonChannel, eventIDs: []int64{19, 20, 21}}},
+	"dns_query":                 {{channel: sysmonChannel, eventIDs: []int64{22}}},
+	"file_delete":               {{channel: sysmonChannel, eventIDs: []int64{23, 26}}},
+	"clipboard_capture":         {{channel: sysmonChannel, eventIDs: []int64{24}}},
+	"process_tampering":         {{channel: sysmonChannel, eventIDs: []int64{25}}},
+	"file_block_executable":     {{channel: sysmonChannel, eventIDs: []int64{27}}},
+	"sysmon_status":             {{channel: sysmonChannel, eventIDs: []int64{4, 16}}},
+	"sysmon_error":              {{channel: sysmonChannel, eventIDs: []int64{255}}},
+	"ps_module":                 {{channel: powershellChannel, eventIDs: []int64{4103}}},
+	"ps_script":                 {{channel: powershellChannel, eventIDs: []int64{4104}}},
+	"ps_classic_start":          {{channel: windowsPowershellChannel, eventIDs: []int64{400}}},
+	"ps_classic_provider_start": {{channel: windowsPowershellChannel, eventIDs: []int64{600}}},
+	"ps_classic_script":         {{channel: windowsPowershellChannel, eventIDs: []int64{800}}},
+}
+
+// sigmaSystemFields maps Sigma field names to the System fields of eventlog
+// elements.
+var sigmaSystemFields = map[string]string{
+	"EventID":       "System.EventID.Value",
+	"Channel":       "System.Channel",
+	"Provider_Name": "System.Provider.Name",
+	"Computer":      "System.Computer",
+	"Level":         "System.Level",
+	"Keywords":      "System.Keywords",
+	"Task":          "System.Task",
+	"Opcode":        "System.Opcode",
+	"EventRecordID": "System.EventRecordID",
+	"Version":       "System.Version",
+}
+
+var (
+	// errSigmaProduct marks rules for other products than Windows.
+	errSigmaProduct = errors.New("rule is not for windows")
+	// errSigmaUnsupported marks rules that cannot be evaluated on eventlogs.
+	errSigmaUnsupported = errors.New("unsupported")
+)
+
+// sigmaLogSource returns the sources of a Sigma log source.
+func sigmaLogSource(logSource map[string]string) ([]sigmaSource, error) {
+	if product, ok := logSource["product"]; ok && !strings.EqualFold(product, "windows") {
+		return nil, errSigmaProduct
+	}
+	category, service := logSource["category"], logSource["service"]
+
+	var sources []sigmaSource
+	switch {
+	case category != "":
+		categorySources, ok := sigmaCategories[category]
+		if !ok {
+			return nil, fmt.Errorf("%w category %s", errSigmaUnsupported, category)
+		}
+		sources = categorySources
+		if service != "" {
+			// e.g. a category restricted to the Security channel
+			channel, ok := sigmaServices[service]
+			if !ok {
+				return nil, fmt.Errorf("%w service %s", errSigmaUnsupported, service)
+			}
+			var restricted []sigmaSource
+			for _, source := range sources {
+				if strings.EqualFold(source.channel, channel) {
+					restricted = append(restricted, source)
+				}
+			}
+			sources = restricted
+		}
+	case service != "":
+		channel, ok := sigmaServices[service]
+		if !ok {
+			return nil, fmt.Errorf("%w service %s", errSigmaUnsupported, service)
+		}
+		sources = []sigmaSource{{channel: channel}}
+	default:
+		sources = []sigmaSource{{}}
+	}
+	if len(sources) == 0 {
+		return nil, fmt.Errorf("%w log source %v", errSigmaUnsupported, logSource)
+	}
+	return sources, nil
+}
+
+// sigmaEvent is an eventlog element with the field mapping of the log source
+// of a rule.
+type sigmaEvent struct {
+	element []byte
+	fields  map[string]string
+}
+
+// value returns the value of a Sigma field.
+func (e sigmaEvent) value(field string) gjson.Result {
+	if mapped, ok := e.fields[field]; ok {
+		field = mapped
+	}
+	if path, ok := sigmaSystemFields[field]; ok {
+		return gjson.GetBytes(e.element, path)
+	}
+	escaped := gjsonEscape(field)
+	if value := gjson.GetBytes(e.element, "EventData."+escaped); value.Exists() {
+		return value
+	}
+	userData := gjson.GetBytes(e.element, "UserData")
+	var value gjson.Result
+	userData.ForEach(func(_, data gjson.Result) bool {
+		value = data.Get(escaped)
+		return !value.Exists()
+	})
+	if value.Exists() {
+		return value
+	}
+	return gjson.GetBytes(e.element, "System."+escaped)
+}
+
+// strings returns all string values of the event data.
+func (e sigmaEvent) strings() []string {
+	var values []string
+	var collect func(gjson.Result)
+	collect = func(value gjson.Result) {
+		if value.IsObject() || value.IsArray() {
+			value.ForEach(func(_, child gjson.Result) bool {
+				collect(child)
+				return true
+			})
+			return
+		}
+		if value.Exists() {
+			values = append(values, value.String())
+		}
+	}
+	for _, path := range []string{"EventData", "UserData", "Message"} {
+		collect(gjson.GetBytes(e.element, path))
+	}
+	return values
+}
+
+func gjsonEscape(field string) string {
+	var b strings.Builder
+	for _, c := range field {
+		if strings.ContainsRune(`.*?|#@\`, c) {
+			b.WriteRune('\\')
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+// sigmaMatcher is a compiled part of a Sigma detection.
+type sigmaMatcher interface {
+	match(event sigmaEvent) bool
+}
+
+type sigmaAnd []sigmaMatcher
+
+func (a sigmaAnd) match(event sigmaEvent) bool {
+	for _, m := range a {
+		if !m.match(event) {
+			return false
+		}
+	}
+	return true
+}
+
+type sigmaOr []sigmaMatcher
+
+func (o sigmaOr) match(event sigmaEvent) bool {
+	for _, m := range o {
+		if m.match(event) {
+			return true
+		}
+	}
+	return false
+}
+
+type sigmaNot struct{ sigmaMatcher }
+
+func (n sigmaNot) match(event sigmaEvent) bool {
+	return !n.sigmaMatcher.match(event)
+}
+
+// sigmaValue matches a single string.
+type sigmaValue func(s string) bool
+
+// sigmaField matches the values of a field.
+type sigmaField struct {
+	field  string
+	all    bool
+	exists *bool
+	ref    string
+	cased  bool
+	values []sigmaValue
+}
+
+func (f *sigmaField) match(event sigmaEvent) bool {
+	value := event.value(f.field)
+	if f.exists != nil {
+		return value.Exists() == *f.exists
+	}
+	if f.ref != "" {
+		ref := event.value(f.ref)
+		if f.cased {
+			return value.Exists() && value.String() == ref.String()
+		}
+		return value.Exists() && strings.EqualFold(value.String(), ref.String())
+	}
+	if len(f.values) == 0 {
+		return false
+	}
+	s := value.String()
+	for _, v := range f.values {
+		matched := v(s)
+		if matched && !f.all {
+			return true
+		}
+		if !matched && f.all {
+			return false
+		}
+	}
+	return f.all
+}
+
+// sigmaKeywords matches any string of the event.
+type sigmaKeywords []sigmaValue
+
+func (k sigmaKeywords) match(event sigmaEvent) bool {
+	for _, s := range event.strings() {
+		for _, v := range k {
+			if v(s) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// sigmaRule is a compiled Sigma rule.
+type sigmaRule struct {
+	sigmaDocument
+	path      string
+	sources   []sigmaSource
+	condition sigmaMatcher
+}
+
+// match returns if the rule matches the eventlog element.
+func (r *sigmaRule) match(channel string, eventID int64, element []byte) bool {
+	for _, source := range r.sources {
+		if source.match(channel, eventID) && r.condition.match(sigmaEvent{element: element, fields: source.fields}) {
+			return true
+		}
+	}
+	return false
+}
+
+// compileSigma compiles the detection of a Sigma rule document.
+func compileSigma(path string, document sigmaDocument) (*sigmaRule, error) {
+	sources, err := sigmaLogSource(document.LogSource)
+	if err != nil {
+		return nil, err
+	}
+
+	condition, ok := document.Detection["condition"]
+	if !ok {
+		return nil, errors.New("missing condition")
+	}
+	var conditions []string
+	switch c := condition.(type) {
+	case string:
+		conditions = []string{c}
+	case []interface{}:
+		for _, item := range c {
+			s, ok := item.(string)
+			if !ok {
+				return nil, fmt.Errorf("invalid condition %v", item)
+			}
+			conditions = append(conditions, s)
+		}
+	default:
+		return nil, fmt.Errorf("invalid condition %v", condition)
+	}
+
+	searches := map[string]sigmaMatcher{}
+	for name, search := range document.Detection {
+		if name == "condition" || name == "timeframe" {
+			continue
+		}
+		matcher, err := compileSigmaSearch(search)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		searches[name] = matcher
+	}
+
+	var matchers sigmaOr
+	for _, c := range conditions {
+		matcher, err := parseSigmaCondition(c, searches)
+		if err != nil {
+			return nil, fmt.Errorf("condition %q: %w", c, err)
+		}
+		matchers = append(matchers, matcher)
+	}
+
+	rule := &sigmaRule{sigmaDocument: document, path: path, sources: sources, condition: matchers}
+	if len(matchers) == 1 {
+		rule.condition = matchers[0]
+	}
+	return rule, nil
+}
+
+func compileSigmaSearch(search interface{}) (sigmaMatcher, error) {
+	switch s := search.(type) {
+	case map[interface{}]interface{}, map[string]interface{}:
+		return compileSigmaMap(s)
+	case []interface{}:
+		var keywords sigmaKeywords
+		var matchers sigmaOr
+		for _, item := range s {
+			switch i := item.(type) {
+			case map[interface{}]interface{}, map[string]interface{}:
+				matcher, err := compileSigmaMap(i)
+				if err != nil {
+					return nil, err
+				}
+				matchers = append(matchers, matcher)
+			default:
+				value, err := sigmaPattern(fmt.Sprint(i), "contains", false)
+				if err != nil {
+					return nil, err
+				}
+				keywords = append(keywords, value)
+			}
+		}
+		if len(keywords) > 0 {
+			matchers = append(matchers, keywords)
+		}
+		return matchers, nil
+	case string, int, float64:
+		value, err := sigmaPattern(fmt.Sprint(s), "contains", false)
+		if err != nil {
+			return nil, err
+		}
+		return sigmaKeywords{value}, nil
+	default:
+		return nil, fmt.Errorf("invalid search %v", search)
+	}
+}
+
+func compileSigmaMap(search interface{}) (sigmaMatcher, error) {
+	fields := map[string]interface{}{}
+	switch s := search.(type) {
+	case map[interface{}]interface{}:
+		for key, value := range s {
+			fields[fmt.Sprint(key)] = value
+		}
+	case map[string]interface{}:
+		fields = s
+	}
+
+	// sort the fields so the matching order is stable
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var matchers sigmaAnd
+	for _, key := range keys {
+		matcher, err := compileSigmaField(key, fields[key])
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", key, err)
+		}
+		matchers = append(matchers, matcher)
+	}
+	return matchers, nil
+}
+
+// compileSigmaField compiles a field with its modifiers, e.g.
+// CommandLine|contains|all.
+func compileSigmaField(key string, value interface{}) (sigmaMatcher, error) { // nolint: gocyclo
+	parts := strings.Split(key, "|")
+	f := &sigmaField{field: parts[0]}
+
+	var values []interface{}
+	switch v := value.(type) {
+	case []interface{}:
+		values = v
+	default:
+		values = []interface{}{v}
+	}
+
+	operator := ""
+	var encodings []string
+	for _, modifier := range parts[1:] {
+		switch modifier {
+		case "contains", "startswith", "endswith", "re", "cidr", "lt", "lte", "gt", "gte":
+			operator = modifier
+		case "all":
+			f.all = true
+		case "cased":
+			f.cased = true
+		case "exists":
+			exists := len(values) == 1 && values[0] == true
+			f.exists = &exists
+			return f, nil
+		case "fieldref":
+			if len(values) != 1 {
+				return nil, errors.New("fieldref requires a single field")
+			}
+			f.ref = fmt.Sprint(values[0])
+			return f, nil
+		case "base64", "base64offset", "wide", "utf16le", "utf16be", "utf16", "windash":
+			encodings = append(encodings, modifier)
+		case "i", "m", "s":
+			if operator != "re" {
+				return nil, fmt.Errorf("unknown modifier %s", modifier)
+			}
+			encodings = append(encodings, modifier)
+		default:
+			return nil, fmt.Errorf("unknown modifier %s", modifier)
+		}
+	}
+
+	for _, v := range values {
+		if v == nil {
+			// null matches missing and empty fields
+			f.values = append(f.values, func(s string) bool { return s == "" })
+			continue
+		}
+		s := fmt.Sprint(v)
+		if operator == "re" {
+			flags := ""
+			for _, encoding := range encodings {
+				flags += encoding
+			}
+			if flags != "" {
+				s = "(?" + flags + ")" + s
+			}
+			re, err := regexp.Compile(s)
+			if err != nil {
+				return nil, err
+			}
+			f.values = append(f.values, re.MatchString)
+			continue
+		}
+		if operator == "cidr" {
+			_, network, err := net.ParseCIDR(s)
+			if err != nil {
+				return nil, err
+			}
+			f.values = append(f.values, func(s string) bool {
+				ip := net.ParseIP(strings.TrimPrefix(s, "::ffff:"))
+				return ip != nil && network.Contains(ip)
+			})
+			continue
+		}
+		if operator == "lt" || operator == "lte" || operator == "gt" || operator == "gte" {
+			limit, err := strconv.ParseFloat(s, 64)
+			if err != nil {
+				return nil, err
+			}
+			f.values = append(f.values, sigmaCompare(operator, limit))
+			continue
+		}
+
+		variants := sigmaEncode(s, encodings)
+		var matchers []sigmaValue
+		for _, variant := range variants {
+			matcher, err := sigmaPattern(variant, operator, f.cased)
+			if err != nil {
+				return nil, err
+			}
+			matchers = append(matchers, matcher)
+		}
+		f.values = append(f.values, func(s string) bool {
+			for _, m := range matchers {
+				if m(s) {
+					return true
+				}
+			}
+			return false
+		})
+	}
+	return f, nil
+}
+
+func sigmaCompare(operator string, limit float64) sigmaValue {
+	return func(s string) bool {
+		n, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return false
+		}
+		switch operator {
+		case "lt":
+			return n < limit
+		case "lte":
+			return n <= limit
+		case "gt":
+			return n > limit
+		default:
+			return n >= limit
+		}
+	}
+}
+
+// sigmaEncode returns the variants of a value after applying the encoding
+// modifiers.
+func sigmaEncode(s string, encodings []string) []string {
+	variants := []string{s}
+	for _, encoding := range encodings {
+		var next []string
+		for _, variant := range variants {
+			switch encoding {
+			case "wide", "utf16le", "utf16":
+				next = append(next, string(utf16Bytes(variant, false)))
+			case "utf16be":
+				next = append(next, string(utf16Bytes(variant, true)))
+			case "base64":
+				next = append(next, base64.StdEncoding.EncodeToString([]byte(variant)))
+			case "base64offset":
+				next = append(next, base64Offsets(variant)...)
+			case "windash":
+				next = append(next, variant)
+				if strings.HasPrefix(variant, "-") || strings.Contains(variant, " -") {
+					for _, dash := range []string{"/", "–", "—", "―"} {
+						replaced := strings.ReplaceAll(variant, " -", " "+dash)
+						if strings.HasPrefix(replaced, "-") {
+							replaced = dash + replaced[1:]
+						}
+						next = append(next, replaced)
+					}
+				}
+			}
+		}
+		variants = next
+	}
+	return variants
+}
+
+func utf16Bytes(s string, bigEndian bool) []byte {
+	var b []byte
+	for _, c := range utf16.Encode([]rune(s)) {
+		if bigEndian {
+			b = append(b, byte(c>>8), byte(c))
+		} else {
+			b = append(b, byte(c), byte(c>>8))
+		}
+	}
+	return b
+}
+
+// base64Offsets returns the three base64 representations of a value at the
+// possible offsets in a longer encoded string.
+func base64Offsets(s string) []string {
+	var offsets []string
+	start := []int{0, 2, 3}
+	for i := 0; i < 3; i++ {
+		encoded := base64.StdEncoding.EncodeToString(append(make([]byte, i), s...))
+		end := []int{0, -3, -2}[(i+len(s))%3]
+		encoded = encoded[start[i] : len(encoded)+end]
+		offsets = append(offsets, encoded)
+	}
+	return offsets
+}
+
+// sigmaPattern compiles a value with the wildcards * and ? into a matcher.
+func sigmaPattern(value, operator string, cased bool) (sigmaValue, error) {
+	var pattern strings.Builder
+	wildcards := false
+	escaped := false
+	for _, c := range value {
+		switch {
+		case escaped:
+			pattern.WriteString(regexp.QuoteMeta(string(c)))
+			escaped = false
+		case c == '\\':
+			escaped = true
+		case c == '*':
+			pattern.WriteString(".*")
+			wildcards = true
+		case c == '?':
+			pattern.WriteString(".")
+			wildcards = true
+		default:
+			pattern.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	if escaped {
+		pattern.WriteString(regexp.QuoteMeta(`\`))
+	}
+
+	if !wildcards {
+		plain := strings.ReplaceAll(strings.ReplaceAll(strings.ReplaceAll(value, `\*`, "*"), `\?`, "?"), `\\`, `\`)
+		if !cased {
+			plain = strings.ToLower(plain)
+		}
+		normalize := func(s string) string {
+			if cased {
+				return s
+			}
+			return strings.ToLower(s)
+		}
+		switch operator {
+		case "contains":
+			return func(s string) bool { return strings.Contains(normalize(s), plain) }, nil
+		case "startswith":
+			return func(s string) bool { return strings.HasPrefix(normalize(s), plain) }, nil
+		case "endswith":
+			return func(s string) bool { return strings.HasSuffix(normalize(s), plain) }, nil
+		default:
+			return func(s string) bool { return normalize(s) == plain }, nil
+		}
+	}
+
+	expression := pattern.String()
+	switch operator {
+	case "contains":
+		expression = ".*" + expression + ".*"
+	case "startswith":
+		expression += ".*"
+	case "endswith":
+		expression = ".*" + expression
+	}
+	flags := "(?s)"
+	if !cased {
+		flags = "(?is)"
+	}
+	re, err := regexp.Compile(flags + "^" + expression + "$")
+	if err != nil {
+		return nil, err
+	}
+	return re.MatchString, nil
+}
+
+// parseSigmaCondition parses a condition like
+// "selection and not 1 of filter_*".
+func parseSigmaCondition(condition string, searches map[string]sigmaMatcher) (sigmaMatcher, error) {
+	if strings.Contains(condition, "|") {
+		return nil, fmt.Errorf("%w aggregation", errSigmaUnsupported)
+	}
+	p := &sigmaParser{tokens: sigmaTokens(condition), searches: searches}
+	matcher, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("unexpected %s", p.tokens[p.pos])
+	}
+	return matcher, nil
+}
+
+func sigmaTokens(condition string) []string {
+	condition = strings.NewReplacer("(", " ( ", ")", " ) ").Replace(condition)
+	return strings.Fields(condition)
+}
+
+type sigmaParser struct {
+	tokens   []string
+	pos      int
+	searches map[string]sigmaMatcher
+}
+
+func (p *sigmaParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return strings.ToLower(p.tokens[p.pos])
+	}
+	return ""
+}
+
+func (p *sigmaParser) or() (sigmaMatcher, error) {
+	left, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+	matchers := sigmaOr{left}
+	for p.peek() == "or" {
+		p.pos++
+		right, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		matchers = append(matchers, right)
+	}
+	if len(matchers) == 1 {
+		return left, nil
+	}
+	return matchers, nil
+}
+
+func (p *sigmaParser) and() (sigmaMatcher, error) {
+	left, err := p.not()
+	if err != nil {
+		return nil, err
+	}
+	matchers := sigmaAnd{left}
+	for p.peek() == "and" {
+		p.pos++
+		right, err := p.not()
+		if err != nil {
+			return nil, err
+		}
+		matchers = append(matchers, right)
+	}
+	if len(matchers) == 1 {
+		return left, nil
+	}
+	return matchers, nil
+}
+
+func (p *sigmaParser) not() (sigmaMatcher, error) {
+	if p.peek() == "not" {
+		p.pos++
+		matcher, err := p.not()
+		if err != nil {
+			return nil, err
+		}
+		return sigmaNot{matcher}, nil
+	}
+	return p.primary()
+}
+
+func (p *sigmaParser) primary() (sigmaMatcher, error) {
+	token := p.peek()
+	switch {
+	case token == "":
+		return nil, errors.New("unexpected end of condition")
+	case token == "(":
+		p.pos++
+		matcher, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek() != ")" {
+			return nil, errors.New("missing )")
+		}
+		p.pos++
+		return matcher, nil
+	case token == "1" || token == "any" || token == "all":
+		p.pos++
+		if p.peek() != "of" {
+			return nil, fmt.Errorf("expected of after %s", token)
+		}
+		p.pos++
+		if p.pos >= len(p.tokens) {
+			return nil, errors.New("unexpected end of condition")
+		}
+		pattern := p.tokens[p.pos]
+		p.pos++
+		matchers, err := p.searchPattern(pattern)
+		if err != nil {
+			return nil, err
+		}
+		if token == "all" {
+			return sigmaAnd(matchers), nil
+		}
+		return sigmaOr(matchers), nil
+	default:
+		search, ok := p.searches[p.tokens[p.pos]]
+		if !ok {
+			return nil, fmt.Errorf("unknown search %s", p.tokens[p.pos])
+		}
+		p.pos++
+		return search, nil
+	}
+}
+
+// searchPattern returns the searches matched by a pattern like selection_*
+// or them.
+func (p *sigmaParser) searchPattern(pattern string) ([]sigmaMatcher, error) {
+	var names []string
+	for name := range p.searches {
+		if pattern == "them" {
+			if !strings.HasPrefix(name, "_") {
+				names = append(names, name)
+			}
+			continue
+		}
+		if pathMatch(pattern, name) {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return nil, fmt.Errorf("no search matches %s", pattern)
+	}
+	sort.Strings(names)
+	matchers := make([]sigmaMatcher, 0, len(names))
+	for _, name := range names {
+		matchers = append(matchers, p.searches[name])
+	}
+	return matchers, nil
+}
+
+// pathMatch matches a name against a pattern with the wildcard *.
+func pathMatch(pattern, name string) bool {
+	re := regexp.MustCompile("^" + strings.ReplaceAll(regexp.QuoteMeta(pattern), `\*`, ".*") + "$")
+	return re.MatchString(name)
+}
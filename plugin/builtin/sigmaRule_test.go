@@ -0,0 +1,250 @@
+// Copyright (c) 2020 Siemens AG
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+//
+// Author(s): Jonas Plum
+
+package builtin
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+func TestBase64Offsets(t *testing.T) {
+	want := []string{"aHR0cDovL", "h0dHA6Ly", "odHRwOi8v"}
+	if got := base64Offsets("http://"); !reflect.DeepEqual(got, want) {
+		t.Errorf("base64Offsets() = %v, want %v", got, want)
+	}
+}
+
+func TestSigmaPattern(t *testing.T) {
+	tests := []struct {
+		name     string
+		value    string
+		operator string
+		cased    bool
+		s        string
+		want     bool
+	}{
+		{"equal", "cmd.exe", "", false, "CMD.EXE", true},
+		{"equal cased", "cmd.exe", "", true, "CMD.EXE", false},
+		{"contains", "-enc", "contains", false, "powershell -EncodedCommand", true},
+		{"startswith", `C:\Windows\`, "startswith", false, `c:\windows\system32\cmd.exe`, true},
+		{"endswith", `\cmd.exe`, "endswith", false, `C:\Windows\System32\cmd.exe`, true},
+		{"wildcard", `*\rundll32.exe`, "", false, `C:\Windows\System32\rundll32.exe`, true},
+		{"question mark", "cmd.?xe", "", false, "cmd.exe", true},
+		{"escaped wildcard", `foo\*`, "", false, "foobar", false},
+		{"escaped wildcard literal", `foo\*`, "", false, "foo*", true},
+		{"no match", "cmd.exe", "", false, "cmd.exe.bak", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			match, err := sigmaPattern(tt.value, tt.operator, tt.cased)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := match(tt.s); got != tt.want {
+				t.Errorf("sigmaPattern(%q)(%q) = %v, want %v", tt.value, tt.s, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompileSigma(t *testing.T) {
+	process := []byte(`{"type": "eventlog", "System": {"Channel": "Microsoft-Windows-Sysmon/Operational", "EventID": {"Value": 1}, "Computer": "PC"},
+		"EventData": {"Image": "C:\\Windows\\System32\\WindowsPowerShell\\v1.0\\powershell.exe", "ParentImage": "C:\\Windows\\explorer.exe",
+		"CommandLine": "powershell -nop -w hidden -enc SQBFAFgA", "User": "CORP\\admin", "DestinationIp": "10.1.2.3", "Count": "12"}}`)
+	security := []byte(`{"type": "eventlog", "System": {"Channel": "Security", "EventID": {"Value": 4688}, "Computer": "PC"},
+		"EventData": {"NewProcessName": "C:\\Windows\\System32\\WindowsPowerShell\\v1.0\\powershell.exe", "ParentProcessName": "C:\\Windows\\explorer.exe", "CommandLine": "powershell /enc SQBFAFgA"}}`)
+	cleared := []byte(`{"type": "eventlog", "System": {"Channel": "Security", "EventID": {"Value": 1102}, "Computer": "PC"},
+		"UserData": {"LogFileCleared": {"SubjectUserName": "admin"}}}`)
+
+	tests := []struct {
+		name      string
+		rule      string
+		wantErr   error
+		wantMatch []bool
+	}{
+		{"process creation", `
+logsource: {category: process_creation, product: windows}
+detection:
+  selection:
+    Image|endswith: '\powershell.exe'
+    CommandLine|contains|windash: ' -enc '
+  condition: selection`, nil, []bool{true, true, false}},
+		{"not and all", `
+logsource: {category: process_creation, product: windows}
+detection:
+  selection:
+    CommandLine|contains|all: [-nop, hidden]
+  filter:
+    ParentImage|endswith: '\services.exe'
+  condition: selection and not filter`, nil, []bool{true, false, false}},
+		{"one of pattern", `
+logsource: {category: process_creation}
+detection:
+  selection_img:
+    - Image|endswith: '\cmd.exe'
+    - OriginalFileName: Cmd.Exe
+  selection_cli:
+    CommandLine|re: '(?i)-w\s+hid'
+  condition: 1 of selection_*`, nil, []bool{true, false, false}},
+		{"all of them", `
+logsource: {category: process_creation}
+detection:
+  a:
+    User: 'CORP\admin'
+  b:
+    DestinationIp|cidr: 10.0.0.0/8
+    Count|gte: 10
+  condition: all of them`, nil, []bool{true, false, false}},
+		{"service and user data", `
+logsource: {product: windows, service: security}
+detection:
+  selection:
+    EventID: 1102
+    SubjectUserName: admin
+  condition: selection`, nil, []bool{false, false, true}},
+		{"keywords", `
+logsource: {product: windows}
+detection:
+  keywords:
+    - '*hidden -enc*'
+  condition: keywords`, nil, []bool{true, false, false}},
+		{"exists and null", `
+logsource: {product: windows}
+detection:
+  selection:
+    CommandLine|exists: true
+    OriginalFileName: null
+  condition: selection`, nil, []bool{true, true, false}},
+		{"base64offset", `
+logsource: {product: windows}
+detection:
+  selection:
+    CommandLine|base64offset|contains: 'IEX'
+  condition: selection`, nil, []bool{false, false, false}},
+		{"other product", `
+logsource: {product: linux, category: process_creation}
+detection:
+  selection: {Image: /bin/sh}
+  condition: selection`, errSigmaProduct, nil},
+		{"aggregation", `
+logsource: {product: windows, service: security}
+detection:
+  selection: {EventID: 4625}
+  condition: selection | count() by IpAddress > 10`, errSigmaUnsupported, nil},
+		{"unknown category", `
+logsource: {product: windows, category: foo}
+detection:
+  selection: {EventID: 1}
+  condition: selection`, errSigmaUnsupported, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var document sigmaDocument
+			if err := yaml.Unmarshal([]byte(tt.rule), &document); err != nil {
+				t.Fatal(err)
+			}
+			rule, err := compileSigma("test.yml", document)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("compileSigma() error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			for i, element := range [][]byte{process, security, cleared} {
+				event := sigmaEvent{element: element}
+				if got := rule.match(event.value("Channel").String(), event.value("EventID").Int(), element); got != tt.wantMatch[i] {
+					t.Errorf("match(%d) = %v, want %v", i, got, tt.wantMatch[i])
+				}
+			}
+		})
+	}
+}
+
+func TestLoadSigmaRules(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sigma")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	collection := `action: global
+title: Collection
+level: high
+tags: [attack.execution, attack.t1059.001]
+detection:
+  condition: selection
+---
+logsource: {product: windows, service: security}
+detection:
+  selection: {EventID: 4688}
+---
+logsource: {category: process_creation}
+detection:
+  selection: {Image: cmd.exe}
+`
+	files := map[string]string{
+		"collection.yml": collection,
+		"low.yaml":       "title: Low\nlevel: low\nlogsource: {service: system}\ndetection: {selection: {EventID: 7045}, condition: selection}\n",
+		"linux.yml":      "title: Linux\nlevel: high\nlogsource: {product: linux}\ndetection: {selection: {a: b}, condition: selection}\n",
+		"invalid.yml":    "title: Invalid\nlevel: high\nlogsource: {service: system}\ndetection: {selection: {a|foo: b}, condition: selection}\n",
+		"sub/readme.txt": "no rule",
+		"sub/nested.yml": "title: Nested\nlevel: critical\ndetection: {selection: {EventID: 1}, condition: selection}\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var warnings []string
+	rules, err := loadSigmaRules([]string{dir}, "medium", func(path string, err error) {
+		warnings = append(warnings, filepath.Base(path))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var titles []string
+	for _, rule := range rules {
+		titles = append(titles, rule.Title)
+	}
+	if want := []string{"Collection", "Collection", "Nested"}; !reflect.DeepEqual(titles, want) {
+		t.Errorf("titles = %v, want %v", titles, want)
+	}
+	if want := []string{"invalid.yml"}; !reflect.DeepEqual(warnings, want) {
+		t.Errorf("warnings = %v, want %v", warnings, want)
+	}
+
+	techniques, tactics := attackTags(rules[0].Tags)
+	if !reflect.DeepEqual(techniques, []string{"T1059.001"}) || !reflect.DeepEqual(tactics, []string{"execution"}) {
+		t.Errorf("attackTags() = %v, %v", techniques, tactics)
+	}
+}
@@ -0,0 +1,72 @@
+// Copyright (c) 2020 Siemens AG
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+//
+// Author(s): Jonas Plum
+
+package builtin
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/tidwall/gjson"
+
+	"github.com/forensicanalysis/forensicstore"
+)
+
+func TestSigmaFromStore(t *testing.T) {
+	dir := t.TempDir()
+	store, teardown, err := forensicstore.New(filepath.Join(dir, "test.forensicstore"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer teardown()
+	for _, element := range []string{
+		`{"type": "eventlog", "System": {"Channel": "System", "EventID": {"Value": 1}}}`,
+		`{"type": "eventlog", "System": {"Channel": "", "EventID": {"Value": 1}}}`,
+		`{"type": "eventlog", "System": {"Channel": "System", "EventID": {"Value": 2}}}`,
+	} {
+		if _, err := store.Insert([]byte(element)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rule := "title: Any\nlevel: high\ndetection: {selection: {EventID: 1}, condition: selection}\n"
+	if err := ioutil.WriteFile(filepath.Join(dir, "any.yml"), []byte(rule), 0600); err != nil {
+		t.Fatal(err)
+	}
+	rules, err := loadSigmaRules([]string{dir}, "low", func(path string, err error) { t.Fatal(path, err) })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tlw := &testLineWriter{}
+	if err := sigmaFromStore(tlw, store, nil, rules); err != nil {
+		t.Fatal(err)
+	}
+	var channels []string
+	for _, line := range tlw.lines {
+		channels = append(channels, gjson.GetBytes(line, "channel").String())
+	}
+	// the rule without a channel matches every event once
+	if len(channels) != 2 || channels[0] != "System" || channels[1] != "" {
+		t.Errorf("matches = %q, want one per event", channels)
+	}
+}
@@ -0,0 +1,296 @@
+// Copyright (c) 2020 Siemens AG
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+//
+// Author(s): Jonas Plum
+
+package builtin
+
+import (
+	"encoding/json"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/tidwall/gjson"
+
+	"github.com/forensicanalysis/elementary/pluginlib"
+	"github.com/forensicanalysis/forensicstore"
+)
+
+var _ pluginlib.Plugin = &Sysmon{}
+
+const sysmonChannel = "Microsoft-Windows-Sysmon/Operational"
+
+// Sysmon normalizes the eventlog elements of the Sysmon channel into process,
+// network-connection, file, registry and dns elements.
+type Sysmon struct {
+	parameter pluginlib.ParameterList
+}
+
+func (s *Sysmon) Name() string {
+	return "sysmon"
+}
+
+func (s *Sysmon) Short() string {
+	return "Normalize Sysmon eventlog elements"
+}
+
+func (s *Sysmon) Parameter() pluginlib.ParameterList {
+	if s.parameter == nil {
+		s.parameter = pluginlib.ParameterList{
+			{Name: "forensicstore", Type: pluginlib.Path, Description: "forensicstore", Required: true, Argument: true},
+			Filter,
+		}
+	}
+	return s.parameter
+}
+
+func (s *Sysmon) Output() *pluginlib.Config {
+	return &pluginlib.Config{Header: []string{"event_time", "type", "action", "computer", "pid", "image", "command_line"}}
+}
+
+func (s *Sysmon) Run(p pluginlib.Plugin, out pluginlib.LineWriter) error {
+	store, teardown, err := getForensicStore(p)
+	if err != nil {
+		return err
+	}
+	defer teardown()
+
+	filter := pluginlib.ExtractFilter(p.Parameter().GetStringArrayValue("filter"))
+	return sysmonFromStore(out, store, filter)
+}
+
+func sysmonFromStore(out pluginlib.LineWriter, store *forensicstore.ForensicStore, filter pluginlib.Filter) error {
+	for idx := range filter {
+		filter[idx]["type"] = "eventlog"
+		filter[idx]["System.Channel"] = sysmonChannel
+	}
+
+	if len(filter) == 0 {
+		filter = pluginlib.Filter{{"type": "eventlog", "System.Channel": sysmonChannel}}
+	}
+
+	return pluginlib.ForEach(store, filter, func(element []byte) error {
+		id := gjson.GetBytes(element, "id").String()
+		normalized := normalizeSysmon(element)
+		if normalized == nil {
+			return nil
+		}
+		b, err := json.Marshal(normalized)
+		if err != nil {
+			return err
+		}
+		if b, err = pluginlib.SetSource(b, id); err != nil {
+			return err
+		}
+		return out.WriteLine(b)
+	})
+}
+
+// normalizedElement is an element built from eventlog data, empty values
+// are omitted.
+type normalizedElement map[string]interface{}
+
+func (e normalizedElement) set(key string, value interface{}) {
+	switch v := value.(type) {
+	case string:
+		if v == "" {
+			return
+		}
+	case int64:
+		if v == 0 {
+			return
+		}
+	case map[string]string:
+		if len(v) == 0 {
+			return
+		}
+	case []string:
+		if len(v) == 0 {
+			return
+		}
+	case normalizedElement:
+		if len(v) == 0 {
+			return
+		}
+	}
+	e[key] = value
+}
+
+// normalizeSysmon converts a Sysmon eventlog element. Events without a
+// normalized representation return nil.
+func normalizeSysmon(event []byte) normalizedElement { // nolint: gocyclo
+	data := gjson.GetBytes(event, "EventData")
+	eventID := gjson.GetBytes(event, "System.EventID.Value").Int()
+
+	element := normalizedElement{}
+	switch eventID {
+	case 1, 5:
+		element["type"] = "process"
+	case 3:
+		element["type"] = "network-connection"
+	case 2, 11, 15, 23, 26:
+		element["type"] = "file"
+	case 12, 13, 14:
+		element["type"] = "registry"
+	case 22:
+		element["type"] = "dns"
+	default:
+		return nil
+	}
+
+	element.set("event_time", sysmonTime(data.Get("UtcTime").String()))
+	element.set("computer", gjson.GetBytes(event, "System.Computer").String())
+	element["event_id"] = eventID
+	element.set("record_id", gjson.GetBytes(event, "System.EventRecordID").Int())
+	element.set("pid", data.Get("ProcessId").Int())
+	element.set("process_guid", data.Get("ProcessGuid").String())
+	element.set("image", data.Get("Image").String())
+	element.set("user", data.Get("User").String())
+
+	switch eventID {
+	case 1:
+		element["action"] = "create"
+		element.set("name", windowsBase(data.Get("Image").String()))
+		element.set("created", sysmonTime(data.Get("UtcTime").String()))
+		element.set("command_line", data.Get("CommandLine").String())
+		element.set("cwd", data.Get("CurrentDirectory").String())
+		element.set("logon_id", data.Get("LogonId").String())
+		element.set("integrity_level", strings.ToLower(data.Get("IntegrityLevel").String()))
+		element.set("original_file_name", data.Get("OriginalFileName").String())
+		element.set("hashes", parseSysmonHashes(data.Get("Hashes").String()))
+
+		parent := normalizedElement{}
+		parent.set("pid", data.Get("ParentProcessId").Int())
+		parent.set("process_guid", data.Get("ParentProcessGuid").String())
+		parent.set("image", data.Get("ParentImage").String())
+		parent.set("command_line", data.Get("ParentCommandLine").String())
+		element.set("parent", parent)
+	case 5:
+		element["action"] = "terminate"
+		element.set("name", windowsBase(data.Get("Image").String()))
+	case 3:
+		element["action"] = "connect"
+		element.set("protocol", data.Get("Protocol").String())
+		element["initiated"] = data.Get("Initiated").Bool()
+		element.set("source_ip", data.Get("SourceIp").String())
+		element.set("source_port", data.Get("SourcePort").Int())
+		element.set("source_hostname", data.Get("SourceHostname").String())
+		element.set("destination_ip", data.Get("DestinationIp").String())
+		element.set("destination_port", data.Get("DestinationPort").Int())
+		element.set("destination_hostname", data.Get("DestinationHostname").String())
+	case 2, 11, 15, 23, 26:
+		element["action"] = map[int64]string{2: "change-creation-time", 11: "create", 15: "create-stream", 23: "delete", 26: "delete"}[eventID]
+		element.set("path", data.Get("TargetFilename").String())
+		element.set("name", windowsBase(data.Get("TargetFilename").String()))
+		element.set("created", sysmonTime(data.Get("CreationUtcTime").String()))
+		element.set("previous_created", sysmonTime(data.Get("PreviousCreationUtcTime").String()))
+		hashes := data.Get("Hashes").String()
+		if hashes == "" {
+			hashes = data.Get("Hash").String()
+		}
+		element.set("hashes", parseSysmonHashes(hashes))
+	case 12, 13, 14:
+		element["action"] = kebabCase(data.Get("EventType").String())
+		element.set("key", data.Get("TargetObject").String())
+		element.set("details", data.Get("Details").String())
+		element.set("new_name", data.Get("NewName").String())
+	case 22:
+		element["action"] = "query"
+		element.set("query", data.Get("QueryName").String())
+		element["status"] = data.Get("QueryStatus").Int()
+		element.set("answers", parseSysmonQueryResults(data.Get("QueryResults").String()))
+	}
+	return element
+}
+
+// sysmonHashNames maps the Sysmon hash names to the names used in file
+// elements.
+var sysmonHashNames = map[string]string{
+	"MD5":    "MD5",
+	"SHA1":   "SHA-1",
+	"SHA256": "SHA-256",
+}
+
+// parseSysmonHashes parses hashes in the format
+// SHA1=...,MD5=...,SHA256=...,IMPHASH=... .
+func parseSysmonHashes(s string) map[string]string {
+	hashes := map[string]string{}
+	for _, part := range strings.Split(s, ",") {
+		kv := strings.SplitN(strings.TrimSpace(part), "=", 2)
+		if len(kv) != 2 || kv[1] == "" {
+			continue
+		}
+		name := strings.ToUpper(kv[0])
+		if stixName, ok := sysmonHashNames[name]; ok {
+			name = stixName
+		}
+		hashes[name] = strings.ToLower(kv[1])
+	}
+	return hashes
+}
+
+var dnsRecordType = regexp.MustCompile(`^type:\s+\d+\s+`)
+
+// parseSysmonQueryResults parses the query results of DNS events, e.g.
+// "type:  5 example.org;::ffff:93.184.216.34;".
+func parseSysmonQueryResults(s string) []string {
+	var answers []string
+	for _, answer := range strings.Split(s, ";") {
+		answer = strings.TrimSpace(answer)
+		answer = dnsRecordType.ReplaceAllString(answer, "")
+		answer = strings.TrimPrefix(answer, "::ffff:")
+		if answer != "" && answer != "-" {
+			answers = append(answers, answer)
+		}
+	}
+	return answers
+}
+
+// sysmonTime converts the Sysmon time format to RFC 3339.
+func sysmonTime(s string) string {
+	t, err := time.Parse("2006-01-02 15:04:05.999999999", s)
+	if err != nil {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+func windowsBase(p string) string {
+	if p == "" || p == "-" {
+		return ""
+	}
+	return path.Base(strings.ReplaceAll(p, `\`, "/"))
+}
+
+// kebabCase converts e.g. SetValue to set-value.
+func kebabCase(s string) string {
+	var b strings.Builder
+	for i, r := range s {
+		if r >= 'A' && r <= 'Z' {
+			if i > 0 {
+				b.WriteByte('-')
+			}
+			r += 'a' - 'A'
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
@@ -0,0 +1,111 @@
+// Copyright (c) 2020 Siemens AG
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+//
+// Author(s): Jonas Plum
+
+package builtin
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestNormalizeSysmon(t *testing.T) {
+	event := func(eventID int, data string) []byte {
+		return []byte(fmt.Sprintf(`{"System": {"EventID": {"Value": %d}, "Computer": "PC", "EventRecordID": 42, "Channel": "Microsoft-Windows-Sysmon/Operational"}, "EventData": %s, "type": "eventlog"}`, eventID, data))
+	}
+
+	tests := []struct {
+		name  string
+		event []byte
+		want  map[string]interface{}
+	}{
+		{
+			"process create",
+			event(1, `{"UtcTime": "2021-03-04 05:06:07.890", "ProcessGuid": "{B2796A13-0000}", "ProcessId": 4242, "Image": "C:\\Windows\\System32\\cmd.exe", "CommandLine": "cmd.exe /c whoami", "CurrentDirectory": "C:\\", "User": "PC\\admin", "LogonId": "0x3e7", "IntegrityLevel": "High", "Hashes": "SHA1=AAAA,MD5=BBBB,SHA256=CCCC,IMPHASH=DDDD", "ParentProcessGuid": "{B2796A13-0001}", "ParentProcessId": 1000, "ParentImage": "C:\\Program Files\\Microsoft Office\\WINWORD.EXE", "ParentCommandLine": "WINWORD.EXE doc.docm"}`),
+			map[string]interface{}{
+				"type": "process", "action": "create", "event_time": "2021-03-04T05:06:07.89Z", "created": "2021-03-04T05:06:07.89Z",
+				"computer": "PC", "event_id": 1, "record_id": 42, "pid": 4242, "process_guid": "{B2796A13-0000}",
+				"image": `C:\Windows\System32\cmd.exe`, "name": "cmd.exe", "command_line": "cmd.exe /c whoami", "cwd": `C:\`,
+				"user": `PC\admin`, "logon_id": "0x3e7", "integrity_level": "high",
+				"hashes": map[string]interface{}{"SHA-1": "aaaa", "MD5": "bbbb", "SHA-256": "cccc", "IMPHASH": "dddd"},
+				"parent": map[string]interface{}{
+					"pid": 1000, "process_guid": "{B2796A13-0001}",
+					"image": `C:\Program Files\Microsoft Office\WINWORD.EXE`, "command_line": "WINWORD.EXE doc.docm",
+				},
+			},
+		},
+		{
+			"network connection",
+			event(3, `{"UtcTime": "2021-03-04 05:06:07.890", "ProcessId": 4242, "Image": "C:\\Windows\\System32\\cmd.exe", "Protocol": "tcp", "Initiated": "true", "SourceIp": "10.0.0.1", "SourcePort": 50000, "DestinationIp": "93.184.216.34", "DestinationPort": 443, "DestinationHostname": "example.org"}`),
+			map[string]interface{}{
+				"type": "network-connection", "action": "connect", "event_time": "2021-03-04T05:06:07.89Z",
+				"computer": "PC", "event_id": 3, "record_id": 42, "pid": 4242, "image": `C:\Windows\System32\cmd.exe`,
+				"protocol": "tcp", "initiated": true, "source_ip": "10.0.0.1", "source_port": 50000,
+				"destination_ip": "93.184.216.34", "destination_port": 443, "destination_hostname": "example.org",
+			},
+		},
+		{
+			"registry",
+			event(13, `{"EventType": "SetValue", "UtcTime": "2021-03-04 05:06:07.890", "ProcessId": 4242, "TargetObject": "HKLM\\Software\\Run\\foo", "Details": "C:\\foo.exe"}`),
+			map[string]interface{}{
+				"type": "registry", "action": "set-value", "event_time": "2021-03-04T05:06:07.89Z",
+				"computer": "PC", "event_id": 13, "record_id": 42, "pid": 4242,
+				"key": `HKLM\Software\Run\foo`, "details": `C:\foo.exe`,
+			},
+		},
+		{
+			"dns",
+			event(22, `{"UtcTime": "2021-03-04 05:06:07.890", "ProcessId": 4242, "QueryName": "www.example.org", "QueryStatus": "0", "QueryResults": "type:  5 example.org;::ffff:93.184.216.34;"}`),
+			map[string]interface{}{
+				"type": "dns", "action": "query", "event_time": "2021-03-04T05:06:07.89Z",
+				"computer": "PC", "event_id": 22, "record_id": 42, "pid": 4242,
+				"query": "www.example.org", "status": 0, "answers": []interface{}{"example.org", "93.184.216.34"},
+			},
+		},
+		{"unsupported", event(4, `{}`), nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			element := normalizeSysmon(tt.event)
+			if tt.want == nil {
+				if element != nil {
+					t.Fatalf("normalizeSysmon() = %v, want nil", element)
+				}
+				return
+			}
+
+			b, err := json.Marshal(element)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got, want interface{}
+			if err := json.Unmarshal(b, &got); err != nil {
+				t.Fatal(err)
+			}
+			wb, _ := json.Marshal(tt.want)
+			json.Unmarshal(wb, &want) // nolint: errcheck
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("normalizeSysmon() = %s, want %s", b, wb)
+			}
+		})
+	}
+}
@@ -21,123 +21,327 @@
 
 package builtin
 
-/*
 import (
+	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"io/ioutil"
-	"log"
-	"os"
-	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
 	"time"
+	"unicode"
+	"unicode/utf8"
 
-	"github.com/hillu/go-yara"
-	"github.com/spf13/afero"
-	"github.com/spf13/cobra"
+	"github.com/tidwall/gjson"
 
+	"github.com/forensicanalysis/elementary/pluginlib"
 	"github.com/forensicanalysis/forensicstore"
 )
 
-func Yara() daggy.command {
-	var rulesPath string
-	prefetchCommand := &BuiltInCommand{
-		Use:   "yara <forensicstore>",
-		Short: "Process prefetch files",
-		Args:  RequireStore,
-		run: func(cmd daggy.command, args []string) error {
-			log.Printf("run yara %s", args)
+var _ pluginlib.Plugin = &Yara{}
 
-			c, err := yara.NewCompiler()
-			if err != nil {
-				return err
-			}
+// Yara scans the stored files with yara rules.
+type Yara struct {
+	parameter pluginlib.ParameterList
+}
 
-			f, err := os.Open(rulesPath) // #nosec
-			if err != nil {
-				return err
-			}
-			err = c.AddFile(f, "default")
-			if err != nil {
-				return err
-			}
-			err = f.Close()
-			if err != nil {
-				return err
-			}
+func (y *Yara) Name() string {
+	return "yara"
+}
 
-			rules, err := c.GetRules()
-			if err != nil {
-				return err
-			}
+func (y *Yara) Short() string {
+	return "Scan files with yara rules"
+}
 
-			for _, url := range args {
-				err = yaraStore(url, rules, cmd)
-				if err != nil {
-					return err
-				}
-			}
-			return nil
-		},
+func (y *Yara) Parameter() pluginlib.ParameterList {
+	if y.parameter == nil {
+		y.parameter = pluginlib.ParameterList{
+			{Name: "forensicstore", Type: pluginlib.Path, Description: "forensicstore", Required: true, Argument: true},
+			Filter,
+			{Name: "rules", Type: pluginlib.PathArray, Description: "yara rule files or directories", Required: true},
+			{Name: "timeout", Type: pluginlib.String, Description: "maximal scan time per file, checked between matches and while regular expressions read the file", Value: "1m", Required: false},
+			{Name: "max-size", Type: pluginlib.String, Description: "maximal file size, larger files are skipped", Value: "256MB", Required: false},
+			{Name: "max-memory", Type: pluginlib.String, Description: "maximal size of the files scanned at the same time", Value: "1GB", Required: false},
+		}
 	}
-	addOutputFlags(prefetchCommand)
-	prefetchCommand.Flags().StringVar(&rulesPath, "rules", "", "yara rule directory")
-	return prefetchCommand
+	return y.parameter
+}
+
+func (y *Yara) Output() *pluginlib.Config {
+	return &pluginlib.Config{Header: []string{"rule", "tags", "path", "file_ref"}}
 }
 
-func yaraStore(url string, rules *yara.Rules, cmd daggy.command) error {
-	store, teardown, err := forensicstore.Open(url)
+func (y *Yara) Run(p pluginlib.Plugin, out pluginlib.LineWriter) error {
+	timeout, err := time.ParseDuration(p.Parameter().StringValue("timeout"))
+	if err != nil || timeout <= 0 {
+		return fmt.Errorf("invalid timeout %s", p.Parameter().StringValue("timeout"))
+	}
+
+	maxSize, err := parseByteSize(p.Parameter().StringValue("max-size"))
+	if err != nil || maxSize <= 0 {
+		return fmt.Errorf("invalid max-size %s", p.Parameter().StringValue("max-size"))
+	}
+	maxMemory, err := parseByteSize(p.Parameter().StringValue("max-memory"))
+	if err != nil || maxMemory <= 0 {
+		return fmt.Errorf("invalid max-memory %s", p.Parameter().StringValue("max-memory"))
+	}
+
+	rules, err := loadYaraRules(p.Parameter().GetStringArrayValue("rules"), func(path string, err error) {
+		pluginlib.Warn(out, path, err)
+	})
+	if err != nil {
+		return err
+	}
+	if len(rules.rules) == 0 {
+		return errors.New("no yara rules found")
+	}
+
+	store, teardown, err := getForensicStore(p)
 	if err != nil {
 		return err
 	}
 	defer teardown()
 
-	var elements []forensicstore.JSONElement
+	filter := pluginlib.ExtractFilter(p.Parameter().GetStringArrayValue("filter"))
+	return yaraFromStore(out, store, filter, rules, yaraOptions{Workers: runtime.NumCPU(), Timeout: timeout, MaxSize: maxSize, Memory: maxMemory})
+}
 
-	err = afero.Walk(store.Fs, "/", func(path string, info os.FileInfo, err error) error {
-		if err != nil || info.IsDir() {
-			return err
+// loadYaraRules compiles the yara rules of files and directories. Files and
+// rules that cannot be compiled are passed to warn and skipped.
+func loadYaraRules(paths []string, warn func(path string, err error)) (*yaraRules, error) {
+	rules := &yaraRules{}
+	err := forEachFile(paths, []string{".yar", ".yara"}, func(path string) {
+		fileRules := &yaraRules{}
+		ruleErrors, err := compileYara(path, fileRules)
+		for _, ruleErr := range ruleErrors {
+			warn(path, ruleErr)
 		}
-		f, err := store.LoadFile(filepath.ToSlash(path))
 		if err != nil {
-			return err
+			// yara rejects a file with syntax errors as a whole
+			warn(path, err)
+			return
 		}
-		defer f.Close()
+		rules.rules = append(rules.rules, fileRules.rules...)
+	})
+	return rules, err
+}
 
-		b, err := ioutil.ReadAll(f)
-		if err != nil {
-			return err
+// YaraMatch is a file matched by a yara rule.
+type YaraMatch struct {
+	Type     string                 `json:"type"`
+	Rule     string                 `json:"rule"`
+	Tags     []string               `json:"tags,omitempty"`
+	Meta     map[string]interface{} `json:"meta,omitempty"`
+	Strings  []YaraString           `json:"strings,omitempty"`
+	RulePath string                 `json:"rule_path"`
+	Path     string                 `json:"path,omitempty"`
+	FileRef  string                 `json:"file_ref"`
+}
+
+// YaraString is a match of a string of a yara rule. Data that is not
+// printable is hex encoded.
+type YaraString struct {
+	Identifier string `json:"identifier"`
+	Offset     int    `json:"offset"`
+	Data       string `json:"data,omitempty"`
+	Hex        string `json:"hex,omitempty"`
+}
+
+type yaraOptions struct {
+	// Workers is the number of files scanned in parallel.
+	Workers int
+	// Timeout is the maximal scan time per file.
+	Timeout time.Duration
+	// MaxSize is the maximal size of a scanned file in bytes.
+	MaxSize int64
+	// Memory is the maximal size of the files that are scanned at the same
+	// time. A file larger than Memory is scanned alone.
+	Memory int64
+}
+
+// parseByteSize parses a size in bytes with an optional KB, MB or GB suffix.
+func parseByteSize(s string) (int64, error) {
+	multiplier := int64(1)
+	for i, suffix := range []string{"KB", "MB", "GB"} {
+		if strings.HasSuffix(s, suffix) {
+			multiplier, s = int64(1)<<(10*(i+1)), strings.TrimSuffix(s, suffix)
+			break
 		}
+	}
+	size, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	return size * multiplier, nil
+}
 
-		matches, err := rules.ScanMem(b, 0, time.Second*10) // nolint: gomnd
-		if err != nil {
-			return err
-		}
-		for _, match := range matches {
-			b, err := json.Marshal(struct {
-				Type string                 `json:"type"`
-				Rule string                 `json:"rule"`
-				Meta map[string]interface{} `json:"meta"`
-			}{
-				Type: "yara",
-				Rule: match.Rule,
-				Meta: match.Meta,
-			})
+type yaraJob struct {
+	id     string
+	path   string
+	data   []byte
+	result chan yaraResult
+}
+
+type yaraResult struct {
+	matches []*YaraMatch
+	err     error
+}
+
+// yaraFromStore scans the files with a pool of workers. The files are read
+// and the matches are written in store order, so the store is only used by
+// a single goroutine. Besides the file that is read, the scanned files use
+// at most options.Memory bytes.
+func yaraFromStore(out pluginlib.LineWriter, store *forensicstore.ForensicStore, filter pluginlib.Filter, rules *yaraRules, options yaraOptions) error {
+	workers := options.Workers
+	if workers < 1 {
+		workers = 1
+	}
+	jobs := make(chan *yaraJob)
+	defer close(jobs)
+	for i := 0; i < workers; i++ {
+		go func() {
+			for job := range jobs {
+				matches, err := rules.scanFile(job.id, job.path, job.data, options.Timeout)
+				job.result <- yaraResult{matches: matches, err: err}
+			}
+		}()
+	}
+
+	var pending []*yaraJob
+	var scanned int64
+	write := func(job *yaraJob) error {
+		result := <-job.result
+		scanned -= int64(len(job.data))
+		job.data = nil
+		if result.err != nil {
+			pluginlib.Warn(out, job.id, fmt.Errorf("could not scan %s: %w", job.path, result.err))
+		}
+		for _, match := range result.matches {
+			b, err := json.Marshal(match)
 			if err != nil {
 				return err
 			}
-			elements = append(elements, b)
+			if b, err = pluginlib.SetSource(b, job.id); err != nil {
+				return err
+			}
+			if err := out.WriteLine(b); err != nil {
+				return err
+			}
 		}
 		return nil
+	}
+
+	err := pluginlib.ForEach(store, combineFilter(filter, pluginlib.Filter{{"type": "file"}}), func(element []byte) error {
+		exportPath := gjson.GetBytes(element, "export_path").String()
+		if exportPath == "" {
+			return nil
+		}
+		id := gjson.GetBytes(element, "id").String()
+		path := gjson.GetBytes(element, "origin.path").String()
+		if path == "" {
+			path = exportPath
+		}
+
+		tooLarge := fmt.Errorf("skipped %s, file is larger than %d bytes", exportPath, options.MaxSize)
+		if options.MaxSize > 0 && gjson.GetBytes(element, "size").Int() > options.MaxSize {
+			pluginlib.Warn(out, id, tooLarge)
+			return nil
+		}
+
+		file, teardown, err := store.LoadFile(exportPath)
+		if err != nil {
+			pluginlib.Warn(out, id, fmt.Errorf("could not read %s: %w", exportPath, err))
+			return nil
+		}
+		var reader io.Reader = file
+		if options.MaxSize > 0 {
+			// the size of the element is not reliable, so the read is bounded as well
+			reader = io.LimitReader(file, options.MaxSize+1)
+		}
+		data, err := ioutil.ReadAll(reader)
+		teardown() // nolint: errcheck
+		if err != nil {
+			pluginlib.Warn(out, id, fmt.Errorf("could not read %s: %w", exportPath, err))
+			return nil
+		}
+		if options.MaxSize > 0 && int64(len(data)) > options.MaxSize {
+			pluginlib.Warn(out, id, tooLarge)
+			return nil
+		}
+
+		for len(pending) > 0 && (len(pending) == workers || (options.Memory > 0 && scanned+int64(len(data)) > options.Memory)) {
+			if err := write(pending[0]); err != nil {
+				return err
+			}
+			pending = pending[1:]
+		}
+		job := &yaraJob{id: id, path: path, data: data, result: make(chan yaraResult, 1)}
+		jobs <- job
+		scanned += int64(len(data))
+		pending = append(pending, job)
+		return nil
 	})
+	for _, job := range pending {
+		if writeErr := write(job); err == nil {
+			err = writeErr
+		}
+	}
+	return err
+}
+
+// scanFile evaluates the rules on a file and returns the matching rules with
+// their string matches.
+func (rules *yaraRules) scanFile(id, path string, data []byte, timeout time.Duration) ([]*YaraMatch, error) {
+	matched, scan, err := rules.scan(data, timeout)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	config := &outputConfig{
-		Header: []string{
-			"Rule",
-		},
+	var matches []*YaraMatch
+	for _, rule := range matched {
+		match := &YaraMatch{
+			Type:     "yara",
+			Rule:     rule.Name,
+			Tags:     rule.Tags,
+			Meta:     rule.Meta,
+			RulePath: rule.Namespace,
+			Path:     path,
+			FileRef:  id,
+		}
+		if len(match.Meta) == 0 {
+			match.Meta = nil
+		}
+		for _, str := range rule.strings {
+			if str.private {
+				continue
+			}
+			found, err := scan.find(str)
+			if err != nil {
+				return nil, err
+			}
+			for _, m := range found {
+				yaraString := YaraString{Identifier: "$" + str.id, Offset: m.offset}
+				yaraString.Data, yaraString.Hex = yaraData(data[m.offset : m.offset+m.length])
+				match.Strings = append(match.Strings, yaraString)
+			}
+		}
+		matches = append(matches, match)
+	}
+	return matches, nil
+}
+
+// yaraData returns printable data as text and other data hex encoded.
+func yaraData(b []byte) (string, string) {
+	if !utf8.Valid(b) {
+		return "", hex.EncodeToString(b)
+	}
+	for _, r := range string(b) {
+		if !unicode.IsPrint(r) && !unicode.IsSpace(r) {
+			return "", hex.EncodeToString(b)
+		}
 	}
-	printElements(cmd, config, elements, store)
-	return nil
+	return string(b), ""
 }
-*/
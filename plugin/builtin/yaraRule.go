@@ -0,0 +1,1794 @@
+// Copyright (c) 2020 Siemens AG
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+//
+// Author(s): Jonas Plum
+
+package builtin
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// The yara rules are parsed and evaluated natively, modules like pe are not
+// supported and rules that use them are skipped.
+
+// maxYaraMatches limits the matches per string and file.
+const maxYaraMatches = 1000
+
+var errYaraTimeout = errors.New("timeout")
+
+type yaraTokenKind int
+
+const (
+	yaraEOF yaraTokenKind = iota
+	yaraIdent
+	yaraText
+	yaraRegex
+	yaraHex
+	yaraNumber
+	yaraVar    // $a
+	yaraCount  // #a
+	yaraOffset // @a
+	yaraLength // !a
+	yaraPunct
+)
+
+type yaraToken struct {
+	kind  yaraTokenKind
+	text  string
+	value int64
+	line  int
+}
+
+func (t yaraToken) String() string {
+	if t.kind == yaraEOF {
+		return "end of file"
+	}
+	return strconv.Quote(t.text)
+}
+
+// yaraTokens splits yara rules into tokens.
+func yaraTokens(src string) ([]yaraToken, error) { // nolint: gocyclo
+	var tokens []yaraToken
+	line := 1
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == '\n':
+			line++
+			i++
+		case c == ' ' || c == '\t' || c == '\r':
+			i++
+		case strings.HasPrefix(src[i:], "//"):
+			for i < len(src) && src[i] != '\n' {
+				i++
+			}
+		case strings.HasPrefix(src[i:], "/*"):
+			end := strings.Index(src[i+2:], "*/")
+			if end < 0 {
+				return nil, fmt.Errorf("line %d: unterminated comment", line)
+			}
+			line += strings.Count(src[i:i+2+end], "\n")
+			i += end + 4
+		case c == '"':
+			text, n, err := yaraUnquote(src[i:])
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", line, err)
+			}
+			tokens = append(tokens, yaraToken{kind: yaraText, text: text, line: line})
+			i += n
+		case c == '/':
+			// yara uses \ for divisions, so / always starts a regular expression
+			j := i + 1
+			for ; j < len(src) && src[j] != '/'; j++ {
+				if src[j] == '\\' {
+					j++
+				}
+				if j < len(src) && src[j] == '\n' {
+					return nil, fmt.Errorf("line %d: unterminated regular expression", line)
+				}
+			}
+			if j >= len(src) {
+				return nil, fmt.Errorf("line %d: unterminated regular expression", line)
+			}
+			k := j + 1
+			for k < len(src) && (src[k] == 'i' || src[k] == 's') {
+				k++
+			}
+			// the flags are stored in front of the expression
+			tokens = append(tokens, yaraToken{kind: yaraRegex, text: src[j+1:k] + "/" + src[i+1:j], line: line})
+			i = k
+		case c == '{' && len(tokens) > 0 && tokens[len(tokens)-1].text == "=" && tokens[len(tokens)-1].kind == yaraPunct:
+			end := strings.IndexByte(src[i:], '}')
+			if end < 0 {
+				return nil, fmt.Errorf("line %d: unterminated hex string", line)
+			}
+			hex := src[i+1 : i+end]
+			tokens = append(tokens, yaraToken{kind: yaraHex, text: hex, line: line})
+			line += strings.Count(hex, "\n")
+			i += end + 1
+		case c == '$' || c == '#' || c == '@' || (c == '!' && i+1 < len(src) && src[i+1] != '='):
+			j := i + 1
+			for j < len(src) && (isYaraIdent(src[j]) || (c == '$' && src[j] == '*')) {
+				j++
+			}
+			kind := map[byte]yaraTokenKind{'$': yaraVar, '#': yaraCount, '@': yaraOffset, '!': yaraLength}[c]
+			tokens = append(tokens, yaraToken{kind: kind, text: src[i+1 : j], line: line})
+			i = j
+		case c >= '0' && c <= '9':
+			j := i
+			for j < len(src) && (isYaraIdent(src[j])) {
+				j++
+			}
+			text := src[i:j]
+			multiplier := int64(1)
+			switch {
+			case strings.HasSuffix(text, "KB"):
+				multiplier, text = 1024, strings.TrimSuffix(text, "KB")
+			case strings.HasSuffix(text, "MB"):
+				multiplier, text = 1024*1024, strings.TrimSuffix(text, "MB")
+			}
+			if strings.HasPrefix(text, "0o") {
+				text = "0" + text[2:]
+			}
+			value, err := strconv.ParseInt(text, 0, 64)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: invalid number %s", line, src[i:j])
+			}
+			tokens = append(tokens, yaraToken{kind: yaraNumber, text: src[i:j], value: value * multiplier, line: line})
+			i = j
+		case isYaraIdent(c):
+			j := i
+			for j < len(src) && isYaraIdent(src[j]) {
+				j++
+			}
+			tokens = append(tokens, yaraToken{kind: yaraIdent, text: src[i:j], line: line})
+			i = j
+		default:
+			punct := string(c)
+			for _, p := range []string{"..", "==", "!=", "<=", ">=", "<<", ">>"} {
+				if strings.HasPrefix(src[i:], p) {
+					punct = p
+					break
+				}
+			}
+			if !strings.Contains("{}()[]:=,.<>+-*\\%&|^~", punct[:1]) {
+				return nil, fmt.Errorf("line %d: unexpected %q", line, c)
+			}
+			tokens = append(tokens, yaraToken{kind: yaraPunct, text: punct, line: line})
+			i += len(punct)
+		}
+	}
+	return append(tokens, yaraToken{kind: yaraEOF, line: line}), nil
+}
+
+func isYaraIdent(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+// yaraUnquote reads a quoted yara string and returns its bytes and the
+// length of the quoted string.
+func yaraUnquote(s string) (string, int, error) {
+	var b strings.Builder
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '"':
+			return b.String(), i + 1, nil
+		case '\n':
+			return "", 0, errors.New("unterminated string")
+		case '\\':
+			i++
+			if i >= len(s) {
+				return "", 0, errors.New("unterminated string")
+			}
+			switch s[i] {
+			case 'n':
+				b.WriteByte('\n')
+			case 't':
+				b.WriteByte('\t')
+			case 'r':
+				b.WriteByte('\r')
+			case '"', '\\':
+				b.WriteByte(s[i])
+			case 'x':
+				if i+2 >= len(s) {
+					return "", 0, errors.New("invalid escape")
+				}
+				v, err := strconv.ParseUint(s[i+1:i+3], 16, 8)
+				if err != nil {
+					return "", 0, errors.New("invalid escape")
+				}
+				b.WriteByte(byte(v))
+				i += 2
+			default:
+				return "", 0, fmt.Errorf("invalid escape \\%c", s[i])
+			}
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return "", 0, errors.New("unterminated string")
+}
+
+// yaraRule is a compiled yara rule.
+type yaraRule struct {
+	Name      string
+	Namespace string
+	Tags      []string
+	Meta      map[string]interface{}
+	private   bool
+	global    bool
+	strings   []*yaraString
+	condition yaraExpr
+}
+
+// yaraRules are the compiled rules of one or more files.
+type yaraRules struct {
+	rules []*yaraRule
+}
+
+// yaraRuleError is a rule that could not be compiled, the other rules of the
+// file are still used.
+type yaraRuleError struct {
+	rule string
+	err  error
+}
+
+func (e *yaraRuleError) Error() string {
+	return fmt.Sprintf("rule %s: %s", e.rule, e.err)
+}
+
+// compileYara parses the rules of a file. The rules of included files are
+// added as well. Rules that use unsupported features are returned as
+// errors and skipped.
+func compileYara(path string, rules *yaraRules) ([]*yaraRuleError, error) {
+	b, err := ioutil.ReadFile(path) // #nosec
+	if err != nil {
+		return nil, err
+	}
+	tokens, err := yaraTokens(string(b))
+	if err != nil {
+		return nil, err
+	}
+	p := &yaraParser{tokens: tokens, path: path, rules: rules}
+	if err := p.file(); err != nil {
+		return p.ruleErrors, err
+	}
+	return p.ruleErrors, nil
+}
+
+type yaraParser struct {
+	tokens     []yaraToken
+	pos        int
+	path       string
+	rules      *yaraRules
+	rule       *yaraRule
+	ruleErr    error
+	loopVars   map[string]bool
+	inLoop     bool
+	ruleErrors []*yaraRuleError
+}
+
+func (p *yaraParser) peek() yaraToken {
+	return p.tokens[p.pos]
+}
+
+func (p *yaraParser) peekN(n int) yaraToken {
+	if p.pos+n < len(p.tokens) {
+		return p.tokens[p.pos+n]
+	}
+	return p.tokens[len(p.tokens)-1]
+}
+
+func (p *yaraParser) next() yaraToken {
+	t := p.tokens[p.pos]
+	if t.kind != yaraEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *yaraParser) is(text string) bool {
+	t := p.peek()
+	return (t.kind == yaraPunct || t.kind == yaraIdent) && t.text == text
+}
+
+func (p *yaraParser) expect(text string) error {
+	if !p.is(text) {
+		return p.errorf("expected %q, got %s", text, p.peek())
+	}
+	p.next()
+	return nil
+}
+
+func (p *yaraParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("line %d: %s", p.peek().line, fmt.Sprintf(format, args...))
+}
+
+// unsupported marks the current rule as not compilable.
+func (p *yaraParser) unsupported(format string, args ...interface{}) {
+	if p.ruleErr == nil {
+		p.ruleErr = fmt.Errorf(format, args...)
+	}
+}
+
+func (p *yaraParser) file() error {
+	for p.peek().kind != yaraEOF {
+		switch {
+		case p.is("import"):
+			p.next()
+			if t := p.next(); t.kind != yaraText {
+				return p.errorf("expected module name")
+			}
+		case p.is("include"):
+			p.next()
+			t := p.next()
+			if t.kind != yaraText {
+				return p.errorf("expected include path")
+			}
+			include := t.text
+			if !filepath.IsAbs(include) {
+				include = filepath.Join(filepath.Dir(p.path), include)
+			}
+			ruleErrors, err := compileYara(include, p.rules)
+			p.ruleErrors = append(p.ruleErrors, ruleErrors...)
+			if err != nil {
+				return fmt.Errorf("%s: %w", include, err)
+			}
+		default:
+			if err := p.parseRule(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (p *yaraParser) parseRule() error { // nolint: gocyclo
+	rule := &yaraRule{Namespace: p.path, Meta: map[string]interface{}{}}
+	for p.is("private") || p.is("global") {
+		if p.next().text == "private" {
+			rule.private = true
+		} else {
+			rule.global = true
+		}
+	}
+	if err := p.expect("rule"); err != nil {
+		return err
+	}
+	name := p.next()
+	if name.kind != yaraIdent {
+		return p.errorf("expected rule name")
+	}
+	rule.Name = name.text
+	if p.is(":") {
+		p.next()
+		for p.peek().kind == yaraIdent {
+			rule.Tags = append(rule.Tags, p.next().text)
+		}
+	}
+	if err := p.expect("{"); err != nil {
+		return err
+	}
+
+	p.rule, p.ruleErr = rule, nil
+	if p.is("meta") {
+		p.next()
+		if err := p.expect(":"); err != nil {
+			return err
+		}
+		for p.peek().kind == yaraIdent && p.peekN(1).text == "=" {
+			key := p.next().text
+			p.next()
+			value, err := p.metaValue()
+			if err != nil {
+				return err
+			}
+			rule.Meta[key] = value
+		}
+	}
+	if p.is("strings") {
+		p.next()
+		if err := p.expect(":"); err != nil {
+			return err
+		}
+		for p.peek().kind == yaraVar {
+			if err := p.parseString(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := p.expect("condition"); err != nil {
+		return err
+	}
+	if err := p.expect(":"); err != nil {
+		return err
+	}
+	condition, err := p.or()
+	if err != nil {
+		return err
+	}
+	rule.condition = condition
+	if err := p.expect("}"); err != nil {
+		return err
+	}
+
+	if p.ruleErr != nil {
+		p.ruleErrors = append(p.ruleErrors, &yaraRuleError{rule: rule.Name, err: p.ruleErr})
+		return nil
+	}
+	p.rules.rules = append(p.rules.rules, rule)
+	return nil
+}
+
+func (p *yaraParser) metaValue() (interface{}, error) {
+	t := p.next()
+	switch {
+	case t.kind == yaraText:
+		return t.text, nil
+	case t.kind == yaraNumber:
+		return t.value, nil
+	case t.kind == yaraPunct && t.text == "-" && p.peek().kind == yaraNumber:
+		return -p.next().value, nil
+	case t.kind == yaraIdent && (t.text == "true" || t.text == "false"):
+		return t.text == "true", nil
+	}
+	return nil, p.errorf("invalid meta value %s", t)
+}
+
+// yaraString is a text, hex or regex string of a rule.
+type yaraString struct {
+	id       string
+	private  bool
+	fullword bool
+	wide     bool
+	nocase   bool
+	// patterns are searched literally
+	patterns [][]byte
+	// re and anchored match hex strings and regular expressions on bytes
+	re       *regexp.Regexp
+	anchored *regexp.Regexp
+	prefix   []byte
+}
+
+func (p *yaraParser) parseString() error { // nolint: gocyclo
+	s := &yaraString{id: p.next().text}
+	if err := p.expect("="); err != nil {
+		return err
+	}
+	value := p.next()
+
+	modifiers := map[string]bool{}
+	xorMin, xorMax := 0, 255
+	alphabet := ""
+	for p.peek().kind == yaraIdent && !p.is("condition") {
+		modifier := p.next().text
+		modifiers[modifier] = true
+		switch modifier {
+		case "xor":
+			if p.is("(") {
+				p.next()
+				min := p.next()
+				xorMin, xorMax = int(min.value), int(min.value)
+				if p.is("-") {
+					p.next()
+					xorMax = int(p.next().value)
+				}
+				if err := p.expect(")"); err != nil {
+					return err
+				}
+				if min.kind != yaraNumber || xorMin < 0 || xorMax > 255 || xorMin > xorMax {
+					return p.errorf("invalid xor range")
+				}
+			}
+		case "base64", "base64wide":
+			if p.is("(") {
+				p.next()
+				t := p.next()
+				if t.kind != yaraText || len(t.text) != 64 {
+					return p.errorf("invalid base64 alphabet")
+				}
+				alphabet = t.text
+				if err := p.expect(")"); err != nil {
+					return err
+				}
+			}
+		case "nocase", "wide", "ascii", "fullword", "private":
+		default:
+			return p.errorf("unknown modifier %s", modifier)
+		}
+	}
+	s.private, s.fullword, s.nocase = modifiers["private"], modifiers["fullword"], modifiers["nocase"]
+	s.wide = modifiers["wide"]
+
+	switch value.kind {
+	case yaraText:
+		ascii := modifiers["ascii"] || !modifiers["wide"]
+		var variants []string
+		if ascii {
+			variants = append(variants, value.text)
+		}
+		if modifiers["wide"] {
+			variants = append(variants, wideString(value.text))
+		}
+		if modifiers["xor"] {
+			var xored []string
+			for _, variant := range variants {
+				for key := xorMin; key <= xorMax; key++ {
+					b := []byte(variant)
+					for i := range b {
+						b[i] ^= byte(key)
+					}
+					xored = append(xored, string(b))
+				}
+			}
+			variants = xored
+		}
+		if modifiers["base64"] || modifiers["base64wide"] {
+			var encoded []string
+			for _, offset := range base64Offsets(value.text) {
+				if alphabet != "" {
+					offset = yaraBase64Alphabet(offset, alphabet)
+				}
+				if modifiers["base64"] {
+					encoded = append(encoded, offset)
+				}
+				if modifiers["base64wide"] {
+					encoded = append(encoded, wideString(offset))
+				}
+			}
+			variants = encoded
+		}
+		for _, variant := range variants {
+			pattern := []byte(variant)
+			if s.nocase {
+				pattern = bytes.ToLower(pattern)
+			}
+			s.patterns = append(s.patterns, pattern)
+		}
+	case yaraHex:
+		expression, err := yaraHexExpression(value.text)
+		if err != nil {
+			return p.errorf("%s: %s", s.id, err)
+		}
+		if err := s.compile("(?s)" + expression); err != nil {
+			return p.errorf("%s: %s", s.id, err)
+		}
+	case yaraRegex:
+		parts := strings.SplitN(value.text, "/", 2)
+		flags := ""
+		if strings.Contains(parts[0], "i") || s.nocase {
+			flags += "i"
+		}
+		if strings.Contains(parts[0], "s") {
+			flags += "s"
+		}
+		expression := parts[1]
+		if flags != "" {
+			expression = "(?" + flags + ")" + expression
+		}
+		if modifiers["wide"] {
+			p.unsupported("%s: wide regular expressions are not supported", s.id)
+		}
+		if err := s.compile(expression); err != nil {
+			return p.errorf("%s: %s", s.id, err)
+		}
+	default:
+		return p.errorf("invalid string %s", value)
+	}
+
+	if s.id != "" {
+		for _, other := range p.rule.strings {
+			if other.id == s.id {
+				return p.errorf("duplicate string $%s", s.id)
+			}
+		}
+	}
+	p.rule.strings = append(p.rule.strings, s)
+	return nil
+}
+
+func (s *yaraString) compile(expression string) error {
+	re, err := regexp.Compile(expression)
+	if err != nil {
+		return err
+	}
+	s.re = re
+	s.anchored = regexp.MustCompile(`^(?:` + expression + `)`)
+	prefix, _ := re.LiteralPrefix()
+	for _, r := range prefix {
+		if r > 0xff {
+			s.prefix = nil
+			break
+		}
+		s.prefix = append(s.prefix, byte(r))
+	}
+	return nil
+}
+
+func wideString(s string) string {
+	b := make([]byte, 0, len(s)*2)
+	for i := 0; i < len(s); i++ {
+		b = append(b, s[i], 0)
+	}
+	return string(b)
+}
+
+func yaraBase64Alphabet(s, alphabet string) string {
+	const standard = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+/"
+	b := []byte(s)
+	for i, c := range b {
+		if idx := strings.IndexByte(standard, c); idx >= 0 {
+			b[i] = alphabet[idx]
+		}
+	}
+	return string(b)
+}
+
+var (
+	yaraHexComment = regexp.MustCompile(`(?s)//[^\n]*|/\*.*?\*/`)
+	yaraHexJump    = regexp.MustCompile(`\[[^\]]*\]`)
+)
+
+// yaraHexExpression converts a hex string like { 4D 5A ?? [2-4] (90 | 91) }
+// into a regular expression on bytes.
+func yaraHexExpression(hex string) (string, error) { // nolint: gocyclo
+	hex = yaraHexComment.ReplaceAllString(hex, " ")
+	hex = yaraHexJump.ReplaceAllStringFunc(hex, func(jump string) string {
+		return strings.Join(strings.Fields(jump), "")
+	})
+	fields := strings.Fields(strings.NewReplacer("(", " ( ", ")", " ) ", "|", " | ", "[", " [", "]", "] ").Replace(hex))
+	var b strings.Builder
+	depth := 0
+	for i := 0; i < len(fields); i++ {
+		field := fields[i]
+		switch {
+		case field == "(":
+			b.WriteString("(?:")
+			depth++
+		case field == ")":
+			b.WriteString(")")
+			depth--
+		case field == "|":
+			b.WriteString("|")
+		case strings.HasPrefix(field, "["):
+			jump := strings.TrimSuffix(strings.TrimPrefix(field, "["), "]")
+			parts := strings.SplitN(jump, "-", 2)
+			switch {
+			case len(parts) == 1:
+				if _, err := strconv.Atoi(parts[0]); err != nil {
+					return "", fmt.Errorf("invalid jump %s", field)
+				}
+				b.WriteString(".{" + parts[0] + "}")
+			case parts[0] == "" && parts[1] == "":
+				b.WriteString(".*?")
+			default:
+				if parts[0] == "" {
+					parts[0] = "0"
+				}
+				b.WriteString(".{" + parts[0] + "," + parts[1] + "}?")
+			}
+		default:
+			// a field can contain multiple bytes, e.g. 4D5A
+			not := strings.HasPrefix(field, "~")
+			field = strings.TrimPrefix(field, "~")
+			if len(field)%2 != 0 {
+				return "", fmt.Errorf("invalid hex %s", field)
+			}
+			for j := 0; j < len(field); j += 2 {
+				class, err := yaraHexByte(field[j:j+2], not)
+				if err != nil {
+					return "", err
+				}
+				b.WriteString(class)
+			}
+		}
+	}
+	if depth != 0 {
+		return "", errors.New("unbalanced parentheses")
+	}
+	return b.String(), nil
+}
+
+// yaraHexByte converts a byte with optional nibble wildcards like 4? into a
+// regular expression.
+func yaraHexByte(s string, not bool) (string, error) {
+	if s == "??" {
+		return ".", nil
+	}
+	var values []string
+	for v := 0; v < 256; v++ {
+		hex := fmt.Sprintf("%02X", v)
+		if (s[0] == '?' || unicode.ToUpper(rune(s[0])) == rune(hex[0])) && (s[1] == '?' || unicode.ToUpper(rune(s[1])) == rune(hex[1])) {
+			values = append(values, fmt.Sprintf(`\x{%02x}`, v))
+		}
+	}
+	if len(values) == 0 {
+		return "", fmt.Errorf("invalid hex %s", s)
+	}
+	switch {
+	case not:
+		return "[^" + strings.Join(values, "") + "]", nil
+	case len(values) == 1:
+		return values[0], nil
+	default:
+		return "[" + strings.Join(values, "") + "]", nil
+	}
+}
+
+// yaraMatch is a match of a string.
+type yaraMatch struct {
+	offset int
+	length int
+}
+
+// byteRuneReader reads every byte as a rune, so regular expressions match
+// bytes and the positions are byte offsets. The reader ends early at the
+// deadline, so a regular expression on a large file can be interrupted.
+type byteRuneReader struct {
+	data     []byte
+	pos      int
+	deadline time.Time
+	timedOut bool
+}
+
+func (r *byteRuneReader) ReadRune() (rune, int, error) {
+	if r.pos >= len(r.data) || r.timedOut {
+		return 0, 0, errEOF
+	}
+	if r.pos%(64*1024) == 0 && r.pos > 0 && !r.deadline.IsZero() && time.Now().After(r.deadline) {
+		r.timedOut = true
+		return 0, 0, errEOF
+	}
+	c := r.data[r.pos]
+	r.pos++
+	return rune(c), 1, nil
+}
+
+var errEOF = errors.New("EOF")
+
+// yaraScan holds the data and the string matches of a scanned file.
+type yaraScan struct {
+	data     []byte
+	lower    []byte
+	deadline time.Time
+	matches  map[*yaraString][]yaraMatch
+	results  map[string]bool
+	vars     map[string]int64
+	current  *yaraString
+	steps    int
+}
+
+func (s *yaraScan) timedOut() bool {
+	s.steps++
+	return s.steps%1024 == 0 && !s.deadline.IsZero() && time.Now().After(s.deadline)
+}
+
+// find returns the matches of a string in the data.
+func (s *yaraScan) find(str *yaraString) ([]yaraMatch, error) { // nolint: gocyclo
+	if matches, ok := s.matches[str]; ok {
+		return matches, nil
+	}
+	var matches []yaraMatch
+	add := func(offset, length int) bool {
+		if str.fullword && !fullword(s.data, offset, length, str.wide) {
+			return true
+		}
+		matches = append(matches, yaraMatch{offset: offset, length: length})
+		return len(matches) < maxYaraMatches
+	}
+
+	if str.re == nil {
+		data := s.data
+		if str.nocase {
+			if s.lower == nil {
+				s.lower = bytes.ToLower(s.data)
+			}
+			data = s.lower
+		}
+	patterns:
+		for _, pattern := range str.patterns {
+			if len(pattern) == 0 {
+				continue
+			}
+			for from := 0; from < len(data); {
+				if s.timedOut() {
+					return nil, errYaraTimeout
+				}
+				i := bytes.Index(data[from:], pattern)
+				if i < 0 {
+					break
+				}
+				if !add(from+i, len(pattern)) {
+					break patterns
+				}
+				from += i + 1
+			}
+		}
+		sort.Slice(matches, func(i, j int) bool { return matches[i].offset < matches[j].offset })
+		matches = uniqueYaraMatches(matches)
+	} else {
+		for from := 0; from < len(s.data); {
+			if s.timedOut() {
+				return nil, errYaraTimeout
+			}
+			var loc []int
+			if len(str.prefix) > 0 {
+				i := bytes.Index(s.data[from:], str.prefix)
+				if i < 0 {
+					break
+				}
+				from += i
+				reader := &byteRuneReader{data: s.data[from:], deadline: s.deadline}
+				if loc = str.anchored.FindReaderIndex(reader); reader.timedOut {
+					return nil, errYaraTimeout
+				}
+			} else {
+				reader := &byteRuneReader{data: s.data[from:], deadline: s.deadline}
+				if loc = str.re.FindReaderIndex(reader); reader.timedOut {
+					return nil, errYaraTimeout
+				}
+				if loc == nil {
+					break
+				}
+				from += loc[0]
+				loc = []int{0, loc[1] - loc[0]}
+			}
+			if loc != nil && !add(from, loc[1]) {
+				break
+			}
+			from++
+		}
+	}
+	s.matches[str] = matches
+	return matches, nil
+}
+
+func uniqueYaraMatches(matches []yaraMatch) []yaraMatch {
+	var unique []yaraMatch
+	for i, match := range matches {
+		if i == 0 || match.offset != matches[i-1].offset {
+			unique = append(unique, match)
+		}
+	}
+	return unique
+}
+
+// fullword tests that a match is not surrounded by alphanumeric characters.
+func fullword(data []byte, offset, length int, wide bool) bool {
+	alnum := func(i int) bool {
+		if i < 0 || i >= len(data) {
+			return false
+		}
+		c := data[i]
+		return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+	}
+	if wide {
+		return !alnum(offset-2) && !alnum(offset+length)
+	}
+	return !alnum(offset-1) && !alnum(offset+length)
+}
+
+// scan evaluates the rules on the data and returns the matching rules that
+// are not private.
+func (rules *yaraRules) scan(data []byte, timeout time.Duration) ([]*yaraRule, *yaraScan, error) {
+	s := &yaraScan{data: data, matches: map[*yaraString][]yaraMatch{}, results: map[string]bool{}, vars: map[string]int64{}}
+	if timeout > 0 {
+		s.deadline = time.Now().Add(timeout)
+	}
+
+	// a global rule that does not match suppresses all rules of its file
+	failed := map[string]bool{}
+	var matched []*yaraRule
+	for _, rule := range rules.rules {
+		value, ok, err := rule.condition.eval(s, rule)
+		if err != nil {
+			return nil, nil, err
+		}
+		result := ok && value != 0
+		s.results[rule.Name] = result
+		if rule.global && !result {
+			failed[rule.Namespace] = true
+		}
+		if result && !rule.private {
+			matched = append(matched, rule)
+		}
+	}
+	var result []*yaraRule
+	for _, rule := range matched {
+		if !failed[rule.Namespace] {
+			result = append(result, rule)
+		}
+	}
+	return result, s, nil
+}
+
+// yaraExpr is a part of a condition. The value is only defined if ok is true,
+// e.g. reading after the end of the file is undefined.
+type yaraExpr interface {
+	eval(s *yaraScan, rule *yaraRule) (value int64, ok bool, err error)
+}
+
+func boolValue(b bool) int64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+type yaraConst int64
+
+func (c yaraConst) eval(*yaraScan, *yaraRule) (int64, bool, error) {
+	return int64(c), true, nil
+}
+
+type yaraFilesize struct{}
+
+func (yaraFilesize) eval(s *yaraScan, _ *yaraRule) (int64, bool, error) {
+	return int64(len(s.data)), true, nil
+}
+
+type yaraRuleRef string
+
+func (r yaraRuleRef) eval(s *yaraScan, _ *yaraRule) (int64, bool, error) {
+	return boolValue(s.results[string(r)]), true, nil
+}
+
+type yaraVarRef string
+
+func (v yaraVarRef) eval(s *yaraScan, _ *yaraRule) (int64, bool, error) {
+	value, ok := s.vars[string(v)]
+	return value, ok, nil
+}
+
+type yaraBinary struct {
+	op          string
+	left, right yaraExpr
+}
+
+func (b *yaraBinary) eval(s *yaraScan, rule *yaraRule) (int64, bool, error) { // nolint: gocyclo
+	left, lok, err := b.left.eval(s, rule)
+	if err != nil {
+		return 0, false, err
+	}
+	switch b.op {
+	case "and":
+		if !lok || left == 0 {
+			return 0, true, nil
+		}
+		right, rok, err := b.right.eval(s, rule)
+		return boolValue(rok && right != 0), true, err
+	case "or":
+		if lok && left != 0 {
+			return 1, true, nil
+		}
+		right, rok, err := b.right.eval(s, rule)
+		return boolValue(rok && right != 0), true, err
+	}
+
+	right, rok, err := b.right.eval(s, rule)
+	if err != nil || !lok || !rok {
+		return 0, false, err
+	}
+	switch b.op {
+	case "==":
+		return boolValue(left == right), true, nil
+	case "!=":
+		return boolValue(left != right), true, nil
+	case "<":
+		return boolValue(left < right), true, nil
+	case "<=":
+		return boolValue(left <= right), true, nil
+	case ">":
+		return boolValue(left > right), true, nil
+	case ">=":
+		return boolValue(left >= right), true, nil
+	case "+":
+		return left + right, true, nil
+	case "-":
+		return left - right, true, nil
+	case "*":
+		return left * right, true, nil
+	case "\\":
+		if right == 0 {
+			return 0, false, nil
+		}
+		return left / right, true, nil
+	case "%":
+		if right == 0 {
+			return 0, false, nil
+		}
+		return left % right, true, nil
+	case "&":
+		return left & right, true, nil
+	case "|":
+		return left | right, true, nil
+	case "^":
+		return left ^ right, true, nil
+	case "<<":
+		return left << uint64(right), true, nil
+	case ">>":
+		return left >> uint64(right), true, nil
+	}
+	return 0, false, fmt.Errorf("unknown operator %s", b.op)
+}
+
+type yaraUnary struct {
+	op   string
+	expr yaraExpr
+}
+
+func (u *yaraUnary) eval(s *yaraScan, rule *yaraRule) (int64, bool, error) {
+	value, ok, err := u.expr.eval(s, rule)
+	if err != nil {
+		return 0, false, err
+	}
+	switch u.op {
+	case "not":
+		return boolValue(!ok || value == 0), ok, nil
+	case "-":
+		return -value, ok, nil
+	default:
+		return ^value, ok, nil
+	}
+}
+
+// yaraRead reads an integer from the data, e.g. uint16(0).
+type yaraRead struct {
+	size      int
+	signed    bool
+	bigEndian bool
+	offset    yaraExpr
+}
+
+func (r *yaraRead) eval(s *yaraScan, rule *yaraRule) (int64, bool, error) {
+	offset, ok, err := r.offset.eval(s, rule)
+	if err != nil || !ok || offset < 0 || offset+int64(r.size) > int64(len(s.data)) {
+		return 0, false, err
+	}
+	b := s.data[offset : offset+int64(r.size)]
+	var order binary.ByteOrder = binary.LittleEndian
+	if r.bigEndian {
+		order = binary.BigEndian
+	}
+	switch r.size {
+	case 1:
+		if r.signed {
+			return int64(int8(b[0])), true, nil
+		}
+		return int64(b[0]), true, nil
+	case 2:
+		if r.signed {
+			return int64(int16(order.Uint16(b))), true, nil
+		}
+		return int64(order.Uint16(b)), true, nil
+	default:
+		if r.signed {
+			return int64(int32(order.Uint32(b))), true, nil
+		}
+		return int64(order.Uint32(b)), true, nil
+	}
+}
+
+// yaraStringExpr is a reference to a string: $a, #a, @a[i] or !a[i] and
+// $a at offset or $a in (start..end). A nil string is the current string of
+// a for loop.
+type yaraStringExpr struct {
+	kind       yaraTokenKind
+	str        *yaraString
+	index      yaraExpr
+	at         yaraExpr
+	start, end yaraExpr
+}
+
+func (e *yaraStringExpr) eval(s *yaraScan, rule *yaraRule) (int64, bool, error) { // nolint: gocyclo
+	str := e.str
+	if str == nil {
+		str = s.current
+	}
+	matches, err := s.find(str)
+	if err != nil {
+		return 0, false, err
+	}
+
+	switch e.kind {
+	case yaraCount:
+		if e.start != nil {
+			start, end, ok, err := evalRange(s, rule, e.start, e.end)
+			if err != nil || !ok {
+				return 0, false, err
+			}
+			count := int64(0)
+			for _, match := range matches {
+				if int64(match.offset) >= start && int64(match.offset) <= end {
+					count++
+				}
+			}
+			return count, true, nil
+		}
+		return int64(len(matches)), true, nil
+	case yaraOffset, yaraLength:
+		index := int64(1)
+		if e.index != nil {
+			var ok bool
+			if index, ok, err = e.index.eval(s, rule); err != nil || !ok {
+				return 0, false, err
+			}
+		}
+		if index < 1 || index > int64(len(matches)) {
+			return 0, false, nil
+		}
+		if e.kind == yaraOffset {
+			return int64(matches[index-1].offset), true, nil
+		}
+		return int64(matches[index-1].length), true, nil
+	}
+
+	switch {
+	case e.at != nil:
+		at, ok, err := e.at.eval(s, rule)
+		if err != nil || !ok {
+			return 0, ok, err
+		}
+		for _, match := range matches {
+			if int64(match.offset) == at {
+				return 1, true, nil
+			}
+		}
+		return 0, true, nil
+	case e.start != nil:
+		start, end, ok, err := evalRange(s, rule, e.start, e.end)
+		if err != nil || !ok {
+			return 0, ok, err
+		}
+		for _, match := range matches {
+			if int64(match.offset) >= start && int64(match.offset) <= end {
+				return 1, true, nil
+			}
+		}
+		return 0, true, nil
+	}
+	return boolValue(len(matches) > 0), true, nil
+}
+
+func evalRange(s *yaraScan, rule *yaraRule, startExpr, endExpr yaraExpr) (int64, int64, bool, error) {
+	start, sok, err := startExpr.eval(s, rule)
+	if err != nil {
+		return 0, 0, false, err
+	}
+	end, eok, err := endExpr.eval(s, rule)
+	return start, end, sok && eok, err
+}
+
+// yaraQuantifier is the quantifier of of-expressions and for loops: any, all,
+// none, a number or a percentage.
+type yaraQuantifier struct {
+	kind    string
+	count   yaraExpr
+	percent bool
+}
+
+// satisfied tests the number of true items against the quantifier.
+func (q *yaraQuantifier) satisfied(s *yaraScan, rule *yaraRule, matched, total int) (bool, error) {
+	switch q.kind {
+	case "any":
+		return matched > 0, nil
+	case "all":
+		return matched == total, nil
+	case "none":
+		return matched == 0, nil
+	}
+	count, ok, err := q.count.eval(s, rule)
+	if err != nil || !ok {
+		return false, err
+	}
+	if q.percent {
+		return int64(matched)*100 >= count*int64(total), nil
+	}
+	return int64(matched) >= count, nil
+}
+
+// yaraOf is an expression like 2 of ($a*) or for any of them : ( $ at 0 ).
+type yaraOf struct {
+	quantifier *yaraQuantifier
+	strings    []*yaraString
+	condition  yaraExpr
+}
+
+func (o *yaraOf) eval(s *yaraScan, rule *yaraRule) (int64, bool, error) {
+	matched := 0
+	previous := s.current
+	defer func() { s.current = previous }()
+	for _, str := range o.strings {
+		s.current = str
+		condition := o.condition
+		if condition == nil {
+			condition = &yaraStringExpr{kind: yaraVar}
+		}
+		value, ok, err := condition.eval(s, rule)
+		if err != nil {
+			return 0, false, err
+		}
+		if ok && value != 0 {
+			matched++
+		}
+	}
+	satisfied, err := o.quantifier.satisfied(s, rule, matched, len(o.strings))
+	return boolValue(satisfied), true, err
+}
+
+// yaraFor is a loop over integers like for any i in (1..#a) : ( @a[i] < 10 ).
+type yaraFor struct {
+	quantifier *yaraQuantifier
+	variable   string
+	start, end yaraExpr
+	values     []yaraExpr
+	condition  yaraExpr
+}
+
+func (f *yaraFor) eval(s *yaraScan, rule *yaraRule) (int64, bool, error) { // nolint: gocyclo
+	var values []int64
+	start, end := int64(0), int64(-1)
+	if f.start != nil {
+		var ok bool
+		var err error
+		if start, end, ok, err = evalRange(s, rule, f.start, f.end); err != nil || !ok {
+			return 0, false, err
+		}
+	}
+	for _, expr := range f.values {
+		value, ok, err := expr.eval(s, rule)
+		if err != nil {
+			return 0, false, err
+		}
+		if ok {
+			values = append(values, value)
+		}
+	}
+
+	previous, hadPrevious := s.vars[f.variable]
+	defer func() {
+		if hadPrevious {
+			s.vars[f.variable] = previous
+		} else {
+			delete(s.vars, f.variable)
+		}
+	}()
+	matched, total := 0, 0
+	test := func(value int64) error {
+		if s.timedOut() {
+			return errYaraTimeout
+		}
+		total++
+		s.vars[f.variable] = value
+		result, ok, err := f.condition.eval(s, rule)
+		if ok && result != 0 {
+			matched++
+		}
+		return err
+	}
+	for i := start; i <= end; i++ {
+		if err := test(i); err != nil {
+			return 0, false, err
+		}
+	}
+	for _, value := range values {
+		if err := test(value); err != nil {
+			return 0, false, err
+		}
+	}
+	satisfied, err := f.quantifier.satisfied(s, rule, matched, total)
+	return boolValue(satisfied), true, err
+}
+
+func (p *yaraParser) or() (yaraExpr, error) {
+	left, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+	for p.is("or") {
+		p.next()
+		right, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		left = &yaraBinary{op: "or", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *yaraParser) and() (yaraExpr, error) {
+	left, err := p.not()
+	if err != nil {
+		return nil, err
+	}
+	for p.is("and") {
+		p.next()
+		right, err := p.not()
+		if err != nil {
+			return nil, err
+		}
+		left = &yaraBinary{op: "and", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *yaraParser) not() (yaraExpr, error) {
+	if p.is("not") {
+		p.next()
+		expr, err := p.not()
+		if err != nil {
+			return nil, err
+		}
+		return &yaraUnary{op: "not", expr: expr}, nil
+	}
+	return p.comparison()
+}
+
+var yaraStringOperators = map[string]bool{
+	"contains": true, "icontains": true, "startswith": true, "istartswith": true,
+	"endswith": true, "iendswith": true, "iequals": true, "matches": true,
+}
+
+func (p *yaraParser) comparison() (yaraExpr, error) {
+	left, err := p.binary(0)
+	if err != nil {
+		return nil, err
+	}
+	for _, op := range []string{"==", "!=", "<=", ">=", "<", ">"} {
+		if p.peek().kind == yaraPunct && p.is(op) {
+			p.next()
+			right, err := p.binary(0)
+			if err != nil {
+				return nil, err
+			}
+			return &yaraBinary{op: op, left: left, right: right}, nil
+		}
+	}
+	if p.peek().kind == yaraIdent && yaraStringOperators[p.peek().text] {
+		op := p.next().text
+		p.unsupported("string operator %s is not supported", op)
+		if _, err := p.binary(0); err != nil {
+			return nil, err
+		}
+		return yaraConst(0), nil
+	}
+	return left, nil
+}
+
+// yaraPrecedence lists the binary operators from the lowest precedence.
+var yaraPrecedence = [][]string{
+	{"|"}, {"^"}, {"&"}, {"<<", ">>"}, {"+", "-"}, {"*", "\\", "%"},
+}
+
+func (p *yaraParser) binary(level int) (yaraExpr, error) {
+	if level == len(yaraPrecedence) {
+		return p.unary()
+	}
+	left, err := p.binary(level + 1)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op := ""
+		for _, candidate := range yaraPrecedence[level] {
+			if p.peek().kind == yaraPunct && p.is(candidate) {
+				op = candidate
+			}
+		}
+		if op == "" {
+			return left, nil
+		}
+		p.next()
+		right, err := p.binary(level + 1)
+		if err != nil {
+			return nil, err
+		}
+		left = &yaraBinary{op: op, left: left, right: right}
+	}
+}
+
+func (p *yaraParser) unary() (yaraExpr, error) {
+	if p.peek().kind == yaraPunct && (p.is("-") || p.is("~")) {
+		op := p.next().text
+		expr, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return &yaraUnary{op: op, expr: expr}, nil
+	}
+	return p.primary()
+}
+
+var yaraReadFunction = regexp.MustCompile(`^(u?)int(8|16|32)(be)?$`)
+
+func (p *yaraParser) primary() (yaraExpr, error) { // nolint: gocyclo
+	t := p.peek()
+	switch t.kind {
+	case yaraNumber:
+		if p.peekN(1).text == "of" || (p.peekN(1).text == "%" && p.peekN(2).text == "of") {
+			return p.of()
+		}
+		p.next()
+		return yaraConst(t.value), nil
+	case yaraText:
+		p.next()
+		p.unsupported("string values are not supported")
+		return yaraConst(0), nil
+	case yaraRegex:
+		p.next()
+		p.unsupported("regular expression values are not supported")
+		return yaraConst(0), nil
+	case yaraVar, yaraCount, yaraOffset, yaraLength:
+		return p.stringExpr()
+	case yaraPunct:
+		if t.text == "(" {
+			p.next()
+			expr, err := p.or()
+			if err != nil {
+				return nil, err
+			}
+			return expr, p.expect(")")
+		}
+	case yaraIdent:
+		switch t.text {
+		case "true", "false":
+			p.next()
+			return yaraConst(boolValue(t.text == "true")), nil
+		case "filesize":
+			p.next()
+			return yaraFilesize{}, nil
+		case "any", "all", "none":
+			return p.of()
+		case "for":
+			return p.forExpr()
+		case "them":
+			return nil, p.errorf("unexpected them")
+		}
+		if match := yaraReadFunction.FindStringSubmatch(t.text); match != nil && p.peekN(1).text == "(" {
+			p.next()
+			p.next()
+			offset, err := p.or()
+			if err != nil {
+				return nil, err
+			}
+			size, _ := strconv.Atoi(match[2])
+			return &yaraRead{size: size / 8, signed: match[1] == "", bigEndian: match[3] != "", offset: offset}, p.expect(")")
+		}
+		p.next()
+		if p.loopVars[t.text] {
+			return yaraVarRef(t.text), nil
+		}
+		if p.is(".") || p.is("(") || p.is("[") {
+			p.unsupported("module %s is not supported", t.text)
+			return yaraConst(0), p.skipAccessors()
+		}
+		if t.text == "entrypoint" {
+			p.unsupported("entrypoint is not supported")
+			return yaraConst(0), nil
+		}
+		for _, rule := range p.rules.rules {
+			if rule.Name == t.text {
+				return yaraRuleRef(t.text), nil
+			}
+		}
+		if p.ruleErr == nil {
+			// rules that use skipped rules are skipped as well
+			for _, ruleErr := range p.ruleErrors {
+				if ruleErr.rule == t.text {
+					p.unsupported("rule %s is not supported", t.text)
+					return yaraConst(0), nil
+				}
+			}
+		}
+		return nil, fmt.Errorf("line %d: undefined identifier %s", t.line, t.text)
+	}
+	return nil, p.errorf("unexpected %s", t)
+}
+
+// skipAccessors skips the fields, indices and calls of module values.
+func (p *yaraParser) skipAccessors() error {
+	for {
+		switch {
+		case p.is("."):
+			p.next()
+			p.next()
+		case p.is("(") || p.is("["):
+			closing := map[string]string{"(": ")", "[": "]"}[p.next().text]
+			for !p.is(closing) {
+				if p.peek().kind == yaraEOF {
+					return p.errorf("expected %s", closing)
+				}
+				if p.is("(") || p.is("[") {
+					if err := p.skipAccessors(); err != nil {
+						return err
+					}
+					continue
+				}
+				p.next()
+			}
+			p.next()
+		default:
+			return nil
+		}
+	}
+}
+
+func (p *yaraParser) stringExpr() (yaraExpr, error) { // nolint: gocyclo
+	t := p.next()
+	e := &yaraStringExpr{kind: t.kind}
+	if t.text == "" {
+		if !p.inLoop {
+			return nil, fmt.Errorf("line %d: anonymous string outside of a for loop", t.line)
+		}
+	} else {
+		for _, str := range p.rule.strings {
+			if str.id == t.text {
+				e.str = str
+			}
+		}
+		if e.str == nil {
+			return nil, fmt.Errorf("line %d: undefined string %s", t.line, t.text)
+		}
+	}
+
+	switch t.kind {
+	case yaraOffset, yaraLength:
+		if p.is("[") {
+			p.next()
+			index, err := p.or()
+			if err != nil {
+				return nil, err
+			}
+			e.index = index
+			return e, p.expect("]")
+		}
+	case yaraCount:
+		if p.is("in") {
+			p.next()
+			start, end, err := p.rangeExpr()
+			if err != nil {
+				return nil, err
+			}
+			e.start, e.end = start, end
+		}
+	case yaraVar:
+		switch {
+		case p.is("at"):
+			p.next()
+			at, err := p.binary(0)
+			if err != nil {
+				return nil, err
+			}
+			e.at = at
+		case p.is("in"):
+			p.next()
+			start, end, err := p.rangeExpr()
+			if err != nil {
+				return nil, err
+			}
+			e.start, e.end = start, end
+		}
+	}
+	return e, nil
+}
+
+func (p *yaraParser) rangeExpr() (yaraExpr, yaraExpr, error) {
+	if err := p.expect("("); err != nil {
+		return nil, nil, err
+	}
+	start, err := p.binary(0)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := p.expect(".."); err != nil {
+		return nil, nil, err
+	}
+	end, err := p.binary(0)
+	if err != nil {
+		return nil, nil, err
+	}
+	return start, end, p.expect(")")
+}
+
+func (p *yaraParser) quantifier() (*yaraQuantifier, error) {
+	t := p.next()
+	switch {
+	case t.kind == yaraIdent && (t.text == "any" || t.text == "all" || t.text == "none"):
+		return &yaraQuantifier{kind: t.text}, nil
+	case t.kind == yaraNumber:
+		q := &yaraQuantifier{count: yaraConst(t.value)}
+		if p.is("%") {
+			p.next()
+			q.percent = true
+		}
+		return q, nil
+	}
+	return nil, fmt.Errorf("line %d: invalid quantifier %s", t.line, t)
+}
+
+// stringSet parses them or a list of strings like ($a, $b*).
+func (p *yaraParser) stringSet() ([]*yaraString, error) {
+	if p.is("them") {
+		p.next()
+		return p.rule.strings, nil
+	}
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	var set []*yaraString
+	for {
+		t := p.next()
+		if t.kind != yaraVar {
+			return nil, fmt.Errorf("line %d: expected string, got %s", t.line, t)
+		}
+		found := false
+		for _, str := range p.rule.strings {
+			if str.id == t.text || (strings.HasSuffix(t.text, "*") && strings.HasPrefix(str.id, strings.TrimSuffix(t.text, "*"))) {
+				set = append(set, str)
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("line %d: undefined string %s", t.line, t.text)
+		}
+		if !p.is(",") {
+			break
+		}
+		p.next()
+	}
+	return set, p.expect(")")
+}
+
+func (p *yaraParser) of() (yaraExpr, error) {
+	quantifier, err := p.quantifier()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect("of"); err != nil {
+		return nil, err
+	}
+	set, err := p.stringSet()
+	if err != nil {
+		return nil, err
+	}
+	o := &yaraOf{quantifier: quantifier, strings: set}
+	switch {
+	case p.is("in"):
+		p.next()
+		start, end, err := p.rangeExpr()
+		if err != nil {
+			return nil, err
+		}
+		o.condition = &yaraStringExpr{kind: yaraVar, start: start, end: end}
+	case p.is("at"):
+		p.next()
+		at, err := p.binary(0)
+		if err != nil {
+			return nil, err
+		}
+		o.condition = &yaraStringExpr{kind: yaraVar, at: at}
+	}
+	return o, nil
+}
+
+func (p *yaraParser) forExpr() (yaraExpr, error) { // nolint: gocyclo
+	p.next()
+	quantifier, err := p.quantifier()
+	if err != nil {
+		return nil, err
+	}
+
+	if p.is("of") {
+		p.next()
+		set, err := p.stringSet()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(":"); err != nil {
+			return nil, err
+		}
+		inLoop := p.inLoop
+		p.inLoop = true
+		condition, err := p.parenthesized()
+		p.inLoop = inLoop
+		if err != nil {
+			return nil, err
+		}
+		return &yaraOf{quantifier: quantifier, strings: set, condition: condition}, nil
+	}
+
+	variable := p.next()
+	if variable.kind != yaraIdent {
+		return nil, fmt.Errorf("line %d: expected identifier, got %s", variable.line, variable)
+	}
+	if p.is(",") {
+		p.unsupported("iterating over module values is not supported")
+	}
+	if err := p.expect("in"); err != nil {
+		return nil, err
+	}
+	f := &yaraFor{quantifier: quantifier, variable: variable.text}
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	first, err := p.binary(0)
+	if err != nil {
+		return nil, err
+	}
+	if p.is("..") {
+		p.next()
+		end, err := p.binary(0)
+		if err != nil {
+			return nil, err
+		}
+		f.start, f.end = first, end
+	} else {
+		f.values = append(f.values, first)
+		for p.is(",") {
+			p.next()
+			value, err := p.binary(0)
+			if err != nil {
+				return nil, err
+			}
+			f.values = append(f.values, value)
+		}
+	}
+	if err := p.expect(")"); err != nil {
+		return nil, err
+	}
+	if err := p.expect(":"); err != nil {
+		return nil, err
+	}
+
+	if p.loopVars == nil {
+		p.loopVars = map[string]bool{}
+	}
+	defined := p.loopVars[variable.text]
+	p.loopVars[variable.text] = true
+	condition, err := p.parenthesized()
+	p.loopVars[variable.text] = defined
+	if err != nil {
+		return nil, err
+	}
+	f.condition = condition
+	return f, nil
+}
+
+func (p *yaraParser) parenthesized() (yaraExpr, error) {
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	expr, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	return expr, p.expect(")")
+}
@@ -0,0 +1,237 @@
+// Copyright (c) 2020 Siemens AG
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+//
+// Author(s): Jonas Plum
+
+package builtin
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func compileTestYara(t *testing.T, rules string) (*yaraRules, []*yaraRuleError) {
+	path := filepath.Join(t.TempDir(), "test.yar")
+	if err := ioutil.WriteFile(path, []byte(rules), 0600); err != nil {
+		t.Fatal(err)
+	}
+	compiled := &yaraRules{}
+	ruleErrors, err := compileYara(path, compiled)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return compiled, ruleErrors
+}
+
+func TestYaraRules(t *testing.T) {
+	pe := append([]byte("MZ\x90\x00"), make([]byte, 60)...)
+	pe = append(pe, []byte("This program cannot be run in DOS mode. mimikatz s\x00e\x00k\x00u\x00r\x00l\x00s\x00a\x00 Invoke-Mimikatz")...)
+
+	tests := []struct {
+		name      string
+		condition string
+		strings   string
+		want      bool
+	}{
+		{"text", "$a", `$a = "mimikatz"`, true},
+		{"text no match", "$a", `$a = "MIMIKATZ"`, false},
+		{"nocase", "$a", `$a = "MIMIKATZ" nocase`, true},
+		{"wide", "$a", `$a = "sekurlsa" wide`, true},
+		{"wide only", "$a", `$a = "mimikatz" wide`, false},
+		{"fullword", "$a", `$a = "katz" fullword`, false},
+		{"fullword match", "$a", `$a = "mimikatz" fullword`, true},
+		{"xor", "$a", "$a = \"MZ\" xor(1-255)", false},
+		{"escapes", "$a", `$a = "MZ\x90"`, true},
+		{"hex", "$a at 0", `$a = { 4D 5A 90 00 }`, true},
+		{"hex wildcard", "$a at 0", `$a = { 4D ?? 9? 00 }`, true},
+		{"hex jump", "$a", `$a = { 4D 5A [2-4] 00 00 }`, true},
+		{"hex alternative", "$a", `$a = { 4D 5A ( 91 | 90 ) }`, true},
+		{"hex not", "$a", `$a = { 4D 5A ~90 }`, false},
+		{"regex", "$a", `$a = /Invoke-[A-Z][a-z]+/`, true},
+		{"regex nocase", "$a", `$a = /invoke-mimikatz/i`, true},
+		{"regex bytes", "$a at 0", `$a = /MZ\x90\x00/`, true},
+		{"count", "#a == 2", `$a = "imikatz"`, true},
+		{"count range", "#a in (0..120) == 1", `$a = "imikatz"`, true},
+		{"offset", "@a[1] == 0 and !a[1] == 2", `$a = "MZ"`, true},
+		{"undefined offset", "@a[2] == 0", `$a = "MZ"`, false},
+		{"not undefined", "not (@a[2] == 0)", `$a = "MZ"`, false},
+		{"in", "$a in (0..10)", `$a = "mimikatz"`, false},
+		{"filesize", "filesize < 1KB and filesize > 10", `$a = "MZ"`, true},
+		{"uint16", "uint16(0) == 0x5A4D and uint8(2) == 0x90 and uint16be(0) == 0x4D5A", `$a = "MZ"`, true},
+		{"int8", "int8(2) == -112", `$a = "MZ"`, true},
+		{"arithmetic", "(2 + 3) * 4 \\ 2 == 10 and 7 % 4 == 3 and 1 << 4 == 16 and (6 & 3) | 8 == 10 and ~0 == -1", `$a = "MZ"`, true},
+		{"any of them", "any of them", `$a = "nope" $b = "MZ"`, true},
+		{"all of them", "all of them", `$a = "nope" $b = "MZ"`, false},
+		{"none of", "none of ($a*)", `$a1 = "nope" $a2 = "also nope" $b = "MZ"`, true},
+		{"n of", "2 of ($a, $b, $c)", `$a = "nope" $b = "MZ" $c = "DOS"`, true},
+		{"percent of", "50% of them", `$a = "nope" $b = "MZ" $c = "nope"`, false},
+		{"of at", "any of them at 0", `$a = "nope" $b = "MZ"`, true},
+		{"for of", "for all of ($a*) : ( # > 0 and @[1] < 100 )", `$a1 = "MZ" $a2 = "DOS"`, true},
+		{"for in", "for any i in (1..#a) : ( @a[i] > 60 )", `$a = "imikatz"`, true},
+		{"for enumeration", "for all i in (0, 1) : ( uint8(i) > 0x40 )", `$a = "MZ"`, true},
+		{"anonymous", "any of them", `$ = "nope" $ = "MZ"`, true},
+		{"private", "$a and true and not false", `$a = "MZ" private`, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rules, ruleErrors := compileTestYara(t, "rule test { strings: "+tt.strings+" condition: "+tt.condition+" }")
+			if len(ruleErrors) > 0 {
+				t.Fatal(ruleErrors[0])
+			}
+			matched, _, err := rules.scan(pe, time.Minute)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := len(matched) == 1; got != tt.want {
+				t.Errorf("match = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestYaraRuleSet(t *testing.T) {
+	rules, ruleErrors := compileTestYara(t, `
+import "pe"
+
+/* rules can refer to previous rules */
+private rule mz : internal {
+	strings:
+		$mz = { 4D 5A }  // header
+	condition:
+		$mz at 0
+}
+
+rule tool : hacktool windows {
+	meta:
+		author = "test"
+		score = 80
+		active = true
+	strings:
+		$a = "mimikatz" nocase
+		$b = "unused"
+	condition:
+		mz and $a
+}
+
+rule signed {
+	condition:
+		pe.number_of_signatures > 0
+}
+
+rule unsigned {
+	condition:
+		signed or tool
+}
+`)
+	var errs []string
+	for _, ruleErr := range ruleErrors {
+		errs = append(errs, ruleErr.Error())
+	}
+	if want := []string{"rule signed: module pe is not supported", "rule unsigned: rule signed is not supported"}; !reflect.DeepEqual(errs, want) {
+		t.Errorf("errors = %v, want %v", errs, want)
+	}
+
+	matched, scan, err := rules.scan([]byte("MZ Mimikatz MIMIKATZ"), time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(matched) != 1 || matched[0].Name != "tool" {
+		t.Fatalf("matched = %v", matched)
+	}
+	tool := matched[0]
+	if !reflect.DeepEqual(tool.Tags, []string{"hacktool", "windows"}) {
+		t.Errorf("tags = %v", tool.Tags)
+	}
+	if want := map[string]interface{}{"author": "test", "score": int64(80), "active": true}; !reflect.DeepEqual(tool.Meta, want) {
+		t.Errorf("meta = %v, want %v", tool.Meta, want)
+	}
+	matches, err := scan.find(tool.strings[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []yaraMatch{{3, 8}, {12, 8}}; !reflect.DeepEqual(matches, want) {
+		t.Errorf("matches = %v, want %v", matches, want)
+	}
+}
+
+func TestYaraGlobal(t *testing.T) {
+	rules, _ := compileTestYara(t, `
+global rule small { condition: filesize < 10 }
+rule any { condition: true }
+`)
+	for data, want := range map[string]int{"small": 2, "larger than ten bytes": 0} {
+		matched, _, err := rules.scan([]byte(data), time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(matched) != want {
+			t.Errorf("scan(%q) = %d matches, want %d", data, len(matched), want)
+		}
+	}
+}
+
+func TestYaraSyntaxErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		rules string
+		err   string
+	}{
+		{"undefined string", `rule a { condition: $a }`, "undefined string a"},
+		{"undefined rule", `rule a { condition: b }`, "undefined identifier b"},
+		{"missing condition", `rule a { strings: $a = "a" }`, `expected "condition"`},
+		{"invalid hex", `rule a { strings: $a = { 4D 5 } condition: $a }`, "invalid hex"},
+		{"duplicate string", `rule a { strings: $a = "a" $a = "b" condition: $a }`, "duplicate string"},
+		{"unterminated string", "rule a { strings: $a = \"a\n condition: $a }", "unterminated string"},
+		{"unknown modifier", `rule a { strings: $a = "a" foo condition: $a }`, "unknown modifier foo"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "test.yar")
+			if err := ioutil.WriteFile(path, []byte(tt.rules), 0600); err != nil {
+				t.Fatal(err)
+			}
+			_, err := compileYara(path, &yaraRules{})
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("compileYara() error = %v, want %s", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestYaraTimeout(t *testing.T) {
+	rules, _ := compileTestYara(t, `rule a { strings: $a = "a" condition: for all i in (0..filesize) : ( uint8(i) >= 0 ) }`)
+	_, _, err := rules.scan(make([]byte, 1000000), time.Nanosecond)
+	if err != errYaraTimeout {
+		t.Errorf("scan() error = %v, want timeout", err)
+	}
+}
+
+func TestYaraTimeout_Regexp(t *testing.T) {
+	// a single regular expression reads the whole data
+	rules, _ := compileTestYara(t, `rule a { strings: $a = /a[^b]*b/ condition: $a }`)
+	_, _, err := rules.scan(bytes.Repeat([]byte("a"), 1<<24), time.Nanosecond)
+	if err != errYaraTimeout {
+		t.Errorf("scan() error = %v, want timeout", err)
+	}
+}
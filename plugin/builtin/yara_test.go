@@ -21,85 +21,134 @@
 
 package builtin
 
-/*
 import (
-	"log"
+	"io/ioutil"
+	"os"
 	"path/filepath"
 	"testing"
 
+	"github.com/tidwall/gjson"
+
 	"github.com/forensicanalysis/forensicstore"
 )
 
 func TestYara(t *testing.T) {
-
-	log.SetFlags(log.LstdFlags | log.Lshortfile)
-
-	log.Println("Start setup")
-	storeDir, err := setup()
+	dir := t.TempDir()
+	storePath := filepath.Join(dir, "test.forensicstore")
+	store, teardown, err := forensicstore.New(storePath)
 	if err != nil {
 		t.Fatal(err)
 	}
-	log.Println("Setup done")
-	defer cleanup(storeDir)
+	ids := map[string]string{}
+	for name, content := range map[string]string{
+		"tool.exe":   "MZ\x90\x00 my abc text here, abc",
+		"readme.txt": "no abc",
+		"empty.txt":  "",
+	} {
+		exportPath, f, fileTeardown, err := store.StoreFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _ = f.Write([]byte(content))
+		_ = fileTeardown()
 
-	newStorePath := filepath.Join(storeDir, "example.forensicstore")
-	yaraRuleFile := filepath.Join(storeDir, "test.yar")
-
-	store, teardown, err := forensicstore.New(newStorePath)
-	if err != nil {
-		t.Fatal(err)
+		file := forensicstore.NewFile()
+		file.Name = name
+		file.ExportPath = exportPath
+		if ids[name], err = store.InsertStruct(file); err != nil {
+			t.Fatal(err)
+		}
 	}
+	_ = teardown()
 
-	_, f, err := store.StoreFile("test")
-	if err != nil {
+	rulesDir := filepath.Join(dir, "rules")
+	if err := os.Mkdir(rulesDir, 0700); err != nil {
 		t.Fatal(err)
 	}
-	f.Write([]byte("my abc text here"))
-	f.Close()
-	err = teardown()
-	if err != nil {
-		t.Fatal(err)
+	for name, rule := range map[string]string{
+		"text.yar":  `rule text : test { meta: description = "abc" strings: $abc = "abc" fullword condition: $abc }`,
+		"mz.yara":   `rule mz { strings: $mz = { 4D 5A 90 } condition: $mz at 0 }`,
+		"error.yar": `rule broken { condition: $a }`,
+		"readme.md": `not a rule`,
+	} {
+		if err := ioutil.WriteFile(filepath.Join(rulesDir, name), []byte(rule), 0600); err != nil {
+			t.Fatal(err)
+		}
 	}
 
-	type args struct {
-		url  string
-		args []string
-	}
 	tests := []struct {
-		name      string
-		args      args
-		wantCount int
-		wantErr   bool
+		name            string
+		filter          []string
+		maxSize         string
+		maxMemory       string
+		wantCount       int
+		wantDiagnostics int
+		wantErr         bool
 	}{
-		{"rule file", args{newStorePath, []string{"--rules", yaraRuleFile},}, 1, false},
+		{"all files", nil, "256MB", "1GB", 3, 1, false},
+		{"filter", []string{"name=%.exe"}, "256MB", "1GB", 2, 1, false},
+		{"no files", []string{"name=none"}, "256MB", "1GB", 0, 1, false},
+		{"max size", nil, "10", "1GB", 1, 2, false},
+		{"max memory", nil, "256MB", "1", 3, 1, false},
+		{"invalid max size", nil, "10XB", "1GB", 0, 0, true},
+		{"invalid max memory", nil, "256MB", "0", 0, 0, true},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			command := Yara()
-
-			command.Flags().Set("format", "none")
-			command.Flags().Set("add-to-store", "true")
-			command.SetArgs(append(tt.args.args, tt.args.url))
-			err = command.Execute()
-
+			tlw := &testLineWriter{}
+			command := &Yara{}
+			command.Parameter().Set("forensicstore", storePath)
+			command.Parameter().Set("rules", []string{rulesDir})
+			command.Parameter().Set("filter", tt.filter)
+			command.Parameter().Set("max-size", tt.maxSize)
+			command.Parameter().Set("max-memory", tt.maxMemory)
+			err := command.Run(command, tlw)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("Run() error = %v, wantErr %v", err, tt.wantErr)
 			}
-
-			store, teardown, err := forensicstore.Open(tt.args.url)
-			if err != nil {
-				t.Fatal(err)
+			if len(tlw.lines) != tt.wantCount {
+				t.Errorf("len(elements) = %v, wantCount %v", len(tlw.lines), tt.wantCount)
 			}
-			defer teardown()
-			elements, err := store.All()
-			if err != nil {
-				t.Fatal(err)
+			if len(tlw.diagnostics) != tt.wantDiagnostics {
+				t.Errorf("diagnostics = %v, want %d", tlw.diagnostics, tt.wantDiagnostics)
 			}
-
-			if len(elements) != tt.wantCount {
-				t.Errorf("Run() error, wrong number of resuls = %d, want %d", len(elements), tt.wantCount)
+			for _, line := range tlw.lines {
+				name := "tool.exe"
+				if gjson.GetBytes(line, "rule").String() == "text" && gjson.GetBytes(line, "strings.#").Int() == 1 {
+					name = "readme.txt"
+				}
+				if ref := gjson.GetBytes(line, "file_ref").String(); ref != ids[name] {
+					t.Errorf("file_ref = %s, want %s: %s", ref, ids[name], line)
+				}
 			}
 		})
 	}
 }
-*/
+
+func TestParseByteSize(t *testing.T) {
+	for s, want := range map[string]int64{
+		"100":   100,
+		"16KB":  16 * 1024,
+		"256MB": 256 * 1024 * 1024,
+		"2GB":   2 * 1024 * 1024 * 1024,
+	} {
+		if got, err := parseByteSize(s); err != nil || got != want {
+			t.Errorf("parseByteSize(%q) = %d, %v, want %d", s, got, err, want)
+		}
+	}
+	if _, err := parseByteSize("1TB"); err == nil {
+		t.Error("parseByteSize(\"1TB\") error = nil, want error")
+	}
+}
+
+func TestYaraData(t *testing.T) {
+	for b, want := range map[string][2]string{
+		"abc":        {"abc", ""},
+		"MZ\x90":     {"", "4d5a90"},
+		"a\x00b\x00": {"", "61006200"},
+	} {
+		if text, hex := yaraData([]byte(b)); text != want[0] || hex != want[1] {
+			t.Errorf("yaraData(%q) = %q, %q, want %q", b, text, hex, want)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package pluginlib
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"sync"
+)
+
+type Level string
+
+const (
+	Warning Level = "warning"
+	Error   Level = "error"
+)
+
+// A Diagnostic is a warning or error that occurred while processing a single
+// item, e.g. a corrupt file, without failing the whole run.
+type Diagnostic struct {
+	Type    string `json:"type"`
+	Level   Level  `json:"level"`
+	Plugin  string `json:"plugin,omitempty"`
+	Source  string `json:"source,omitempty"`
+	Message string `json:"message"`
+}
+
+func (d Diagnostic) String() string {
+	if d.Source != "" {
+		return fmt.Sprintf("%s: %s (%s)", d.Level, d.Message, d.Source)
+	}
+	return fmt.Sprintf("%s: %s", d.Level, d.Message)
+}
+
+// DiagnosticWriter can be implemented by LineWriters that receive diagnostics
+// next to the elements.
+type DiagnosticWriter interface {
+	WriteDiagnostic(Diagnostic)
+}
+
+// Warn reports a warning for the source element with the given id.
+func Warn(w LineWriter, source string, err error) {
+	Report(w, Diagnostic{Level: Warning, Source: source, Message: err.Error()})
+}
+
+// Report passes the diagnostic to w if it is a DiagnosticWriter and logs it
+// otherwise.
+func Report(w LineWriter, d Diagnostic) {
+	d.Type = "diagnostic"
+	if dw, ok := w.(DiagnosticWriter); ok {
+		dw.WriteDiagnostic(d)
+		return
+	}
+	log.Println(d)
+}
+
+// DiagnosticLineWriter wraps a LineWriter and passes diagnostics to a
+// separate DiagnosticWriter.
+type DiagnosticLineWriter struct {
+	LineWriter
+	Diagnostics DiagnosticWriter
+}
+
+func (d *DiagnosticLineWriter) WriteDiagnostic(diagnostic Diagnostic) {
+	d.Diagnostics.WriteDiagnostic(diagnostic)
+}
+
+func (d *DiagnosticLineWriter) Flush() error {
+	return Flush(d.LineWriter)
+}
+
+// WithDiagnostics forwards diagnostics written to the returned LineWriter to
+// diagnostics, if diagnostics is a DiagnosticWriter.
+func WithDiagnostics(w LineWriter, diagnostics LineWriter) LineWriter {
+	if dw, ok := diagnostics.(DiagnosticWriter); ok {
+		return &DiagnosticLineWriter{LineWriter: w, Diagnostics: dw}
+	}
+	return w
+}
+
+// DiagnosticCollector counts and collects the diagnostics of a single run.
+type DiagnosticCollector struct {
+	LineWriter LineWriter
+	Plugin     string
+
+	mux         sync.Mutex
+	diagnostics []Diagnostic
+}
+
+func (c *DiagnosticCollector) WriteLine(b []byte) error {
+	if c.LineWriter != nil {
+		return c.LineWriter.WriteLine(b)
+	}
+	return nil
+}
+
+func (c *DiagnosticCollector) Flush() error {
+	return Flush(c.LineWriter)
+}
+
+func (c *DiagnosticCollector) WriteDiagnostic(d Diagnostic) {
+	if d.Plugin == "" {
+		d.Plugin = c.Plugin
+	}
+
+	c.mux.Lock()
+	c.diagnostics = append(c.diagnostics, d)
+	c.mux.Unlock()
+
+	if dw, ok := c.LineWriter.(DiagnosticWriter); ok {
+		dw.WriteDiagnostic(d)
+	}
+}
+
+func (c *DiagnosticCollector) Diagnostics() []Diagnostic {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	return append([]Diagnostic{}, c.diagnostics...)
+}
+
+// Count returns the number of collected diagnostics of the given level.
+func (c *DiagnosticCollector) Count(level Level) int {
+	count := 0
+	for _, d := range c.Diagnostics() {
+		if d.Level == level {
+			count++
+		}
+	}
+	return count
+}
+
+// WriteSummary prints all collected diagnostics and their counts.
+func (c *DiagnosticCollector) WriteSummary(w io.Writer) {
+	diagnostics := c.Diagnostics()
+	if len(diagnostics) == 0 {
+		return
+	}
+	for _, d := range diagnostics {
+		fmt.Fprintln(w, d) // nolint: errcheck
+	}
+	fmt.Fprintf(w, "%s: %d warnings, %d errors\n", c.Plugin, c.Count(Warning), c.Count(Error)) // nolint: errcheck
+}
@@ -39,8 +39,14 @@ import (
 	"github.com/forensicanalysis/elementary/pluginlib"
 )
 
+var (
+	_ pluginlib.Plugin    = &command{}
+	_ pluginlib.Versioned = &command{}
+)
+
 type command struct {
 	name      string
+	version   string
 	short     string
 	parameter pluginlib.ParameterList
 	run       func(pluginlib.Plugin, io.Writer) error
@@ -49,8 +55,9 @@ type command struct {
 
 func newCommand(name, image string, labels map[string]string) pluginlib.Plugin {
 	dockerCmd := &command{
-		name:  name,
-		short: "(docker: " + image + ")",
+		name:    name,
+		version: imageVersion(image),
+		short:   "(docker: " + image + ")",
 		run: func(cmd pluginlib.Plugin, writer io.Writer) error {
 			mounts := parseMounts(cmd)
 			args := cmd.Parameter().ToCommandlineArgs()
@@ -75,6 +82,10 @@ func (s *command) Name() string {
 	return s.name
 }
 
+func (s *command) Version() string {
+	return s.version
+}
+
 func (s *command) Short() string {
 	return s.short
 }
@@ -89,8 +100,15 @@ func (s *command) Output() *pluginlib.Config {
 
 func (s *command) Run(c pluginlib.Plugin, writer pluginlib.LineWriter) error {
 	lbw := pluginlib.NewLineWriterBuffer(writer)
-	defer lbw.WriteFooter()
-	return s.run(c, lbw)
+	err := s.run(c, lbw)
+	if lbw.Err() != nil {
+		// the command failed because the output could not be written
+		return lbw.Err()
+	}
+	if cerr := lbw.Close(); err == nil {
+		err = cerr
+	}
+	return err
 }
 
 func parseMounts(cmd pluginlib.Plugin) map[string]string {
@@ -144,6 +162,14 @@ func commandName(prefix, image string) (string, error) {
 	return "", errors.New("no plugin")
 }
 
+func imageVersion(image string) string {
+	idx := strings.LastIndex(image, ":")
+	if idx < 0 || strings.Contains(image[idx:], "/") {
+		return ""
+	}
+	return image[idx+1:]
+}
+
 func dockerCreate(image string, args []string, mountDirs map[string]string, w io.Writer) error {
 	ctx := context.Background()
 	cli, err := client.NewEnvClient()
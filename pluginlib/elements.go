@@ -0,0 +1,142 @@
+package pluginlib
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"crawshaw.io/sqlite"
+
+	"github.com/forensicanalysis/forensicstore"
+)
+
+// ElementIterator iterates over the elements of a forensicstore without
+// loading all of them into memory.
+//
+//	it, err := Iterate(store, filter)
+//	if err != nil {
+//		return err
+//	}
+//	defer it.Close()
+//	for it.Next() {
+//		element := it.Element()
+//	}
+//	return it.Err()
+type ElementIterator struct {
+	stmt    *sqlite.Stmt
+	element []byte
+	err     error
+}
+
+// Iterate returns an iterator over all elements matching the filter. The
+// filter values are SQL LIKE patterns, like in forensicstore.Select.
+func Iterate(store *forensicstore.ForensicStore, filter Filter) (*ElementIterator, error) {
+	return iterate(store, filter, "")
+}
+
+// IterateSorted returns an iterator over all elements matching the filter
+// ordered by the value of a json path. The elements are sorted by SQLite,
+// elements with the same value keep the store order.
+func IterateSorted(store *forensicstore.ForensicStore, filter Filter, path string) (*ElementIterator, error) {
+	return iterate(store, filter, path)
+}
+
+func iterate(store *forensicstore.ForensicStore, filter Filter, orderBy string) (*ElementIterator, error) {
+	var ors []string
+	var args []string
+	for _, condition := range filter {
+		keys := make([]string, 0, len(condition))
+		for key := range condition {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		var ands []string
+		for _, key := range keys {
+			ands = append(ands, "json_extract(json, ?) LIKE ?")
+			args = append(args, "$."+key, condition[key])
+		}
+		if len(ands) > 0 {
+			ors = append(ors, "("+strings.Join(ands, " AND ")+")")
+		}
+	}
+
+	query := "SELECT json FROM `elements`"
+	if len(ors) > 0 {
+		query += " WHERE " + strings.Join(ors, " OR ")
+	}
+	if orderBy != "" {
+		query += " ORDER BY json_extract(json, ?), rowid"
+		args = append(args, "$."+orderBy)
+	}
+
+	// a transient statement allows multiple iterators at the same time
+	stmt, _, err := store.Connection().PrepareTransient(query)
+	if err != nil {
+		return nil, fmt.Errorf("could not prepare statement %s: %w", query, err)
+	}
+	for i, arg := range args {
+		stmt.BindText(i+1, arg)
+	}
+	return &ElementIterator{stmt: stmt}, nil
+}
+
+// Next advances to the next element and returns false if there are no more
+// elements or an error occurred.
+func (it *ElementIterator) Next() bool {
+	if it.err != nil || it.stmt == nil {
+		return false
+	}
+	hasRow, err := it.stmt.Step()
+	if err != nil {
+		it.err = err
+		return false
+	}
+	if !hasRow {
+		return false
+	}
+	it.element = []byte(it.stmt.GetText("json"))
+	return true
+}
+
+// Element returns the current element.
+func (it *ElementIterator) Element() []byte {
+	return it.element
+}
+
+// Err returns the first error that occurred during the iteration.
+func (it *ElementIterator) Err() error {
+	return it.err
+}
+
+// Close releases the iterator, it must be called when the iterator is not
+// used anymore.
+func (it *ElementIterator) Close() error {
+	if it.stmt == nil {
+		return nil
+	}
+	err := it.stmt.Finalize()
+	it.stmt = nil
+	return err
+}
+
+// ForEach calls fn for every element matching the filter. The iteration stops
+// at the first error returned by fn.
+func ForEach(store *forensicstore.ForensicStore, filter Filter, fn func(element []byte) error) (err error) {
+	it, err := Iterate(store, filter)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := it.Close(); err == nil {
+			err = cerr
+		}
+	}()
+
+	for it.Next() {
+		if err := fn(it.Element()); err != nil {
+			return err
+		}
+	}
+	return it.Err()
+}
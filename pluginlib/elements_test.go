@@ -0,0 +1,122 @@
+package pluginlib
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/tidwall/gjson"
+
+	"github.com/forensicanalysis/forensicstore"
+)
+
+func TestForEach(t *testing.T) {
+	store, teardown, err := forensicstore.New(filepath.Join(t.TempDir(), "test.forensicstore"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer teardown()
+
+	for _, element := range []string{
+		`{"type": "foo", "name": "a.pf"}`,
+		`{"type": "foo", "name": "b.evtx"}`,
+		`{"type": "bar", "name": "c.pf"}`,
+	} {
+		if _, err := store.Insert([]byte(element)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		filter Filter
+		want   int
+	}{
+		{"nil filter", nil, 3},
+		{"type", Filter{{"type": "foo"}}, 2},
+		{"like", Filter{{"name": "%.pf"}}, 2},
+		{"and", Filter{{"type": "foo", "name": "%.pf"}}, 1},
+		{"or", Filter{{"type": "bar"}, {"name": "%.evtx"}}, 2},
+		{"quote", Filter{{"name": "'"}}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			count := 0
+			err := ForEach(store, tt.filter, func(element []byte) error {
+				if !gjson.GetBytes(element, "id").Exists() {
+					t.Errorf("element without id %s", element)
+				}
+				count++
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if count != tt.want {
+				t.Errorf("ForEach() count = %d, want %d", count, tt.want)
+			}
+		})
+	}
+}
+
+func TestForEach_Stop(t *testing.T) {
+	store, teardown, err := forensicstore.New(filepath.Join(t.TempDir(), "test.forensicstore"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer teardown()
+
+	for i := 0; i < 3; i++ {
+		if _, err := store.Insert([]byte(`{"type": "foo"}`)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	count := 0
+	err = ForEach(store, nil, func(element []byte) error {
+		count++
+		return ErrStop
+	})
+	if err != ErrStop { // nolint: errorlint
+		t.Errorf("ForEach() error = %v, want %v", err, ErrStop)
+	}
+	if count != 1 {
+		t.Errorf("ForEach() count = %d, want 1", count)
+	}
+}
+
+func TestIterateSorted(t *testing.T) {
+	store, teardown, err := forensicstore.New(filepath.Join(t.TempDir(), "test.forensicstore"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer teardown()
+
+	for _, element := range []string{
+		`{"type": "foo", "name": "c", "time": 3}`,
+		`{"type": "foo", "name": "a", "time": 1}`,
+		`{"type": "bar", "name": "x", "time": 0}`,
+		`{"type": "foo", "name": "b1", "time": 2}`,
+		`{"type": "foo", "name": "b2", "time": 2}`,
+	} {
+		if _, err := store.Insert([]byte(element)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	it, err := IterateSorted(store, Filter{{"type": "foo"}}, "time")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer it.Close()
+	var names []string
+	for it.Next() {
+		names = append(names, gjson.GetBytes(it.Element(), "name").String())
+	}
+	if err := it.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := strings.Join(names, ","), "a,b1,b2,c"; got != want {
+		t.Errorf("IterateSorted() = %s, want %s", got, want)
+	}
+}
@@ -2,35 +2,123 @@ package pluginlib
 
 import (
 	"bytes"
+	"errors"
 	"log"
 
 	"github.com/tidwall/gjson"
 )
 
+// ErrStop can be returned by a LineWriter to signal that no more lines are
+// needed. Plugins should stop and return it like any other error, callers of
+// Run treat it as success.
+var ErrStop = errors.New("stop writing")
+
+// A LineWriter receives the elements emitted by a plugin. If WriteLine
+// returns an error the plugin must stop and return the error, as the output
+// is incomplete.
 type LineWriter interface {
-	WriteLine([]byte)
+	WriteLine([]byte) error
+}
+
+// A Flusher is a LineWriter that buffers lines.
+type Flusher interface {
+	Flush() error
+}
+
+// A LineWriteCloser is a LineWriter that needs to be closed after the run.
+type LineWriteCloser interface {
+	LineWriter
+	Close() error
+}
+
+// Flush flushes w if it is a Flusher.
+func Flush(w LineWriter) error {
+	if f, ok := w.(Flusher); ok {
+		return f.Flush()
+	}
+	return nil
+}
+
+// Close closes w if it is a LineWriteCloser.
+func Close(w LineWriter) error {
+	if c, ok := w.(LineWriteCloser); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// IsStop tests if err is nil or ErrStop.
+func IsStop(err error) bool {
+	return err == nil || errors.Is(err, ErrStop)
 }
 
+// MultiLineWriter writes every line to all LineWriters. A LineWriter that
+// returns ErrStop is skipped afterwards, ErrStop is only returned if all
+// LineWriters stopped.
 type MultiLineWriter struct {
 	LineWriter []LineWriter
+	stopped    map[int]bool
+}
+
+func (m *MultiLineWriter) WriteLine(b []byte) error {
+	for i, lw := range m.LineWriter {
+		if m.stopped[i] {
+			continue
+		}
+		err := lw.WriteLine(b)
+		if errors.Is(err, ErrStop) {
+			if m.stopped == nil {
+				m.stopped = map[int]bool{}
+			}
+			m.stopped[i] = true
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if len(m.stopped) == len(m.LineWriter) {
+		return ErrStop
+	}
+	return nil
 }
 
-func (m *MultiLineWriter) WriteLine(b []byte) {
+func (m *MultiLineWriter) Flush() error {
 	for _, lw := range m.LineWriter {
-		lw.WriteLine(b)
+		if err := Flush(lw); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *MultiLineWriter) WriteDiagnostic(d Diagnostic) {
+	reported := false
+	for _, lw := range m.LineWriter {
+		if dw, ok := lw.(DiagnosticWriter); ok {
+			dw.WriteDiagnostic(d)
+			reported = true
+		}
+	}
+	if !reported {
+		log.Println(d)
 	}
 }
 
 type LineWriterBuffer struct {
 	buffer *bytes.Buffer
 	Writer LineWriter
+	err    error
 }
 
 func NewLineWriterBuffer(w LineWriter) *LineWriterBuffer {
-	return &LineWriterBuffer{&bytes.Buffer{}, w}
+	return &LineWriterBuffer{buffer: &bytes.Buffer{}, Writer: w}
 }
 
 func (o *LineWriterBuffer) Write(b []byte) (n int, err error) {
+	if o.err != nil {
+		return 0, o.err
+	}
 	n = len(b)
 	for {
 		i := bytes.IndexByte(b, '\n')
@@ -39,27 +127,51 @@ func (o *LineWriterBuffer) Write(b []byte) (n int, err error) {
 			break
 		}
 
-		o.writeLine(append(o.buffer.Bytes(), b[:i]...))
-		b = b[i+1:]
+		o.err = o.writeLine(append(o.buffer.Bytes(), b[:i]...))
 		o.buffer.Reset()
+		if o.err != nil {
+			return n - len(b), o.err
+		}
+		b = b[i+1:]
 	}
 	return n, nil
 }
 
-func (o *LineWriterBuffer) writeLine(element []byte) { // nolint: gocyclo
+// Err returns the first error returned by the underlying LineWriter.
+func (o *LineWriterBuffer) Err() error {
+	return o.err
+}
+
+func (o *LineWriterBuffer) writeLine(element []byte) error {
 	element = bytes.TrimSpace(element)
 
-	// print to output
+	if len(element) == 0 {
+		return nil
+	}
+
+	// report non json output
 	if !gjson.ValidBytes(element) {
-		log.Println(string(element))
-		return
+		Report(o.Writer, Diagnostic{Level: Warning, Message: "invalid output: " + string(element)})
+		return nil
 	}
 
-	o.Writer.WriteLine(element)
+	return o.Writer.WriteLine(element)
+}
+
+func (o *LineWriterBuffer) WriteDiagnostic(d Diagnostic) {
+	Report(o.Writer, d)
+}
+
+func (o *LineWriterBuffer) Flush() error {
+	return Flush(o.Writer)
 }
 
-func (o *LineWriterBuffer) WriteFooter() {
+// Close writes the remaining buffer, it does not close the underlying
+// LineWriter.
+func (o *LineWriterBuffer) Close() error {
 	if o.buffer.Len() > 0 {
-		o.writeLine(o.buffer.Bytes())
+		defer o.buffer.Reset()
+		return o.writeLine(o.buffer.Bytes())
 	}
+	return nil
 }
@@ -0,0 +1,87 @@
+package pluginlib
+
+import (
+	"errors"
+	"testing"
+)
+
+type testLineWriter struct {
+	lines       []string
+	diagnostics []Diagnostic
+}
+
+func (t *testLineWriter) WriteLine(b []byte) error {
+	t.lines = append(t.lines, string(b))
+	return nil
+}
+
+func (t *testLineWriter) WriteDiagnostic(d Diagnostic) {
+	t.diagnostics = append(t.diagnostics, d)
+}
+
+func TestLineWriterBuffer_Write(t *testing.T) {
+	tlw := &testLineWriter{}
+	collector := &DiagnosticCollector{LineWriter: tlw, Plugin: "test"}
+	lwb := NewLineWriterBuffer(collector)
+
+	_, _ = lwb.Write([]byte("{\"a\": 1}\nprogress 50%\n\n{\"b\""))
+	_, _ = lwb.Write([]byte(": 2}"))
+	_ = lwb.Close()
+
+	if len(tlw.lines) != 2 {
+		t.Errorf("len(lines) = %d, want 2", len(tlw.lines))
+	}
+	if len(tlw.diagnostics) != 1 {
+		t.Fatalf("len(diagnostics) = %d, want 1", len(tlw.diagnostics))
+	}
+	if tlw.diagnostics[0].Plugin != "test" || tlw.diagnostics[0].Level != Warning {
+		t.Errorf("unexpected diagnostic %#v", tlw.diagnostics[0])
+	}
+	if collector.Count(Warning) != 1 || collector.Count(Error) != 0 {
+		t.Errorf("Count() = %d/%d, want 1/0", collector.Count(Warning), collector.Count(Error))
+	}
+}
+
+type failingLineWriter struct {
+	count int
+}
+
+func (f *failingLineWriter) WriteLine([]byte) error {
+	f.count++
+	return ErrStop
+}
+
+func TestLineWriterBuffer_WriteError(t *testing.T) {
+	flw := &failingLineWriter{}
+	lwb := NewLineWriterBuffer(flw)
+
+	_, err := lwb.Write([]byte("{}\n{}\n"))
+	if !IsStop(err) || err == nil {
+		t.Fatalf("Write() error = %v, want ErrStop", err)
+	}
+	if _, err := lwb.Write([]byte("{}\n")); err == nil {
+		t.Errorf("Write() after error should fail")
+	}
+	if flw.count != 1 {
+		t.Errorf("WriteLine() called %d times, want 1", flw.count)
+	}
+}
+
+func TestMultiLineWriter_WriteLine(t *testing.T) {
+	flw, tlw := &failingLineWriter{}, &testLineWriter{}
+	mlw := &MultiLineWriter{LineWriter: []LineWriter{flw, tlw}}
+
+	for i := 0; i < 3; i++ {
+		if err := mlw.WriteLine([]byte("{}")); err != nil {
+			t.Fatalf("WriteLine() error = %v", err)
+		}
+	}
+	if flw.count != 1 || len(tlw.lines) != 3 {
+		t.Errorf("WriteLine() calls = %d/%d, want 1/3", flw.count, len(tlw.lines))
+	}
+
+	mlw = &MultiLineWriter{LineWriter: []LineWriter{flw}}
+	if err := mlw.WriteLine([]byte("{}")); !errors.Is(err, ErrStop) {
+		t.Errorf("WriteLine() error = %v, want ErrStop", err)
+	}
+}
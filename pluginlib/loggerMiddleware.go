@@ -0,0 +1,21 @@
+package pluginlib
+
+import (
+	"log"
+)
+
+var _ Middleware = &LoggerMiddleware{}
+
+type LoggerMiddleware struct{}
+
+func (s *LoggerMiddleware) Parameter() ParameterList {
+	return nil
+}
+
+func (s *LoggerMiddleware) Run(p Plugin, w LineWriter, next RunFunc) error {
+	log.Printf("run %s\n", p.Name())
+	diagnostics := &DiagnosticCollector{LineWriter: w, Plugin: p.Name()}
+	err := next(p, diagnostics)
+	log.Printf("finished %s: %d warnings, %d errors\n", p.Name(), diagnostics.Count(Warning), diagnostics.Count(Error))
+	return err
+}
@@ -23,8 +23,18 @@ func (cp *PluginProvider) List() []pluginlib.Plugin {
 	}
 	dockerPluginProvider := docker.PluginProvider{Prefix: cp.Name, Images: cp.Images}
 
-	l := scriptPluginProvider.List()
-	l = append(l, dockerPluginProvider.List()...)
-	l = append(l, cp.Plugins...)
-	return l
+	// builtin plugins replace script and docker plugins with the same name,
+	// e.g. images that are still installed from older versions
+	builtin := map[string]bool{}
+	for _, plugin := range cp.Plugins {
+		builtin[plugin.Name()] = true
+	}
+
+	var l []pluginlib.Plugin
+	for _, plugin := range append(scriptPluginProvider.List(), dockerPluginProvider.List()...) {
+		if !builtin[plugin.Name()] {
+			l = append(l, plugin)
+		}
+	}
+	return append(l, cp.Plugins...)
 }
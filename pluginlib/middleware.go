@@ -0,0 +1,146 @@
+package pluginlib
+
+import (
+	"sync"
+)
+
+// A RunFunc runs a plugin, like Plugin.Run.
+type RunFunc func(Plugin, LineWriter) error
+
+// A Middleware wraps the Run of a plugin. It can add parameters to the plugin
+// and wrap the LineWriter to see every emitted element.
+type Middleware interface {
+	Parameter() ParameterList
+	Run(p Plugin, w LineWriter, next RunFunc) error
+}
+
+// Use wraps a plugin with middleware. The first middleware is the outermost,
+// it is called first and receives the LineWriter passed to Run.
+func Use(plugin Plugin, middleware ...Middleware) Plugin {
+	if len(middleware) == 0 {
+		return plugin
+	}
+	return &middlewarePlugin{Internal: plugin, middleware: middleware}
+}
+
+type middlewarePlugin struct {
+	Internal   Plugin
+	middleware []Middleware
+	parameter  ParameterList
+}
+
+func (m *middlewarePlugin) Name() string {
+	return m.Internal.Name()
+}
+
+func (m *middlewarePlugin) Short() string {
+	return m.Internal.Short()
+}
+
+func (m *middlewarePlugin) Parameter() ParameterList {
+	if m.parameter == nil {
+		m.parameter = append(ParameterList{}, m.Internal.Parameter()...)
+		for _, middleware := range m.middleware {
+			for _, parameter := range middleware.Parameter() {
+				if _, err := m.parameter.Get(parameter.Name); err != nil {
+					m.parameter = append(m.parameter, parameter)
+				}
+			}
+		}
+	}
+	return m.parameter
+}
+
+func (m *middlewarePlugin) Output() *Config {
+	return m.Internal.Output()
+}
+
+func (m *middlewarePlugin) Run(p Plugin, w LineWriter) error {
+	run := m.Internal.Run
+	for i := len(m.middleware) - 1; i >= 0; i-- {
+		middleware, next := m.middleware[i], run
+		run = func(p Plugin, w LineWriter) error {
+			return middleware.Run(p, w, next)
+		}
+	}
+	return run(p, w)
+}
+
+// A MiddlewareRegistry is an ordered list of middleware.
+type MiddlewareRegistry struct {
+	mux        sync.Mutex
+	middleware []Middleware
+}
+
+// Register appends middleware to the registry.
+func (r *MiddlewareRegistry) Register(middleware ...Middleware) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	r.middleware = append(r.middleware, middleware...)
+}
+
+// List returns all registered middleware in registration order.
+func (r *MiddlewareRegistry) List() []Middleware {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	return append([]Middleware{}, r.middleware...)
+}
+
+// Wrap wraps all plugins with the registered middleware.
+func (r *MiddlewareRegistry) Wrap(plugins []Plugin) []Plugin {
+	middleware := r.List()
+	var wrapped []Plugin
+	for _, plugin := range plugins {
+		wrapped = append(wrapped, Use(plugin, middleware...))
+	}
+	return wrapped
+}
+
+// Middlewares holds middleware registered by other packages, e.g. in their
+// init function.
+var Middlewares = &MiddlewareRegistry{}
+
+// RegisterMiddleware adds middleware to Middlewares.
+func RegisterMiddleware(middleware ...Middleware) {
+	Middlewares.Register(middleware...)
+}
+
+// ElementMiddleware is a Middleware that calls Func for every emitted
+// element. The returned element is written instead, nil drops the element.
+type ElementMiddleware struct {
+	Parameters ParameterList
+	Func       func(p Plugin, element []byte) ([]byte, error)
+}
+
+func (e *ElementMiddleware) Parameter() ParameterList {
+	return e.Parameters
+}
+
+func (e *ElementMiddleware) Run(p Plugin, w LineWriter, next RunFunc) error {
+	return next(p, &ElementWriter{LineWriter: w, Func: func(element []byte) ([]byte, error) {
+		return e.Func(p, element)
+	}})
+}
+
+// ElementWriter changes or drops elements before they are passed to the
+// wrapped LineWriter.
+type ElementWriter struct {
+	LineWriter LineWriter
+	Func       func(element []byte) ([]byte, error)
+}
+
+func (e *ElementWriter) WriteLine(b []byte) error {
+	b, err := e.Func(b)
+	if err != nil || b == nil {
+		return err
+	}
+	return e.LineWriter.WriteLine(b)
+}
+
+func (e *ElementWriter) WriteDiagnostic(d Diagnostic) {
+	Report(e.LineWriter, d)
+}
+
+func (e *ElementWriter) Flush() error {
+	return Flush(e.LineWriter)
+}
@@ -0,0 +1,77 @@
+package pluginlib
+
+import (
+	"reflect"
+	"testing"
+)
+
+type testPlugin struct {
+	parameter ParameterList
+}
+
+func (t *testPlugin) Name() string             { return "test" }
+func (t *testPlugin) Short() string            { return "test plugin" }
+func (t *testPlugin) Parameter() ParameterList { return t.parameter }
+func (t *testPlugin) Output() *Config          { return nil }
+
+func (t *testPlugin) Run(_ Plugin, w LineWriter) error {
+	for _, line := range []string{`{"a": 1}`, `{"a": 2}`, `{"a": 3}`} {
+		if err := w.WriteLine([]byte(line)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type orderMiddleware struct {
+	name  string
+	order *[]string
+}
+
+func (o *orderMiddleware) Parameter() ParameterList {
+	return ParameterList{{Name: o.name, Type: Bool}, {Name: "shared", Type: String}}
+}
+
+func (o *orderMiddleware) Run(p Plugin, w LineWriter, next RunFunc) error {
+	*o.order = append(*o.order, o.name)
+	return next(p, w)
+}
+
+func TestUse(t *testing.T) {
+	var order []string
+	drop := &ElementMiddleware{Func: func(_ Plugin, element []byte) ([]byte, error) {
+		if string(element) == `{"a": 2}` {
+			return nil, nil
+		}
+		return element, nil
+	}}
+
+	plugin := Use(
+		&testPlugin{parameter: ParameterList{{Name: "shared", Type: String, Value: "plugin"}}},
+		&orderMiddleware{name: "outer", order: &order},
+		drop,
+		&orderMiddleware{name: "inner", order: &order},
+	)
+
+	var names []string
+	for _, parameter := range plugin.Parameter() {
+		names = append(names, parameter.Name)
+	}
+	if !reflect.DeepEqual(names, []string{"shared", "outer", "inner"}) {
+		t.Errorf("Parameter() = %v", names)
+	}
+	if plugin.Parameter().StringValue("shared") != "plugin" {
+		t.Errorf("plugin parameter was replaced by middleware parameter")
+	}
+
+	tlw := &testLineWriter{}
+	if err := plugin.Run(plugin, tlw); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(order, []string{"outer", "inner"}) {
+		t.Errorf("middleware order = %v", order)
+	}
+	if !reflect.DeepEqual(tlw.lines, []string{`{"a": 1}`, `{"a": 3}`}) {
+		t.Errorf("lines = %v", tlw.lines)
+	}
+}
@@ -22,18 +22,19 @@ func NewCSVOutput(dest io.Writer, headers []string) *CSVOutput {
 	return o
 }
 
-func (o *CSVOutput) WriteLine(element []byte) {
+func (o *CSVOutput) WriteLine(element []byte) error {
 	if o.headers == nil {
-		fmt.Fprintln(o.dest, string(element)) // nolint: errcheck
-		return
-	}
-	err := o.csvWriter.Write(getColumns(o.headers, element))
-	if err != nil {
-		fmt.Fprintln(o.dest, string(element)) // nolint: errcheck
-		log.Println(err)
+		_, err := fmt.Fprintln(o.dest, string(element))
+		return err
 	}
+	return o.csvWriter.Write(getColumns(o.headers, element))
 }
 
-func (o *CSVOutput) WriteFooter() {
+func (o *CSVOutput) Flush() error {
 	o.csvWriter.Flush()
+	return o.csvWriter.Error()
+}
+
+func (o *CSVOutput) Close() error {
+	return o.Flush()
 }
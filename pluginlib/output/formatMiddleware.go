@@ -0,0 +1,108 @@
+package output
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/forensicanalysis/elementary/pluginlib"
+)
+
+var (
+	OutputParameter = &pluginlib.Parameter{
+		Name:        "output",
+		Description: "choose an output file",
+		Type:        pluginlib.Path,
+		Value:       "",
+		Required:    false,
+	}
+	FormatParameter = &pluginlib.Parameter{
+		Name:        "format",
+		Description: "choose output format [csv, jsonl, table, tree, json, none]",
+		Type:        pluginlib.String,
+		Value:       "jsonl",
+		Required:    false,
+	}
+)
+
+var _ pluginlib.Middleware = &FormatMiddleware{}
+
+// FormatMiddleware writes the elements in the selected format to stdout or
+// a file. It ignores the LineWriter passed to Run except for diagnostics.
+type FormatMiddleware struct{}
+
+func (s *FormatMiddleware) Parameter() pluginlib.ParameterList {
+	return pluginlib.ParameterList{OutputParameter.Copy(), FormatParameter.Copy()}
+}
+
+func (s *FormatMiddleware) Run(p pluginlib.Plugin, diagnostics pluginlib.LineWriter, next pluginlib.RunFunc) (err error) {
+	path := p.Parameter().StringValue("output")
+	format := p.Parameter().StringValue("format")
+
+	var dest io.Writer
+	if path != "" {
+		f, err := os.Create(path) //#nosec
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+		}()
+		dest = f
+	} else {
+		dest = os.Stdout
+	}
+
+	w, err := newFormatWriter(p, format, dest)
+	if err != nil {
+		return err
+	}
+
+	err = next(p, pluginlib.WithDiagnostics(w, diagnostics))
+	if cerr := pluginlib.Close(w); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func newFormatWriter(p pluginlib.Plugin, format string, dest io.Writer) (pluginlib.LineWriter, error) {
+	var header []string
+	if p.Output() != nil {
+		header = p.Output().Header
+	}
+	if fields := Fields(p); fields != nil {
+		header = fields
+	}
+
+	switch format {
+	case "table":
+		if header == nil {
+			return nil, fmt.Errorf("%s does not support table output", p.Name())
+		}
+		return NewTableOutput(dest, header), nil
+	case "tree":
+		if header == nil {
+			return nil, fmt.Errorf("%s does not support tree output", p.Name())
+		}
+		return NewTreeOutput(dest, header), nil
+	case "csv":
+		if header == nil {
+			return nil, fmt.Errorf("%s does not support csv output", p.Name())
+		}
+		return NewCSVOutput(dest, header), nil
+	case "jsonl":
+		return NewJsonlOutput(dest), nil
+	case "json":
+		return NewJSONOutput(dest), nil
+	case "none":
+		return &DiscardLineWriter{}, nil
+	default:
+		return nil, fmt.Errorf("unknown output format %s", format)
+	}
+}
+
+type DiscardLineWriter struct{}
+
+func (d DiscardLineWriter) WriteLine(_ []byte) error { return nil }
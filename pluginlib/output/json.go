@@ -15,15 +15,19 @@ func NewJSONOutput(dest io.Writer) *JSONOutput {
 	return o
 }
 
-func (j *JSONOutput) WriteLine(element []byte) {
+func (j *JSONOutput) WriteLine(element []byte) error {
 	if j.moreElements {
-		j.dest.Write([]byte(",")) // nolint: errcheck
+		if _, err := j.dest.Write([]byte(",")); err != nil {
+			return err
+		}
 	} else {
 		j.moreElements = true
 	}
-	j.dest.Write(element) // nolint: errcheck
+	_, err := j.dest.Write(element)
+	return err
 }
 
-func (j *JSONOutput) WriteFooter() {
-	j.dest.Write([]byte("]")) // nolint: errcheck
+func (j *JSONOutput) Close() error {
+	_, err := j.dest.Write([]byte("]"))
+	return err
 }
@@ -3,7 +3,6 @@ package output
 import (
 	"fmt"
 	"io"
-	"log"
 )
 
 type JSONLOutput struct {
@@ -14,9 +13,7 @@ func NewJsonlOutput(dest io.Writer) *JSONLOutput {
 	return &JSONLOutput{dest: dest}
 }
 
-func (o *JSONLOutput) WriteLine(element []byte) {
+func (o *JSONLOutput) WriteLine(element []byte) error {
 	_, err := fmt.Fprintln(o.dest, string(element))
-	if err != nil {
-		log.Println(err)
-	}
+	return err
 }
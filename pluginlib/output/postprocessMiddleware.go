@@ -0,0 +1,187 @@
+package output
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+
+	"github.com/forensicanalysis/elementary/pluginlib"
+)
+
+var (
+	FieldsParameter = &pluginlib.Parameter{
+		Name:        "fields",
+		Description: "comma separated list of fields to output",
+		Type:        pluginlib.String,
+		Value:       "",
+		Required:    false,
+	}
+	SortParameter = &pluginlib.Parameter{
+		Name:        "sort",
+		Description: "sort by field, prefix with - for descending order",
+		Type:        pluginlib.String,
+		Value:       "",
+		Required:    false,
+	}
+	LimitParameter = &pluginlib.Parameter{
+		Name:        "limit",
+		Description: "maximum number of output elements",
+		Type:        pluginlib.String,
+		Value:       "",
+		Required:    false,
+	}
+	UniqueParameter = &pluginlib.Parameter{
+		Name:        "unique",
+		Description: "only output the first element for each value of this field",
+		Type:        pluginlib.String,
+		Value:       "",
+		Required:    false,
+	}
+	DedupParameter = &pluginlib.Parameter{
+		Name:        "dedup",
+		Description: "drop duplicate elements, ignoring their id",
+		Type:        pluginlib.Bool,
+		Value:       false,
+		Required:    false,
+	}
+)
+
+var _ pluginlib.Middleware = &PostProcessMiddleware{}
+
+// PostProcessMiddleware deduplicates, sorts, limits and projects the output.
+// It must be placed directly inside the FormatMiddleware, so elements added to
+// the store are not changed.
+type PostProcessMiddleware struct{}
+
+func (s *PostProcessMiddleware) Parameter() pluginlib.ParameterList {
+	return pluginlib.ParameterList{
+		FieldsParameter.Copy(),
+		SortParameter.Copy(),
+		LimitParameter.Copy(),
+		UniqueParameter.Copy(),
+		DedupParameter.Copy(),
+	}
+}
+
+func (s *PostProcessMiddleware) Run(p pluginlib.Plugin, w pluginlib.LineWriter, next pluginlib.RunFunc) error {
+	// the writers are created in reverse order: fields, limit, unique, sort, dedup
+	var sorter *SortWriter
+
+	if fields := Fields(p); fields != nil {
+		w = &pluginlib.ElementWriter{LineWriter: w, Func: func(element []byte) ([]byte, error) {
+			return project(element, fields)
+		}}
+	}
+	if limit := p.Parameter().StringValue("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 0 {
+			return fmt.Errorf("invalid limit %s", limit)
+		}
+		w = &LimitWriter{LineWriter: w, Limit: n}
+	}
+	if unique := p.Parameter().StringValue("unique"); unique != "" {
+		w = &pluginlib.ElementWriter{LineWriter: w, Func: uniqueFunc(unique)}
+	}
+	if field := p.Parameter().StringValue("sort"); field != "" {
+		sorter = NewSortWriter(w, field)
+		w = sorter
+	}
+	if p.Parameter().BoolValue("dedup") {
+		w = &pluginlib.ElementWriter{LineWriter: w, Func: dedupFunc()}
+	}
+
+	err := next(p, w)
+	if sorter != nil {
+		if err == nil {
+			err = sorter.Close()
+		} else {
+			sorter.removeRuns()
+		}
+	}
+	if pluginlib.IsStop(err) {
+		return nil
+	}
+	return err
+}
+
+// Fields returns the fields selected by the fields parameter or nil.
+func Fields(p pluginlib.Plugin) []string {
+	parameter, err := p.Parameter().Get("fields")
+	if err != nil || parameter.StringValue() == "" {
+		return nil
+	}
+	var fields []string
+	for _, field := range strings.Split(parameter.StringValue(), ",") {
+		if field = strings.TrimSpace(field); field != "" {
+			fields = append(fields, field)
+		}
+	}
+	return fields
+}
+
+func project(element []byte, fields []string) ([]byte, error) {
+	projected := []byte("{}")
+	for _, field := range fields {
+		value := gjson.GetBytes(element, field)
+		if !value.Exists() {
+			continue
+		}
+		var err error
+		projected, err = sjson.SetRawBytes(projected, field, []byte(value.Raw))
+		if err != nil {
+			return nil, err
+		}
+	}
+	return projected, nil
+}
+
+func uniqueFunc(field string) func([]byte) ([]byte, error) {
+	seen := map[[sha256.Size]byte]bool{}
+	return func(element []byte) ([]byte, error) {
+		key := sha256.Sum256([]byte(gjson.GetBytes(element, field).Raw))
+		if seen[key] {
+			return nil, nil
+		}
+		seen[key] = true
+		return element, nil
+	}
+}
+
+func dedupFunc() func([]byte) ([]byte, error) {
+	seen := map[[sha256.Size]byte]bool{}
+	return func(element []byte) ([]byte, error) {
+		withoutID, err := sjson.DeleteBytes(element, "id")
+		if err != nil {
+			return nil, err
+		}
+		key := sha256.Sum256([]byte(gjson.GetBytes(withoutID, "@pretty:{\"sortKeys\":true}").Raw))
+		if seen[key] {
+			return nil, nil
+		}
+		seen[key] = true
+		return element, nil
+	}
+}
+
+// LimitWriter passes Limit elements and returns pluginlib.ErrStop afterwards.
+type LimitWriter struct {
+	LineWriter pluginlib.LineWriter
+	Limit      int
+	count      int
+}
+
+func (l *LimitWriter) WriteLine(b []byte) error {
+	if l.count >= l.Limit {
+		return pluginlib.ErrStop
+	}
+	l.count++
+	return l.LineWriter.WriteLine(b)
+}
+
+func (l *LimitWriter) WriteDiagnostic(d pluginlib.Diagnostic) {
+	pluginlib.Report(l.LineWriter, d)
+}
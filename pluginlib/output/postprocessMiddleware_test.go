@@ -0,0 +1,105 @@
+package output
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/forensicanalysis/elementary/pluginlib"
+)
+
+type testLineWriter struct {
+	lines []string
+}
+
+func (t *testLineWriter) WriteLine(b []byte) error {
+	t.lines = append(t.lines, string(b))
+	return nil
+}
+
+type testPlugin struct {
+	elements []string
+}
+
+func (t *testPlugin) Name() string                       { return "test" }
+func (t *testPlugin) Short() string                      { return "test plugin" }
+func (t *testPlugin) Parameter() pluginlib.ParameterList { return nil }
+func (t *testPlugin) Output() *pluginlib.Config          { return nil }
+
+func (t *testPlugin) Run(_ pluginlib.Plugin, w pluginlib.LineWriter) error {
+	for _, element := range t.elements {
+		if err := w.WriteLine([]byte(element)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestPostProcessMiddleware_Run(t *testing.T) {
+	elements := []string{
+		`{"id":"1","exe":"a.exe","run":3}`,
+		`{"id":"2","exe":"b.exe","run":1}`,
+		`{"id":"3","exe":"a.exe","run":5}`,
+		`{"id":"4","exe":"b.exe","run":1}`,
+		`{"id":"5","exe":"c.exe","run":2}`,
+	}
+
+	tests := []struct {
+		name       string
+		parameters map[string]interface{}
+		want       []string
+	}{
+		{"no parameters", nil, elements},
+		{"sort", map[string]interface{}{"sort": "run", "fields": "id"}, []string{
+			`{"id":"2"}`, `{"id":"4"}`, `{"id":"5"}`, `{"id":"1"}`, `{"id":"3"}`,
+		}},
+		{"sort descending", map[string]interface{}{"sort": "-run", "fields": "id"}, []string{
+			`{"id":"3"}`, `{"id":"1"}`, `{"id":"5"}`, `{"id":"2"}`, `{"id":"4"}`,
+		}},
+		{"limit", map[string]interface{}{"limit": "2"}, elements[:2]},
+		{"dedup", map[string]interface{}{"dedup": true, "fields": "id"}, []string{
+			`{"id":"1"}`, `{"id":"2"}`, `{"id":"3"}`, `{"id":"5"}`,
+		}},
+		{"unique sorted", map[string]interface{}{"unique": "exe", "sort": "-run", "fields": "exe,run"}, []string{
+			`{"exe":"a.exe","run":5}`, `{"exe":"c.exe","run":2}`, `{"exe":"b.exe","run":1}`,
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plugin := pluginlib.Use(&testPlugin{elements: elements}, &PostProcessMiddleware{})
+			for name, value := range tt.parameters {
+				plugin.Parameter().Set(name, value)
+			}
+
+			tlw := &testLineWriter{}
+			if err := plugin.Run(plugin, tlw); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(tlw.lines, tt.want) {
+				t.Errorf("Run() = %v, want %v", tlw.lines, tt.want)
+			}
+		})
+	}
+}
+
+func TestSortWriter_Spill(t *testing.T) {
+	tlw := &testLineWriter{}
+	sorter := NewSortWriter(tlw, "n")
+	sorter.MaxBuffer = 20
+
+	for _, element := range []string{`{"n":4}`, `{"n":2}`, `{"n":5}`, `{"n":1}`, `{"n":3}`, `{"n":2,"x":1}`} {
+		if err := sorter.WriteLine([]byte(element)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(sorter.runs) < 2 {
+		t.Fatalf("expected spilled runs, got %d", len(sorter.runs))
+	}
+	if err := sorter.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{`{"n":1}`, `{"n":2}`, `{"n":2,"x":1}`, `{"n":3}`, `{"n":4}`, `{"n":5}`}
+	if !reflect.DeepEqual(tlw.lines, want) {
+		t.Errorf("Close() = %v, want %v", tlw.lines, want)
+	}
+}
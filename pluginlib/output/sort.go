@@ -0,0 +1,213 @@
+package output
+
+import (
+	"bufio"
+	"container/heap"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/tidwall/gjson"
+
+	"github.com/forensicanalysis/elementary/pluginlib"
+)
+
+const defaultSortBuffer = 64 << 20
+
+// SortWriter sorts all elements by a field and writes them to the wrapped
+// LineWriter on Close. If the elements exceed MaxBuffer bytes, sorted runs are
+// spilled to temporary files and merged on Close.
+type SortWriter struct {
+	LineWriter pluginlib.LineWriter
+	Field      string
+	Descending bool
+	MaxBuffer  int
+
+	buffer     [][]byte
+	bufferSize int
+	runs       []*os.File
+}
+
+// NewSortWriter creates a SortWriter, a field prefixed with "-" sorts in
+// descending order.
+func NewSortWriter(w pluginlib.LineWriter, field string) *SortWriter {
+	return &SortWriter{
+		LineWriter: w,
+		Field:      strings.TrimPrefix(field, "-"),
+		Descending: strings.HasPrefix(field, "-"),
+		MaxBuffer:  defaultSortBuffer,
+	}
+}
+
+func (s *SortWriter) less(a, b []byte) bool {
+	if s.Descending {
+		a, b = b, a
+	}
+	return gjson.GetBytes(a, s.Field).Less(gjson.GetBytes(b, s.Field), true)
+}
+
+func (s *SortWriter) WriteLine(b []byte) error {
+	s.buffer = append(s.buffer, append([]byte{}, b...))
+	s.bufferSize += len(b)
+	if s.bufferSize > s.MaxBuffer {
+		return s.spill()
+	}
+	return nil
+}
+
+func (s *SortWriter) WriteDiagnostic(d pluginlib.Diagnostic) {
+	pluginlib.Report(s.LineWriter, d)
+}
+
+func (s *SortWriter) sortBuffer() {
+	sort.SliceStable(s.buffer, func(i, j int) bool {
+		return s.less(s.buffer[i], s.buffer[j])
+	})
+}
+
+// spill writes the sorted buffer into a temporary file.
+func (s *SortWriter) spill() error {
+	s.sortBuffer()
+
+	f, err := ioutil.TempFile("", "elementary-sort")
+	if err != nil {
+		return err
+	}
+	s.runs = append(s.runs, f)
+
+	w := bufio.NewWriter(f)
+	size := make([]byte, binary.MaxVarintLen64)
+	for _, element := range s.buffer {
+		n := binary.PutUvarint(size, uint64(len(element)))
+		if _, err := w.Write(size[:n]); err != nil {
+			return err
+		}
+		if _, err := w.Write(element); err != nil {
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	s.buffer = nil
+	s.bufferSize = 0
+	return nil
+}
+
+// Close writes all sorted elements and removes the temporary files.
+func (s *SortWriter) Close() error {
+	defer s.removeRuns()
+
+	if len(s.runs) == 0 {
+		s.sortBuffer()
+		for _, element := range s.buffer {
+			if err := s.LineWriter.WriteLine(element); err != nil {
+				return err
+			}
+		}
+		s.buffer = nil
+		return nil
+	}
+
+	if len(s.buffer) > 0 {
+		if err := s.spill(); err != nil {
+			return err
+		}
+	}
+	return s.merge()
+}
+
+func (s *SortWriter) merge() error {
+	mh := &mergeHeap{less: s.less}
+	for i, f := range s.runs {
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		r := &run{index: i, reader: bufio.NewReader(f)}
+		ok, err := r.next()
+		if err != nil {
+			return err
+		}
+		if ok {
+			mh.runs = append(mh.runs, r)
+		}
+	}
+	heap.Init(mh)
+
+	for mh.Len() > 0 {
+		r := mh.runs[0]
+		if err := s.LineWriter.WriteLine(r.element); err != nil {
+			return err
+		}
+		ok, err := r.next()
+		if err != nil {
+			return err
+		}
+		if ok {
+			heap.Fix(mh, 0)
+		} else {
+			heap.Pop(mh)
+		}
+	}
+	return nil
+}
+
+func (s *SortWriter) removeRuns() {
+	for _, f := range s.runs {
+		f.Close()
+		os.Remove(f.Name())
+	}
+	s.runs = nil
+}
+
+type run struct {
+	index   int
+	reader  *bufio.Reader
+	element []byte
+}
+
+func (r *run) next() (bool, error) {
+	size, err := binary.ReadUvarint(r.reader)
+	if err == io.EOF { // nolint: errorlint
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	r.element = make([]byte, size)
+	_, err = io.ReadFull(r.reader, r.element)
+	return err == nil, err
+}
+
+type mergeHeap struct {
+	runs []*run
+	less func(a, b []byte) bool
+}
+
+func (h *mergeHeap) Len() int { return len(h.runs) }
+
+func (h *mergeHeap) Less(i, j int) bool {
+	if h.less(h.runs[i].element, h.runs[j].element) {
+		return true
+	}
+	if h.less(h.runs[j].element, h.runs[i].element) {
+		return false
+	}
+	// keep the order of equal elements
+	return h.runs[i].index < h.runs[j].index
+}
+
+func (h *mergeHeap) Swap(i, j int) { h.runs[i], h.runs[j] = h.runs[j], h.runs[i] }
+
+func (h *mergeHeap) Push(x interface{}) { h.runs = append(h.runs, x.(*run)) }
+
+func (h *mergeHeap) Pop() interface{} {
+	old := h.runs
+	r := old[len(old)-1]
+	h.runs = old[:len(old)-1]
+	return r
+}
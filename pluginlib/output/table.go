@@ -19,16 +19,18 @@ func NewTableOutput(dest io.Writer, headers []string) *TableOutput {
 	return o
 }
 
-func (o *TableOutput) WriteLine(element []byte) {
+func (o *TableOutput) WriteLine(element []byte) error {
 	if o.headers == nil {
-		fmt.Fprintln(o.dest, string(element)) // nolint: errcheck
-		return
+		_, err := fmt.Fprintln(o.dest, string(element))
+		return err
 	}
 	o.tableWriter.Append(getColumns(o.headers, element))
+	return nil
 }
 
-func (o *TableOutput) WriteFooter() {
+func (o *TableOutput) Close() error {
 	if o.tableWriter.NumLines() > 0 {
 		o.tableWriter.Render()
 	}
+	return nil
 }
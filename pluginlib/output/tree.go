@@ -0,0 +1,92 @@
+package output
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/tidwall/gjson"
+)
+
+// TreeOutput renders elements as a tree using their id and parent_ref
+// fields. Elements without a known parent are roots. The tree is written on
+// Close, children keep the order in which they were written.
+type TreeOutput struct {
+	dest     io.Writer
+	headers  []string
+	elements [][]byte
+}
+
+func NewTreeOutput(dest io.Writer, headers []string) *TreeOutput {
+	return &TreeOutput{dest: dest, headers: headers}
+}
+
+func (o *TreeOutput) WriteLine(element []byte) error {
+	o.elements = append(o.elements, append([]byte{}, element...))
+	return nil
+}
+
+func (o *TreeOutput) Close() error {
+	ids := map[string]bool{}
+	for _, element := range o.elements {
+		ids[gjson.GetBytes(element, "id").String()] = true
+	}
+
+	children := map[string][]int{}
+	var roots []int
+	for i, element := range o.elements {
+		parent := gjson.GetBytes(element, "parent_ref").String()
+		if parent == "" || !ids[parent] {
+			roots = append(roots, i)
+			continue
+		}
+		children[parent] = append(children[parent], i)
+	}
+
+	visited := map[int]bool{}
+	var render func(i int, prefix, childPrefix string) error
+	render = func(i int, prefix, childPrefix string) error {
+		if visited[i] {
+			return nil
+		}
+		visited[i] = true
+
+		if _, err := fmt.Fprintln(o.dest, prefix+o.label(o.elements[i])); err != nil {
+			return err
+		}
+		nodes := children[gjson.GetBytes(o.elements[i], "id").String()]
+		for n, child := range nodes {
+			if n == len(nodes)-1 {
+				if err := render(child, childPrefix+"└── ", childPrefix+"    "); err != nil {
+					return err
+				}
+			} else if err := render(child, childPrefix+"├── ", childPrefix+"│   "); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	for _, root := range roots {
+		if err := render(root, "", ""); err != nil {
+			return err
+		}
+	}
+	// elements in a parent cycle are not reachable from a root
+	for i := range o.elements {
+		if err := render(i, "", ""); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (o *TreeOutput) label(element []byte) string {
+	var parts []string
+	for _, column := range getColumns(o.headers, element) {
+		if column != "" {
+			parts = append(parts, column)
+		}
+	}
+	return strings.Join(parts, " ")
+}
@@ -0,0 +1,40 @@
+package output
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestTreeOutput(t *testing.T) {
+	elements := []string{
+		`{"id":"1","name":"explorer.exe"}`,
+		`{"id":"2","name":"winword.exe","parent_ref":"1"}`,
+		`{"id":"3","name":"powershell.exe","parent_ref":"2"}`,
+		`{"id":"4","name":"cmd.exe","parent_ref":"1"}`,
+		`{"id":"5","name":"svchost.exe","parent_ref":"0"}`,
+		`{"id":"6","name":"a.exe","parent_ref":"7"}`,
+		`{"id":"7","name":"b.exe","parent_ref":"6"}`,
+	}
+	want := `explorer.exe 1
+├── winword.exe 2
+│   └── powershell.exe 3
+└── cmd.exe 4
+svchost.exe 5
+a.exe 6
+└── b.exe 7
+`
+
+	buf := &bytes.Buffer{}
+	o := NewTreeOutput(buf, []string{"name", "id"})
+	for _, element := range elements {
+		if err := o.WriteLine([]byte(element)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := o.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != want {
+		t.Errorf("tree =\n%s\nwant\n%s", buf.String(), want)
+	}
+}
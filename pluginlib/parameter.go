@@ -30,6 +30,12 @@ type Parameter struct {
 	Argument    bool
 }
 
+// Copy returns a shallow copy of the parameter.
+func (p *Parameter) Copy() *Parameter {
+	c := *p
+	return &c
+}
+
 func (p *Parameter) BoolValue() bool {
 	if p.Value == nil {
 		return false
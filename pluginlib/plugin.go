@@ -3,9 +3,11 @@ package pluginlib
 import (
 	"fmt"
 	"log"
+	"os"
 
 	"github.com/spf13/cobra"
 	"github.com/spf13/pflag"
+	"github.com/tidwall/sjson"
 )
 
 type Config struct {
@@ -20,10 +22,24 @@ type Plugin interface {
 	Run(Plugin, LineWriter) error
 }
 
+// Versioned can be implemented by plugins that have their own version.
+type Versioned interface {
+	Version() string
+}
+
+// SetSource sets the id of the element an element was derived from.
+func SetSource(element []byte, source string) ([]byte, error) {
+	if source == "" {
+		return element, nil
+	}
+	return sjson.SetBytes(element, "provenance.source", source)
+}
+
 type SimpleLineWriter struct{}
 
-func (s SimpleLineWriter) WriteLine(bytes []byte) {
-	fmt.Println(string(bytes))
+func (s SimpleLineWriter) WriteLine(bytes []byte) error {
+	_, err := fmt.Println(string(bytes))
+	return err
 }
 
 func ToCobra(plugins []Plugin) []*cobra.Command {
@@ -38,7 +54,13 @@ func ToCobra(plugins []Plugin) []*cobra.Command {
 				if err != nil {
 					return err
 				}
-				return plgn.Run(plgn, &SimpleLineWriter{})
+				diagnostics := &DiagnosticCollector{LineWriter: &SimpleLineWriter{}, Plugin: plgn.Name()}
+				defer diagnostics.WriteSummary(os.Stderr)
+				err = plgn.Run(plgn, diagnostics)
+				if IsStop(err) {
+					return nil
+				}
+				return err
 			},
 		}
 		for _, parameter := range plgn.Parameter() {
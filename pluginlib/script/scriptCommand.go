@@ -119,6 +119,13 @@ func (s *command) Output() *pluginlib.Config {
 
 func (s *command) Run(c pluginlib.Plugin, writer pluginlib.LineWriter) error {
 	lbw := pluginlib.NewLineWriterBuffer(writer)
-	defer lbw.WriteFooter()
-	return s.run(c, lbw)
+	err := s.run(c, lbw)
+	if lbw.Err() != nil {
+		// the command failed because the output could not be written
+		return lbw.Err()
+	}
+	if cerr := lbw.Close(); err == nil {
+		err = cerr
+	}
+	return err
 }
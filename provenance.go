@@ -0,0 +1,82 @@
+package elementary
+
+import (
+	"encoding/json"
+	"runtime/debug"
+	"time"
+
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+
+	"github.com/forensicanalysis/elementary/pluginlib"
+)
+
+// Provenance describes how an element was created.
+type Provenance struct {
+	Plugin     string                 `json:"plugin"`
+	Version    string                 `json:"version,omitempty"`
+	RunID      string                 `json:"run_id"`
+	Timestamp  string                 `json:"timestamp"`
+	Source     string                 `json:"source,omitempty"`
+	Parameters map[string]interface{} `json:"parameters,omitempty"`
+}
+
+// Version returns the version of the elementary module.
+func Version() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return ""
+	}
+	if info.Main.Path == "github.com/forensicanalysis/elementary" {
+		return info.Main.Version
+	}
+	for _, dep := range info.Deps {
+		if dep.Path == "github.com/forensicanalysis/elementary" {
+			return dep.Version
+		}
+	}
+	return ""
+}
+
+// NewProvenance creates the provenance of a single run of a plugin.
+func NewProvenance(p pluginlib.Plugin, runID string) Provenance {
+	provenance := Provenance{
+		Plugin:     p.Name(),
+		Version:    Version(),
+		RunID:      runID,
+		Parameters: map[string]interface{}{},
+	}
+	if versioned, ok := p.(pluginlib.Versioned); ok {
+		provenance.Version = versioned.Version()
+	}
+	for _, parameter := range p.Parameter() {
+		if !parameter.Argument && parameter.Value != nil {
+			provenance.Parameters[parameter.Name] = parameter.Value
+		}
+	}
+	return provenance
+}
+
+// NewProvenanceWriter adds a provenance block to every element. A source
+// element id set by the plugin in provenance.source is kept.
+func NewProvenanceWriter(w pluginlib.LineWriter, provenance Provenance) pluginlib.LineWriter {
+	return &pluginlib.ElementWriter{LineWriter: w, Func: func(element []byte) ([]byte, error) {
+		elementProvenance := provenance
+		elementProvenance.Timestamp = time.Now().UTC().Format(time.RFC3339Nano)
+		elementProvenance.Source = gjson.GetBytes(element, "provenance.source").String()
+
+		b, err := json.Marshal(elementProvenance)
+		if err != nil {
+			return nil, err
+		}
+		return sjson.SetRawBytes(element, "provenance", b)
+	}}
+}
+
+// NewRunIDWriter only records the run id in provenance.run_id, so elements
+// can be removed with their run even if the provenance is disabled.
+func NewRunIDWriter(w pluginlib.LineWriter, runID string) pluginlib.LineWriter {
+	return &pluginlib.ElementWriter{LineWriter: w, Func: func(element []byte) ([]byte, error) {
+		return sjson.SetBytes(element, "provenance.run_id", runID)
+	}}
+}
@@ -0,0 +1,146 @@
+package elementary
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"crawshaw.io/sqlite"
+	"crawshaw.io/sqlite/sqlitex"
+
+	"github.com/forensicanalysis/forensicstore"
+)
+
+// RunStatus is the state of a run.
+type RunStatus string
+
+const (
+	Running  RunStatus = "running"
+	Finished RunStatus = "finished"
+	Failed   RunStatus = "failed"
+)
+
+// RunRecord is an entry in the run registry of a forensicstore. Every run
+// with add-to-store is recorded, its elements carry the run id in
+// provenance.run_id.
+type RunRecord struct {
+	ID         string                 `json:"id"`
+	Plugin     string                 `json:"plugin"`
+	Version    string                 `json:"version,omitempty"`
+	Parameters map[string]interface{} `json:"parameters,omitempty"`
+	Start      string                 `json:"start"`
+	End        string                 `json:"end,omitempty"`
+	Status     RunStatus              `json:"status"`
+	Elements   int                    `json:"elements"`
+}
+
+// the table name starts with an underscore, so the forensicstore does not
+// treat it as an element table
+const runsTable = "_runs"
+
+var ErrRunNotFound = errors.New("run does not exist")
+
+func createRunsTable(conn *sqlite.Conn) error {
+	return sqlitex.Exec(conn, "CREATE TABLE IF NOT EXISTS `"+runsTable+"` ("+
+		"id TEXT PRIMARY KEY, plugin TEXT, version TEXT, parameters TEXT, "+
+		"start_time TEXT, end_time TEXT, status TEXT, elements INTEGER)", nil)
+}
+
+// StartRun adds a run to the run registry.
+func StartRun(store *forensicstore.ForensicStore, provenance Provenance) error {
+	conn := store.Connection()
+	if err := createRunsTable(conn); err != nil {
+		return err
+	}
+	parameters, err := json.Marshal(provenance.Parameters)
+	if err != nil {
+		return err
+	}
+	err = sqlitex.Exec(conn, "INSERT INTO `"+runsTable+"` (id, plugin, version, parameters, start_time, status, elements) "+
+		"VALUES (?, ?, ?, ?, ?, ?, 0)", nil,
+		provenance.RunID, provenance.Plugin, provenance.Version, string(parameters),
+		time.Now().UTC().Format(time.RFC3339Nano), string(Running),
+	)
+	if err != nil {
+		return fmt.Errorf("could not register run %s: %w", provenance.RunID, err)
+	}
+	return nil
+}
+
+// FinishRun sets the final status and the number of inserted elements of a
+// run.
+func FinishRun(store *forensicstore.ForensicStore, id string, status RunStatus, elements int) error {
+	err := sqlitex.Exec(store.Connection(), "UPDATE `"+runsTable+"` SET end_time=?, status=?, elements=? WHERE id=?", nil,
+		time.Now().UTC().Format(time.RFC3339Nano), string(status), elements, id,
+	)
+	if err != nil {
+		return fmt.Errorf("could not update run %s: %w", id, err)
+	}
+	return nil
+}
+
+// ListRuns returns all registered runs ordered by their start time.
+func ListRuns(store *forensicstore.ForensicStore) ([]RunRecord, error) {
+	conn := store.Connection()
+	if err := createRunsTable(conn); err != nil {
+		return nil, err
+	}
+
+	var runs []RunRecord
+	err := sqlitex.Exec(conn, "SELECT id, plugin, version, parameters, start_time, end_time, status, elements "+
+		"FROM `"+runsTable+"` ORDER BY start_time", func(stmt *sqlite.Stmt) error {
+		run := RunRecord{
+			ID:       stmt.ColumnText(0),
+			Plugin:   stmt.ColumnText(1),
+			Version:  stmt.ColumnText(2),
+			Start:    stmt.ColumnText(4),
+			End:      stmt.ColumnText(5),
+			Status:   RunStatus(stmt.ColumnText(6)),
+			Elements: stmt.ColumnInt(7),
+		}
+		if parameters := stmt.ColumnText(3); parameters != "" {
+			if err := json.Unmarshal([]byte(parameters), &run.Parameters); err != nil {
+				return err
+			}
+		}
+		runs = append(runs, run)
+		return nil
+	})
+	return runs, err
+}
+
+// RemoveRun deletes all elements inserted by a run and the run itself. It
+// returns the number of removed elements.
+func RemoveRun(store *forensicstore.ForensicStore, id string) (removed int, err error) {
+	conn := store.Connection()
+	if err := createRunsTable(conn); err != nil {
+		return 0, err
+	}
+
+	exists := false
+	err = sqlitex.Exec(conn, "SELECT 1 FROM `"+runsTable+"` WHERE id=?", func(*sqlite.Stmt) error {
+		exists = true
+		return nil
+	}, id)
+	if err != nil {
+		return 0, err
+	}
+	if !exists {
+		return 0, fmt.Errorf("%w: %s", ErrRunNotFound, id)
+	}
+
+	defer sqlitex.Save(conn)(&err)
+
+	err = sqlitex.Exec(conn, "DELETE FROM `elements` WHERE json_extract(json, '$.provenance.run_id') = ?", nil, id)
+	if err != nil {
+		return 0, fmt.Errorf("could not remove elements of run %s: %w", id, err)
+	}
+	removed = conn.Changes()
+
+	err = sqlitex.Exec(conn, "DELETE FROM `"+runsTable+"` WHERE id=?", nil, id)
+	if err != nil {
+		return 0, fmt.Errorf("could not remove run %s: %w", id, err)
+	}
+	return removed, nil
+}
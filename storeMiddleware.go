@@ -0,0 +1,135 @@
+package elementary
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/google/uuid"
+
+	"github.com/forensicanalysis/elementary/pluginlib"
+	"github.com/forensicanalysis/forensicstore"
+)
+
+var (
+	AddToStoreParameter = &pluginlib.Parameter{
+		Name:        "add-to-store",
+		Description: "output to store",
+		Type:        pluginlib.Bool,
+		Value:       false,
+		Required:    false,
+	}
+	StoreDiagnosticsParameter = &pluginlib.Parameter{
+		Name:        "store-diagnostics",
+		Description: "add warnings and errors as diagnostic elements to store",
+		Type:        pluginlib.Bool,
+		Value:       false,
+		Required:    false,
+	}
+	ProvenanceParameter = &pluginlib.Parameter{
+		Name:        "provenance",
+		Description: "add a provenance block to elements added to the store",
+		Type:        pluginlib.Bool,
+		Value:       true,
+		Required:    false,
+	}
+	OnConflictParameter = &pluginlib.Parameter{
+		Name:        "on-conflict",
+		Description: "handling of elements that already exist in the store: skip, replace or fail",
+		Type:        pluginlib.String,
+		Value:       string(Skip),
+		Required:    false,
+	}
+	IDKeyParameter = &pluginlib.Parameter{
+		Name:        "id-key",
+		Description: "comma separated list of fields used to derive element ids, defaults to all fields",
+		Type:        pluginlib.String,
+		Value:       "",
+		Required:    false,
+	}
+	BatchSizeParameter = &pluginlib.Parameter{
+		Name:        "batch-size",
+		Description: "number of elements added to the store in a single transaction",
+		Type:        pluginlib.String,
+		Value:       strconv.Itoa(DefaultBatchSize),
+		Required:    false,
+	}
+	ForensicStoreParameter = &pluginlib.Parameter{
+		Name:     "forensicstore",
+		Type:     pluginlib.Path,
+		Required: true,
+		Argument: true,
+	}
+)
+
+var _ pluginlib.Middleware = &StoreMiddleware{}
+
+// StoreMiddleware adds the emitted elements to the forensicstore if
+// add-to-store is set.
+type StoreMiddleware struct{}
+
+func (s *StoreMiddleware) Parameter() pluginlib.ParameterList {
+	return pluginlib.ParameterList{
+		AddToStoreParameter.Copy(),
+		StoreDiagnosticsParameter.Copy(),
+		ProvenanceParameter.Copy(),
+		OnConflictParameter.Copy(),
+		IDKeyParameter.Copy(),
+		BatchSizeParameter.Copy(),
+		ForensicStoreParameter.Copy(),
+	}
+}
+
+func (s *StoreMiddleware) Run(p pluginlib.Plugin, writer pluginlib.LineWriter, next pluginlib.RunFunc) (err error) {
+	path := p.Parameter().StringValue("forensicstore")
+	store, teardown, err := forensicstore.Open(path)
+	if err != nil {
+		return err
+	}
+	defer teardown()
+
+	if p.Parameter().BoolValue("add-to-store") {
+		var onConflict ConflictMode
+		onConflict, err = ParseConflictMode(p.Parameter().StringValue("on-conflict"))
+		if err != nil {
+			return err
+		}
+
+		batchSize, perr := strconv.Atoi(p.Parameter().StringValue("batch-size"))
+		if perr != nil || batchSize < 1 {
+			return fmt.Errorf("invalid batch size %s", p.Parameter().StringValue("batch-size"))
+		}
+
+		provenance := NewProvenance(p, uuid.New().String())
+		if err = StartRun(store, provenance); err != nil {
+			return err
+		}
+
+		forensicStoreOutput := NewForensicStoreOutput(store)
+		forensicStoreOutput.Diagnostics = p.Parameter().BoolValue("store-diagnostics")
+		forensicStoreOutput.OnConflict = onConflict
+		forensicStoreOutput.RunID = provenance.RunID
+		forensicStoreOutput.BatchSize = batchSize
+		var storeWriter pluginlib.LineWriter = forensicStoreOutput
+		if p.Parameter().BoolValue("provenance") {
+			storeWriter = NewProvenanceWriter(storeWriter, provenance)
+		} else {
+			storeWriter = NewRunIDWriter(storeWriter, provenance.RunID)
+		}
+		// ids are derived before the provenance is added
+		storeWriter = NewIDWriter(storeWriter, p.Name(), IDKeys(p))
+		writer = &pluginlib.MultiLineWriter{LineWriter: []pluginlib.LineWriter{writer, storeWriter}}
+		defer func() {
+			if cerr := forensicStoreOutput.Close(); err == nil {
+				err = cerr
+			}
+			status := Finished
+			if err != nil && !pluginlib.IsStop(err) {
+				status = Failed
+			}
+			if ferr := FinishRun(store, provenance.RunID, status, forensicStoreOutput.Inserted()); err == nil {
+				err = ferr
+			}
+		}()
+	}
+	return next(p, writer)
+}